@@ -15,7 +15,11 @@
 package client
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"net/url"
+	"time"
 )
 
 type ChecksOptions struct {
@@ -43,8 +47,11 @@ const (
 type CheckStatus string
 
 const (
-	CheckStatusUp   CheckStatus = "up"
-	CheckStatusDown CheckStatus = "down"
+	CheckStatusPending CheckStatus = "pending"
+	CheckStatusUp      CheckStatus = "up"
+	CheckStatusDown    CheckStatus = "down"
+	CheckStatusPaused  CheckStatus = "paused"
+	CheckStatusStopped CheckStatus = "stopped"
 )
 
 // CheckInfo holds status information for a single health check.
@@ -55,8 +62,14 @@ type CheckInfo struct {
 	// Level is this check's level, from the layer configuration.
 	Level CheckLevel `json:"level"`
 
-	// Status is the status of this check: "up" if healthy, "down" if the
-	// number of failures has reached the configured threshold.
+	// Service is the name of the service this check is bound to, if any. The
+	// check only runs while that service is active.
+	Service string `json:"service,omitempty"`
+
+	// Status is the status of this check: "pending" if it hasn't succeeded
+	// yet, "up" if healthy, "down" if the number of failures has reached the
+	// configured threshold, "paused" if the service it's bound to isn't
+	// active, or "stopped" if it was stopped with StopChecks.
 	Status CheckStatus `json:"status"`
 
 	// Failures is the number of times in a row this check has failed. It is
@@ -66,6 +79,54 @@ type CheckInfo struct {
 	// Threshold is this check's failure threshold, from the layer
 	// configuration.
 	Threshold int `json:"threshold"`
+
+	// Successes is the number of times in a row this check has succeeded.
+	Successes int `json:"successes"`
+
+	// SuccessThreshold is the number of successes in a row needed for a
+	// down or pending check to go up, from the layer configuration.
+	SuccessThreshold int `json:"success-threshold"`
+
+	// FlapThreshold is the number of times this check can go up or down
+	// within FlapWindow before it's reported as flapping, or zero if flap
+	// detection is disabled.
+	FlapThreshold int           `json:"flap-threshold,omitempty"`
+	FlapWindow    time.Duration `json:"flap-window,omitempty"`
+
+	// Flapping is true if this check was detected flapping within the last
+	// FlapWindow.
+	Flapping bool `json:"flapping,omitempty"`
+
+	// LastError is the error message from the most recent failure, if the
+	// check is currently failing.
+	LastError string `json:"last-error,omitempty"`
+
+	// ErrorDetails holds additional details about the most recent failure,
+	// for example the last few lines of output of an exec check.
+	ErrorDetails string `json:"error-details,omitempty"`
+
+	// LastSuccess is the time the check last succeeded, or the zero time if
+	// it hasn't succeeded since the daemon started.
+	LastSuccess time.Time `json:"last-success,omitempty"`
+
+	// ChangeID is the ID of the change that records this check's most recent
+	// run of consecutive failures (see the Change method). It's empty if the
+	// check hasn't failed.
+	ChangeID string `json:"change-id,omitempty"`
+}
+
+type jsonCheckInfo struct {
+	CheckInfo
+	FlapWindow string `json:"flap-window,omitempty"`
+}
+
+func checkInfosFromJSON(jcs []*jsonCheckInfo) []*CheckInfo {
+	checks := make([]*CheckInfo, len(jcs))
+	for i, jc := range jcs {
+		checks[i] = &jc.CheckInfo
+		checks[i].FlapWindow, _ = time.ParseDuration(jc.FlapWindow)
+	}
+	return checks
 }
 
 // Checks fetches information about specific health checks (or all of them),
@@ -78,10 +139,67 @@ func (client *Client) Checks(opts *ChecksOptions) ([]*CheckInfo, error) {
 	if len(opts.Names) > 0 {
 		query["names"] = opts.Names
 	}
-	var checks []*CheckInfo
-	_, err := client.doSync("GET", "/v1/checks", query, nil, nil, &checks)
+	var jcs []*jsonCheckInfo
+	_, err := client.doSync("GET", "/v1/checks", query, nil, nil, &jcs)
+	if err != nil {
+		return nil, err
+	}
+	return checkInfosFromJSON(jcs), nil
+}
+
+// CheckActionOptions holds the options for RunChecks, StopChecks,
+// StartChecks, and ResetChecks.
+type CheckActionOptions struct {
+	// Names is the list of check names to act on. It must not be empty.
+	Names []string
+}
+
+// RunChecks runs the named checks immediately, waiting for them to finish,
+// and returns their information afterwards (ordered by check name).
+func (client *Client) RunChecks(opts *CheckActionOptions) ([]*CheckInfo, error) {
+	return client.doCheckAction("run", opts.Names)
+}
+
+// StopChecks stops running the named checks until they're started again
+// with StartChecks, even if the daemon is restarted, and returns their
+// information.
+func (client *Client) StopChecks(opts *CheckActionOptions) ([]*CheckInfo, error) {
+	return client.doCheckAction("stop", opts.Names)
+}
+
+// StartChecks starts running the named checks again after StopChecks, and
+// returns their information.
+func (client *Client) StartChecks(opts *CheckActionOptions) ([]*CheckInfo, error) {
+	return client.doCheckAction("start", opts.Names)
+}
+
+// ResetChecks clears the failures of the named checks, and returns their
+// information.
+func (client *Client) ResetChecks(opts *CheckActionOptions) ([]*CheckInfo, error) {
+	return client.doCheckAction("reset", opts.Names)
+}
+
+type checkActionData struct {
+	Action string   `json:"action"`
+	Checks []string `json:"checks"`
+}
+
+func (client *Client) doCheckAction(actionName string, checks []string) ([]*CheckInfo, error) {
+	action := checkActionData{
+		Action: actionName,
+		Checks: checks,
+	}
+	data, err := json.Marshal(&action)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal check action: %w", err)
+	}
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+	var jcs []*jsonCheckInfo
+	_, err = client.doSync("POST", "/v1/checks", nil, headers, bytes.NewBuffer(data), &jcs)
 	if err != nil {
 		return nil, err
 	}
-	return checks, nil
+	return checkInfosFromJSON(jcs), nil
 }
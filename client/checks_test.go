@@ -15,7 +15,9 @@
 package client_test
 
 import (
+	"encoding/json"
 	"net/url"
+	"time"
 
 	"gopkg.in/check.v1"
 
@@ -26,7 +28,9 @@ func (cs *clientSuite) TestChecksGet(c *check.C) {
 	cs.rsp = `{
 		"result": [
 			{"name": "chk1", "status": "up"},
-			{"name": "chk3", "status": "down", "failures": 42}
+			{"name": "chk3", "status": "down", "failures": 42, "last-error": "exit status 1",
+			 "error-details": "some output", "last-success": "2023-04-05T06:07:08Z", "change-id": "7",
+			 "successes": 1, "success-threshold": 2, "flap-threshold": 4, "flap-window": "5m0s", "flapping": true}
 		],
 		"status": "OK",
 		"status-code": 200,
@@ -44,9 +48,19 @@ func (cs *clientSuite) TestChecksGet(c *check.C) {
 			Name:   "chk1",
 			Status: client.CheckStatusUp,
 		}, {
-			Name:     "chk3",
-			Status:   client.CheckStatusDown,
-			Failures: 42,
+			Name:         "chk3",
+			Status:       client.CheckStatusDown,
+			Failures:     42,
+			LastError:    "exit status 1",
+			ErrorDetails: "some output",
+			LastSuccess:  time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC),
+			ChangeID:     "7",
+
+			Successes:        1,
+			SuccessThreshold: 2,
+			FlapThreshold:    4,
+			FlapWindow:       5 * time.Minute,
+			Flapping:         true,
 		}})
 	c.Assert(cs.req.Method, check.Equals, "GET")
 	c.Assert(cs.req.URL.Path, check.Equals, "/v1/checks")
@@ -55,3 +69,50 @@ func (cs *clientSuite) TestChecksGet(c *check.C) {
 		"names": {"chk1", "chk3"},
 	})
 }
+
+func (cs *clientSuite) TestCheckActions(c *check.C) {
+	cs.rsp = `{
+		"result": [
+			{"name": "chk1", "status": "stopped", "threshold": 3},
+			{"name": "chk2", "status": "down", "failures": 1, "threshold": 1, "last-error": "exit status 1"}
+		],
+		"status": "OK",
+		"status-code": 200,
+		"type": "sync"
+	}`
+
+	opts := client.CheckActionOptions{
+		Names: []string{"chk1", "chk2"},
+	}
+	actions := map[string]func(*client.CheckActionOptions) ([]*client.CheckInfo, error){
+		"run":   cs.cli.RunChecks,
+		"stop":  cs.cli.StopChecks,
+		"start": cs.cli.StartChecks,
+		"reset": cs.cli.ResetChecks,
+	}
+	for action, f := range actions {
+		cs.req = nil
+		checks, err := f(&opts)
+		c.Assert(err, check.IsNil)
+		c.Check(checks, check.DeepEquals, []*client.CheckInfo{{
+			Name:      "chk1",
+			Status:    client.CheckStatusStopped,
+			Threshold: 3,
+		}, {
+			Name:      "chk2",
+			Status:    client.CheckStatusDown,
+			Failures:  1,
+			Threshold: 1,
+			LastError: "exit status 1",
+		}})
+		c.Check(cs.req.Method, check.Equals, "POST")
+		c.Check(cs.req.URL.Path, check.Equals, "/v1/checks")
+
+		var body map[string]interface{}
+		c.Assert(json.NewDecoder(cs.req.Body).Decode(&body), check.IsNil)
+		c.Check(body, check.DeepEquals, map[string]interface{}{
+			"action": action,
+			"checks": []interface{}{"chk1", "chk2"},
+		})
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type EventsOptions struct {
+	// WriteEvent is called to write a single event to the output (required).
+	WriteEvent func(event Event) error
+
+	// Types is the list of event types to fetch ("service", "check",
+	// "change", "task", "warning" or "layer"). Nil or empty means all types.
+	Types []string
+
+	// Names is the list of names (service, check or layer names, or change
+	// or task IDs) to fetch events for. Nil or empty means all names.
+	Names []string
+
+	// N defines the number of recent events to return. In follow mode, the
+	// default is zero, in non-follow mode it's server-defined (currently
+	// 30). Set to -1 to return all the recent events the server has kept.
+	N int
+
+	// Follow keeps the request open and writes new events as they happen,
+	// until the context is cancelled.
+	Follow bool
+}
+
+// Event is the struct passed to the WriteEvent function.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Name    string    `json:"name,omitempty"`
+	Status  string    `json:"status,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// Events fetches recent events (such as services and checks changing
+// status), and if opts.Follow is set, streams new events until the context
+// is cancelled.
+func (client *Client) Events(ctx context.Context, opts *EventsOptions) error {
+	query := url.Values{}
+	if len(opts.Types) > 0 {
+		query.Set("types", strings.Join(opts.Types, ","))
+	}
+	if len(opts.Names) > 0 {
+		query.Set("names", strings.Join(opts.Names, ","))
+	}
+	if opts.N != 0 {
+		query.Set("n", strconv.Itoa(opts.N))
+	}
+	if opts.Follow {
+		query.Set("follow", "true")
+	}
+	res, err := client.raw(ctx, "GET", "/v1/events", query, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	reader := bufio.NewReaderSize(res.Body, logReaderSize)
+	for {
+		err = decodeEvent(reader, opts.WriteEvent)
+		if errors.Is(err, io.EOF) || errors.Is(err, context.Canceled) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decode next JSON event from reader and call writeEvent on it. Return io.EOF
+// if no more events to read.
+func decodeEvent(reader *bufio.Reader, writeEvent func(event Event) error) error {
+	b, err := reader.ReadSlice('\n')
+	if errors.Is(err, io.EOF) {
+		return io.EOF
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read event line: %w", err)
+	}
+
+	var event Event
+	err = json.Unmarshal(b, &event)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal event: %w", err)
+	}
+
+	err = writeEvent(event)
+	if err != nil {
+		return fmt.Errorf("cannot output event: %w", err)
+	}
+	return nil
+}
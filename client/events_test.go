@@ -0,0 +1,105 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package client_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/canonical/pebble/client"
+)
+
+func (cs *clientSuite) TestEvents(c *check.C) {
+	cs.rsp = `
+{"time":"2023-03-04T05:06:07Z","type":"service","name":"svc1","status":"backoff"}
+{"time":"2023-03-04T05:06:08Z","type":"warning","message":"a warning"}
+`[1:]
+	var events []client.Event
+	err := cs.cli.Events(context.Background(), &client.EventsOptions{
+		WriteEvent: func(event client.Event) error {
+			events = append(events, event)
+			return nil
+		},
+		Types: []string{"service", "warning"},
+		Names: []string{"svc1"},
+		N:     -1,
+	})
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.Method, check.Equals, "GET")
+	c.Check(cs.req.URL.Path, check.Equals, "/v1/events")
+	c.Check(cs.req.URL.Query(), check.DeepEquals, url.Values{
+		"types": {"service,warning"},
+		"names": {"svc1"},
+		"n":     {"-1"},
+	})
+	c.Check(events, check.DeepEquals, []client.Event{{
+		Time:   time.Date(2023, 3, 4, 5, 6, 7, 0, time.UTC),
+		Type:   "service",
+		Name:   "svc1",
+		Status: "backoff",
+	}, {
+		Time:    time.Date(2023, 3, 4, 5, 6, 8, 0, time.UTC),
+		Type:    "warning",
+		Message: "a warning",
+	}})
+}
+
+func (cs *clientSuite) TestEventsFollow(c *check.C) {
+	readsChan := make(chan string)
+	cli, err := client.New(nil)
+	c.Assert(err, check.IsNil)
+	cli.SetDoer(doerFunc(func(req *http.Request) (*http.Response, error) {
+		c.Check(req.URL.Path, check.Equals, "/v1/events")
+		c.Check(req.URL.Query(), check.DeepEquals, url.Values{
+			"follow": {"true"},
+		})
+		rsp := &http.Response{
+			Body:       &followReader{readsChan},
+			Header:     make(http.Header),
+			StatusCode: http.StatusOK,
+		}
+		return rsp, nil
+	}))
+
+	go func() {
+		readsChan <- `{"time":"2023-03-04T05:06:07Z","type":"check","name":"chk1","status":"down"}` + "\n"
+		readsChan <- ""
+	}()
+	var names []string
+	err = cli.Events(context.Background(), &client.EventsOptions{
+		WriteEvent: func(event client.Event) error {
+			names = append(names, event.Name)
+			return nil
+		},
+		Follow: true,
+	})
+	c.Assert(err, check.IsNil)
+	c.Check(names, check.DeepEquals, []string{"chk1"})
+}
+
+func (cs *clientSuite) TestEventsWriteEventError(c *check.C) {
+	cs.rsp = `{"time":"2023-03-04T05:06:07Z","type":"layer","name":"base"}` + "\n"
+	err := cs.cli.Events(context.Background(), &client.EventsOptions{
+		WriteEvent: func(event client.Event) error {
+			return fmt.Errorf("ERROR!")
+		},
+	})
+	c.Assert(err, check.ErrorMatches, "cannot output event: ERROR!")
+}
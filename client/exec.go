@@ -46,6 +46,18 @@ type ExecOptions struct {
 	GroupID *int
 	Group   string
 
+	// Optional sandboxing: prevent the process from gaining privileges,
+	// set its supplementary groups (names or IDs), limit its capability
+	// bounding set and raise ambient capabilities (names like
+	// "CAP_NET_BIND_SERVICE"), run it in another root directory, and give it
+	// a private /tmp.
+	NoNewPrivileges       bool
+	SupplementaryGroups   []string
+	CapabilityBoundingSet []string
+	AmbientCapabilities   []string
+	RootDirectory         string
+	PrivateTmp            bool
+
 	// True to ask the server to set up a pseudo-terminal (PTY) for stdout
 	// (this also allows window resizing). The default is no PTY, and just
 	// to use pipes for stdout/stderr.
@@ -87,6 +99,13 @@ type execPayload struct {
 	SplitStderr bool              `json:"split-stderr,omitempty"`
 	Width       int               `json:"width,omitempty"`
 	Height      int               `json:"height,omitempty"`
+
+	NoNewPrivileges       bool     `json:"no-new-privileges,omitempty"`
+	SupplementaryGroups   []string `json:"supplementary-groups,omitempty"`
+	CapabilityBoundingSet []string `json:"capability-bounding-set,omitempty"`
+	AmbientCapabilities   []string `json:"ambient-capabilities,omitempty"`
+	RootDirectory         string   `json:"root-directory,omitempty"`
+	PrivateTmp            bool     `json:"private-tmp,omitempty"`
 }
 
 type execResult struct {
@@ -135,6 +154,13 @@ func (client *Client) Exec(opts *ExecOptions) (*ExecProcess, error) {
 		SplitStderr: opts.Stderr != nil,
 		Width:       opts.Width,
 		Height:      opts.Height,
+
+		NoNewPrivileges:       opts.NoNewPrivileges,
+		SupplementaryGroups:   opts.SupplementaryGroups,
+		CapabilityBoundingSet: opts.CapabilityBoundingSet,
+		AmbientCapabilities:   opts.AmbientCapabilities,
+		RootDirectory:         opts.RootDirectory,
+		PrivateTmp:            opts.PrivateTmp,
 	}
 	var body bytes.Buffer
 	err := json.NewEncoder(&body).Encode(&payload)
@@ -70,3 +70,31 @@ func (client *Client) PlanBytes(_ *PlanOptions) (data []byte, err error) {
 	}
 	return []byte(dataStr), nil
 }
+
+type EncryptSecretOptions struct {
+	// Value is the secret value to encrypt.
+	Value string
+}
+
+// EncryptSecret encrypts a secret value with the daemon's key. The returned
+// value can be used in a service's environment in a layer, and is only
+// decrypted by the daemon when the service is started.
+func (client *Client) EncryptSecret(opts *EncryptSecretOptions) (string, error) {
+	var payload = struct {
+		Action string `json:"action"`
+		Value  string `json:"value"`
+	}{
+		Action: "encrypt",
+		Value:  opts.Value,
+	}
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(&payload); err != nil {
+		return "", err
+	}
+	var encrypted string
+	_, err := client.doSync("POST", "/v1/secrets", nil, nil, &body, &encrypted)
+	if err != nil {
+		return "", err
+	}
+	return encrypted, nil
+}
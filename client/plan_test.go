@@ -75,3 +75,22 @@ services:
         command: cmd
 `[1:])
 }
+
+func (cs *clientSuite) TestEncryptSecret(c *check.C) {
+	cs.rsp = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": "pebble-secret:abcd"
+	}`
+	encrypted, err := cs.cli.EncryptSecret(&client.EncryptSecretOptions{Value: "hunter2"})
+	c.Assert(err, check.IsNil)
+	c.Check(encrypted, check.Equals, "pebble-secret:abcd")
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, "/v1/secrets")
+	var body map[string]interface{}
+	c.Assert(json.NewDecoder(cs.req.Body).Decode(&body), check.IsNil)
+	c.Assert(body, check.DeepEquals, map[string]interface{}{
+		"action": "encrypt",
+		"value":  "hunter2",
+	})
+}
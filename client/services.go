@@ -53,6 +53,13 @@ func (client *Client) Restart(opts *ServiceOptions) (changeID string, err error)
 	return changeID, err
 }
 
+// Reload reloads the services named in opts.Names, using each service's
+// configured reload command or reload signal (SIGHUP by default).
+func (client *Client) Reload(opts *ServiceOptions) (changeID string, err error) {
+	_, changeID, err = client.doMultiServiceAction("reload", opts.Names)
+	return changeID, err
+}
+
 // Replan stops and (re)starts the services whose configuration has changed
 // since they were started. opts.Names must be empty for this call.
 func (client *Client) Replan(opts *ServiceOptions) (changeID string, err error) {
@@ -84,6 +91,10 @@ type ServicesOptions struct {
 	// Names is the list of service names to query for. If slice is nil or
 	// empty, fetch information for all services.
 	Names []string
+
+	// Stats requests resource usage figures (read from /proc) for running
+	// services.
+	Stats bool
 }
 
 // ServiceInfo holds status information for a single service.
@@ -92,6 +103,39 @@ type ServiceInfo struct {
 	Startup      ServiceStartup `json:"startup"`
 	Current      ServiceStatus  `json:"current"`
 	CurrentSince time.Time      `json:"current-since"`
+
+	// Usage is the service's resource usage. It's only set for an active
+	// service, if the server supports cgroup v2.
+	Usage *ServiceUsage `json:"usage,omitempty"`
+
+	// Stats holds resource usage figures for a running service, summed over
+	// its process group. It's only set if requested with
+	// ServicesOptions.Stats.
+	Stats *ServiceStats `json:"stats,omitempty"`
+}
+
+// ServiceStats holds resource usage figures for a service's processes.
+type ServiceStats struct {
+	// CPUSeconds is the user and system CPU time used.
+	CPUSeconds float64 `json:"cpu-seconds"`
+
+	// RSSBytes is the resident set size (memory in use).
+	RSSBytes uint64 `json:"rss-bytes"`
+
+	// FDs is the number of open file descriptors.
+	FDs int `json:"fds"`
+
+	// Threads is the number of threads.
+	Threads int `json:"threads"`
+}
+
+// ServiceUsage holds the resource usage of a service.
+type ServiceUsage struct {
+	// MemoryBytes is the memory currently used by the service's processes.
+	MemoryBytes uint64 `json:"memory-bytes"`
+
+	// CPUSeconds is the total CPU time used by the service's processes.
+	CPUSeconds float64 `json:"cpu-seconds"`
 }
 
 // ServiceStartup defines the different startup modes for a service.
@@ -109,6 +153,7 @@ const (
 	StatusActive   ServiceStatus = "active"
 	StatusBackoff  ServiceStatus = "backoff"
 	StatusError    ServiceStatus = "error"
+	StatusFailed   ServiceStatus = "failed"
 	StatusInactive ServiceStatus = "inactive"
 )
 
@@ -118,6 +163,9 @@ func (client *Client) Services(opts *ServicesOptions) ([]*ServiceInfo, error) {
 	query := url.Values{
 		"names": []string{strings.Join(opts.Names, ",")},
 	}
+	if opts.Stats {
+		query.Set("stats", "true")
+	}
 	var services []*ServiceInfo
 	_, err := client.doSync("GET", "/v1/services", query, nil, nil, &services)
 	if err != nil {
@@ -88,7 +88,8 @@ func (cs *clientSuite) TestServicesGet(c *check.C) {
 	cs.rsp = `{
 		"result": [
 			{"name": "svc1", "startup": "enabled", "current": "inactive"},
-			{"name": "svc2", "startup": "disabled", "current": "active", "current-since": "2022-04-28T17:05:23Z"}
+			{"name": "svc2", "startup": "disabled", "current": "active", "current-since": "2022-04-28T17:05:23Z",
+			 "usage": {"memory-bytes": 1048576, "cpu-seconds": 1.5}}
 		],
 		"status": "OK",
 		"status-code": 200,
@@ -102,7 +103,8 @@ func (cs *clientSuite) TestServicesGet(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(services, check.DeepEquals, []*client.ServiceInfo{
 		{Name: "svc1", Startup: client.StartupEnabled, Current: client.StatusInactive},
-		{Name: "svc2", Startup: client.StartupDisabled, Current: client.StatusActive, CurrentSince: time.Date(2022, 4, 28, 17, 5, 23, 0, time.UTC)},
+		{Name: "svc2", Startup: client.StartupDisabled, Current: client.StatusActive, CurrentSince: time.Date(2022, 4, 28, 17, 5, 23, 0, time.UTC),
+			Usage: &client.ServiceUsage{MemoryBytes: 1048576, CPUSeconds: 1.5}},
 	})
 	c.Assert(cs.req.Method, check.Equals, "GET")
 	c.Assert(cs.req.URL.Path, check.Equals, "/v1/services")
@@ -111,6 +113,31 @@ func (cs *clientSuite) TestServicesGet(c *check.C) {
 	})
 }
 
+func (cs *clientSuite) TestServicesGetStats(c *check.C) {
+	cs.rsp = `{
+		"result": [
+			{"name": "svc1", "startup": "enabled", "current": "active",
+			 "stats": {"cpu-seconds": 1.25, "rss-bytes": 4096, "fds": 5, "threads": 2}}
+		],
+		"status": "OK",
+		"status-code": 200,
+		"type": "sync"
+	}`
+
+	services, err := cs.cli.Services(&client.ServicesOptions{Stats: true})
+	c.Assert(err, check.IsNil)
+	c.Assert(services, check.DeepEquals, []*client.ServiceInfo{{
+		Name:    "svc1",
+		Startup: client.StartupEnabled,
+		Current: client.StatusActive,
+		Stats:   &client.ServiceStats{CPUSeconds: 1.25, RSSBytes: 4096, FDs: 5, Threads: 2},
+	}})
+	c.Assert(cs.req.URL.Query(), check.DeepEquals, url.Values{
+		"names": {""},
+		"stats": {"true"},
+	})
+}
+
 func (cs *clientSuite) TestRestart(c *check.C) {
 	cs.rsp = `{
 		"result": {},
@@ -137,6 +164,32 @@ func (cs *clientSuite) TestRestart(c *check.C) {
 	c.Check(body["services"], check.DeepEquals, []interface{}{"one", "two"})
 }
 
+func (cs *clientSuite) TestReload(c *check.C) {
+	cs.rsp = `{
+		"result": {},
+		"status": "OK",
+		"status-code": 202,
+		"type": "async",
+		"change": "42"
+	}`
+
+	opts := client.ServiceOptions{
+		Names: []string{"one", "two"},
+	}
+
+	changeId, err := cs.cli.Reload(&opts)
+	c.Check(err, check.IsNil)
+	c.Check(changeId, check.Equals, "42")
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, "/v1/services")
+
+	var body map[string]interface{}
+	c.Assert(json.NewDecoder(cs.req.Body).Decode(&body), check.IsNil)
+	c.Check(body, check.HasLen, 2)
+	c.Check(body["action"], check.Equals, "reload")
+	c.Check(body["services"], check.DeepEquals, []interface{}{"one", "two"})
+}
+
 func (cs *clientSuite) TestReplan(c *check.C) {
 	cs.rsp = `{
 		"result": {},
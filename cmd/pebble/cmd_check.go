@@ -0,0 +1,87 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/canonical/go-flags"
+
+	"github.com/canonical/pebble/client"
+)
+
+type cmdCheck struct {
+	clientMixin
+	Positional struct {
+		Action string   `positional-arg-name:"<action>" required:"1"`
+		Checks []string `positional-arg-name:"<check>" required:"1"`
+	} `positional-args:"yes"`
+}
+
+var shortCheckHelp = "Run, stop, start, or reset health checks"
+var longCheckHelp = `
+The check command acts on the health checks with the provided names. The
+action is one of:
+
+    run    Run the checks now, and wait for them to finish
+    stop   Stop running the checks (they stay stopped if the daemon restarts)
+    start  Start running stopped checks again
+    reset  Clear the checks' failures
+
+The status of the checks is shown afterwards. If a check fails when run,
+the command exits with an error.
+`
+
+func init() {
+	addCommand("check", shortCheckHelp, longCheckHelp, func() flags.Commander { return &cmdCheck{} }, nil, nil)
+}
+
+func (cmd *cmdCheck) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	var action func(*client.CheckActionOptions) ([]*client.CheckInfo, error)
+	switch cmd.Positional.Action {
+	case "run":
+		action = cmd.client.RunChecks
+	case "stop":
+		action = cmd.client.StopChecks
+	case "start":
+		action = cmd.client.StartChecks
+	case "reset":
+		action = cmd.client.ResetChecks
+	default:
+		return fmt.Errorf(`action must be "run", "stop", "start", or "reset", not %q`, cmd.Positional.Action)
+	}
+
+	opts := client.CheckActionOptions{
+		Names: cmd.Positional.Checks,
+	}
+	checks, err := action(&opts)
+	if err != nil {
+		return err
+	}
+	writeChecks(checks)
+
+	if cmd.Positional.Action == "run" {
+		for _, check := range checks {
+			if check.LastError != "" {
+				return fmt.Errorf("check %q failed: %s", check.Name, check.LastError)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gopkg.in/check.v1"
+
+	pebble "github.com/canonical/pebble/cmd/pebble"
+)
+
+func (s *PebbleSuite) TestCheckStop(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.Method, check.Equals, "POST")
+		c.Assert(r.URL.Path, check.Equals, "/v1/checks")
+		var body map[string]interface{}
+		c.Assert(json.NewDecoder(r.Body).Decode(&body), check.IsNil)
+		c.Check(body, check.DeepEquals, map[string]interface{}{
+			"action": "stop",
+			"checks": []interface{}{"chk1", "chk2"},
+		})
+		fmt.Fprint(w, `{
+    "type": "sync",
+    "status-code": 200,
+    "result": [
+		{"name": "chk1", "status": "stopped", "threshold": 3},
+		{"name": "chk2", "level": "alive", "status": "stopped", "failures": 1, "threshold": 3}
+	]
+}`)
+	})
+	rest, err := pebble.Parser(pebble.Client()).ParseArgs([]string{"check", "stop", "chk1", "chk2"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.HasLen, 0)
+	c.Check(s.Stdout(), check.Equals, `
+Check  Level  Status   Failures
+chk1   -      stopped  0/3
+chk2   alive  stopped  1/3
+`[1:])
+	c.Check(s.Stderr(), check.Equals, "")
+}
+
+func (s *PebbleSuite) TestCheckRunFailed(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.Method, check.Equals, "POST")
+		c.Assert(r.URL.Path, check.Equals, "/v1/checks")
+		fmt.Fprint(w, `{
+    "type": "sync",
+    "status-code": 200,
+    "result": [
+		{"name": "chk1", "status": "pending", "failures": 1, "threshold": 3, "last-error": "exit status 1"}
+	]
+}`)
+	})
+	_, err := pebble.Parser(pebble.Client()).ParseArgs([]string{"check", "run", "chk1"})
+	c.Assert(err, check.ErrorMatches, `check "chk1" failed: exit status 1`)
+	c.Check(s.Stdout(), check.Equals, `
+Check  Level  Status   Failures
+chk1   -      pending  1/3
+`[1:])
+}
+
+func (s *PebbleSuite) TestCheckInvalidAction(c *check.C) {
+	_, err := pebble.Parser(pebble.Client()).ParseArgs([]string{"check", "foo", "chk1"})
+	c.Assert(err, check.ErrorMatches, `action must be "run", "stop", "start", or "reset", not "foo"`)
+}
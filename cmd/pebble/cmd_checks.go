@@ -16,6 +16,7 @@ package main
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/canonical/go-flags"
 
@@ -24,21 +25,28 @@ import (
 
 type cmdChecks struct {
 	clientMixin
+	timeMixin
 	Level      string `long:"level"`
+	Verbose    bool   `long:"verbose"`
 	Positional struct {
 		Checks []string `positional-arg-name:"<check>"`
 	} `positional-args:"yes"`
 }
 
-var checksDescs = map[string]string{
-	"level": `Check level to filter for ("alive" or "ready")`,
-}
+var checksDescs = merge(timeDescs, map[string]string{
+	"level":   `Check level to filter for ("alive" or "ready")`,
+	"verbose": "Show more information, including details of the last failure",
+})
 
 var shortChecksHelp = "Query the status of configured health checks"
 var longChecksHelp = `
 The checks command lists status information about the configured health
 checks, optionally filtered by level and check names provided as positional
 arguments.
+
+Each run of consecutive check failures is recorded in a change; use
+'pebble checks --verbose' to show the ID of the most recent one along with
+details of the last failure, and 'pebble tasks <change-id>' to see its log.
 `
 
 func (cmd *cmdChecks) Execute(args []string) error {
@@ -63,6 +71,15 @@ func (cmd *cmdChecks) Execute(args []string) error {
 		return nil
 	}
 
+	if cmd.Verbose {
+		return cmd.writeVerbose(checks)
+	}
+	writeChecks(checks)
+	return nil
+}
+
+// writeChecks writes a table of the checks' status to Stdout.
+func writeChecks(checks []*client.CheckInfo) {
 	w := tabWriter()
 	defer w.Flush()
 
@@ -75,6 +92,54 @@ func (cmd *cmdChecks) Execute(args []string) error {
 		}
 		fmt.Fprintf(w, "%s\t%s\t%s\t%d/%d\n", check.Name, level, check.Status, check.Failures, check.Threshold)
 	}
+}
+
+// writeVerbose writes the checks' information in a YAML-like format, one
+// document per check (as "pebble warnings --verbose" does).
+func (cmd *cmdChecks) writeVerbose(checks []*client.CheckInfo) error {
+	w := tabWriter()
+	for i, check := range checks {
+		if i > 0 {
+			fmt.Fprintln(w, "---")
+		}
+		level := check.Level
+		if level == client.UnsetLevel {
+			level = "-"
+		}
+		lastSuccess := "-"
+		if !check.LastSuccess.IsZero() {
+			lastSuccess = cmd.fmtTime(check.LastSuccess)
+		}
+		changeID := check.ChangeID
+		if changeID == "" {
+			changeID = "-"
+		}
+		fmt.Fprintf(w, "check:\t%s\n", check.Name)
+		fmt.Fprintf(w, "level:\t%s\n", level)
+		if check.Service != "" {
+			fmt.Fprintf(w, "service:\t%s\n", check.Service)
+		}
+		fmt.Fprintf(w, "status:\t%s\n", check.Status)
+		fmt.Fprintf(w, "failures:\t%d/%d\n", check.Failures, check.Threshold)
+		if check.SuccessThreshold > 1 {
+			fmt.Fprintf(w, "successes:\t%d/%d\n", check.Successes, check.SuccessThreshold)
+		}
+		if check.Flapping {
+			fmt.Fprintf(w, "flapping:\ttrue\n")
+		}
+		fmt.Fprintf(w, "last-success:\t%s\n", lastSuccess)
+		fmt.Fprintf(w, "change-id:\t%s\n", changeID)
+		if check.LastError != "" {
+			fmt.Fprintf(w, "last-error:\t%s\n", check.LastError)
+		}
+		w.Flush()
+		if check.ErrorDetails != "" {
+			fmt.Fprintln(Stdout, "error-details: |")
+			for _, line := range strings.Split(strings.TrimRight(check.ErrorDetails, "\n"), "\n") {
+				fmt.Fprintf(Stdout, "  %s\n", line)
+			}
+		}
+	}
 	return nil
 }
 
@@ -51,6 +51,50 @@ chk3   alive  down    42/3
 	c.Check(s.Stderr(), check.Equals, "")
 }
 
+func (s *PebbleSuite) TestChecksVerbose(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.Method, check.Equals, "GET")
+		c.Assert(r.URL.Path, check.Equals, "/v1/checks")
+		fmt.Fprint(w, `{
+    "type": "sync",
+    "status-code": 200,
+    "result": [
+		{"name": "chk1", "status": "up", "threshold": 3, "last-success": "2023-04-05T06:07:08Z"},
+		{"name": "chk2", "level": "alive", "service": "svc2", "status": "down", "failures": 3, "threshold": 3,
+		 "successes": 1, "success-threshold": 2, "flap-threshold": 4, "flap-window": "10m0s", "flapping": true,
+		 "last-success": "2023-04-05T06:07:08Z", "change-id": "7", "last-error": "exit status 1",
+		 "error-details": "line 1\nline 2\n"}
+	]
+}`)
+	})
+	rest, err := pebble.Parser(pebble.Client()).ParseArgs([]string{"checks", "--verbose", "--abs-time"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.HasLen, 0)
+	c.Check(s.Stdout(), check.Equals, `
+check:         chk1
+level:         -
+status:        up
+failures:      0/3
+last-success:  2023-04-05T06:07:08Z
+change-id:     -
+---
+check:         chk2
+level:         alive
+service:       svc2
+status:        down
+failures:      3/3
+successes:     1/2
+flapping:      true
+last-success:  2023-04-05T06:07:08Z
+change-id:     7
+last-error:    exit status 1
+error-details: |
+  line 1
+  line 2
+`[1:])
+	c.Check(s.Stderr(), check.Equals, "")
+}
+
 func (s *PebbleSuite) TestPlanNoChecks(c *check.C) {
 	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
 		c.Assert(r.Method, check.Equals, "GET")
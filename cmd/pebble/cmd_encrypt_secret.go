@@ -0,0 +1,70 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/canonical/go-flags"
+
+	"github.com/canonical/pebble/client"
+)
+
+type cmdEncryptSecret struct {
+	clientMixin
+	Positional struct {
+		Value string `positional-arg-name:"<value>"`
+	} `positional-args:"yes"`
+}
+
+var shortEncryptSecretHelp = "Encrypt a secret value for use in a layer"
+var longEncryptSecretHelp = `
+The encrypt-secret command encrypts a secret value with the daemon's key
+and prints the result, which can be used as the value of a variable in a
+service's environment. The value is only decrypted when the service is
+started, and is redacted in the output of "pebble plan".
+
+If no value is given, it is read from standard input (without a trailing
+newline), which keeps it out of the shell's history.
+`
+
+func (cmd *cmdEncryptSecret) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+	value := cmd.Positional.Value
+	if value == "" {
+		data, err := ioutil.ReadAll(Stdin)
+		if err != nil {
+			return err
+		}
+		value = strings.TrimSuffix(string(data), "\n")
+	}
+	if value == "" {
+		return fmt.Errorf("cannot encrypt an empty value")
+	}
+	encrypted, err := cmd.client.EncryptSecret(&client.EncryptSecretOptions{Value: value})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(Stdout, encrypted)
+	return nil
+}
+
+func init() {
+	addCommand("encrypt-secret", shortEncryptSecretHelp, longEncryptSecretHelp, func() flags.Commander { return &cmdEncryptSecret{} }, nil, nil)
+}
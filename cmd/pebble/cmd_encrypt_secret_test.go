@@ -0,0 +1,60 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+
+	"gopkg.in/check.v1"
+
+	pebble "github.com/canonical/pebble/cmd/pebble"
+)
+
+func (s *PebbleSuite) TestEncryptSecret(c *check.C) {
+	for _, stdin := range []bool{false, true} {
+		s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+			c.Check(r.Method, check.Equals, "POST")
+			c.Check(r.URL.Path, check.Equals, "/v1/secrets")
+			body := DecodedRequestBody(c, r)
+			c.Check(body, check.DeepEquals, map[string]interface{}{
+				"action": "encrypt",
+				"value":  "hunter2",
+			})
+			fmt.Fprint(w, `{
+    "type": "sync",
+    "status-code": 200,
+    "result": "pebble-secret:abcd"
+}`)
+		})
+
+		args := []string{"encrypt-secret", "hunter2"}
+		if stdin {
+			args = []string{"encrypt-secret"}
+			s.stdin.WriteString("hunter2\n")
+		}
+		rest, err := pebble.Parser(pebble.Client()).ParseArgs(args)
+		c.Assert(err, check.IsNil)
+		c.Assert(rest, check.HasLen, 0)
+		c.Check(s.Stdout(), check.Equals, "pebble-secret:abcd\n")
+		c.Check(s.Stderr(), check.Equals, "")
+		s.ResetStdStreams()
+	}
+}
+
+func (s *PebbleSuite) TestEncryptSecretEmpty(c *check.C) {
+	_, err := pebble.Parser(pebble.Client()).ParseArgs([]string{"encrypt-secret"})
+	c.Assert(err, check.ErrorMatches, "cannot encrypt an empty value")
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/go-flags"
+
+	"github.com/canonical/pebble/client"
+)
+
+type cmdEvents struct {
+	clientMixin
+	Follow     bool     `short:"f" long:"follow"`
+	Format     string   `long:"format"`
+	N          string   `short:"n"`
+	Types      []string `long:"type"`
+	Positional struct {
+		Names []string `positional-arg-name:"<name>"`
+	} `positional-args:"yes"`
+}
+
+var eventsDescs = map[string]string{
+	"follow": "Follow (tail) events until Ctrl-C is pressed.",
+	"format": "Output format: \"text\" (default) or \"json\" (JSON lines).",
+	"n":      "Number of recent events to show (before following); defaults\nto 30, or 0 when following. If 'all', show all recent events.",
+	"type":   "Only show events of this type: \"service\", \"check\", \"change\",\n\"task\", \"warning\" or \"layer\" (may be repeated).",
+}
+
+var shortEventsHelp = "Show events such as services and checks changing status"
+var longEventsHelp = `
+The events command shows recent events, such as services and checks changing
+status, changes and tasks progressing, warnings, and layers being added. If
+names are given, only show events for those services, checks or layers (or
+change or task IDs).
+`
+
+func (cmd *cmdEvents) Execute(args []string) error {
+	var n int
+	switch cmd.N {
+	case "":
+		n = 0 // use the server's default
+	case "all":
+		n = -1
+	default:
+		var err error
+		n, err = strconv.Atoi(cmd.N)
+		if err != nil || n < 0 {
+			return fmt.Errorf(`expected n to be a non-negative integer or "all", not %q`, cmd.N)
+		}
+	}
+
+	var writeEvent func(event client.Event) error
+	switch cmd.Format {
+	case "", "text":
+		writeEvent = func(event client.Event) error {
+			fields := []string{event.Time.Format(logTimeFormat), event.Type}
+			for _, field := range []string{event.Name, event.Status, event.Message} {
+				if field != "" {
+					fields = append(fields, field)
+				}
+			}
+			_, err := fmt.Fprintln(Stdout, strings.Join(fields, " "))
+			return err
+		}
+
+	case "json":
+		encoder := json.NewEncoder(Stdout)
+		encoder.SetEscapeHTML(false)
+		writeEvent = func(event client.Event) error {
+			return encoder.Encode(&event)
+		}
+
+	default:
+		return fmt.Errorf(`invalid output format (expected "json" or "text", not %q)`, cmd.Format)
+	}
+
+	opts := client.EventsOptions{
+		WriteEvent: writeEvent,
+		Types:      cmd.Types,
+		Names:      cmd.Positional.Names,
+		N:          n,
+		Follow:     cmd.Follow,
+	}
+	ctx := context.Background()
+	if cmd.Follow {
+		// Stop following when Ctrl-C pressed (SIGINT).
+		ctx = notifyContext(ctx, os.Interrupt)
+	}
+	return cmd.client.Events(ctx, &opts)
+}
+
+func init() {
+	addCommand("events", shortEventsHelp, longEventsHelp, func() flags.Commander { return &cmdEvents{} }, eventsDescs, nil)
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	. "gopkg.in/check.v1"
+
+	pebble "github.com/canonical/pebble/cmd/pebble"
+)
+
+var eventsResponse = `
+{"time":"2023-03-04T05:06:07.123Z","type":"service","name":"svc1","status":"backoff"}
+{"time":"2023-03-04T05:06:08Z","type":"change","name":"5","status":"Done","message":"Start service \"svc1\""}
+{"time":"2023-03-04T05:06:09Z","type":"warning","message":"something happened"}
+`[1:]
+
+func (s *PebbleSuite) TestEventsText(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "GET")
+		c.Check(r.URL.Path, Equals, "/v1/events")
+		c.Check(r.URL.Query(), HasLen, 0)
+		fmt.Fprint(w, eventsResponse)
+	})
+	rest, err := pebble.Parser(pebble.Client()).ParseArgs([]string{"events"})
+	c.Assert(err, IsNil)
+	c.Assert(rest, HasLen, 0)
+	c.Check(s.Stdout(), Equals, `
+2023-03-04T05:06:07.123Z service svc1 backoff
+2023-03-04T05:06:08.000Z change 5 Done Start service "svc1"
+2023-03-04T05:06:09.000Z warning something happened
+`[1:])
+	c.Check(s.Stderr(), Equals, "")
+}
+
+func (s *PebbleSuite) TestEventsJSONFiltered(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, Equals, "/v1/events")
+		c.Check(r.URL.Query(), DeepEquals, url.Values{
+			"types":  {"service,check"},
+			"names":  {"svc1,chk1"},
+			"n":      {"-1"},
+			"follow": {"true"},
+		})
+		fmt.Fprint(w, `{"time":"2023-03-04T05:06:07Z","type":"service","name":"svc1","status":"backoff"}`+"\n")
+	})
+	rest, err := pebble.Parser(pebble.Client()).ParseArgs([]string{
+		"events", "--format", "json", "--follow", "-n", "all",
+		"--type", "service", "--type", "check", "svc1", "chk1"})
+	c.Assert(err, IsNil)
+	c.Assert(rest, HasLen, 0)
+	c.Check(s.Stdout(), Equals, `{"time":"2023-03-04T05:06:07Z","type":"service","name":"svc1","status":"backoff"}`+"\n")
+	c.Check(s.Stderr(), Equals, "")
+}
+
+func (s *PebbleSuite) TestEventsInvalid(c *C) {
+	_, err := pebble.Parser(pebble.Client()).ParseArgs([]string{"events", "--format", "invalid"})
+	c.Assert(err, ErrorMatches, `invalid output format \(expected "json" or "text", not "invalid"\)`)
+
+	_, err = pebble.Parser(pebble.Client()).ParseArgs([]string{"events", "-n", "x"})
+	c.Assert(err, ErrorMatches, `expected n to be a non-negative integer or "all", not "x"`)
+}
@@ -172,11 +172,11 @@ var helpCategories = []helpCategory{{
 }, {
 	Label:       "Plan",
 	Description: "view and change configuration",
-	Commands:    []string{"add", "plan"},
+	Commands:    []string{"add", "plan", "encrypt-secret", "sign-layer"},
 }, {
 	Label:       "Services",
 	Description: "manage services",
-	Commands:    []string{"services", "logs", "checks", "start", "restart", "signal", "stop", "replan"},
+	Commands:    []string{"services", "logs", "events", "checks", "check", "start", "restart", "reload", "signal", "stop", "replan"},
 }, {
 	Label:       "Files",
 	Description: "work with files and execute commands",
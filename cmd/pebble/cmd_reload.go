@@ -0,0 +1,61 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"github.com/canonical/go-flags"
+
+	"github.com/canonical/pebble/client"
+)
+
+var shortReloadHelp = "Reload a service"
+var longReloadHelp = `
+The reload command asks the named service(s) to reload their configuration
+without restarting, by running the service's reload-command or by sending
+it the reload-signal (SIGHUP by default).
+`
+
+type cmdReload struct {
+	waitMixin
+	Positional struct {
+		Services []string `positional-arg-name:"<service>" required:"1"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("reload", shortReloadHelp, longReloadHelp, func() flags.Commander { return &cmdReload{} }, waitDescs, nil)
+}
+
+func (cmd cmdReload) Execute(args []string) error {
+	if len(args) > 1 {
+		return ErrExtraArgs
+	}
+
+	servopts := client.ServiceOptions{
+		Names: cmd.Positional.Services,
+	}
+	changeID, err := cmd.client.Reload(&servopts)
+	if err != nil {
+		return err
+	}
+
+	if _, err := cmd.wait(changeID); err != nil {
+		if err == noWait {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
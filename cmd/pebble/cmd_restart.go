@@ -22,7 +22,8 @@ import (
 
 var shortRestartHelp = "Restart a service"
 var longRestartHelp = `
-The restart command restarts the named service(s) in the correct order.
+The restart command restarts the named service(s) in the correct order. A
+group or template service name restarts all of its services.
 `
 
 type cmdRestart struct {
@@ -34,20 +34,30 @@ import (
 var shortRunHelp = "Run the pebble environment"
 var longRunHelp = `
 The run command starts pebble and runs the configured environment.
+
+Sending pebble the SIGHUP signal makes it re-execute itself (for example,
+after it has been upgraded) without stopping the running services, which
+are then adopted by the new instance.
 `
 
 type sharedRunEnterOpts struct {
-	CreateDirs bool   `long:"create-dirs"`
-	Hold       bool   `long:"hold"`
-	HTTP       string `long:"http"`
-	Verbose    bool   `short:"v" long:"verbose"`
+	CreateDirs   bool   `long:"create-dirs"`
+	Hold         bool   `long:"hold"`
+	HTTP         string `long:"http"`
+	ProbeHTTP    string `long:"probe-http"`
+	Verbose      bool   `short:"v" long:"verbose"`
+	GuestMetrics bool   `long:"guest-metrics"`
+	Cgroups      bool   `long:"cgroups"`
 }
 
 var sharedRunEnterOptsHelp = map[string]string{
-	"create-dirs": "Create pebble directory on startup if it doesn't exist",
-	"hold":        "Do not start default services automatically",
-	"http":        `Start HTTP API listening on this address (e.g., ":4000")`,
-	"verbose":     "Log all output from services to stdout",
+	"create-dirs":   "Create pebble directory on startup if it doesn't exist",
+	"hold":          "Do not start default services automatically",
+	"http":          `Start HTTP API listening on this address (e.g., ":4000")`,
+	"probe-http":    `Serve the /livez and /readyz health probes on this address (e.g., ":8081")`,
+	"verbose":       "Log all output from services to stdout",
+	"guest-metrics": "Allow anyone to read the metrics API (for example, over HTTP)",
+	"cgroups":       "Run each service in its own cgroup (requires cgroup v2)",
 }
 
 type cmdRun struct {
@@ -72,9 +82,14 @@ func (rcmd *cmdRun) Execute(args []string) error {
 
 func (rcmd *cmdRun) run(ready chan<- func()) {
 	sigs := make(chan os.Signal, 2)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
 
 	if err := runDaemon(rcmd, sigs, ready); err != nil {
+		if err == daemon.ErrReexec {
+			err = reexec()
+			fmt.Fprintf(os.Stderr, "cannot re-execute pebble: %v\n", err)
+			panic(&exitStatus{1})
+		}
 		if err == daemon.ErrRestartSocket {
 			// No "error: " prefix as this isn't an error.
 			fmt.Fprintf(os.Stdout, "%v\n", err)
@@ -86,6 +101,18 @@ func (rcmd *cmdRun) run(ready chan<- func()) {
 	}
 }
 
+// reexec replaces the current process with a new instance of the pebble
+// binary (which may have been upgraded since it was started), run with the
+// same arguments. It only returns if that fails.
+func reexec() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	logger.Noticef("Re-executing %s.", exe)
+	return syscall.Exec(exe, os.Args, os.Environ())
+}
+
 func runWatchdog(d *daemon.Daemon) (*time.Ticker, error) {
 	if os.Getenv("WATCHDOG_USEC") == "" {
 		// Not running under systemd.
@@ -140,6 +167,9 @@ func runDaemon(rcmd *cmdRun, ch chan os.Signal, ready chan<- func()) error {
 		dopts.ServiceOutput = os.Stdout
 	}
 	dopts.HTTPAddress = rcmd.HTTP
+	dopts.ProbeAddress = rcmd.ProbeHTTP
+	dopts.GuestMetrics = rcmd.GuestMetrics
+	dopts.Cgroups = rcmd.Cgroups
 
 	d, err := daemon.New(&dopts)
 	if err != nil {
@@ -196,6 +226,13 @@ out:
 	for {
 		select {
 		case sig := <-ch:
+			if sig == syscall.SIGHUP {
+				// Leave the services running, to be adopted by the
+				// re-executed daemon.
+				logger.Noticef("Re-executing on %s signal.\n", sig)
+				d.Reexec()
+				break out
+			}
 			logger.Noticef("Exiting on %s signal.\n", sig)
 			break out
 		case <-d.Dying():
@@ -16,8 +16,11 @@ package main
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/canonical/go-flags"
+	"github.com/canonical/x-go/strutil/quantity"
 
 	"github.com/canonical/pebble/client"
 )
@@ -25,15 +28,21 @@ import (
 type cmdServices struct {
 	clientMixin
 	timeMixin
+	Stats      bool `long:"stats"`
 	Positional struct {
 		Services []string `positional-arg-name:"<service>"`
 	} `positional-args:"yes"`
 }
 
+var servicesDescs = map[string]string{
+	"stats": "Show resource usage of running services (CPU time, memory, open files and threads)",
+}
+
 var shortServicesHelp = "Query the status of configured services"
 var longServicesHelp = `
 The services command lists status information about the services specified, or
-about all services if none are specified.
+about all services if none are specified. A group or template service name
+lists all of its services.
 `
 
 func (cmd *cmdServices) Execute(args []string) error {
@@ -43,6 +52,7 @@ func (cmd *cmdServices) Execute(args []string) error {
 
 	opts := client.ServicesOptions{
 		Names: cmd.Positional.Services,
+		Stats: cmd.Stats,
 	}
 	services, err := cmd.client.Services(&opts)
 	if err != nil {
@@ -60,14 +70,29 @@ func (cmd *cmdServices) Execute(args []string) error {
 	w := tabWriter()
 	defer w.Flush()
 
-	fmt.Fprintln(w, "Service\tStartup\tCurrent\tSince")
+	if cmd.Stats {
+		fmt.Fprintln(w, "Service\tStartup\tCurrent\tSince\tCPU\tRSS\tFDs\tThreads")
+	} else {
+		fmt.Fprintln(w, "Service\tStartup\tCurrent\tSince")
+	}
 
 	for _, svc := range services {
 		since := "-"
 		if !svc.CurrentSince.IsZero() {
 			since = cmd.fmtTime(svc.CurrentSince)
 		}
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", svc.Name, svc.Startup, svc.Current, since)
+		if !cmd.Stats {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", svc.Name, svc.Startup, svc.Current, since)
+			continue
+		}
+		cpu, rss, fds, threads := "-", "-", "-", "-"
+		if st := svc.Stats; st != nil {
+			cpu = strings.TrimSpace(quantity.FormatDuration(st.CPUSeconds))
+			rss = quantity.FormatAmount(st.RSSBytes, -1) + "B"
+			fds = strconv.Itoa(st.FDs)
+			threads = strconv.Itoa(st.Threads)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", svc.Name, svc.Startup, svc.Current, since, cpu, rss, fds, threads)
 	}
 	return nil
 }
@@ -75,5 +100,5 @@ func (cmd *cmdServices) Execute(args []string) error {
 func init() {
 	addCommand("services", shortServicesHelp, longServicesHelp,
 		func() flags.Commander { return &cmdServices{} },
-		timeDescs, nil)
+		merge(servicesDescs, timeDescs), nil)
 }
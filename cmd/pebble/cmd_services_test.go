@@ -128,3 +128,29 @@ func (s *PebbleSuite) TestServicesFail(c *check.C) {
 	c.Check(s.Stdout(), check.Equals, "")
 	c.Check(s.Stderr(), check.Equals, "")
 }
+
+func (s *PebbleSuite) TestServicesStats(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.Method, check.Equals, "GET")
+		c.Assert(r.URL.Path, check.Equals, "/v1/services")
+		c.Assert(r.URL.Query(), check.DeepEquals, url.Values{"names": {""}, "stats": {"true"}})
+		fmt.Fprint(w, `{
+    "type": "sync",
+    "status-code": 200,
+    "result": [
+		{"name": "svc1", "current": "active", "startup": "enabled", "current-since": "2022-04-28T17:05:23+12:00",
+		 "stats": {"cpu-seconds": 75.2, "rss-bytes": 5242880, "fds": 12, "threads": 3}},
+		{"name": "svc2", "current": "inactive", "startup": "enabled"}
+	]
+}`)
+	})
+	rest, err := pebble.Parser(pebble.Client()).ParseArgs([]string{"services", "--stats", "--abs-time"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.HasLen, 0)
+	c.Check(s.Stdout(), check.Equals, `
+Service  Startup  Current   Since                      CPU    RSS     FDs  Threads
+svc1     enabled  active    2022-04-28T17:05:23+12:00  1m15s  5.24MB  12   3
+svc2     enabled  inactive  -                          -      -       -    -
+`[1:])
+	c.Check(s.Stderr(), check.Equals, "")
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/canonical/go-flags"
+
+	"github.com/canonical/pebble/internal/plan"
+)
+
+type cmdSignLayer struct {
+	Key        string `long:"key" required:"1"`
+	Label      string `long:"label" required:"1"`
+	Combine    bool   `long:"combine"`
+	Positional struct {
+		LayerPath string `positional-arg-name:"<layer-path>" required:"1"`
+	} `positional-args:"yes"`
+}
+
+var signLayerDescs = map[string]string{
+	"key":     `Path of the ed25519 private key (in PEM format) to sign with`,
+	"label":   `Label the layer is added with (for a file in the layers directory, the label in its name)`,
+	"combine": `Sign the layer to be combined with an existing layer, as with "pebble add --combine"`,
+}
+
+var shortSignLayerHelp = "Sign a layer so it's accepted with trusted keys"
+var longSignLayerHelp = `
+The sign-layer command signs the layer YAML at the given path with an
+ed25519 private key, and prints the layer with the signature appended as a
+final comment line. If the layer is already signed, its signature is
+replaced. The signature is only valid for the given label, and for
+adding the layer with or without --combine, as specified.
+
+When the pebble directory has public keys in its "trusted-keys"
+sub-directory, only layers signed by one of the corresponding private keys
+are accepted, both from the "layers" directory and by "pebble add".
+
+A key pair can be generated with:
+
+    openssl genpkey -algorithm ed25519 -out layers-key.pem
+    openssl pkey -in layers-key.pem -pubout -out layers-key.pub
+`
+
+func (cmd *cmdSignLayer) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+	keyData, err := ioutil.ReadFile(cmd.Key)
+	if err != nil {
+		return err
+	}
+	key, err := plan.ParsePrivateKey(keyData)
+	if err != nil {
+		return fmt.Errorf("cannot parse private key: %v", err)
+	}
+	data, err := ioutil.ReadFile(cmd.Positional.LayerPath)
+	if err != nil {
+		return err
+	}
+	// Check it's a valid layer before signing it.
+	_, err = plan.ParseLayer(0, "layer", data)
+	if err != nil {
+		return err
+	}
+	Stdout.Write(plan.SignLayer(data, cmd.Label, cmd.Combine, key))
+	return nil
+}
+
+func init() {
+	addCommand("sign-layer", shortSignLayerHelp, longSignLayerHelp, func() flags.Commander { return &cmdSignLayer{} }, signLayerDescs, nil)
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main_test
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/check.v1"
+
+	pebble "github.com/canonical/pebble/cmd/pebble"
+	"github.com/canonical/pebble/internal/plan"
+)
+
+func (s *PebbleSuite) TestSignLayer(c *check.C) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	c.Assert(err, check.IsNil)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	c.Assert(err, check.IsNil)
+	dir := c.MkDir()
+	keyPath := filepath.Join(dir, "key.pem")
+	err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	c.Assert(err, check.IsNil)
+	layer := "services:\n    foo:\n        override: replace\n        command: cmd\n"
+	layerPath := filepath.Join(dir, "layer.yaml")
+	err = ioutil.WriteFile(layerPath, []byte(layer), 0644)
+	c.Assert(err, check.IsNil)
+
+	rest, err := pebble.Parser(pebble.Client()).ParseArgs([]string{"sign-layer", "--key", keyPath, "--label", "base", layerPath})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.HasLen, 0)
+	c.Check(strings.HasPrefix(s.Stdout(), layer), check.Equals, true)
+	c.Check(plan.VerifyLayer([]byte(s.Stdout()), "base", false, []ed25519.PublicKey{publicKey}), check.IsNil)
+	c.Check(s.Stderr(), check.Equals, "")
+	s.ResetStdStreams()
+
+	_, err = pebble.Parser(pebble.Client()).ParseArgs([]string{"sign-layer", "--key", keyPath, "--label", "base", "--combine", layerPath})
+	c.Assert(err, check.IsNil)
+	c.Check(plan.VerifyLayer([]byte(s.Stdout()), "base", true, []ed25519.PublicKey{publicKey}), check.IsNil)
+	c.Check(plan.VerifyLayer([]byte(s.Stdout()), "base", false, []ed25519.PublicKey{publicKey}), check.NotNil)
+}
+
+func (s *PebbleSuite) TestSignLayerBadKey(c *check.C) {
+	dir := c.MkDir()
+	keyPath := filepath.Join(dir, "key.pem")
+	err := ioutil.WriteFile(keyPath, []byte("not a key"), 0600)
+	c.Assert(err, check.IsNil)
+
+	_, err = pebble.Parser(pebble.Client()).ParseArgs([]string{"sign-layer", "--key", keyPath, "--label", "base", "layer.yaml"})
+	c.Assert(err, check.ErrorMatches, "cannot parse private key: no PEM data found")
+}
@@ -22,7 +22,8 @@ import (
 var shortStartHelp = "Start a service and its dependencies"
 var longStartHelp = `
 The start command starts the service with the provided name and
-any other services it depends on, in the correct order.
+any other services it depends on, in the correct order. A group or template
+service name starts all of its services.
 `
 
 type cmdStart struct {
@@ -22,7 +22,8 @@ import (
 var shortStopHelp = "Stop a service and its dependents"
 var longStopHelp = `
 The stop command stops the service with the provided name and
-any other service that depends on it, in the correct order.
+any other service that depends on it, in the correct order. A group or
+template service name stops all of its services.
 `
 
 type cmdStop struct {
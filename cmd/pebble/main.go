@@ -31,6 +31,7 @@ import (
 
 	"github.com/canonical/pebble/client"
 	"github.com/canonical/pebble/internal/logger"
+	"github.com/canonical/pebble/internal/sandbox"
 )
 
 var (
@@ -312,6 +313,10 @@ var (
 var clientConfig client.Config
 
 func main() {
+	// If this process is the exec helper for a sandboxed command, run that
+	// command instead (see the sandbox package).
+	sandbox.RunHelper()
+
 	defer func() {
 		if v := recover(); v != nil {
 			if e, ok := v.(*exitStatus); ok {
@@ -0,0 +1,340 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package cgroup manages the cgroup v2 subtree that services run in, to
+// limit their resources, account for their usage, and kill all their
+// processes.
+//
+// Under the root cgroup, each service gets its own cgroup in the "services"
+// sub-directory. As a cgroup with child cgroups that have controllers
+// enabled can't also contain processes, Pebble itself is moved to a "daemon"
+// leaf cgroup if needed.
+package cgroup
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// MountPoint is where the cgroup v2 hierarchy is normally mounted.
+const MountPoint = "/sys/fs/cgroup"
+
+// cpuPeriod is the period, in microseconds, used for cpu.max quotas.
+const cpuPeriod = 100000
+
+// controllers are the controllers enabled for services, if available.
+var controllers = []string{"cpu", "memory", "pids"}
+
+// Limits are the resource limits applied to a service's cgroup. Zero values
+// mean no limit (or the default weight).
+type Limits struct {
+	// MemoryMax is the memory limit in bytes.
+	MemoryMax uint64
+
+	// CPUWeight is the relative CPU weight, from 1 to 10000 (default 100).
+	CPUWeight int
+
+	// CPUMax is the CPU limit, as a percentage of one CPU.
+	CPUMax int
+
+	// PidsMax is the maximum number of processes (and threads).
+	PidsMax int
+}
+
+// Usage is the resource usage of a service's cgroup.
+type Usage struct {
+	// MemoryBytes is the memory currently used.
+	MemoryBytes uint64
+
+	// CPUTime is the total CPU time used.
+	CPUTime time.Duration
+}
+
+// Manager manages the cgroups of services under a root cgroup.
+type Manager struct {
+	root string
+}
+
+// Detect returns the cgroup that Pebble itself is in, if the cgroup v2
+// hierarchy is mounted (in "unified" mode) at MountPoint.
+func Detect() (string, error) {
+	var st unix.Statfs_t
+	err := unix.Statfs(MountPoint, &st)
+	if err != nil {
+		return "", fmt.Errorf("cannot find cgroup hierarchy: %v", err)
+	}
+	if st.Type != unix.CGROUP2_SUPER_MAGIC {
+		return "", fmt.Errorf("cgroup v2 hierarchy not mounted at %s", MountPoint)
+	}
+	data, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return filepath.Join(MountPoint, line[len("0::"):]), nil
+		}
+	}
+	return "", fmt.Errorf("cannot find cgroup v2 path in /proc/self/cgroup")
+}
+
+// New returns a manager for service cgroups under the given root cgroup
+// directory, enabling the cpu, memory and pids controllers for them.
+func New(root string) (*Manager, error) {
+	if !filepath.IsAbs(root) {
+		return nil, fmt.Errorf("cgroup root %q must be absolute", root)
+	}
+	m := &Manager{root: root}
+	err := m.enableControllers(root)
+	if errors.Is(err, syscall.EBUSY) {
+		// The root cgroup has processes in it (usually just Pebble itself),
+		// so move Pebble to a leaf cgroup.
+		err = m.moveSelf(filepath.Join(root, "daemon"))
+		if err != nil {
+			return nil, err
+		}
+		err = m.enableControllers(root)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = os.Mkdir(m.servicesDir(), 0755)
+	if err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	err = m.enableControllers(m.servicesDir())
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Manager) servicesDir() string {
+	return filepath.Join(m.root, "services")
+}
+
+// Dir returns the directory of the service's cgroup.
+func (m *Manager) Dir(service string) string {
+	return filepath.Join(m.servicesDir(), service)
+}
+
+// enableControllers enables the available controllers for the child cgroups
+// of the cgroup in dir.
+func (m *Manager) enableControllers(dir string) error {
+	enable := controllers
+	data, err := ioutil.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err == nil {
+		available := make(map[string]bool)
+		for _, c := range strings.Fields(string(data)) {
+			available[c] = true
+		}
+		enable = nil
+		for _, c := range controllers {
+			if available[c] {
+				enable = append(enable, c)
+			}
+		}
+	}
+	if len(enable) == 0 {
+		return nil
+	}
+	value := "+" + strings.Join(enable, " +")
+	err = writeFile(filepath.Join(dir, "cgroup.subtree_control"), value)
+	if err != nil {
+		return fmt.Errorf("cannot enable cgroup controllers: %w", err)
+	}
+	return nil
+}
+
+// moveSelf moves the current process to the cgroup in leafDir, creating it
+// if needed. Other processes are left alone: if the parent cgroup still has
+// processes in it, enabling controllers will fail.
+func (m *Manager) moveSelf(leafDir string) error {
+	err := os.Mkdir(leafDir, 0755)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+	err = writeFile(filepath.Join(leafDir, "cgroup.procs"), strconv.Itoa(os.Getpid()))
+	if err != nil {
+		return fmt.Errorf("cannot move daemon to cgroup: %w", err)
+	}
+	return nil
+}
+
+// Create creates the service's cgroup (if it doesn't exist) and applies the
+// given limits to it, returning its directory.
+func (m *Manager) Create(service string, limits Limits) (string, error) {
+	dir := m.Dir(service)
+	err := os.Mkdir(dir, 0755)
+	if err != nil && !os.IsExist(err) {
+		return "", err
+	}
+
+	// Write all the limits (not just those set), to reset any limits that
+	// were set when the service was last started.
+	memoryMax := "max"
+	if limits.MemoryMax > 0 {
+		memoryMax = strconv.FormatUint(limits.MemoryMax, 10)
+	}
+	cpuWeight := 100
+	if limits.CPUWeight > 0 {
+		cpuWeight = limits.CPUWeight
+	}
+	cpuMax := fmt.Sprintf("max %d", cpuPeriod)
+	if limits.CPUMax > 0 {
+		cpuMax = fmt.Sprintf("%d %d", limits.CPUMax*cpuPeriod/100, cpuPeriod)
+	}
+	pidsMax := "max"
+	if limits.PidsMax > 0 {
+		pidsMax = strconv.Itoa(limits.PidsMax)
+	}
+	files := []struct {
+		name  string
+		value string
+		isSet bool
+	}{
+		{"memory.max", memoryMax, limits.MemoryMax > 0},
+		{"cpu.weight", strconv.Itoa(cpuWeight), limits.CPUWeight > 0},
+		{"cpu.max", cpuMax, limits.CPUMax > 0},
+		{"pids.max", pidsMax, limits.PidsMax > 0},
+	}
+	for _, f := range files {
+		err := writeFile(filepath.Join(dir, f.name), f.value)
+		if err != nil && (f.isSet || !os.IsNotExist(err)) {
+			// It's only an error if the controller isn't available when a
+			// limit is actually requested.
+			return "", fmt.Errorf("cannot set %s: %w", f.name, err)
+		}
+	}
+	return dir, nil
+}
+
+// Kill kills all the processes in the service's cgroup.
+func (m *Manager) Kill(service string) error {
+	dir := m.Dir(service)
+	err := writeFile(filepath.Join(dir, "cgroup.kill"), "1")
+	if err == nil {
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+
+	// Older kernels (before 5.14) don't have cgroup.kill, so kill the
+	// processes one by one.
+	return m.Signal(service, syscall.SIGKILL)
+}
+
+// Signal sends the signal to each process in the service's cgroup.
+func (m *Manager) Signal(service string, sig syscall.Signal) error {
+	pids, err := readPids(m.Dir(service))
+	if err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		err := syscall.Kill(pid, sig)
+		if err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
+
+// Usage returns the current resource usage of the service's cgroup.
+func (m *Manager) Usage(service string) (*Usage, error) {
+	dir := m.Dir(service)
+	var usage Usage
+	data, err := ioutil.ReadFile(filepath.Join(dir, "memory.current"))
+	if err != nil {
+		return nil, err
+	}
+	usage.MemoryBytes, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid memory.current: %v", err)
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "usage_usec" {
+			usec, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cpu.stat usage_usec: %v", err)
+			}
+			usage.CPUTime = time.Duration(usec) * time.Microsecond
+		}
+	}
+	return &usage, nil
+}
+
+func readPids(dir string) ([]int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(data)) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid process ID %q in cgroup.procs", field)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// writeFile writes a value to a cgroup interface file. Unlike
+// ioutil.WriteFile, it doesn't create the file if it doesn't exist (unless
+// the root is a plain directory, as in tests).
+func writeFile(path, value string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if os.IsNotExist(err) && !isCgroupFS(filepath.Dir(path)) {
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(value)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func isCgroupFS(dir string) bool {
+	var st unix.Statfs_t
+	err := unix.Statfs(dir, &st)
+	return err == nil && st.Type == unix.CGROUP2_SUPER_MAGIC
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cgroup_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/pebble/internal/cgroup"
+	"github.com/canonical/pebble/internal/testutil"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type cgroupSuite struct{}
+
+var _ = Suite(&cgroupSuite{})
+
+func (s *cgroupSuite) TestNew(c *C) {
+	root := c.MkDir()
+	err := ioutil.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpuset cpu io memory pids\n"), 0644)
+	c.Assert(err, IsNil)
+
+	_, err = cgroup.New(root)
+	c.Assert(err, IsNil)
+	c.Assert(filepath.Join(root, "cgroup.subtree_control"), testutil.FileEquals, "+cpu +memory +pids")
+	c.Assert(filepath.Join(root, "services", "cgroup.subtree_control"), testutil.FileEquals, "+cpu +memory +pids")
+
+	_, err = cgroup.New("relative")
+	c.Assert(err, ErrorMatches, `cgroup root "relative" must be absolute`)
+}
+
+func (s *cgroupSuite) TestCreate(c *C) {
+	m, err := cgroup.New(c.MkDir())
+	c.Assert(err, IsNil)
+
+	dir, err := m.Create("svc1", cgroup.Limits{
+		MemoryMax: 512 * 1024 * 1024,
+		CPUWeight: 50,
+		CPUMax:    150,
+		PidsMax:   100,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(dir, Equals, m.Dir("svc1"))
+	c.Assert(filepath.Join(dir, "memory.max"), testutil.FileEquals, "536870912")
+	c.Assert(filepath.Join(dir, "cpu.weight"), testutil.FileEquals, "50")
+	c.Assert(filepath.Join(dir, "cpu.max"), testutil.FileEquals, "150000 100000")
+	c.Assert(filepath.Join(dir, "pids.max"), testutil.FileEquals, "100")
+
+	// Creating it again resets the limits that are no longer set.
+	dir, err = m.Create("svc1", cgroup.Limits{PidsMax: 10})
+	c.Assert(err, IsNil)
+	c.Assert(filepath.Join(dir, "memory.max"), testutil.FileEquals, "max")
+	c.Assert(filepath.Join(dir, "cpu.weight"), testutil.FileEquals, "100")
+	c.Assert(filepath.Join(dir, "cpu.max"), testutil.FileEquals, "max 100000")
+	c.Assert(filepath.Join(dir, "pids.max"), testutil.FileEquals, "10")
+}
+
+func (s *cgroupSuite) TestKill(c *C) {
+	m, err := cgroup.New(c.MkDir())
+	c.Assert(err, IsNil)
+
+	// Killing a cgroup that doesn't exist does nothing.
+	err = m.Kill("svc1")
+	c.Assert(err, IsNil)
+
+	dir, err := m.Create("svc1", cgroup.Limits{})
+	c.Assert(err, IsNil)
+	err = m.Kill("svc1")
+	c.Assert(err, IsNil)
+	c.Assert(filepath.Join(dir, "cgroup.kill"), testutil.FileEquals, "1")
+}
+
+func (s *cgroupSuite) TestSignal(c *C) {
+	m, err := cgroup.New(c.MkDir())
+	c.Assert(err, IsNil)
+
+	// Signalling a cgroup that doesn't exist does nothing.
+	err = m.Signal("svc1", syscall.SIGTERM)
+	c.Assert(err, IsNil)
+
+	cmd := exec.Command("sleep", "10")
+	c.Assert(cmd.Start(), IsNil)
+	dir, err := m.Create("svc1", cgroup.Limits{})
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), 0644)
+	c.Assert(err, IsNil)
+
+	err = m.Signal("svc1", syscall.SIGTERM)
+	c.Assert(err, IsNil)
+	err = cmd.Wait()
+	c.Assert(err, ErrorMatches, "signal: terminated")
+}
+
+func (s *cgroupSuite) TestUsage(c *C) {
+	m, err := cgroup.New(c.MkDir())
+	c.Assert(err, IsNil)
+	dir, err := m.Create("svc1", cgroup.Limits{})
+	c.Assert(err, IsNil)
+
+	_, err = m.Usage("svc1")
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "memory.current"), []byte("1048576\n"), 0644)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(filepath.Join(dir, "cpu.stat"), []byte("usage_usec 1500000\nuser_usec 1000000\nsystem_usec 500000\n"), 0644)
+	c.Assert(err, IsNil)
+	usage, err := m.Usage("svc1")
+	c.Assert(err, IsNil)
+	c.Assert(usage, DeepEquals, &cgroup.Usage{MemoryBytes: 1048576, CPUTime: 1500 * time.Millisecond})
+}
@@ -69,6 +69,10 @@ var api = []*Command{{
 	Path:   "/v1/layers",
 	UserOK: true,
 	POST:   v1PostLayers,
+}, {
+	Path:   "/v1/secrets",
+	UserOK: true,
+	POST:   v1PostSecrets,
 }, {
 	Path:   "/v1/files",
 	UserOK: true,
@@ -94,6 +98,16 @@ var api = []*Command{{
 	Path:   "/v1/checks",
 	UserOK: true,
 	GET:    v1GetChecks,
+	POST:   v1PostChecks,
+}, {
+	Path:   "/v1/events",
+	UserOK: true,
+	GET:    v1GetEvents,
+}, {
+	// Guest access can be enabled with Options.GuestMetrics, see addRoutes.
+	Path:   "/v1/metrics",
+	UserOK: true,
+	GET:    v1GetMetrics,
 }}
 
 var (
@@ -15,19 +15,32 @@
 package daemon
 
 import (
+	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/canonical/x-go/strutil"
 
+	"github.com/canonical/pebble/internal/overlord/checkstate"
 	"github.com/canonical/pebble/internal/plan"
 )
 
 type checkInfo struct {
-	Name      string `json:"name"`
-	Level     string `json:"level,omitempty"`
-	Status    string `json:"status"`
-	Failures  int    `json:"failures,omitempty"`
-	Threshold int    `json:"threshold"`
+	Name             string     `json:"name"`
+	Level            string     `json:"level,omitempty"`
+	Service          string     `json:"service,omitempty"`
+	Status           string     `json:"status"`
+	Failures         int        `json:"failures,omitempty"`
+	Threshold        int        `json:"threshold"`
+	Successes        int        `json:"successes,omitempty"`
+	SuccessThreshold int        `json:"success-threshold"`
+	FlapThreshold    int        `json:"flap-threshold,omitempty"`
+	FlapWindow       string     `json:"flap-window,omitempty"`
+	Flapping         bool       `json:"flapping,omitempty"`
+	LastError        string     `json:"last-error,omitempty"`
+	ErrorDetails     string     `json:"error-details,omitempty"`
+	LastSuccess      *time.Time `json:"last-success,omitempty"`
+	ChangeID         string     `json:"change-id,omitempty"`
 }
 
 func v1GetChecks(c *Command, r *http.Request, _ *userState) Response {
@@ -52,15 +65,84 @@ func v1GetChecks(c *Command, r *http.Request, _ *userState) Response {
 		levelMatch := level == plan.UnsetLevel || level == check.Level
 		namesMatch := len(names) == 0 || strutil.ListContains(names, check.Name)
 		if levelMatch && namesMatch {
-			info := checkInfo{
-				Name:      check.Name,
-				Level:     string(check.Level),
-				Status:    string(check.Status),
-				Failures:  check.Failures,
-				Threshold: check.Threshold,
-			}
-			infos = append(infos, info)
+			infos = append(infos, newCheckInfo(check))
 		}
 	}
 	return SyncResponse(infos)
 }
+
+func v1PostChecks(c *Command, r *http.Request, _ *userState) Response {
+	var payload struct {
+		Action string   `json:"action"`
+		Checks []string `json:"checks"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		return statusBadRequest("cannot decode data from request body: %v", err)
+	}
+
+	checkMgr := c.d.overlord.CheckManager()
+	var action func(names []string) error
+	switch payload.Action {
+	case "run":
+		action = checkMgr.RunChecks
+	case "stop":
+		action = checkMgr.StopChecks
+	case "start":
+		action = checkMgr.StartChecks
+	case "reset":
+		action = checkMgr.ResetChecks
+	default:
+		return statusBadRequest("action %q is unsupported", payload.Action)
+	}
+	if len(payload.Checks) == 0 {
+		return statusBadRequest("no checks to %s provided", payload.Action)
+	}
+
+	err := action(payload.Checks)
+	if err != nil {
+		return statusBadRequest("%v", err)
+	}
+
+	// Respond with the checks' information after the action, so that the
+	// result of a "run" is returned directly.
+	checks, err := checkMgr.Checks()
+	if err != nil {
+		return statusInternalError("%v", err)
+	}
+	infos := []checkInfo{}
+	for _, check := range checks {
+		if strutil.ListContains(payload.Checks, check.Name) {
+			infos = append(infos, newCheckInfo(check))
+		}
+	}
+	return SyncResponse(infos)
+}
+
+func newCheckInfo(check *checkstate.CheckInfo) checkInfo {
+	info := checkInfo{
+		Name:         check.Name,
+		Level:        string(check.Level),
+		Service:      check.Service,
+		Status:       string(check.Status),
+		Failures:     check.Failures,
+		Threshold:    check.Threshold,
+		LastError:    check.LastError,
+		ErrorDetails: check.ErrorDetails,
+		ChangeID:     check.ChangeID,
+
+		Successes:        check.Successes,
+		SuccessThreshold: check.SuccessThreshold,
+		FlapThreshold:    check.FlapThreshold,
+		Flapping:         check.Flapping,
+	}
+	if check.FlapWindow != 0 {
+		info.FlapWindow = check.FlapWindow.String()
+	}
+	if !check.LastSuccess.IsZero() {
+		lastSuccess := check.LastSuccess
+		info.LastSuccess = &lastSuccess
+	}
+	return info
+}
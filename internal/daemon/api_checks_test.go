@@ -15,11 +15,16 @@
 package daemon
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"time"
 
 	. "gopkg.in/check.v1"
+
+	"github.com/canonical/pebble/internal/plan"
 )
 
 func (s *apiSuite) TestChecksGet(c *C) {
@@ -27,22 +32,29 @@ func (s *apiSuite) TestChecksGet(c *C) {
 checks:
     chk1:
         override: replace
+        startup-delay: 1m
         level: ready
         http:
             url: https://example.com/bad
 
     chk2:
         override: replace
+        startup-delay: 1m
         level: alive
+        success-threshold: 2
+        flap-threshold: 5
+        flap-window: 5m
         tcp:
             port: 8080
 
     chk3:
         override: replace
+        startup-delay: 1m
         exec:
             command: sleep x
 `)
 	s.daemon(c)
+	// Checks stay pending (and don't run) during their startup delay.
 	_, err := s.d.overlord.ServiceManager().Plan() // ensure plan is loaded
 	c.Assert(err, IsNil)
 
@@ -59,9 +71,9 @@ checks:
 	err = json.Unmarshal(rec.Body.Bytes(), &body)
 	c.Check(err, IsNil)
 	c.Check(body["result"], DeepEquals, []interface{}{
-		map[string]interface{}{"name": "chk1", "status": "up", "level": "ready", "threshold": 3.0},
-		map[string]interface{}{"name": "chk2", "status": "up", "level": "alive", "threshold": 3.0},
-		map[string]interface{}{"name": "chk3", "status": "up", "threshold": 3.0},
+		map[string]interface{}{"name": "chk1", "status": "pending", "level": "ready", "threshold": 3.0, "success-threshold": 1.0},
+		map[string]interface{}{"name": "chk2", "status": "pending", "level": "alive", "threshold": 3.0, "success-threshold": 2.0, "flap-threshold": 5.0, "flap-window": "5m0s"},
+		map[string]interface{}{"name": "chk3", "status": "pending", "threshold": 3.0, "success-threshold": 1.0},
 	})
 
 	// Request with names filter
@@ -76,8 +88,8 @@ checks:
 	err = json.Unmarshal(rec.Body.Bytes(), &body)
 	c.Check(err, IsNil)
 	c.Check(body["result"], DeepEquals, []interface{}{
-		map[string]interface{}{"name": "chk1", "status": "up", "level": "ready", "threshold": 3.0},
-		map[string]interface{}{"name": "chk3", "status": "up", "threshold": 3.0},
+		map[string]interface{}{"name": "chk1", "status": "pending", "level": "ready", "threshold": 3.0, "success-threshold": 1.0},
+		map[string]interface{}{"name": "chk3", "status": "pending", "threshold": 3.0, "success-threshold": 1.0},
 	})
 
 	// Request with names filter (comma-separated values)
@@ -92,8 +104,8 @@ checks:
 	err = json.Unmarshal(rec.Body.Bytes(), &body)
 	c.Check(err, IsNil)
 	c.Check(body["result"], DeepEquals, []interface{}{
-		map[string]interface{}{"name": "chk1", "status": "up", "level": "ready", "threshold": 3.0},
-		map[string]interface{}{"name": "chk3", "status": "up", "threshold": 3.0},
+		map[string]interface{}{"name": "chk1", "status": "pending", "level": "ready", "threshold": 3.0, "success-threshold": 1.0},
+		map[string]interface{}{"name": "chk3", "status": "pending", "threshold": 3.0, "success-threshold": 1.0},
 	})
 
 	// Request with level filter
@@ -108,7 +120,7 @@ checks:
 	err = json.Unmarshal(rec.Body.Bytes(), &body)
 	c.Check(err, IsNil)
 	c.Check(body["result"], DeepEquals, []interface{}{
-		map[string]interface{}{"name": "chk2", "status": "up", "level": "alive", "threshold": 3.0},
+		map[string]interface{}{"name": "chk2", "status": "pending", "level": "alive", "threshold": 3.0, "success-threshold": 2.0, "flap-threshold": 5.0, "flap-window": "5m0s"},
 	})
 
 	// Request with names and level filters
@@ -123,10 +135,67 @@ checks:
 	err = json.Unmarshal(rec.Body.Bytes(), &body)
 	c.Check(err, IsNil)
 	c.Check(body["result"], DeepEquals, []interface{}{
-		map[string]interface{}{"name": "chk1", "status": "up", "level": "ready", "threshold": 3.0},
+		map[string]interface{}{"name": "chk1", "status": "pending", "level": "ready", "threshold": 3.0, "success-threshold": 1.0},
 	})
 }
 
+func (s *apiSuite) TestChecksGetFailureDetails(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "some details")
+	}))
+	defer server.Close()
+	// The check runs as soon as it's added, and fails straight away (its
+	// default timeout is far longer than the server takes to respond).
+	writeTestLayer(s.pebbleDir, fmt.Sprintf(`
+checks:
+    chk1:
+        override: replace
+        threshold: 1
+        http:
+            url: %s
+`, server.URL))
+	s.daemon(c)
+	_, err := s.d.overlord.ServiceManager().Plan() // ensure plan is loaded
+	c.Assert(err, IsNil)
+	defer s.d.overlord.CheckManager().PlanChanged(&plan.Plan{})
+
+	var result []interface{}
+	for i := 0; ; i++ {
+		if i >= 100 {
+			c.Fatalf("timed out waiting for check to fail")
+		}
+		req, err := http.NewRequest("GET", "/v1/checks", nil)
+		c.Assert(err, IsNil)
+		rsp := v1GetChecks(apiCmd("/v1/checks"), req, nil).(*resp)
+		rec := httptest.NewRecorder()
+		rsp.ServeHTTP(rec, req)
+		c.Assert(rec.Code, Equals, 200)
+		var body map[string]interface{}
+		err = json.Unmarshal(rec.Body.Bytes(), &body)
+		c.Assert(err, IsNil)
+		result = body["result"].([]interface{})
+		if result[0].(map[string]interface{})["failures"] != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	info := result[0].(map[string]interface{})
+	c.Check(info["last-error"], Equals, "received non-20x status code 503")
+	c.Check(info["error-details"], Equals, "some details")
+	c.Check(info["last-success"], IsNil)
+	changeID, _ := info["change-id"].(string)
+	c.Assert(changeID, Not(Equals), "")
+
+	st := s.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	chg := st.Change(changeID)
+	c.Assert(chg, NotNil)
+	c.Check(chg.Kind(), Equals, "check-failure")
+}
+
 func (s *apiSuite) TestChecksGetInvalidLevel(c *C) {
 	s.daemon(c)
 	_, err := s.d.overlord.ServiceManager().Plan() // ensure plan is loaded
@@ -165,3 +234,101 @@ func (s *apiSuite) TestChecksEmpty(c *C) {
 	c.Check(err, IsNil)
 	c.Check(body["result"], DeepEquals, []interface{}{}) // should be [] rather than null
 }
+
+func (s *apiSuite) TestChecksPost(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	writeTestLayer(s.pebbleDir, fmt.Sprintf(`
+checks:
+    chk1:
+        override: replace
+        startup-delay: 1m
+        http:
+            url: %s
+    chk2:
+        override: replace
+        startup-delay: 1m
+        tcp:
+            port: 8080
+`, server.URL))
+	s.daemon(c)
+	_, err := s.d.overlord.ServiceManager().Plan() // ensure plan is loaded
+	c.Assert(err, IsNil)
+	defer s.d.overlord.CheckManager().PlanChanged(&plan.Plan{})
+
+	post := func(body string) (int, interface{}) {
+		req, err := http.NewRequest("POST", "/v1/checks", bytes.NewBufferString(body))
+		c.Assert(err, IsNil)
+		rsp := v1PostChecks(apiCmd("/v1/checks"), req, nil).(*resp)
+		rec := httptest.NewRecorder()
+		rsp.ServeHTTP(rec, req)
+		var result map[string]interface{}
+		err = json.Unmarshal(rec.Body.Bytes(), &result)
+		c.Assert(err, IsNil)
+		return rec.Code, result["result"]
+	}
+
+	// Running a check returns its result.
+	code, result := post(`{"action": "run", "checks": ["chk1"]}`)
+	c.Assert(code, Equals, 200)
+	c.Assert(result, HasLen, 1)
+	info := result.([]interface{})[0].(map[string]interface{})
+	c.Check(info["name"], Equals, "chk1")
+	c.Check(info["status"], Equals, "pending")
+	c.Check(info["failures"], Equals, 1.0)
+	c.Check(info["last-error"], Equals, "received non-20x status code 503")
+
+	code, result = post(`{"action": "reset", "checks": ["chk1"]}`)
+	c.Assert(code, Equals, 200)
+	c.Check(result, DeepEquals, []interface{}{
+		map[string]interface{}{"name": "chk1", "status": "pending", "threshold": 3.0, "success-threshold": 1.0, "change-id": info["change-id"]},
+	})
+
+	code, result = post(`{"action": "stop", "checks": ["chk1", "chk2"]}`)
+	c.Assert(code, Equals, 200)
+	c.Check(result, DeepEquals, []interface{}{
+		map[string]interface{}{"name": "chk1", "status": "stopped", "threshold": 3.0, "success-threshold": 1.0, "change-id": info["change-id"]},
+		map[string]interface{}{"name": "chk2", "status": "stopped", "threshold": 3.0, "success-threshold": 1.0},
+	})
+
+	code, result = post(`{"action": "start", "checks": ["chk2"]}`)
+	c.Assert(code, Equals, 200)
+	c.Check(result, DeepEquals, []interface{}{
+		map[string]interface{}{"name": "chk2", "status": "pending", "threshold": 3.0, "success-threshold": 1.0},
+	})
+}
+
+func (s *apiSuite) TestChecksPostErrors(c *C) {
+	writeTestLayer(s.pebbleDir, `
+checks:
+    chk1:
+        override: replace
+        startup-delay: 1m
+        tcp:
+            port: 8080
+`)
+	s.daemon(c)
+	_, err := s.d.overlord.ServiceManager().Plan() // ensure plan is loaded
+	c.Assert(err, IsNil)
+	defer s.d.overlord.CheckManager().PlanChanged(&plan.Plan{})
+
+	tests := []struct {
+		body    string
+		message string
+	}{
+		{`{"action": "foo", "checks": ["chk1"]}`, `action "foo" is unsupported`},
+		{`{"action": "run"}`, `no checks to run provided`},
+		{`{"action": "stop", "checks": ["chk2"]}`, `cannot find check "chk2"`},
+		{`{"action": "start", "checks": ["chk1"]`, `cannot decode data from request body: .*`},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("POST", "/v1/checks", bytes.NewBufferString(test.body))
+		c.Assert(err, IsNil)
+		rsp := v1PostChecks(apiCmd("/v1/checks"), req, nil).(*resp)
+		c.Check(rsp.Status, Equals, 400)
+		c.Check(rsp.Type, Equals, ResponseTypeError)
+		c.Check(rsp.Result.(*errorResult).Message, Matches, test.message)
+	}
+}
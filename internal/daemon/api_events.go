@@ -0,0 +1,196 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/canonical/x-go/strutil"
+
+	"github.com/canonical/pebble/internal/events"
+	"github.com/canonical/pebble/internal/logger"
+)
+
+const defaultNumEvents = 30
+
+var eventTypes = []events.Type{
+	events.TypeService,
+	events.TypeCheck,
+	events.TypeChange,
+	events.TypeTask,
+	events.TypeWarning,
+	events.TypeLayer,
+}
+
+type eventJSON struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Name    string    `json:"name,omitempty"`
+	Status  string    `json:"status,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+func newEventJSON(event events.Event) *eventJSON {
+	return &eventJSON{
+		Time:    event.Time,
+		Type:    string(event.Type),
+		Name:    event.Name,
+		Status:  event.Status,
+		Message: event.Message,
+	}
+}
+
+func v1GetEvents(c *Command, _ *http.Request, _ *userState) Response {
+	return eventsResponse{
+		hub: c.d.overlord.Events(),
+	}
+}
+
+// eventsResponse is a Response implementation to stream events in JSON Lines
+// format (like logsResponse).
+type eventsResponse struct {
+	hub *events.Hub
+}
+
+// eventFilter matches events by type and name.
+type eventFilter struct {
+	types map[events.Type]bool
+	names map[string]bool
+}
+
+func (f *eventFilter) matches(event events.Event) bool {
+	if len(f.types) > 0 && !f.types[event.Type] {
+		return false
+	}
+	if len(f.names) > 0 && !f.names[event.Name] {
+		return false
+	}
+	return true
+}
+
+func (r eventsResponse) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	filter := &eventFilter{
+		types: make(map[events.Type]bool),
+		names: make(map[string]bool),
+	}
+	for _, t := range strutil.MultiCommaSeparatedList(query["types"]) {
+		if !validEventType(events.Type(t)) {
+			response := statusBadRequest("invalid event type %q", t)
+			response.ServeHTTP(w, req)
+			return
+		}
+		filter.types[events.Type(t)] = true
+	}
+	for _, name := range strutil.MultiCommaSeparatedList(query["names"]) {
+		filter.names[name] = true
+	}
+
+	followStr := query.Get("follow")
+	if followStr != "" && followStr != "true" && followStr != "false" {
+		response := statusBadRequest(`follow parameter must be "true" or "false"`)
+		response.ServeHTTP(w, req)
+		return
+	}
+	follow := followStr == "true"
+
+	var numEvents int
+	nStr := query.Get("n")
+	if nStr != "" {
+		n, err := strconv.Atoi(nStr)
+		if err != nil || n < -1 {
+			response := statusBadRequest("n must be -1, 0, or a positive integer")
+			response.ServeHTTP(w, req)
+			return
+		}
+		numEvents = n
+	} else if follow {
+		numEvents = 0
+	} else {
+		numEvents = defaultNumEvents
+	}
+
+	var sub *events.Subscription
+	var recent []events.Event
+	if follow {
+		sub, recent = r.hub.Subscribe()
+		defer sub.Close()
+	} else {
+		recent = r.hub.Recent()
+	}
+
+	// Output the most recent "n" matching events.
+	var matching []events.Event
+	for _, event := range recent {
+		if filter.matches(event) {
+			matching = append(matching, event)
+		}
+	}
+	if numEvents >= 0 && len(matching) > numEvents {
+		matching = matching[len(matching)-numEvents:]
+	}
+
+	// Output format is JSON Lines, as for logs.
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	for _, event := range matching {
+		err := encoder.Encode(newEventJSON(event))
+		if err != nil {
+			logger.Noticef("Cannot write events: %v", err)
+			return
+		}
+	}
+	if !follow {
+		return
+	}
+	flushWriter(w)
+
+	// Following: output new events until the request is cancelled (or the
+	// subscription is dropped because the client isn't keeping up).
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if !filter.matches(event) {
+				continue
+			}
+			err := encoder.Encode(newEventJSON(event))
+			if err != nil {
+				logger.Noticef("Cannot write events: %v", err)
+				return
+			}
+			flushWriter(w)
+
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+func validEventType(t events.Type) bool {
+	for _, valid := range eventTypes {
+		if t == valid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package daemon
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/pebble/internal/events"
+)
+
+var _ = Suite(&eventsSuite{})
+
+type eventsSuite struct{}
+
+var eventTime = time.Date(2023, 3, 4, 5, 6, 7, 0, time.UTC)
+
+func (s *eventsSuite) recordResponse(c *C, url string, hub *events.Hub) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	rsp := eventsResponse{hub: hub}
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+	if rec.Code == http.StatusOK {
+		c.Assert(rec.Header().Get("Content-Type"), Equals, "application/x-ndjson")
+	} else {
+		c.Assert(rec.Header().Get("Content-Type"), Equals, "application/json")
+	}
+	return rec
+}
+
+func (s *eventsSuite) TestInvalidParams(c *C) {
+	rec := s.recordResponse(c, "/v1/events?follow=invalid", events.NewHub())
+	c.Assert(rec.Code, Equals, http.StatusBadRequest)
+	checkError(c, rec.Body.Bytes(), http.StatusBadRequest, `follow parameter must be "true" or "false"`)
+
+	rec = s.recordResponse(c, "/v1/events?n=-2", events.NewHub())
+	c.Assert(rec.Code, Equals, http.StatusBadRequest)
+	checkError(c, rec.Body.Bytes(), http.StatusBadRequest, `n must be -1, 0, or a positive integer`)
+
+	rec = s.recordResponse(c, "/v1/events?types=service,foo", events.NewHub())
+	c.Assert(rec.Code, Equals, http.StatusBadRequest)
+	checkError(c, rec.Body.Bytes(), http.StatusBadRequest, `invalid event type "foo"`)
+}
+
+func (s *eventsSuite) TestRecent(c *C) {
+	hub := events.NewHub()
+	hub.Publish(events.Event{Time: eventTime, Type: events.TypeService, Name: "svc1", Status: "active"})
+	hub.Publish(events.Event{Time: eventTime, Type: events.TypeCheck, Name: "chk1", Status: "down"})
+	hub.Publish(events.Event{Time: eventTime, Type: events.TypeWarning, Message: `a "warning"`})
+	hub.Publish(events.Event{Time: eventTime, Type: events.TypeService, Name: "svc2", Status: "backoff"})
+
+	rec := s.recordResponse(c, "/v1/events", hub)
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), Equals, `
+{"time":"2023-03-04T05:06:07Z","type":"service","name":"svc1","status":"active"}
+{"time":"2023-03-04T05:06:07Z","type":"check","name":"chk1","status":"down"}
+{"time":"2023-03-04T05:06:07Z","type":"warning","message":"a \"warning\""}
+{"time":"2023-03-04T05:06:07Z","type":"service","name":"svc2","status":"backoff"}
+`[1:])
+
+	rec = s.recordResponse(c, "/v1/events?types=service&n=1", hub)
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), Equals, `
+{"time":"2023-03-04T05:06:07Z","type":"service","name":"svc2","status":"backoff"}
+`[1:])
+
+	rec = s.recordResponse(c, "/v1/events?names=svc1,chk1", hub)
+	c.Assert(rec.Code, Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), Equals, `
+{"time":"2023-03-04T05:06:07Z","type":"service","name":"svc1","status":"active"}
+{"time":"2023-03-04T05:06:07Z","type":"check","name":"chk1","status":"down"}
+`[1:])
+}
+
+func (s *eventsSuite) TestFollow(c *C) {
+	hub := events.NewHub()
+	hub.Publish(events.Event{Time: eventTime, Type: events.TypeService, Name: "svc1", Status: "active"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, "GET", "/v1/events?follow=true&types=service", nil)
+	c.Assert(err, IsNil)
+	rsp := eventsResponse{hub: hub}
+	logChan := make(chan string)
+	rec := &followRecorder{logChan: logChan}
+	done := make(chan struct{})
+	go func() {
+		rsp.ServeHTTP(rec, req)
+		done <- struct{}{}
+	}()
+
+	waitOutput := func() string {
+		select {
+		case output := <-logChan:
+			return output
+		case <-time.After(time.Second):
+			c.Fatalf("timed out waiting for events")
+			return ""
+		}
+	}
+
+	// When following, recent events aren't output by default.
+	c.Assert(waitOutput(), Equals, "")
+
+	hub.Publish(events.Event{Time: eventTime, Type: events.TypeCheck, Name: "chk1", Status: "down"})
+	hub.Publish(events.Event{Time: eventTime, Type: events.TypeService, Name: "svc1", Status: "inactive"})
+	c.Assert(waitOutput(), Equals, `{"time":"2023-03-04T05:06:07Z","type":"service","name":"svc1","status":"inactive"}`+"\n")
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		c.Fatalf("timed out waiting for request to be finished")
+	}
+	c.Assert(rec.status, Equals, http.StatusOK)
+}
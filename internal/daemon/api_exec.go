@@ -19,11 +19,14 @@ import (
 	"fmt"
 	"net/http"
 	"os/exec"
+	"path/filepath"
 	"time"
 
 	"github.com/canonical/pebble/internal/osutil"
 	"github.com/canonical/pebble/internal/overlord/cmdstate"
 	"github.com/canonical/pebble/internal/overlord/state"
+	"github.com/canonical/pebble/internal/plan"
+	"github.com/canonical/pebble/internal/sandbox"
 )
 
 type execPayload struct {
@@ -40,6 +43,13 @@ type execPayload struct {
 	SplitStderr bool              `json:"split-stderr"`
 	Width       int               `json:"width"`
 	Height      int               `json:"height"`
+
+	NoNewPrivileges       bool     `json:"no-new-privileges"`
+	SupplementaryGroups   []string `json:"supplementary-groups"`
+	CapabilityBoundingSet []string `json:"capability-bounding-set"`
+	AmbientCapabilities   []string `json:"ambient-capabilities"`
+	RootDirectory         string   `json:"root-directory"`
+	PrivateTmp            bool     `json:"private-tmp"`
 }
 
 func v1PostExec(c *Command, req *http.Request, _ *userState) Response {
@@ -61,8 +71,29 @@ func v1PostExec(c *Command, req *http.Request, _ *userState) Response {
 		}
 	}
 
-	// Check up-front that the executable exists.
-	_, err := exec.LookPath(payload.Command[0])
+	sandboxConfig := plan.Sandbox{
+		NoNewPrivileges:       payload.NoNewPrivileges,
+		SupplementaryGroups:   payload.SupplementaryGroups,
+		CapabilityBoundingSet: payload.CapabilityBoundingSet,
+		AmbientCapabilities:   payload.AmbientCapabilities,
+		RootDirectory:         payload.RootDirectory,
+		PrivateTmp:            payload.PrivateTmp,
+	}
+	sandboxOpts, err := sandbox.PlanOptions(&sandboxConfig)
+	if err != nil {
+		return statusBadRequest("invalid sandbox options: %v", err)
+	}
+
+	// Check up-front that the executable exists (within the root directory,
+	// if one is specified).
+	if sandboxOpts != nil && sandboxOpts.RootDir != "" {
+		if !filepath.IsAbs(payload.Command[0]) {
+			return statusBadRequest("command must be an absolute path when using a root directory")
+		}
+		_, err = exec.LookPath(filepath.Join(sandboxOpts.RootDir, payload.Command[0]))
+	} else {
+		_, err = exec.LookPath(payload.Command[0])
+	}
 	if err != nil {
 		return statusBadRequest("%v", err)
 	}
@@ -89,6 +120,7 @@ func v1PostExec(c *Command, req *http.Request, _ *userState) Response {
 		SplitStderr: payload.SplitStderr,
 		Width:       payload.Width,
 		Height:      payload.Height,
+		Sandbox:     sandboxOpts,
 	}
 	task, metadata, err := cmdstate.Exec(st, args)
 	if err != nil {
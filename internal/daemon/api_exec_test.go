@@ -191,6 +191,30 @@ func (s *execSuite) TestUserIDGroupID(c *C) {
 	c.Check(stderr, Equals, "")
 }
 
+func (s *execSuite) TestSandbox(c *C) {
+	stdout, stderr, waitErr := s.exec(c, "", &client.ExecOptions{
+		Command:         []string{"grep", "NoNewPrivs", "/proc/self/status"},
+		NoNewPrivileges: true,
+	})
+	c.Check(waitErr, IsNil)
+	c.Check(stdout, Equals, "NoNewPrivs:\t1\n")
+	c.Check(stderr, Equals, "")
+}
+
+func (s *execSuite) TestSandboxInvalid(c *C) {
+	_, err := s.client.Exec(&client.ExecOptions{
+		Command:             []string{"true"},
+		AmbientCapabilities: []string{"CAP_FLY"},
+	})
+	c.Assert(err, ErrorMatches, `invalid sandbox options: ambient-capabilities invalid: unknown capability "CAP_FLY"`)
+
+	_, err = s.client.Exec(&client.ExecOptions{
+		Command:       []string{"true"},
+		RootDirectory: "/",
+	})
+	c.Assert(err, ErrorMatches, "command must be an absolute path when using a root directory")
+}
+
 func (s *execSuite) exec(c *C, stdin string, opts *client.ExecOptions) (stdout, stderr string, waitErr error) {
 	outBuf := &bytes.Buffer{}
 	errBuf := &bytes.Buffer{}
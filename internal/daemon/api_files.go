@@ -27,17 +27,19 @@ import (
 	"os"
 	"os/user"
 	pathpkg "path"
+	"path/filepath"
 	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/canonical/pebble/internal/osutil"
 	"github.com/canonical/pebble/internal/osutil/sys"
+	"github.com/canonical/pebble/internal/secrets"
 )
 
 const minBoundaryLength = 32
 
-func v1GetFiles(_ *Command, req *http.Request, _ *userState) Response {
+func v1GetFiles(c *Command, req *http.Request, _ *userState) Response {
 	query := req.URL.Query()
 	action := query.Get("action")
 	switch action {
@@ -49,7 +51,7 @@ func v1GetFiles(_ *Command, req *http.Request, _ *userState) Response {
 		if req.Header.Get("Accept") != "multipart/form-data" {
 			return statusBadRequest(`must accept multipart/form-data`)
 		}
-		return readFilesResponse{paths: paths}
+		return readFilesResponse{paths: paths, pebbleDir: c.d.pebbleDir}
 	case "list":
 		path := query.Get("path")
 		if path == "" {
@@ -75,7 +77,8 @@ type fileResult struct {
 
 // Custom Response implementation to serve the multipart.
 type readFilesResponse struct {
-	paths []string
+	paths     []string
+	pebbleDir string
 }
 
 func (r readFilesResponse) ServeHTTP(w http.ResponseWriter, req *http.Request) {
@@ -89,7 +92,7 @@ func (r readFilesResponse) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// Read each file's contents to multipart response.
 	result := make([]fileResult, len(r.paths))
 	for i, path := range r.paths {
-		err := readFile(path, mw)
+		err := readFile(path, r.pebbleDir, mw)
 		result[i] = fileResult{
 			Path:  path,
 			Error: fileErrorToResult(err),
@@ -132,7 +135,7 @@ func nonAbsolutePathError(path string) error {
 	return fmt.Errorf("paths must be absolute, got %q", path)
 }
 
-func readFile(path string, mw *multipart.Writer) error {
+func readFile(path, pebbleDir string, mw *multipart.Writer) error {
 	if !pathpkg.IsAbs(path) {
 		return nonAbsolutePathError(path)
 	}
@@ -143,6 +146,9 @@ func readFile(path string, mw *multipart.Writer) error {
 	if !info.Mode().IsRegular() {
 		return fmt.Errorf("can only read a regular file: %q", path)
 	}
+	if isSecretFile(path, info, pebbleDir) {
+		return fmt.Errorf("cannot read %q: %w", path, os.ErrPermission)
+	}
 	f, err := os.Open(path)
 	if err != nil {
 		return err
@@ -160,6 +166,25 @@ func readFile(path string, mw *multipart.Writer) error {
 	return nil
 }
 
+// isSecretFile reports whether the file is the daemon's secret key, or a
+// layer in its layers directory (which may hold values encrypted with the
+// key), neither of which the files API returns.
+func isSecretFile(path string, info os.FileInfo, pebbleDir string) bool {
+	keyInfo, err := os.Stat(secrets.KeyPath(pebbleDir))
+	if err == nil && os.SameFile(info, keyInfo) {
+		return true
+	}
+	layersDir, err := filepath.EvalSymlinks(filepath.Join(pebbleDir, "layers"))
+	if err != nil {
+		return false
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	return filepath.Dir(resolved) == layersDir
+}
+
 func fileErrorToResult(err error) *errorResult {
 	if err == nil {
 		return nil
@@ -39,7 +39,16 @@ import (
 
 var _ = Suite(&filesSuite{})
 
-type filesSuite struct{}
+type filesSuite struct {
+	pebbleDir string
+}
+
+func (s *filesSuite) SetUpTest(c *C) {
+	s.pebbleDir = c.MkDir()
+	d, err := New(&Options{Dir: s.pebbleDir})
+	c.Assert(err, IsNil)
+	d.addRoutes()
+}
 
 func (s *filesSuite) TestGetFilesInvalidAction(c *C) {
 	query := url.Values{"action": []string{"foo"}}
@@ -231,6 +240,37 @@ func (s *filesSuite) TestReadSingle(c *C) {
 	})
 }
 
+func (s *filesSuite) TestReadSecretFiles(c *C) {
+	layersDir := filepath.Join(s.pebbleDir, "layers")
+	c.Assert(os.Mkdir(layersDir, 0755), IsNil)
+	layerPath := filepath.Join(layersDir, "001-base.yaml")
+	c.Assert(ioutil.WriteFile(layerPath, []byte("services: {}\n"), 0644), IsNil)
+	linkPath := filepath.Join(c.MkDir(), "key")
+	c.Assert(os.Symlink(filepath.Join(s.pebbleDir, ".pebble.secret-key"), linkPath), IsNil)
+
+	query := url.Values{
+		"action": []string{"read"},
+		"path": []string{
+			s.pebbleDir + "/.pebble.secret-key",
+			linkPath,
+			layerPath,
+		},
+	}
+	headers := http.Header{
+		"Accept": []string{"multipart/form-data"},
+	}
+	response, body := doRequest(c, v1GetFiles, "GET", "/v1/files", query, headers, nil)
+	c.Check(response.StatusCode, Equals, http.StatusOK)
+
+	var r testFilesResponse
+	files := readMultipart(c, response, body, &r)
+	c.Check(r.Result, HasLen, 3)
+	checkFileResult(c, r.Result[0], s.pebbleDir+"/.pebble.secret-key", "permission-denied", ".*permission denied")
+	checkFileResult(c, r.Result[1], linkPath, "permission-denied", ".*permission denied")
+	checkFileResult(c, r.Result[2], layerPath, "permission-denied", ".*permission denied")
+	c.Check(files, HasLen, 0)
+}
+
 func (s *filesSuite) TestReadErrorOnRead(c *C) {
 	// You can open /proc/self/mem with error, but when you read from it
 	// at offset 0 you get a Read error -- this tests that code path.
@@ -16,16 +16,29 @@ package daemon
 
 import (
 	"net/http"
+	"net/url"
 
 	"github.com/canonical/x-go/strutil"
 
 	"github.com/canonical/pebble/internal/logger"
+	"github.com/canonical/pebble/internal/overlord"
 	"github.com/canonical/pebble/internal/overlord/checkstate"
 	"github.com/canonical/pebble/internal/plan"
 )
 
 type healthInfo struct {
-	Healthy bool `json:"healthy"`
+	Healthy bool              `json:"healthy"`
+	Checks  []healthCheckInfo `json:"checks,omitempty"`
+}
+
+// healthCheckInfo is the status of a check that contributes to the health
+// result, returned with ?verbose=true.
+type healthCheckInfo struct {
+	Name      string `json:"name"`
+	Level     string `json:"level,omitempty"`
+	Status    string `json:"status"`
+	Healthy   bool   `json:"healthy"`
+	LastError string `json:"last-error,omitempty"`
 }
 
 func v1Health(c *Command, r *http.Request, _ *userState) Response {
@@ -36,30 +49,77 @@ func v1Health(c *Command, r *http.Request, _ *userState) Response {
 	default:
 		return statusBadRequest(`level must be "alive" or "ready"`)
 	}
+	if !c.isLocalUser(r) {
+		// The checks' details (including their errors) are only shown to
+		// local users, not guests, as /v1/health doesn't require
+		// authentication.
+		query.Del("verbose")
+	}
+	return health(c.d.overlord, level, query)
+}
 
+// health returns the health of the checks at the given level, optionally
+// filtered by the "names" query parameter. If the "verbose" parameter is
+// "true", the status of each contributing check is included too.
+func health(o *overlord.Overlord, level plan.CheckLevel, query url.Values) Response {
 	names := strutil.MultiCommaSeparatedList(query["names"])
 
-	checks, err := getChecks(c.d.overlord)
+	verboseStr := query.Get("verbose")
+	if verboseStr != "" && verboseStr != "true" && verboseStr != "false" {
+		return statusBadRequest(`verbose parameter must be "true" or "false"`)
+	}
+	verbose := verboseStr == "true"
+
+	checks, err := getChecks(o)
 	if err != nil {
 		logger.Noticef("Cannot fetch checks: %v", err.Error())
 		return statusInternalError("internal server error")
 	}
 
-	healthy := true
+	info := healthInfo{Healthy: true}
 	status := http.StatusOK
 	for _, check := range checks {
 		levelMatch := level == plan.UnsetLevel || level == check.Level ||
 			level == plan.ReadyLevel && check.Level == plan.AliveLevel // ready implies alive
 		namesMatch := len(names) == 0 || strutil.ListContains(names, check.Name)
-		if levelMatch && namesMatch && check.Status != checkstate.CheckStatusUp {
-			healthy = false
+		if !levelMatch || !namesMatch {
+			continue
+		}
+		healthy := checkHealthy(check.Status, level)
+		if !healthy {
+			info.Healthy = false
 			status = http.StatusBadGateway
 		}
+		if verbose {
+			info.Checks = append(info.Checks, healthCheckInfo{
+				Name:      check.Name,
+				Level:     string(check.Level),
+				Status:    string(check.Status),
+				Healthy:   healthy,
+				LastError: check.LastError,
+			})
+		}
 	}
 
 	return SyncResponse(&resp{
 		Type:   ResponseTypeSync,
 		Status: status,
-		Result: healthInfo{Healthy: healthy},
+		Result: info,
 	})
 }
+
+// checkHealthy reports whether a check with the given status counts as
+// healthy for the requested level. A pending check (one that hasn't run or
+// succeeded yet) or a paused one (whose service isn't active) isn't ready,
+// but it doesn't mean a liveness failure either. A check stopped by the user
+// is ignored.
+func checkHealthy(status checkstate.CheckStatus, level plan.CheckLevel) bool {
+	switch status {
+	case checkstate.CheckStatusUp, checkstate.CheckStatusStopped:
+		return true
+	case checkstate.CheckStatusPending, checkstate.CheckStatusPaused:
+		return level == plan.AliveLevel
+	default:
+		return false
+	}
+}
@@ -81,10 +81,90 @@ func (s *healthSuite) TestUnhealthy(c *C) {
 	})
 }
 
+func (s *healthSuite) TestPending(c *C) {
+	restore := FakeGetChecks(func(o *overlord.Overlord) ([]*checkstate.CheckInfo, error) {
+		return []*checkstate.CheckInfo{
+			{Name: "chk1", Status: checkstate.CheckStatusUp},
+			{Name: "chk2", Status: checkstate.CheckStatusPending},
+		}, nil
+	})
+	defer restore()
+
+	status, response := serveHealth(c, "GET", "/v1/health", nil)
+
+	c.Assert(status, Equals, 502)
+	c.Assert(response, DeepEquals, map[string]interface{}{
+		"healthy": false,
+	})
+}
+
+func (s *healthSuite) TestVerbose(c *C) {
+	restore := FakeGetChecks(func(o *overlord.Overlord) ([]*checkstate.CheckInfo, error) {
+		return []*checkstate.CheckInfo{
+			{Name: "chk1", Level: plan.AliveLevel, Status: checkstate.CheckStatusUp},
+			{Name: "chk2", Level: plan.ReadyLevel, Status: checkstate.CheckStatusDown, LastError: "exit status 1"},
+			{Name: "chk3", Status: checkstate.CheckStatusPending},
+		}, nil
+	})
+	defer restore()
+
+	status, response := serveHealthAs(c, localUser, "/v1/health?verbose=true")
+	c.Assert(status, Equals, 502)
+	c.Assert(response, DeepEquals, map[string]interface{}{
+		"healthy": false,
+		"checks": []interface{}{
+			map[string]interface{}{"name": "chk1", "level": "alive", "status": "up", "healthy": true},
+			map[string]interface{}{"name": "chk2", "level": "ready", "status": "down", "healthy": false, "last-error": "exit status 1"},
+			map[string]interface{}{"name": "chk3", "status": "pending", "healthy": false},
+		},
+	})
+
+	// Only the checks that contribute to the result are listed.
+	status, response = serveHealthAs(c, localUser, "/v1/health?level=alive&verbose=true")
+	c.Assert(status, Equals, 200)
+	c.Assert(response, DeepEquals, map[string]interface{}{
+		"healthy": true,
+		"checks": []interface{}{
+			map[string]interface{}{"name": "chk1", "level": "alive", "status": "up", "healthy": true},
+		},
+	})
+
+	status, response = serveHealthAs(c, localUser, "/v1/health?verbose=false")
+	c.Assert(status, Equals, 502)
+	c.Assert(response, DeepEquals, map[string]interface{}{
+		"healthy": false,
+	})
+
+	status, response = serveHealthAs(c, localUser, "/v1/health?verbose=foo")
+	c.Assert(status, Equals, 400)
+	c.Assert(response, DeepEquals, map[string]interface{}{
+		"message": `verbose parameter must be "true" or "false"`,
+	})
+}
+
+func (s *healthSuite) TestVerboseGuest(c *C) {
+	restore := FakeGetChecks(func(o *overlord.Overlord) ([]*checkstate.CheckInfo, error) {
+		return []*checkstate.CheckInfo{
+			{Name: "chk1", Level: plan.ReadyLevel, Status: checkstate.CheckStatusDown, LastError: "exit status 1"},
+		}, nil
+	})
+	defer restore()
+
+	// Guests (such as clients of the HTTP API server, or of the untrusted
+	// socket) don't get the checks' details.
+	for _, remoteAddr := range []string{"", "127.0.0.1:1234", "pid=100;uid=1000;socket=" + untrustedSocket + ";"} {
+		status, response := serveHealthAs(c, remoteAddr, "/v1/health?verbose=true")
+		c.Check(status, Equals, 502)
+		c.Check(response, DeepEquals, map[string]interface{}{
+			"healthy": false,
+		}, Commentf("remote address %q", remoteAddr))
+	}
+}
+
 func (s *healthSuite) TestLevel(c *C) {
 	type levelTest struct {
-		aliveCheck   string // alive check: "up", "down", or no alive check
-		readyCheck   string // ready check: "up", "down", or no ready check
+		aliveCheck   string // alive check: "up", "down", "pending", "paused", "stopped", or no alive check
+		readyCheck   string // ready check: "up", "down", "pending", "paused", "stopped", or no ready check
 		aliveHealthy bool   // expected response with ?level=alive filter
 		readyHealthy bool   // expected response with ?level=ready filter
 	}
@@ -100,6 +180,11 @@ func (s *healthSuite) TestLevel(c *C) {
 		{aliveCheck: "", readyCheck: "up", aliveHealthy: true, readyHealthy: true},         // no alive check, but ready
 		{aliveCheck: "", readyCheck: "down", aliveHealthy: true, readyHealthy: false},      // no alive check, not ready
 		{aliveCheck: "", readyCheck: "", aliveHealthy: true, readyHealthy: true},           // no alive or ready check
+		{aliveCheck: "pending", readyCheck: "up", aliveHealthy: true, readyHealthy: false}, // alive pending => not ready
+		{aliveCheck: "up", readyCheck: "pending", aliveHealthy: true, readyHealthy: false}, // alive but ready pending
+		{aliveCheck: "pending", readyCheck: "", aliveHealthy: true, readyHealthy: false},   // alive pending, no ready check
+		{aliveCheck: "up", readyCheck: "paused", aliveHealthy: true, readyHealthy: false},  // ready check's service not active
+		{aliveCheck: "stopped", readyCheck: "up", aliveHealthy: true, readyHealthy: true},  // stopped checks are ignored
 	}
 
 	for _, test := range tests {
@@ -203,12 +288,27 @@ func (s *healthSuite) TestChecksError(c *C) {
 	})
 }
 
+const (
+	untrustedSocket = "/run/pebble/.pebble.socket.untrusted"
+	localUser       = "pid=100;uid=1000;socket=/run/pebble/.pebble.socket;"
+)
+
 func serveHealth(c *C, method, url string, body io.Reader) (int, map[string]interface{}) {
+	return serveHealthRequest(c, method, url, body, "")
+}
+
+// serveHealthAs serves a GET request for url from the given remote address.
+func serveHealthAs(c *C, remoteAddr, url string) (int, map[string]interface{}) {
+	return serveHealthRequest(c, "GET", url, nil, remoteAddr)
+}
+
+func serveHealthRequest(c *C, method, url string, body io.Reader, remoteAddr string) (int, map[string]interface{}) {
 	recorder := httptest.NewRecorder()
 	request, err := http.NewRequest(method, url, body)
 	c.Assert(err, IsNil)
+	request.RemoteAddr = remoteAddr
 
-	server := v1Health(&Command{d: &Daemon{}}, request, nil)
+	server := v1Health(&Command{d: &Daemon{untrustedSocketPath: untrustedSocket}}, request, nil)
 	server.ServeHTTP(recorder, request)
 
 	c.Assert(recorder.Result().Header.Get("Content-Type"), Equals, "application/json")
@@ -0,0 +1,210 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package daemon
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/canonical/pebble/internal/logger"
+	"github.com/canonical/pebble/internal/metrics"
+	"github.com/canonical/pebble/internal/overlord"
+	"github.com/canonical/pebble/internal/overlord/checkstate"
+	"github.com/canonical/pebble/internal/overlord/servstate"
+)
+
+// serviceStatuses are the possible service statuses, reported as one
+// pebble_service_status sample each so that a status with no services is
+// reported as zero rather than missing.
+var serviceStatuses = []servstate.ServiceStatus{
+	servstate.StatusActive,
+	servstate.StatusBackoff,
+	servstate.StatusError,
+	servstate.StatusFailed,
+	servstate.StatusInactive,
+}
+
+var getCheckMetrics = func(o *overlord.Overlord) []*checkstate.CheckMetrics {
+	return o.CheckManager().Metrics()
+}
+
+func v1GetMetrics(c *Command, r *http.Request, _ *userState) Response {
+	servmgr := overlordServiceManager(c.d.overlord)
+	services, err := servmgr.Services(nil)
+	if err != nil {
+		return statusInternalError("%v", err)
+	}
+
+	st := c.d.overlord.State()
+	st.Lock()
+	changeCounts := make(map[string]int)
+	for _, chg := range st.Changes() {
+		changeCounts[chg.Status().String()]++
+	}
+	taskCounts := make(map[string]int)
+	for _, t := range st.Tasks() {
+		taskCounts[t.Status().String()]++
+	}
+	st.Unlock()
+
+	return metricsResponse{
+		services:     services,
+		checks:       getCheckMetrics(c.d.overlord),
+		changeCounts: changeCounts,
+		taskCounts:   taskCounts,
+		api:          c.d.apiMetrics,
+	}
+}
+
+// metricsResponse is a Response implementation to serve the metrics in the
+// Prometheus text format.
+type metricsResponse struct {
+	services     []*servstate.ServiceInfo
+	checks       []*checkstate.CheckMetrics
+	changeCounts map[string]int
+	taskCounts   map[string]int
+	api          *apiMetrics
+}
+
+func (r metricsResponse) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	mw := metrics.NewWriter(w)
+
+	mw.Family("pebble_service_status", metrics.TypeGauge, "Whether the service is in the given status (1) or not (0).")
+	for _, svc := range r.services {
+		for _, status := range serviceStatuses {
+			mw.Sample("pebble_service_status", boolValue(svc.Current == status), "service", svc.Name, "status", string(status))
+		}
+	}
+	mw.Family("pebble_service_restarts_total", metrics.TypeCounter, "Number of times the service has been restarted automatically.")
+	for _, svc := range r.services {
+		mw.Sample("pebble_service_restarts_total", float64(svc.Restarts), "service", svc.Name)
+	}
+
+	mw.Family("pebble_check_up", metrics.TypeGauge, "Whether the check is up (1) or down (0).")
+	for _, check := range r.checks {
+		mw.Sample("pebble_check_up", boolValue(check.Up), "check", check.Name)
+	}
+	mw.Family("pebble_check_failures_total", metrics.TypeCounter, "Number of times the check has failed.")
+	for _, check := range r.checks {
+		mw.Sample("pebble_check_failures_total", float64(check.TotalFailures), "check", check.Name)
+	}
+	mw.Family("pebble_check_duration_seconds", metrics.TypeHistogram, "Time taken to run the check.")
+	for _, check := range r.checks {
+		mw.Histogram("pebble_check_duration_seconds", check.Durations, "check", check.Name)
+	}
+
+	mw.Family("pebble_changes", metrics.TypeGauge, "Number of changes in the state, by status.")
+	for _, status := range sortedKeys(r.changeCounts) {
+		mw.Sample("pebble_changes", float64(r.changeCounts[status]), "status", status)
+	}
+	mw.Family("pebble_tasks", metrics.TypeGauge, "Number of tasks in the state, by status.")
+	for _, status := range sortedKeys(r.taskCounts) {
+		mw.Sample("pebble_tasks", float64(r.taskCounts[status]), "status", status)
+	}
+
+	if r.api != nil {
+		r.api.write(mw)
+	}
+
+	err := mw.Flush()
+	if err != nil {
+		logger.Debugf("Cannot write metrics: %v", err)
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// apiMetrics records the latencies of API requests, by method and route.
+type apiMetrics struct {
+	router *mux.Router
+
+	mutex     sync.Mutex
+	latencies map[apiMetricsKey]*metrics.Histogram
+}
+
+type apiMetricsKey struct {
+	method string
+	path   string
+}
+
+func newAPIMetrics(router *mux.Router) *apiMetrics {
+	return &apiMetrics{
+		router:    router,
+		latencies: make(map[apiMetricsKey]*metrics.Histogram),
+	}
+}
+
+// observe records the latency of a request. The path is recorded as the
+// route's path template (for example, "/v1/changes/{id}") so that the number
+// of distinct label values stays small.
+func (m *apiMetrics) observe(r *http.Request, duration time.Duration) {
+	path := "unknown"
+	var match mux.RouteMatch
+	if m.router.Match(r, &match) && match.Route != nil {
+		path = match.Route.GetName()
+	}
+	key := apiMetricsKey{method: r.Method, path: path}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	h, ok := m.latencies[key]
+	if !ok {
+		h = metrics.NewHistogram(metrics.DefaultBuckets)
+		m.latencies[key] = h
+	}
+	h.ObserveDuration(duration)
+}
+
+func (m *apiMetrics) write(mw *metrics.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	keys := make([]apiMetricsKey, 0, len(m.latencies))
+	for key := range m.latencies {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].path != keys[j].path {
+			return keys[i].path < keys[j].path
+		}
+		return keys[i].method < keys[j].method
+	})
+
+	mw.Family("pebble_api_request_duration_seconds", metrics.TypeHistogram, "Time taken to handle API requests.")
+	for _, key := range keys {
+		mw.Histogram("pebble_api_request_duration_seconds", m.latencies[key], "method", key.method, "path", key.path)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package daemon
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/pebble/internal/metrics"
+	"github.com/canonical/pebble/internal/overlord"
+	"github.com/canonical/pebble/internal/overlord/checkstate"
+)
+
+func (s *apiSuite) TestMetrics(c *C) {
+	writeTestLayer(s.pebbleDir, `
+services:
+    svc1:
+        override: replace
+        command: sleep 10
+`)
+	d := s.daemon(c)
+	_, err := d.overlord.ServiceManager().Plan() // ensure plan is loaded
+	c.Assert(err, IsNil)
+
+	durations := metrics.NewHistogram([]float64{0.1, 1})
+	durations.Observe(0.05)
+	durations.Observe(0.5)
+	restore := FakeGetCheckMetrics(func(o *overlord.Overlord) []*checkstate.CheckMetrics {
+		return []*checkstate.CheckMetrics{
+			{Name: "chk1", Up: false, TotalFailures: 3, Durations: durations},
+		}
+	})
+	defer restore()
+
+	st := d.overlord.State()
+	st.Lock()
+	chg := st.NewChange("foo", "Foo")
+	chg.AddTask(st.NewTask("bar", "Bar"))
+	st.Unlock()
+
+	req, err := http.NewRequest("GET", "/v1/services", nil)
+	c.Assert(err, IsNil)
+	d.apiMetrics.observe(req, 30*time.Millisecond)
+	req, err = http.NewRequest("GET", "/v1/foo", nil)
+	c.Assert(err, IsNil)
+	d.apiMetrics.observe(req, 5*time.Second)
+
+	req, err = http.NewRequest("GET", "/v1/metrics", nil)
+	c.Assert(err, IsNil)
+	rsp := v1GetMetrics(apiCmd("/v1/metrics"), req, nil)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+	c.Check(rec.Code, Equals, 200)
+	c.Check(rec.Header().Get("Content-Type"), Equals, "text/plain; version=0.0.4; charset=utf-8")
+	c.Check(rec.Body.String(), Equals, `
+# HELP pebble_service_status Whether the service is in the given status (1) or not (0).
+# TYPE pebble_service_status gauge
+pebble_service_status{service="svc1",status="active"} 0
+pebble_service_status{service="svc1",status="backoff"} 0
+pebble_service_status{service="svc1",status="error"} 0
+pebble_service_status{service="svc1",status="failed"} 0
+pebble_service_status{service="svc1",status="inactive"} 1
+# HELP pebble_service_restarts_total Number of times the service has been restarted automatically.
+# TYPE pebble_service_restarts_total counter
+pebble_service_restarts_total{service="svc1"} 0
+# HELP pebble_check_up Whether the check is up (1) or down (0).
+# TYPE pebble_check_up gauge
+pebble_check_up{check="chk1"} 0
+# HELP pebble_check_failures_total Number of times the check has failed.
+# TYPE pebble_check_failures_total counter
+pebble_check_failures_total{check="chk1"} 3
+# HELP pebble_check_duration_seconds Time taken to run the check.
+# TYPE pebble_check_duration_seconds histogram
+pebble_check_duration_seconds_bucket{check="chk1",le="0.1"} 1
+pebble_check_duration_seconds_bucket{check="chk1",le="1"} 2
+pebble_check_duration_seconds_bucket{check="chk1",le="+Inf"} 2
+pebble_check_duration_seconds_sum{check="chk1"} 0.55
+pebble_check_duration_seconds_count{check="chk1"} 2
+# HELP pebble_changes Number of changes in the state, by status.
+# TYPE pebble_changes gauge
+pebble_changes{status="Do"} 1
+# HELP pebble_tasks Number of tasks in the state, by status.
+# TYPE pebble_tasks gauge
+pebble_tasks{status="Do"} 1
+# HELP pebble_api_request_duration_seconds Time taken to handle API requests.
+# TYPE pebble_api_request_duration_seconds histogram
+pebble_api_request_duration_seconds_bucket{method="GET",path="/v1/services",le="0.005"} 0
+pebble_api_request_duration_seconds_bucket{method="GET",path="/v1/services",le="0.01"} 0
+pebble_api_request_duration_seconds_bucket{method="GET",path="/v1/services",le="0.025"} 0
+pebble_api_request_duration_seconds_bucket{method="GET",path="/v1/services",le="0.05"} 1
+pebble_api_request_duration_seconds_bucket{method="GET",path="/v1/services",le="0.1"} 1
+pebble_api_request_duration_seconds_bucket{method="GET",path="/v1/services",le="0.25"} 1
+pebble_api_request_duration_seconds_bucket{method="GET",path="/v1/services",le="0.5"} 1
+pebble_api_request_duration_seconds_bucket{method="GET",path="/v1/services",le="1"} 1
+pebble_api_request_duration_seconds_bucket{method="GET",path="/v1/services",le="2.5"} 1
+pebble_api_request_duration_seconds_bucket{method="GET",path="/v1/services",le="5"} 1
+pebble_api_request_duration_seconds_bucket{method="GET",path="/v1/services",le="10"} 1
+pebble_api_request_duration_seconds_bucket{method="GET",path="/v1/services",le="+Inf"} 1
+pebble_api_request_duration_seconds_sum{method="GET",path="/v1/services"} 0.03
+pebble_api_request_duration_seconds_count{method="GET",path="/v1/services"} 1
+pebble_api_request_duration_seconds_bucket{method="GET",path="unknown",le="0.005"} 0
+pebble_api_request_duration_seconds_bucket{method="GET",path="unknown",le="0.01"} 0
+pebble_api_request_duration_seconds_bucket{method="GET",path="unknown",le="0.025"} 0
+pebble_api_request_duration_seconds_bucket{method="GET",path="unknown",le="0.05"} 0
+pebble_api_request_duration_seconds_bucket{method="GET",path="unknown",le="0.1"} 0
+pebble_api_request_duration_seconds_bucket{method="GET",path="unknown",le="0.25"} 0
+pebble_api_request_duration_seconds_bucket{method="GET",path="unknown",le="0.5"} 0
+pebble_api_request_duration_seconds_bucket{method="GET",path="unknown",le="1"} 0
+pebble_api_request_duration_seconds_bucket{method="GET",path="unknown",le="2.5"} 0
+pebble_api_request_duration_seconds_bucket{method="GET",path="unknown",le="5"} 1
+pebble_api_request_duration_seconds_bucket{method="GET",path="unknown",le="10"} 1
+pebble_api_request_duration_seconds_bucket{method="GET",path="unknown",le="+Inf"} 1
+pebble_api_request_duration_seconds_sum{method="GET",path="unknown"} 5
+pebble_api_request_duration_seconds_count{method="GET",path="unknown"} 1
+`[1:])
+}
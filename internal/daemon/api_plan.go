@@ -22,6 +22,7 @@ import (
 
 	"github.com/canonical/pebble/internal/overlord/servstate"
 	"github.com/canonical/pebble/internal/plan"
+	"github.com/canonical/pebble/internal/secrets"
 )
 
 func v1GetPlan(c *Command, r *http.Request, _ *userState) Response {
@@ -35,13 +36,33 @@ func v1GetPlan(c *Command, r *http.Request, _ *userState) Response {
 	if err != nil {
 		return statusInternalError("%v", err)
 	}
-	planYAML, err := yaml.Marshal(plan)
+	planYAML, err := yaml.Marshal(redactSecrets(plan))
 	if err != nil {
 		return statusInternalError("cannot serialize plan: %v", err)
 	}
 	return SyncResponse(string(planYAML))
 }
 
+// redactSecrets returns a copy of the plan with encrypted secret values in
+// services' environment replaced by a placeholder, so that they're never
+// returned to clients. Services' environment is the only place values are
+// decrypted, so other fields (such as commands, check headers and target
+// locations) are returned as is, and mustn't be used for secrets.
+func redactSecrets(p *plan.Plan) *plan.Plan {
+	redacted := *p
+	redacted.Services = make(map[string]*plan.Service, len(p.Services))
+	for name, service := range p.Services {
+		service = service.Copy()
+		for k, v := range service.Environment {
+			if secrets.IsEncrypted(v) {
+				service.Environment[k] = secrets.Redacted
+			}
+		}
+		redacted.Services[name] = service
+	}
+	return &redacted
+}
+
 func v1PostLayers(c *Command, r *http.Request, _ *userState) Response {
 	var payload struct {
 		Action  string `json:"action"`
@@ -64,6 +85,19 @@ func v1PostLayers(c *Command, r *http.Request, _ *userState) Response {
 	if payload.Format != "yaml" {
 		return statusBadRequest("invalid format %q", payload.Format)
 	}
+
+	// If there are trusted keys, only accept layers signed by one of them.
+	trustedKeys, err := plan.ReadTrustedKeys(c.d.pebbleDir)
+	if err != nil {
+		return statusInternalError("%v", err)
+	}
+	if len(trustedKeys) > 0 {
+		err := plan.VerifyLayer([]byte(payload.Layer), payload.Label, payload.Combine, trustedKeys)
+		if err != nil {
+			return statusForbidden("cannot verify layer: %v", err)
+		}
+	}
+
 	layer, err := plan.ParseLayer(0, payload.Label, []byte(payload.Layer))
 	if err != nil {
 		return statusBadRequest("cannot parse layer YAML: %v", err)
@@ -16,11 +16,20 @@ package daemon
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 
 	. "gopkg.in/check.v1"
 	"gopkg.in/yaml.v3"
+
+	"github.com/canonical/pebble/internal/plan"
 )
 
 var planLayer = `
@@ -198,3 +207,61 @@ func (s *apiSuite) TestLayersCombineFormatError(c *C) {
 	result := rsp.Result.(*errorResult)
 	c.Assert(result.Message, Matches, `layer "base" must define "override" for service "dynamic"`)
 }
+
+func (s *apiSuite) TestLayersAddSigned(c *C) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	c.Assert(err, IsNil)
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	c.Assert(err, IsNil)
+	keysDir := filepath.Join(s.pebbleDir, "trusted-keys")
+	c.Assert(os.Mkdir(keysDir, 0755), IsNil)
+	err = ioutil.WriteFile(filepath.Join(keysDir, "ci.pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+	c.Assert(err, IsNil)
+
+	_ = s.daemon(c)
+	layersCmd := apiCmd("/v1/layers")
+
+	addLayer := func(label string, combine bool, layer string) *resp {
+		payload, err := json.Marshal(map[string]interface{}{
+			"action":  "add",
+			"label":   label,
+			"combine": combine,
+			"format":  "yaml",
+			"layer":   layer,
+		})
+		c.Assert(err, IsNil)
+		req, err := http.NewRequest("POST", "/v1/layers", bytes.NewReader(payload))
+		c.Assert(err, IsNil)
+		return v1PostLayers(layersCmd, req, nil).(*resp)
+	}
+
+	layer := "services:\n dynamic:\n  override: replace\n  command: echo dynamic\n"
+
+	rsp := addLayer("signed", false, layer)
+	c.Assert(rsp.Status, Equals, http.StatusForbidden)
+	c.Assert(rsp.Result.(*errorResult).Message, Equals, "cannot verify layer: layer is not signed")
+
+	signed := string(plan.SignLayer([]byte(layer), "signed", false, privateKey))
+	tampered := bytes.Replace([]byte(signed), []byte("echo dynamic"), []byte("rm -rf /"), 1)
+	rsp = addLayer("signed", false, string(tampered))
+	c.Assert(rsp.Status, Equals, http.StatusForbidden)
+	c.Assert(rsp.Result.(*errorResult).Message, Equals, "cannot verify layer: layer signature is not valid for any trusted key")
+
+	// The signature isn't valid for another label or combine mode.
+	rsp = addLayer("other", false, signed)
+	c.Assert(rsp.Status, Equals, http.StatusForbidden)
+	c.Assert(rsp.Result.(*errorResult).Message, Equals, "cannot verify layer: layer signature is not valid for any trusted key")
+	rsp = addLayer("signed", true, signed)
+	c.Assert(rsp.Status, Equals, http.StatusForbidden)
+	c.Assert(rsp.Result.(*errorResult).Message, Equals, "cannot verify layer: layer signature is not valid for any trusted key")
+	s.planLayersHasLen(c, 0)
+
+	rsp = addLayer("signed", false, signed)
+	c.Assert(rsp.Status, Equals, 200)
+	c.Assert(s.planYAML(c), Equals, `
+services:
+    dynamic:
+        override: replace
+        command: echo dynamic
+`[1:])
+}
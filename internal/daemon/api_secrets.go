@@ -0,0 +1,41 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+func v1PostSecrets(c *Command, r *http.Request, _ *userState) Response {
+	var payload struct {
+		Action string `json:"action"`
+		Value  string `json:"value"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		return statusBadRequest("cannot decode request body: %v", err)
+	}
+	if payload.Action != "encrypt" {
+		return statusBadRequest("invalid action %q", payload.Action)
+	}
+
+	servmgr := overlordServiceManager(c.d.overlord)
+	encrypted, err := servmgr.EncryptSecret(payload.Value)
+	if err != nil {
+		return statusInternalError("%v", err)
+	}
+	return SyncResponse(encrypted)
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/pebble/internal/plan"
+)
+
+func (s *apiSuite) TestPostSecretsErrors(c *C) {
+	var tests = []struct {
+		payload string
+		status  int
+		message string
+	}{
+		{"@", 400, `cannot decode request body: invalid character '@' looking for beginning of value`},
+		{`{"action": "decrypt", "value": "x"}`, 400, `invalid action "decrypt"`},
+	}
+
+	_ = s.daemon(c)
+	secretsCmd := apiCmd("/v1/secrets")
+
+	for _, test := range tests {
+		req, err := http.NewRequest("POST", "/v1/secrets", bytes.NewBufferString(test.payload))
+		c.Assert(err, IsNil)
+		rsp := v1PostSecrets(secretsCmd, req, nil).(*resp)
+		rec := httptest.NewRecorder()
+		rsp.ServeHTTP(rec, req)
+		c.Assert(rec.Code, Equals, test.status)
+		c.Assert(rsp.Type, Equals, ResponseTypeError)
+		c.Assert(rsp.Result.(*errorResult).Message, Matches, test.message)
+	}
+}
+
+func (s *apiSuite) TestPostSecretsEncryptAndRedact(c *C) {
+	_ = s.daemon(c)
+	secretsCmd := apiCmd("/v1/secrets")
+
+	req, err := http.NewRequest("POST", "/v1/secrets", bytes.NewBufferString(`{"action": "encrypt", "value": "hunter2"}`))
+	c.Assert(err, IsNil)
+	rsp := v1PostSecrets(secretsCmd, req, nil).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+	c.Assert(rec.Code, Equals, 200)
+	c.Assert(rsp.Type, Equals, ResponseTypeSync)
+	encrypted := rsp.Result.(string)
+	c.Assert(encrypted, Matches, `pebble-secret:.+`)
+	c.Assert(strings.Contains(encrypted, "hunter2"), Equals, false)
+
+	// Add a layer using the encrypted value; the plan returned to clients has
+	// it redacted.
+	layer, err := plan.ParseLayer(0, "secret", []byte(fmt.Sprintf(`
+services:
+    svc1:
+        override: replace
+        command: foo
+        environment:
+            PASSWORD: %s
+            USER: bob
+`, encrypted)))
+	c.Assert(err, IsNil)
+	err = s.d.overlord.ServiceManager().AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	req, err = http.NewRequest("GET", "/v1/plan?format=yaml", nil)
+	c.Assert(err, IsNil)
+	rsp = v1GetPlan(apiCmd("/v1/plan"), req, nil).(*resp)
+	c.Assert(rsp.Status, Equals, 200)
+	c.Assert(rsp.Result.(string), Equals, `
+services:
+    svc1:
+        override: replace
+        command: foo
+        environment:
+            PASSWORD: pebble-secret:redacted
+            USER: bob
+`[1:])
+
+	// The plan itself still has the encrypted value.
+	c.Assert(s.planYAML(c), Matches, `(?s).*PASSWORD: `+encrypted+`\n.*`)
+}
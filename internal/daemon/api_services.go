@@ -33,10 +33,31 @@ type serviceInfo struct {
 	Startup      string     `json:"startup"`
 	Current      string     `json:"current"`
 	CurrentSince *time.Time `json:"current-since,omitempty"` // pointer as omitempty doesn't work with time.Time directly
+	Usage        *usageInfo `json:"usage,omitempty"`
+	Stats        *statsInfo `json:"stats,omitempty"`
+}
+
+type usageInfo struct {
+	MemoryBytes uint64  `json:"memory-bytes"`
+	CPUSeconds  float64 `json:"cpu-seconds"`
+}
+
+type statsInfo struct {
+	CPUSeconds float64 `json:"cpu-seconds"`
+	RSSBytes   uint64  `json:"rss-bytes"`
+	FDs        int     `json:"fds"`
+	Threads    int     `json:"threads"`
 }
 
 func v1GetServices(c *Command, r *http.Request, _ *userState) Response {
-	names := strutil.MultiCommaSeparatedList(r.URL.Query()["names"])
+	query := r.URL.Query()
+	names := strutil.MultiCommaSeparatedList(query["names"])
+
+	statsStr := query.Get("stats")
+	if statsStr != "" && statsStr != "true" && statsStr != "false" {
+		return statusBadRequest(`stats parameter must be "true" or "false"`)
+	}
+	withStats := statsStr == "true"
 
 	servmgr := overlordServiceManager(c.d.overlord)
 	services, err := servmgr.Services(names)
@@ -44,6 +65,18 @@ func v1GetServices(c *Command, r *http.Request, _ *userState) Response {
 		return statusInternalError("%v", err)
 	}
 
+	var stats map[string]*servstate.ProcessStats
+	if withStats {
+		serviceNames := make([]string, len(services))
+		for i, svc := range services {
+			serviceNames[i] = svc.Name
+		}
+		stats, err = servmgr.ServiceStats(serviceNames)
+		if err != nil {
+			return statusInternalError("cannot read service stats: %v", err)
+		}
+	}
+
 	infos := make([]serviceInfo, 0, len(services))
 	for _, svc := range services {
 		info := serviceInfo{
@@ -54,6 +87,20 @@ func v1GetServices(c *Command, r *http.Request, _ *userState) Response {
 		if !svc.CurrentSince.IsZero() {
 			info.CurrentSince = &svc.CurrentSince
 		}
+		if svc.Usage != nil {
+			info.Usage = &usageInfo{
+				MemoryBytes: svc.Usage.MemoryBytes,
+				CPUSeconds:  svc.Usage.CPUTime.Seconds(),
+			}
+		}
+		if s, ok := stats[svc.Name]; ok {
+			info.Stats = &statsInfo{
+				CPUSeconds: s.CPUTime.Seconds(),
+				RSSBytes:   s.RSS,
+				FDs:        s.FDs,
+				Threads:    s.Threads,
+			}
+		}
 		infos = append(infos, info)
 	}
 	return SyncResponse(infos)
@@ -99,6 +146,12 @@ func v1PostServices(c *Command, r *http.Request, _ *userState) Response {
 		}
 	}
 
+	// Resolve the names of template services to the names of their instances.
+	names, err := servmgr.ExpandServiceNames(payload.Services)
+	if err != nil {
+		return statusInternalError("%v", err)
+	}
+
 	st := c.d.overlord.State()
 	st.Lock()
 	defer st.Unlock()
@@ -123,7 +176,7 @@ func v1PostServices(c *Command, r *http.Request, _ *userState) Response {
 		if err != nil {
 			break
 		}
-		services = intersectOrdered(payload.Services, services)
+		services = intersectOrdered(names, services)
 		var stopTasks *state.TaskSet
 		stopTasks, err = servstate.Stop(st, services)
 		if err != nil {
@@ -142,6 +195,13 @@ func v1PostServices(c *Command, r *http.Request, _ *userState) Response {
 		taskSet = state.NewTaskSet()
 		taskSet.AddAll(stopTasks)
 		taskSet.AddAll(startTasks)
+	case "reload":
+		services, err = servmgr.StartOrder(payload.Services)
+		if err != nil {
+			break
+		}
+		services = intersectOrdered(names, services)
+		taskSet, err = servstate.Reload(st, services)
 	case "replan":
 		var stopNames, startNames []string
 		stopNames, startNames, err = servmgr.Replan()
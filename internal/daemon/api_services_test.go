@@ -280,6 +280,180 @@ func (s *apiSuite) TestServicesRestart(c *C) {
 	c.Assert(tasks[4].Summary(), Equals, `Start service "test3"`)
 }
 
+func (s *apiSuite) TestServicesReload(c *C) {
+	// Setup
+	writeTestLayer(s.pebbleDir, servicesLayer)
+	d := s.daemon(c)
+	st := d.overlord.State()
+
+	soon := 0
+	restore := FakeStateEnsureBefore(func(st *state.State, d time.Duration) {
+		soon++
+	})
+	defer restore()
+
+	servicesCmd := apiCmd("/v1/services")
+
+	payload := bytes.NewBufferString(`{"action": "reload", "services": ["test3", "test1"]}`)
+
+	// Execute
+	req, err := http.NewRequest("POST", "/v1/services", payload)
+	c.Assert(err, IsNil)
+	rsp := v1PostServices(servicesCmd, req, nil).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+
+	// Verify
+	c.Check(rec.Code, Equals, 202)
+	c.Check(rsp.Status, Equals, 202)
+	c.Check(rsp.Type, Equals, ResponseTypeAsync)
+	c.Check(rsp.Result, IsNil)
+
+	st.Lock()
+	defer st.Unlock()
+
+	chg := st.Change(rsp.Change)
+	c.Assert(chg, NotNil)
+	c.Assert(chg.Summary(), Equals, `Reload service "test3" and 1 more`)
+
+	c.Check(chg.Kind(), Equals, "reload")
+
+	tasks := chg.Tasks()
+	c.Assert(tasks, HasLen, 2)
+
+	// In the proper order, but without dependencies.
+	c.Assert(tasks[0].Summary(), Equals, `Reload service "test1"`)
+	c.Assert(tasks[1].Summary(), Equals, `Reload service "test3"`)
+}
+
+func (s *apiSuite) TestServicesRestartTemplate(c *C) {
+	// Setup
+	writeTestLayer(s.pebbleDir, `
+services:
+    worker@:
+        override: replace
+        command: worker --id {{instance}}
+        instances: 3
+`)
+	d := s.daemon(c)
+	st := d.overlord.State()
+
+	restore := FakeStateEnsureBefore(func(st *state.State, d time.Duration) {})
+	defer restore()
+
+	servicesCmd := apiCmd("/v1/services")
+
+	payload := bytes.NewBufferString(`{"action": "restart", "services": ["worker"]}`)
+
+	// Execute
+	req, err := http.NewRequest("POST", "/v1/services", payload)
+	c.Assert(err, IsNil)
+	rsp := v1PostServices(servicesCmd, req, nil).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+
+	// Verify
+	c.Check(rec.Code, Equals, 202)
+
+	st.Lock()
+	defer st.Unlock()
+
+	chg := st.Change(rsp.Change)
+	c.Assert(chg, NotNil)
+	c.Assert(chg.Summary(), Equals, `Restart service "worker" and 2 more`)
+
+	tasks := chg.Tasks()
+	c.Assert(tasks, HasLen, 6)
+	c.Check(tasks[0].Summary(), Equals, `Stop service "worker@1"`)
+	c.Check(tasks[1].Summary(), Equals, `Stop service "worker@2"`)
+	c.Check(tasks[2].Summary(), Equals, `Stop service "worker@3"`)
+	c.Check(tasks[3].Summary(), Equals, `Start service "worker@1"`)
+	c.Check(tasks[4].Summary(), Equals, `Start service "worker@2"`)
+	c.Check(tasks[5].Summary(), Equals, `Start service "worker@3"`)
+}
+
+var groupsLayer = `
+groups:
+    backend: [test1, test3]
+services:
+    test1:
+        override: replace
+        command: /bin/sh -c "sleep 300"
+        requires:
+            - test2
+    test2:
+        override: replace
+        command: /bin/sh -c "sleep 300"
+        before:
+            - test1
+    test3:
+        override: replace
+        command: some-bad-command
+        groups:
+            - extra
+`
+
+func (s *apiSuite) TestServicesGetGroup(c *C) {
+	// Setup
+	writeTestLayer(s.pebbleDir, groupsLayer)
+	s.daemon(c)
+
+	// Execute
+	req, err := http.NewRequest("GET", "/v1/services?names=backend", nil)
+	c.Assert(err, IsNil)
+	rsp := v1GetServices(apiCmd("/v1/services"), req, nil).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+
+	// Verify
+	c.Check(rec.Code, Equals, 200)
+	var body map[string]interface{}
+	err = json.Unmarshal(rec.Body.Bytes(), &body)
+	c.Check(err, IsNil)
+	c.Check(body["result"], DeepEquals, []interface{}{
+		map[string]interface{}{"startup": "disabled", "name": "test1", "current": "inactive"},
+		map[string]interface{}{"startup": "disabled", "name": "test3", "current": "inactive"},
+	})
+}
+
+func (s *apiSuite) TestServicesStartGroup(c *C) {
+	// Setup
+	writeTestLayer(s.pebbleDir, groupsLayer)
+	d := s.daemon(c)
+	st := d.overlord.State()
+
+	restore := FakeStateEnsureBefore(func(st *state.State, d time.Duration) {})
+	defer restore()
+
+	servicesCmd := apiCmd("/v1/services")
+
+	payload := bytes.NewBufferString(`{"action": "start", "services": ["backend"]}`)
+
+	// Execute
+	req, err := http.NewRequest("POST", "/v1/services", payload)
+	c.Assert(err, IsNil)
+	rsp := v1PostServices(servicesCmd, req, nil).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+
+	// Verify
+	c.Check(rec.Code, Equals, 202)
+
+	st.Lock()
+	defer st.Unlock()
+
+	chg := st.Change(rsp.Change)
+	c.Assert(chg, NotNil)
+	c.Assert(chg.Summary(), Equals, `Start service "backend" and 2 more`)
+
+	// In the proper order, with dependencies.
+	tasks := chg.Tasks()
+	c.Assert(tasks, HasLen, 3)
+	c.Check(tasks[0].Summary(), Equals, `Start service "test2"`)
+	c.Check(tasks[1].Summary(), Equals, `Start service "test1"`)
+	c.Check(tasks[2].Summary(), Equals, `Start service "test3"`)
+}
+
 func (s *apiSuite) TestServicesReplan(c *C) {
 	// Setup
 	writeTestLayer(s.pebbleDir, servicesLayer)
@@ -49,6 +49,7 @@ import (
 
 var (
 	ErrRestartSocket = fmt.Errorf("daemon stop requested to wait for socket activation")
+	ErrReexec        = fmt.Errorf("daemon stop requested to re-execute the daemon")
 
 	systemdSdNotify = systemd.SdNotify
 	sysGetuid       = sys.Getuid
@@ -69,9 +70,22 @@ type Options struct {
 	// server is not started.
 	HTTPAddress string
 
+	// ProbeAddress is the address for the health probe server, which serves
+	// /livez and /readyz without authentication (and nothing else), for
+	// example ":8081". If not set, the probe server is not started.
+	ProbeAddress string
+
 	// ServiceOuput is an optional io.Writer for the service log output, if set, all services
 	// log output will be written to the writer.
 	ServiceOutput io.Writer
+
+	// GuestMetrics allows anyone to read the metrics API (/v1/metrics),
+	// for example a Prometheus server scraping the HTTP API server.
+	GuestMetrics bool
+
+	// Cgroups runs each service in its own cgroup, if cgroup v2 is
+	// available, so its resources can be limited and its processes killed.
+	Cgroups bool
 }
 
 // A Daemon listens for requests and routes them to the right command
@@ -82,15 +96,20 @@ type Daemon struct {
 	normalSocketPath    string
 	untrustedSocketPath string
 	httpAddress         string
+	probeAddress        string
+	guestMetrics        bool
 	overlord            *overlord.Overlord
 	state               *state.State
 	generalListener     net.Listener
 	untrustedListener   net.Listener
 	httpListener        net.Listener
+	probeListener       net.Listener
 	connTracker         *connTracker
 	serve               *http.Server
+	probeServe          *http.Server
 	tomb                tomb.Tomb
 	router              *mux.Router
+	apiMetrics          *apiMetrics
 	standbyOpinions     *standby.StandbyOpinions
 
 	// set to remember we need to restart the system
@@ -100,6 +119,10 @@ type Daemon struct {
 	// prevents systemd from restarting it
 	restartSocket bool
 
+	// set to remember that the daemon is stopping to re-execute itself,
+	// leaving its services running to be adopted
+	reexec bool
+
 	// degradedErr is set when the daemon is in degraded mode
 	degradedErr error
 
@@ -211,6 +234,14 @@ func (c *Command) canAccess(r *http.Request, user *userState) accessResult {
 	return accessUnauthorized
 }
 
+// isLocalUser reports whether the request comes from a local user, identified
+// by its credentials on the Unix socket (other than the untrusted one), rather
+// than from a guest such as a client of the HTTP API server.
+func (c *Command) isLocalUser(r *http.Request) bool {
+	_, _, socket, err := ucrednetGet(r.RemoteAddr)
+	return err == nil && socket != c.d.untrustedSocketPath
+}
+
 func userFromRequest(state interface{}, r *http.Request) (*userState, error) {
 	return nil, nil
 }
@@ -324,22 +355,26 @@ func (w *wrappedWriter) status() int {
 	return w.s
 }
 
-func logit(handler http.Handler) http.Handler {
+func logit(handler http.Handler, metrics *apiMetrics) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ww := &wrappedWriter{w: w}
 		t0 := time.Now()
 		handler.ServeHTTP(ww, r)
 		t := time.Now().Sub(t0)
+		if metrics != nil {
+			metrics.observe(r, t)
+		}
 
 		// Don't log GET /v1/changes/{change-id} as that's polled quickly by
 		// clients when waiting for a change (e.g., service starting). Also
 		// don't log GET /v1/system-info or GET /v1/health to avoid hits to
 		// those filling logs with noise (Juju hits them every 5s for checking
-		// health, for example).
+		// health, for example). GET /v1/metrics is polled by Prometheus.
 		skipLog := r.Method == "GET" &&
 			(strings.HasPrefix(r.URL.Path, "/v1/changes/") && strings.Count(r.URL.Path, "/") == 3 ||
 				r.URL.Path == "/v1/system-info" ||
-				r.URL.Path == "/v1/health")
+				r.URL.Path == "/v1/health" ||
+				r.URL.Path == "/v1/metrics")
 		if !skipLog {
 			if strings.HasSuffix(r.RemoteAddr, ";") {
 				logger.Debugf("%s %s %s %s %d", r.RemoteAddr, r.Method, r.URL, t, ww.status())
@@ -381,6 +416,15 @@ func (d *Daemon) Init() error {
 		logger.Noticef("HTTP API server listening on %q.", d.httpAddress)
 	}
 
+	if d.probeAddress != "" {
+		listener, err := net.Listen("tcp", d.probeAddress)
+		if err != nil {
+			return fmt.Errorf("cannot listen on %q: %v", d.probeAddress, err)
+		}
+		d.probeListener = listener
+		logger.Noticef("Health probe server listening on %q.", d.probeAddress)
+	}
+
 	logger.Noticef("Started daemon.")
 	return nil
 }
@@ -404,6 +448,12 @@ func (d *Daemon) addRoutes() {
 
 	for _, c := range api {
 		c.d = d
+		if c.Path == "/v1/metrics" && d.guestMetrics {
+			// Copy the command, as api is shared by all daemons.
+			guestCmd := *c
+			guestCmd.GuestOK = true
+			c = &guestCmd
+		}
 		if c.PathPrefix == "" {
 			d.router.Handle(c.Path, c).Name(c.Path)
 		} else {
@@ -414,6 +464,8 @@ func (d *Daemon) addRoutes() {
 	// also maybe add a /favicon.ico handler...
 
 	d.router.NotFoundHandler = statusNotFound("invalid API endpoint requested")
+
+	d.apiMetrics = newAPIMetrics(d.router)
 }
 
 type connTracker struct {
@@ -468,7 +520,7 @@ func (d *Daemon) Start() {
 
 	d.connTracker = &connTracker{conns: make(map[net.Conn]struct{})}
 	d.serve = &http.Server{
-		Handler:   logit(d.router),
+		Handler:   logit(d.router, d.apiMetrics),
 		ConnState: d.connTracker.trackConn,
 	}
 
@@ -503,6 +555,19 @@ func (d *Daemon) Start() {
 		})
 	}
 
+	if d.probeListener != nil {
+		// Start the health probe server, which has its own handler so that
+		// only the probe endpoints are exposed.
+		d.probeServe = &http.Server{Handler: d.probeHandler()}
+		d.tomb.Go(func() error {
+			err := d.probeServe.Serve(d.probeListener)
+			if err != http.ErrServerClosed && d.tomb.Err() == tomb.ErrStillAlive {
+				return err
+			}
+			return nil
+		})
+	}
+
 	// notify systemd that we are ready
 	systemdSdNotify("READY=1")
 }
@@ -533,6 +598,16 @@ func (d *Daemon) HandleRestart(t restart.RestartType) {
 	d.tomb.Kill(nil)
 }
 
+// Reexec stops the daemon without stopping its services, so that it can be
+// re-executed (for example, after the pebble binary has been upgraded) and
+// adopt them. Stop returns ErrReexec once the daemon has stopped.
+func (d *Daemon) Reexec() {
+	d.mu.Lock()
+	d.reexec = true
+	d.mu.Unlock()
+	d.tomb.Kill(nil)
+}
+
 var (
 	rebootNoticeWait       = 3 * time.Second
 	rebootWaitTimeout      = 10 * time.Minute
@@ -552,13 +627,21 @@ func (d *Daemon) Stop(sigCh chan<- os.Signal) error {
 		return fmt.Errorf("internal error: no Overlord")
 	}
 
-	// Stop all running services. Must do this before overlord.Stop, as it
+	d.mu.Lock()
+	reexec := d.reexec
+	d.mu.Unlock()
+
+	// Stop all running services, unless the daemon is going to re-execute
+	// itself and adopt them. Must do this before overlord.Stop, as it
 	// creates a change and waits for the change, and overlord.Stop calls
 	// StateEngine.Stop, which locks, so Ensure would result in a deadlock.
-	err := d.stopRunningServices()
-	if err != nil {
-		// This isn't fatal for exiting the daemon, so log and continue.
-		logger.Noticef("Cannot stop running services: %v", err)
+	var err error
+	if !reexec {
+		err = d.stopRunningServices()
+		if err != nil {
+			// This isn't fatal for exiting the daemon, so log and continue.
+			logger.Noticef("Cannot stop running services: %v", err)
+		}
 	}
 
 	d.tomb.Kill(nil)
@@ -579,6 +662,10 @@ func (d *Daemon) Stop(sigCh chan<- os.Signal) error {
 		d.httpListener.Close()
 	}
 
+	if d.probeListener != nil {
+		d.probeListener.Close()
+	}
+
 	if restartSystem {
 		// give time to polling clients to notice restart
 		time.Sleep(rebootNoticeWait)
@@ -589,6 +676,9 @@ func (d *Daemon) Stop(sigCh chan<- os.Signal) error {
 	// called.
 	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	d.tomb.Kill(d.serve.Shutdown(ctx))
+	if d.probeServe != nil {
+		d.tomb.Kill(d.probeServe.Shutdown(ctx))
+	}
 	cancel()
 
 	if !restartSystem {
@@ -611,6 +701,12 @@ func (d *Daemon) Stop(sigCh chan<- os.Signal) error {
 	}
 	d.overlord.Stop()
 
+	if reexec {
+		// Now that no more changes can run, hand the services over to the
+		// daemon's next instance.
+		d.overlord.ServiceManager().HandOver()
+	}
+
 	err = d.tomb.Wait()
 	if err != nil {
 		// do not stop the shutdown even if the tomb errors
@@ -632,6 +728,10 @@ func (d *Daemon) Stop(sigCh chan<- os.Signal) error {
 		return ErrRestartSocket
 	}
 
+	if reexec {
+		return ErrReexec
+	}
+
 	return nil
 }
 
@@ -782,6 +882,8 @@ func New(opts *Options) (*Daemon, error) {
 		normalSocketPath:    opts.SocketPath,
 		untrustedSocketPath: opts.SocketPath + ".untrusted",
 		httpAddress:         opts.HTTPAddress,
+		probeAddress:        opts.ProbeAddress,
+		guestMetrics:        opts.GuestMetrics,
 	}
 
 	ovld, err := overlord.New(opts.Dir, d, opts.ServiceOutput)
@@ -798,6 +900,9 @@ func New(opts *Options) (*Daemon, error) {
 	}
 	d.overlord = ovld
 	d.state = ovld.State()
+	if opts.Cgroups {
+		ovld.ServiceManager().EnableCgroups()
+	}
 	return d, nil
 }
 
@@ -37,10 +37,12 @@ import (
 	. "gopkg.in/check.v1"
 
 	"github.com/canonical/pebble/internal/osutil"
+	"github.com/canonical/pebble/internal/overlord/checkstate"
 	"github.com/canonical/pebble/internal/overlord/patch"
 	"github.com/canonical/pebble/internal/overlord/restart"
 	"github.com/canonical/pebble/internal/overlord/standby"
 	"github.com/canonical/pebble/internal/overlord/state"
+	"github.com/canonical/pebble/internal/sandbox"
 	"github.com/canonical/pebble/internal/systemd"
 	"github.com/canonical/pebble/internal/testutil"
 )
@@ -48,10 +50,18 @@ import (
 // Hook up check.v1 into the "go test" runner
 func Test(t *testing.T) { check.TestingT(t) }
 
+// TestMain runs the exec helper when a sandboxed command started by a test
+// re-runs the test binary as one.
+func TestMain(m *testing.M) {
+	sandbox.RunHelper()
+	os.Exit(m.Run())
+}
+
 type daemonSuite struct {
 	pebbleDir       string
 	socketPath      string
 	httpAddress     string
+	probeAddress    string
 	statePath       string
 	authorized      bool
 	err             error
@@ -75,13 +85,15 @@ func (s *daemonSuite) TearDownTest(c *check.C) {
 	s.notified = nil
 	s.authorized = false
 	s.err = nil
+	s.probeAddress = ""
 }
 
 func (s *daemonSuite) newDaemon(c *check.C) *Daemon {
 	d, err := New(&Options{
-		Dir:         s.pebbleDir,
-		SocketPath:  s.socketPath,
-		HTTPAddress: s.httpAddress,
+		Dir:          s.pebbleDir,
+		SocketPath:   s.socketPath,
+		HTTPAddress:  s.httpAddress,
+		ProbeAddress: s.probeAddress,
 	})
 	c.Assert(err, check.IsNil)
 	d.addRoutes()
@@ -307,6 +319,29 @@ func (s *daemonSuite) TestUntrustedAccessUntrustedOKWithRoot(c *check.C) {
 	c.Check(cmd.canAccess(del, nil), check.Equals, accessOK)
 }
 
+func (s *daemonSuite) TestGuestMetrics(c *check.C) {
+	get := &http.Request{Method: "GET"}
+
+	d := s.newDaemon(c)
+	cmd := d.router.Get("/v1/metrics").GetHandler().(*Command)
+	c.Check(cmd.canAccess(get, nil), check.Equals, accessUnauthorized)
+
+	d, err := New(&Options{
+		Dir:          s.pebbleDir,
+		SocketPath:   s.socketPath,
+		GuestMetrics: true,
+	})
+	c.Assert(err, check.IsNil)
+	d.addRoutes()
+	cmd = d.router.Get("/v1/metrics").GetHandler().(*Command)
+	c.Check(cmd.canAccess(get, nil), check.Equals, accessOK)
+
+	// The shared command isn't changed.
+	for _, cmd := range api {
+		c.Check(cmd.GuestOK && cmd.Path == "/v1/metrics", check.Equals, false)
+	}
+}
+
 func (s *daemonSuite) TestUserAccess(c *check.C) {
 	d := s.newDaemon(c)
 
@@ -1090,6 +1125,145 @@ func (s *daemonSuite) TestHTTPAPI(c *check.C) {
 	c.Assert(err, ErrorMatches, ".* connection refused")
 }
 
+func (s *daemonSuite) TestProbes(c *check.C) {
+	s.probeAddress = ":0"
+	d := s.newDaemon(c)
+	d.Init()
+	d.Start()
+	port := d.probeListener.Addr().(*net.TCPAddr).Port
+
+	for _, path := range []string{"/livez", "/readyz?verbose=true"} {
+		response, err := http.Get(fmt.Sprintf("http://localhost:%d%s", port, path))
+		c.Assert(err, IsNil)
+		c.Assert(response.StatusCode, Equals, http.StatusOK)
+		var m map[string]interface{}
+		err = json.NewDecoder(response.Body).Decode(&m)
+		c.Assert(err, IsNil)
+		c.Assert(m["result"], DeepEquals, map[string]interface{}{
+			"healthy": true,
+		})
+	}
+
+	// Only the probe endpoints are served, not the rest of the API.
+	response, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/health", port))
+	c.Assert(err, IsNil)
+	c.Assert(response.StatusCode, Equals, http.StatusNotFound)
+
+	response, err = http.Post(fmt.Sprintf("http://localhost:%d/livez", port), "application/json", nil)
+	c.Assert(err, IsNil)
+	c.Assert(response.StatusCode, Equals, http.StatusMethodNotAllowed)
+
+	err = d.Stop(nil)
+	c.Assert(err, IsNil)
+	_, err = http.Get(fmt.Sprintf("http://localhost:%d/livez", port))
+	c.Assert(err, ErrorMatches, ".* connection refused")
+}
+
+func (s *daemonSuite) TestProbesNotVerbose(c *check.C) {
+	writeTestLayer(s.pebbleDir, `
+checks:
+    chk1:
+        override: replace
+        level: ready
+        period: 50ms
+        threshold: 1
+        tcp:
+            host: 127.0.0.1
+            port: 1
+`)
+	s.probeAddress = ":0"
+	d := s.newDaemon(c)
+	err := d.Init()
+	c.Assert(err, IsNil)
+	d.Start()
+	defer d.Stop(nil)
+	port := d.probeListener.Addr().(*net.TCPAddr).Port
+	_, err = d.overlord.ServiceManager().Plan() // load the plan to start the check
+	c.Assert(err, IsNil)
+
+	for i := 0; ; i++ {
+		if i >= 100 {
+			c.Fatalf("timed out waiting for check to fail")
+		}
+		checks, err := d.overlord.CheckManager().Checks()
+		c.Assert(err, IsNil)
+		if len(checks) == 1 && checks[0].Status == checkstate.CheckStatusDown {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// The verbose parameter is ignored, so the check's error isn't exposed.
+	response, err := http.Get(fmt.Sprintf("http://localhost:%d/readyz?verbose=true&names=chk1", port))
+	c.Assert(err, IsNil)
+	c.Assert(response.StatusCode, Equals, http.StatusBadGateway)
+	body, err := ioutil.ReadAll(response.Body)
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Not(Matches), "(?s).*127.0.0.1:1.*")
+	var m map[string]interface{}
+	err = json.Unmarshal(body, &m)
+	c.Assert(err, IsNil)
+	c.Assert(m["result"], DeepEquals, map[string]interface{}{
+		"healthy": false,
+	})
+}
+
+func (s *daemonSuite) TestReexecLeavesServicesRunning(c *C) {
+	writeTestLayer(s.pebbleDir, `
+services:
+    test1:
+        override: replace
+        command: sleep 10
+`)
+	d := s.newDaemon(c)
+	err := d.Init()
+	c.Assert(err, IsNil)
+	d.Start()
+
+	payload := bytes.NewBufferString(`{"action": "start", "services": ["test1"]}`)
+	req, err := http.NewRequest("POST", "/v1/services", payload)
+	c.Assert(err, IsNil)
+	rsp := v1PostServices(apiCmd("/v1/services"), req, nil).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+	c.Check(rec.Result().StatusCode, Equals, 202)
+	for i := 0; ; i++ {
+		if i >= 25 {
+			c.Fatalf("timed out waiting or service to start")
+		}
+		d.state.Lock()
+		change := d.state.Change(rsp.Change)
+		d.state.Unlock()
+		if change != nil && change.IsReady() {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Stopping the daemon to re-execute it doesn't stop the service, and
+	// records its process to be adopted.
+	d.Reexec()
+	err = d.Stop(nil)
+	c.Assert(err, Equals, ErrReexec)
+
+	d.state.Lock()
+	defer d.state.Unlock()
+	for _, chg := range d.state.Changes() {
+		c.Check(chg.Kind(), Not(Equals), "stop")
+	}
+	var processes map[string]struct {
+		PID      int `json:"pid"`
+		OutputFD int `json:"output-fd"`
+	}
+	err = d.state.Get("service-processes", &processes)
+	c.Assert(err, IsNil)
+	c.Assert(processes["test1"].PID, Not(Equals), 0)
+	c.Check(processes["test1"].OutputFD, Not(Equals), 0)
+	defer syscall.Close(processes["test1"].OutputFD)
+	c.Check(syscall.Kill(processes["test1"].PID, 0), IsNil)
+	syscall.Kill(-processes["test1"].PID, syscall.SIGKILL)
+}
+
 func (s *daemonSuite) TestStopRunning(c *C) {
 	// Start the daemon.
 	writeTestLayer(s.pebbleDir, `
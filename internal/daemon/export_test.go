@@ -46,3 +46,11 @@ func FakeGetChecks(f func(o *overlord.Overlord) ([]*checkstate.CheckInfo, error)
 		getChecks = old
 	}
 }
+
+func FakeGetCheckMetrics(f func(o *overlord.Overlord) []*checkstate.CheckMetrics) (restore func()) {
+	old := getCheckMetrics
+	getCheckMetrics = f
+	return func() {
+		getCheckMetrics = old
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package daemon
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/canonical/pebble/internal/plan"
+)
+
+// probeHandler returns the handler for the health probe server, which serves
+// the liveness (/livez) and readiness (/readyz) endpoints without
+// authentication. They're equivalent to /v1/health with level=alive and
+// level=ready, respectively, but only accept the names parameter: verbose is
+// ignored, so the checks' errors aren't exposed without authentication.
+func (d *Daemon) probeHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/livez", d.probe(plan.AliveLevel))
+	mux.Handle("/readyz", d.probe(plan.ReadyLevel))
+	return mux
+}
+
+func (d *Daemon) probe(level plan.CheckLevel) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rsp Response
+		if r.Method == "GET" {
+			query := url.Values{"names": r.URL.Query()["names"]}
+			rsp = health(d.overlord, level, query)
+		} else {
+			rsp = statusMethodNotAllowed("method %q not allowed", r.Method)
+		}
+		rsp.ServeHTTP(w, r)
+	})
+}
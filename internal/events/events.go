@@ -0,0 +1,166 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package events implements a hub that distributes events (such as a
+// service or check changing status) to subscribers, and keeps the most
+// recent events so they can be fetched later.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Type is the type of an event.
+type Type string
+
+const (
+	// TypeService is the type of events for a service changing status. Name
+	// is the service name and Status its new status, or ServiceExited.
+	TypeService Type = "service"
+
+	// TypeCheck is the type of events for a check going up or down. Name is
+	// the check name and Status its new status.
+	TypeCheck Type = "check"
+
+	// TypeChange is the type of events for a change changing status. Name is
+	// the change ID, Status its new status and Message its summary.
+	TypeChange Type = "change"
+
+	// TypeTask is the type of events for a task changing status. Name is the
+	// task ID, Status its new status and Message its summary.
+	TypeTask Type = "task"
+
+	// TypeWarning is the type of events for a warning being recorded.
+	// Message is the warning message.
+	TypeWarning Type = "warning"
+
+	// TypeLayer is the type of events for a layer being added to the plan
+	// (or combined into an existing layer). Name is the layer label.
+	TypeLayer Type = "layer"
+)
+
+// ServiceExited is the Status of the service event published when a service
+// exits by itself, rather than being stopped, before any change of status
+// that results from it. Message is its exit code.
+const ServiceExited = "exited"
+
+// Event is a single event.
+type Event struct {
+	Time    time.Time
+	Type    Type
+	Name    string
+	Status  string
+	Message string
+}
+
+const (
+	// maxRecent is the number of recent events the hub keeps.
+	maxRecent = 1000
+
+	// subscriberBuffer is the number of events that can be queued for a
+	// subscriber before it's considered too slow and is dropped.
+	subscriberBuffer = 256
+)
+
+// Hub distributes events to subscribers. It's safe for concurrent use.
+type Hub struct {
+	mutex       sync.Mutex
+	recent      []Event
+	subscribers map[*Subscription]bool
+}
+
+// NewHub returns a new event hub.
+func NewHub() *Hub {
+	return &Hub{
+		subscribers: make(map[*Subscription]bool),
+	}
+}
+
+// Publish records an event and sends it to all subscribers. If the event's
+// time isn't set, it's set to the current time.
+//
+// Publish never blocks, so it may be called with other locks held. If a
+// subscriber isn't keeping up, it's closed rather than blocking the
+// publisher.
+func (h *Hub) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if len(h.recent) >= maxRecent {
+		copy(h.recent, h.recent[1:])
+		h.recent = h.recent[:len(h.recent)-1]
+	}
+	h.recent = append(h.recent, event)
+
+	for sub := range h.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			delete(h.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// Recent returns the most recent events, oldest first.
+func (h *Hub) Recent() []Event {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return append([]Event(nil), h.recent...)
+}
+
+// Subscribe returns a new subscription to events published from now on,
+// along with the most recent events published before it (oldest first).
+// The subscription must be closed when it's no longer needed.
+func (h *Hub) Subscribe() (*Subscription, []Event) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	sub := &Subscription{
+		hub:    h,
+		events: make(chan Event, subscriberBuffer),
+	}
+	h.subscribers[sub] = true
+	return sub, append([]Event(nil), h.recent...)
+}
+
+// Subscription is a subscription to the events published to a hub.
+type Subscription struct {
+	hub    *Hub
+	events chan Event
+}
+
+// Events returns the channel on which events are delivered. The channel is
+// closed when the subscription is closed, or if the subscriber fell too far
+// behind.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close closes the subscription.
+func (s *Subscription) Close() {
+	s.hub.mutex.Lock()
+	defer s.hub.mutex.Unlock()
+
+	if s.hub.subscribers[s] {
+		delete(s.hub.subscribers, s)
+		close(s.events)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package events_test
+
+import (
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/pebble/internal/events"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type eventsSuite struct{}
+
+var _ = Suite(&eventsSuite{})
+
+func (s *eventsSuite) TestPublishSubscribe(c *C) {
+	hub := events.NewHub()
+	t := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	hub.Publish(events.Event{Time: t, Type: events.TypeService, Name: "svc1", Status: "active"})
+
+	sub, recent := hub.Subscribe()
+	defer sub.Close()
+	c.Assert(recent, DeepEquals, []events.Event{
+		{Time: t, Type: events.TypeService, Name: "svc1", Status: "active"},
+	})
+
+	hub.Publish(events.Event{Type: events.TypeCheck, Name: "chk1", Status: "down"})
+	select {
+	case event := <-sub.Events():
+		c.Check(event.Time.IsZero(), Equals, false)
+		event.Time = time.Time{}
+		c.Check(event, DeepEquals, events.Event{Type: events.TypeCheck, Name: "chk1", Status: "down"})
+	case <-time.After(time.Second):
+		c.Fatalf("timed out waiting for event")
+	}
+
+	c.Assert(hub.Recent(), HasLen, 2)
+}
+
+func (s *eventsSuite) TestClose(c *C) {
+	hub := events.NewHub()
+	sub, _ := hub.Subscribe()
+	sub.Close()
+	_, ok := <-sub.Events()
+	c.Assert(ok, Equals, false)
+
+	// Closing again or publishing after closing is fine.
+	sub.Close()
+	hub.Publish(events.Event{Type: events.TypeWarning, Message: "foo"})
+}
+
+func (s *eventsSuite) TestSlowSubscriber(c *C) {
+	hub := events.NewHub()
+	sub, _ := hub.Subscribe()
+	defer sub.Close()
+
+	// Publishing never blocks; a subscriber that falls behind is closed.
+	for i := 0; i < 1000; i++ {
+		hub.Publish(events.Event{Type: events.TypeTask, Name: "1"})
+	}
+	n := 0
+	for range sub.Events() {
+		n++
+	}
+	c.Assert(n > 0 && n < 1000, Equals, true)
+}
+
+func (s *eventsSuite) TestRecentLimit(c *C) {
+	hub := events.NewHub()
+	for i := 0; i < 1500; i++ {
+		hub.Publish(events.Event{Type: events.TypeLayer, Name: "layer"})
+	}
+	c.Assert(hub.Recent(), HasLen, 1000)
+}
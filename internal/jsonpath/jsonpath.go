@@ -0,0 +1,133 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package jsonpath implements a small subset of JSONPath for looking up
+// values in decoded JSON documents, such as "$.status" or "$.items[0].name".
+package jsonpath
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Path is a parsed JSON path. Each element is either a string (an object
+// key) or an int (an array index).
+type Path []interface{}
+
+// Parse parses a path of the form "$.key.other[index]". The leading "$" is
+// optional, and keys may contain any characters except ".", "[" and "]".
+func Parse(s string) (Path, error) {
+	rest := strings.TrimPrefix(s, "$")
+	if rest == "" {
+		return nil, fmt.Errorf("invalid JSON path %q: path is empty", s)
+	}
+	if rest[0] != '.' && rest[0] != '[' {
+		// Allow "key.other" as shorthand for "$.key.other".
+		rest = "." + rest
+	}
+
+	var path Path
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[]")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: empty key", s)
+			}
+			path = append(path, rest[:end])
+			rest = rest[end:]
+
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: missing ']'", s)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: invalid index %q", s, rest[1:end])
+			}
+			path = append(path, index)
+			rest = rest[end+1:]
+
+		default:
+			return nil, fmt.Errorf("invalid JSON path %q: unexpected %q", s, rest[0])
+		}
+	}
+	return path, nil
+}
+
+// Lookup returns the value at the path in v, a JSON document decoded into
+// an interface{}, and reports whether it was found.
+func (p Path) Lookup(v interface{}) (interface{}, bool) {
+	for _, elem := range p {
+		switch elem := elem.(type) {
+		case string:
+			object, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			v, ok = object[elem]
+			if !ok {
+				return nil, false
+			}
+		case int:
+			array, ok := v.([]interface{})
+			if !ok || elem >= len(array) {
+				return nil, false
+			}
+			v = array[elem]
+		}
+	}
+	return v, true
+}
+
+// String returns the path in its canonical "$.key[index]" form.
+func (p Path) String() string {
+	var sb strings.Builder
+	sb.WriteString("$")
+	for _, elem := range p {
+		switch elem := elem.(type) {
+		case string:
+			sb.WriteString(".")
+			sb.WriteString(elem)
+		case int:
+			fmt.Fprintf(&sb, "[%d]", elem)
+		}
+	}
+	return sb.String()
+}
+
+// Format formats a decoded JSON value for comparison with an expected
+// value: strings are returned as is, numbers in their shortest form, and
+// other values (including objects and arrays) as compact JSON.
+func Format(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(b)
+	}
+}
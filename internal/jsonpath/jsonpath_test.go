@@ -0,0 +1,104 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package jsonpath_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/pebble/internal/jsonpath"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type jsonpathSuite struct{}
+
+var _ = Suite(&jsonpathSuite{})
+
+func (s *jsonpathSuite) TestParse(c *C) {
+	tests := []struct {
+		path     string
+		expected jsonpath.Path
+		str      string
+	}{
+		{"$.status", jsonpath.Path{"status"}, "$.status"},
+		{"status", jsonpath.Path{"status"}, "$.status"},
+		{"$.checks.db.status", jsonpath.Path{"checks", "db", "status"}, "$.checks.db.status"},
+		{"$.items[0].name", jsonpath.Path{"items", 0, "name"}, "$.items[0].name"},
+		{"$[1][2]", jsonpath.Path{1, 2}, "$[1][2]"},
+		{"items[10]", jsonpath.Path{"items", 10}, "$.items[10]"},
+	}
+	for _, test := range tests {
+		path, err := jsonpath.Parse(test.path)
+		c.Assert(err, IsNil, Commentf("path %q", test.path))
+		c.Check(path, DeepEquals, test.expected, Commentf("path %q", test.path))
+		c.Check(path.String(), Equals, test.str)
+	}
+}
+
+func (s *jsonpathSuite) TestParseErrors(c *C) {
+	tests := []struct {
+		path  string
+		error string
+	}{
+		{"", `invalid JSON path "": path is empty`},
+		{"$", `invalid JSON path "\$": path is empty`},
+		{"$.a..b", `invalid JSON path "\$.a..b": empty key`},
+		{"$.a[0", `invalid JSON path "\$.a\[0": missing '\]'`},
+		{"$.a[x]", `invalid JSON path "\$.a\[x\]": invalid index "x"`},
+		{"$.a[-1]", `invalid JSON path "\$.a\[-1\]": invalid index "-1"`},
+		{"$.a]", `invalid JSON path "\$.a\]": unexpected '\]'`},
+	}
+	for _, test := range tests {
+		_, err := jsonpath.Parse(test.path)
+		c.Check(err, ErrorMatches, test.error, Commentf("path %q", test.path))
+	}
+}
+
+func (s *jsonpathSuite) TestLookup(c *C) {
+	var doc interface{}
+	err := json.Unmarshal([]byte(`{"status": "ok", "count": 3, "ready": true,
+		"items": [{"name": "a"}, {"name": "b", "size": 1.5}], "none": null}`), &doc)
+	c.Assert(err, IsNil)
+
+	tests := []struct {
+		path  string
+		value string
+		found bool
+	}{
+		{"$.status", "ok", true},
+		{"$.count", "3", true},
+		{"$.ready", "true", true},
+		{"$.none", "null", true},
+		{"$.items[1].name", "b", true},
+		{"$.items[1].size", "1.5", true},
+		{"$.items[0]", `{"name":"a"}`, true},
+		{"$.items[2]", "", false},
+		{"$.status.foo", "", false},
+		{"$.missing", "", false},
+		{"$[0]", "", false},
+	}
+	for _, test := range tests {
+		path, err := jsonpath.Parse(test.path)
+		c.Assert(err, IsNil)
+		value, found := path.Lookup(doc)
+		c.Check(found, Equals, test.found, Commentf("path %q", test.path))
+		if found {
+			c.Check(jsonpath.Format(value), Equals, test.value, Commentf("path %q", test.path))
+		}
+	}
+}
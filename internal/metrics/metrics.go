@@ -0,0 +1,167 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package metrics writes metrics in the Prometheus text exposition format:
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Type is the type of a metric family.
+type Type string
+
+const (
+	TypeCounter   Type = "counter"
+	TypeGauge     Type = "gauge"
+	TypeHistogram Type = "histogram"
+)
+
+// DefaultBuckets are the default histogram buckets (in seconds), suited to
+// timing things like requests and checks.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram counts observations (typically durations) in buckets. It isn't
+// safe for concurrent use; callers must provide their own locking.
+type Histogram struct {
+	// Buckets are the upper bounds of the buckets, in increasing order.
+	Buckets []float64
+
+	// Counts are the number of observations in each bucket (not cumulative).
+	// The last element counts observations greater than the last bucket.
+	Counts []uint64
+
+	// Count is the total number of observations.
+	Count uint64
+
+	// Sum is the sum of all the observed values.
+	Sum float64
+}
+
+// NewHistogram returns an empty histogram with the given bucket bounds.
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		Buckets: buckets,
+		Counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+// Observe adds a single observation to the histogram.
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.Buckets, value)
+	h.Counts[i]++
+	h.Count++
+	h.Sum += value
+}
+
+// ObserveDuration adds a duration observation, in seconds.
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+// Copy returns a copy of the histogram.
+func (h *Histogram) Copy() *Histogram {
+	copied := *h
+	copied.Counts = append([]uint64(nil), h.Counts...)
+	return &copied
+}
+
+// Writer writes metrics in the Prometheus text format. Write errors are
+// recorded and returned by Flush.
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter returns a Writer that writes to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Family writes the HELP and TYPE lines for a metric family. It must be
+// called before writing the family's samples.
+func (w *Writer) Family(name string, typ Type, help string) {
+	w.w.WriteString("# HELP " + name + " " + escapeHelp(help) + "\n")
+	w.w.WriteString("# TYPE " + name + " " + string(typ) + "\n")
+}
+
+// Sample writes a single sample. Labels are given as name, value pairs.
+func (w *Writer) Sample(name string, value float64, labels ...string) {
+	w.w.WriteString(name)
+	w.writeLabels(labels)
+	w.w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// Histogram writes the bucket, sum and count samples of a histogram.
+// Labels are given as name, value pairs.
+func (w *Writer) Histogram(name string, h *Histogram, labels ...string) {
+	var cumulative uint64
+	for i, bound := range h.Buckets {
+		cumulative += h.Counts[i]
+		w.Sample(name+"_bucket", float64(cumulative), append(labels[:len(labels):len(labels)], "le", formatFloat(bound))...)
+	}
+	w.Sample(name+"_bucket", float64(h.Count), append(labels[:len(labels):len(labels)], "le", "+Inf")...)
+	w.Sample(name+"_sum", h.Sum, labels...)
+	w.Sample(name+"_count", float64(h.Count), labels...)
+}
+
+// Flush writes any buffered data to the underlying writer, and returns the
+// first error that occurred while writing.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+func (w *Writer) writeLabels(labels []string) {
+	if len(labels) == 0 {
+		return
+	}
+	w.w.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			w.w.WriteByte(',')
+		}
+		w.w.WriteString(labels[i] + `="` + escapeLabelValue(labels[i+1]) + `"`)
+	}
+	w.w.WriteByte('}')
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package metrics_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/pebble/internal/metrics"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type metricsSuite struct{}
+
+var _ = Suite(&metricsSuite{})
+
+func (s *metricsSuite) TestSamples(c *C) {
+	var buf bytes.Buffer
+	w := metrics.NewWriter(&buf)
+	w.Family("pebble_up", metrics.TypeGauge, "Whether it's up.")
+	w.Sample("pebble_up", 1)
+	w.Family("pebble_things_total", metrics.TypeCounter, "Number of things,\nwith a \\ backslash.")
+	w.Sample("pebble_things_total", 42, "name", "foo")
+	w.Sample("pebble_things_total", 0.5, "name", `a "quoted"`+"\n"+`\ value`, "kind", "x")
+	err := w.Flush()
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, `
+# HELP pebble_up Whether it's up.
+# TYPE pebble_up gauge
+pebble_up 1
+# HELP pebble_things_total Number of things,\nwith a \\ backslash.
+# TYPE pebble_things_total counter
+pebble_things_total{name="foo"} 42
+pebble_things_total{name="a \"quoted\"\n\\ value",kind="x"} 0.5
+`[1:])
+}
+
+func (s *metricsSuite) TestHistogram(c *C) {
+	h := metrics.NewHistogram([]float64{0.1, 1})
+	h.ObserveDuration(50 * time.Millisecond)
+	h.Observe(0.1)
+	h.Observe(0.5)
+	h.Observe(3)
+	c.Assert(h.Count, Equals, uint64(4))
+	c.Assert(h.Counts, DeepEquals, []uint64{2, 1, 1})
+
+	copied := h.Copy()
+	h.Observe(0.01)
+	c.Assert(copied.Count, Equals, uint64(4))
+	c.Assert(copied.Counts, DeepEquals, []uint64{2, 1, 1})
+
+	var buf bytes.Buffer
+	w := metrics.NewWriter(&buf)
+	w.Family("pebble_duration_seconds", metrics.TypeHistogram, "Duration.")
+	w.Histogram("pebble_duration_seconds", copied, "name", "foo")
+	err := w.Flush()
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, `
+# HELP pebble_duration_seconds Duration.
+# TYPE pebble_duration_seconds histogram
+pebble_duration_seconds_bucket{name="foo",le="0.1"} 2
+pebble_duration_seconds_bucket{name="foo",le="1"} 3
+pebble_duration_seconds_bucket{name="foo",le="+Inf"} 4
+pebble_duration_seconds_sum{name="foo"} 3.65
+pebble_duration_seconds_count{name="foo"} 4
+`[1:])
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package osutil_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/pebble/internal/osutil"
+)
+
+type capabilitiesSuite struct{}
+
+var _ = Suite(&capabilitiesSuite{})
+
+func (s *capabilitiesSuite) TestParseCapability(c *C) {
+	for _, name := range []string{"CAP_NET_BIND_SERVICE", "cap_net_bind_service", "net_bind_service", "NET_BIND_SERVICE"} {
+		n, err := osutil.ParseCapability(name)
+		c.Check(err, IsNil)
+		c.Check(n, Equals, uintptr(10))
+	}
+	_, err := osutil.ParseCapability("CAP_FOO")
+	c.Assert(err, ErrorMatches, `unknown capability "CAP_FOO"`)
+
+	caps, err := osutil.ParseCapabilities([]string{"kill", "CAP_CHOWN"})
+	c.Assert(err, IsNil)
+	c.Assert(caps, DeepEquals, []uintptr{0, 5})
+}
+
+func (s *capabilitiesSuite) TestCapabilityName(c *C) {
+	c.Check(osutil.CapabilityName(10), Equals, "CAP_NET_BIND_SERVICE")
+	c.Check(osutil.CapabilityName(1000), Equals, "1000")
+}
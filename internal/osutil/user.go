@@ -124,3 +124,24 @@ func NormalizeUidGid(uid, gid *int, username, group string) (*int, *int, error)
 	}
 	return uid, gid, nil
 }
+
+// LookupGroups returns the IDs of the given groups, which may be group
+// names or numeric IDs.
+func LookupGroups(groups []string) ([]uint32, error) {
+	gids := make([]uint32, 0, len(groups))
+	for _, group := range groups {
+		gid, err := strconv.ParseUint(group, 10, 32)
+		if err != nil {
+			g, err := userLookupGroup(group)
+			if err != nil {
+				return nil, err
+			}
+			gid, err = strconv.ParseUint(g.Gid, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse group ID %q: %v", g.Gid, err)
+			}
+		}
+		gids = append(gids, uint32(gid))
+	}
+	return gids, nil
+}
@@ -149,3 +149,12 @@ func (s *userSuite) TestNormalizeUidGid(c *check.C) {
 	groupErr = fmt.Errorf("GROUP ERROR!")
 	test(ptr(1), nil, "", "GROUP", nil, nil, "GROUP ERROR!")
 }
+
+func (s *userSuite) TestLookupGroups(c *check.C) {
+	gids, err := osutil.LookupGroups([]string{"0", "1234"})
+	c.Assert(err, check.IsNil)
+	c.Assert(gids, check.DeepEquals, []uint32{0, 1234})
+
+	_, err = osutil.LookupGroups([]string{"no-such-group-xyz"})
+	c.Assert(err, check.ErrorMatches, `.*no-such-group-xyz.*`)
+}
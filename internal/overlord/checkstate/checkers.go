@@ -16,6 +16,9 @@ package checkstate
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -23,15 +26,21 @@ import (
 	"net"
 	"net/http"
 	"os/exec"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/canonical/x-go/strutil/shlex"
 
+	"github.com/canonical/pebble/internal/jsonpath"
 	"github.com/canonical/pebble/internal/logger"
 	"github.com/canonical/pebble/internal/osutil"
+	"github.com/canonical/pebble/internal/plan"
 	"github.com/canonical/pebble/internal/reaper"
+	"github.com/canonical/pebble/internal/sandbox"
 	"github.com/canonical/pebble/internal/servicelog"
 )
 
@@ -40,17 +49,84 @@ const (
 	maxErrorLines = 20
 )
 
-// httpChecker is a checker that ensures an HTTP GET at a specified URL returns 20x.
+// httpChecker is a checker that ensures an HTTP request to a specified URL
+// returns the expected response (by default, a GET that returns 20x).
 type httpChecker struct {
 	name    string
 	url     string
+	method  string
 	headers map[string]string
+	body    string
+
+	expectStatus []plan.StatusRange
+	expectBody   *regexp.Regexp
+	expectJSON   []jsonExpectation
+
+	caFile             string
+	insecureSkipVerify bool
+	clientCert         string
+	clientKey          string
+	socketPath         string
+}
+
+// jsonExpectation is the value expected at a path in a JSON response body.
+type jsonExpectation struct {
+	path  jsonpath.Path
+	value string
+}
+
+const maxBodyBytes = 1024 * 1024
+
+// newHTTPChecker creates an HTTP checker from the check's (already
+// validated) configuration.
+func newHTTPChecker(name string, config *plan.HTTPCheck) *httpChecker {
+	c := &httpChecker{
+		name:               name,
+		url:                config.URL,
+		method:             config.Method,
+		headers:            config.Headers,
+		body:               config.Body,
+		caFile:             config.CAFile,
+		insecureSkipVerify: config.InsecureSkipVerify,
+		clientCert:         config.ClientCert,
+		clientKey:          config.ClientKey,
+		socketPath:         config.SocketPath,
+	}
+	c.expectStatus, _ = plan.ParseStatusRanges(config.ExpectStatus)
+	if config.ExpectBody != "" {
+		c.expectBody = regexp.MustCompile(config.ExpectBody)
+	}
+	for path, value := range config.ExpectJSON {
+		parsed, err := jsonpath.Parse(path)
+		if err != nil {
+			continue
+		}
+		c.expectJSON = append(c.expectJSON, jsonExpectation{path: parsed, value: value})
+	}
+	sort.Slice(c.expectJSON, func(i, j int) bool {
+		return c.expectJSON[i].path.String() < c.expectJSON[j].path.String()
+	})
+	return c
 }
 
 func (c *httpChecker) check(ctx context.Context) error {
-	logger.Debugf("Check %q (http): requesting %q", c.name, c.url)
-	client := &http.Client{}
-	request, err := http.NewRequestWithContext(ctx, "GET", c.url, nil)
+	method := c.method
+	if method == "" {
+		method = "GET"
+	}
+	logger.Debugf("Check %q (http): requesting %s %q", c.name, method, c.url)
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+	var body io.Reader
+	if c.body != "" {
+		body = strings.NewReader(c.body)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.url, body)
+	if err != nil {
+		return err
+	}
 	for k, v := range c.headers {
 		request.Header.Set(k, v)
 	}
@@ -61,33 +137,144 @@ func (c *httpChecker) check(ctx context.Context) error {
 	}
 	defer response.Body.Close()
 
-	if response.StatusCode < 200 || response.StatusCode > 299 {
+	if !c.statusOK(response.StatusCode) {
 		// Include first few lines of response body in error details
 		output, err := ioutil.ReadAll(io.LimitReader(response.Body, maxErrorBytes))
 		details := ""
 		if err != nil {
 			details = fmt.Sprintf("cannot read response body: %v", err)
 		} else {
-			lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-			if len(lines) > maxErrorLines {
-				lines = lines[:maxErrorLines+1]
-				lines[maxErrorLines] = "(...)"
-			}
-			details = strings.Join(lines, "\n")
+			details = bodyDetails(output)
+		}
+		err = fmt.Errorf("received non-20x status code %d", response.StatusCode)
+		if len(c.expectStatus) > 0 {
+			err = fmt.Errorf("received unexpected status code %d", response.StatusCode)
 		}
 		return &detailsError{
-			error:   fmt.Errorf("received non-20x status code %d", response.StatusCode),
+			error:   err,
 			details: details,
 		}
 	}
+
+	if c.expectBody == nil && len(c.expectJSON) == 0 {
+		return nil
+	}
+	output, err := ioutil.ReadAll(io.LimitReader(response.Body, maxBodyBytes))
+	if err != nil {
+		return fmt.Errorf("cannot read response body: %w", err)
+	}
+	if c.expectBody != nil && !c.expectBody.Match(output) {
+		return &detailsError{
+			error:   fmt.Errorf("response body does not match %q", c.expectBody.String()),
+			details: bodyDetails(output),
+		}
+	}
+	if len(c.expectJSON) > 0 {
+		var doc interface{}
+		err := json.Unmarshal(output, &doc)
+		if err != nil {
+			return &detailsError{
+				error:   fmt.Errorf("cannot decode response body as JSON: %v", err),
+				details: bodyDetails(output),
+			}
+		}
+		for _, expect := range c.expectJSON {
+			value, ok := expect.path.Lookup(doc)
+			if !ok {
+				return &detailsError{
+					error:   fmt.Errorf("response JSON has no value at %s", expect.path),
+					details: bodyDetails(output),
+				}
+			}
+			if actual := jsonpath.Format(value); actual != expect.value {
+				return &detailsError{
+					error:   fmt.Errorf("response JSON value at %s is %q, expected %q", expect.path, actual, expect.value),
+					details: bodyDetails(output),
+				}
+			}
+		}
+	}
 	return nil
 }
 
-// tcpChecker is a checker that ensures a TCP port is open.
+// statusOK reports whether the response status is one of the expected ones
+// (any 20x status if none are configured).
+func (c *httpChecker) statusOK(status int) bool {
+	if len(c.expectStatus) == 0 {
+		return status >= 200 && status <= 299
+	}
+	for _, r := range c.expectStatus {
+		if r.Contains(status) {
+			return true
+		}
+	}
+	return false
+}
+
+// client returns the HTTP client to use for the check. The TLS files are
+// loaded on every check so that updated certificates are picked up.
+func (c *httpChecker) client() (*http.Client, error) {
+	if c.caFile == "" && !c.insecureSkipVerify && c.clientCert == "" && c.socketPath == "" {
+		return &http.Client{}, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = true
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.insecureSkipVerify,
+	}
+	if c.caFile != "" {
+		data, err := ioutil.ReadFile(c.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("cannot find any certificates in CA file %q", c.caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.clientCert != "" {
+		cert, err := tls.LoadX509KeyPair(c.clientCert, c.clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport.TLSClientConfig = tlsConfig
+	if c.socketPath != "" {
+		// Send the request over the unix socket whatever the URL's host.
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", c.socketPath)
+		}
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// bodyDetails returns the first few lines of a response body, for use in
+// error details.
+func bodyDetails(body []byte) string {
+	if len(body) > maxErrorBytes {
+		body = body[:maxErrorBytes]
+	}
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	if len(lines) > maxErrorLines {
+		lines = lines[:maxErrorLines+1]
+		lines[maxErrorLines] = "(...)"
+	}
+	return strings.Join(lines, "\n")
+}
+
+// tcpChecker is a checker that ensures a TCP port is open, and optionally
+// that sending a payload gets the expected reply.
 type tcpChecker struct {
-	name string
-	host string
-	port int
+	name   string
+	host   string
+	port   int
+	send   string
+	expect *regexp.Regexp
 }
 
 func (c *tcpChecker) check(ctx context.Context) error {
@@ -103,11 +290,138 @@ func (c *tcpChecker) check(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	err = conn.Close()
+	defer func() {
+		err := conn.Close()
+		if err != nil {
+			logger.Noticef("Check %q (tcp): unexpected error closing connection: %v", c.name, err)
+		}
+	}()
+	return exchange(ctx, conn, c.send, c.expect, false)
+}
+
+// unixChecker is a checker that ensures a unix socket accepts connections,
+// and optionally that sending a payload gets the expected reply.
+type unixChecker struct {
+	name   string
+	path   string
+	send   string
+	expect *regexp.Regexp
+}
+
+func (c *unixChecker) check(ctx context.Context) error {
+	logger.Debugf("Check %q (unix): connecting to %q", c.name, c.path)
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", c.path)
 	if err != nil {
-		logger.Noticef("Check %q (tcp): unexpected error closing connection: %v", c.name, err)
+		return err
+	}
+	defer func() {
+		err := conn.Close()
+		if err != nil {
+			logger.Noticef("Check %q (unix): unexpected error closing connection: %v", c.name, err)
+		}
+	}()
+	return exchange(ctx, conn, c.send, c.expect, false)
+}
+
+// udpChecker is a checker that ensures sending a UDP datagram gets a reply
+// (that matches the expected reply, if set).
+type udpChecker struct {
+	name   string
+	host   string
+	port   int
+	send   string
+	expect *regexp.Regexp
+}
+
+func (c *udpChecker) check(ctx context.Context) error {
+	logger.Debugf("Check %q (udp): sending to port %d", c.name, c.port)
+
+	host := c.host
+	if host == "" {
+		host = "localhost"
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(host, strconv.Itoa(c.port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	expect := c.expect
+	if expect == nil {
+		// Any reply will do, but there must be one.
+		expect = anyReply
+	}
+	return exchange(ctx, conn, c.send, expect, true)
+}
+
+var anyReply = regexp.MustCompile(``)
+
+// exchange sends the payload (if any) over the connection, then waits until
+// ctx is done for a reply that matches expect (if set). For datagram
+// connections, only the first reply is considered.
+func exchange(ctx context.Context, conn net.Conn, send string, expect *regexp.Regexp, datagram bool) error {
+	if send == "" && expect == nil {
+		return nil
+	}
+
+	// Stop reading or writing when the context is done.
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	if send != "" {
+		_, err := io.WriteString(conn, send)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("cannot send payload: %w", err)
+		}
+	}
+	if expect == nil {
+		return nil
+	}
+
+	var received []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		received = append(received, buf[:n]...)
+		if len(received) > maxErrorBytes {
+			received = received[len(received)-maxErrorBytes:]
+		}
+		if n > 0 && expect.Match(received) {
+			return nil
+		}
+		switch {
+		case datagram && err == nil:
+			err = fmt.Errorf("reply does not match %q", expect.String())
+		case ctx.Err() != nil:
+			err = fmt.Errorf("timed out waiting for reply matching %q", expect.String())
+		case err == io.EOF:
+			err = fmt.Errorf("connection closed without reply matching %q", expect.String())
+		case err != nil:
+			err = fmt.Errorf("cannot read reply: %w", err)
+		default:
+			continue
+		}
+		return &detailsError{
+			error:   err,
+			details: bodyDetails(received),
+		}
 	}
-	return nil
 }
 
 // execChecker is a checker that ensures a command executes successfully.
@@ -120,6 +434,7 @@ type execChecker struct {
 	groupID     *int
 	group       string
 	workingDir  string
+	sandbox     plan.Sandbox
 }
 
 func (c *execChecker) check(ctx context.Context) error {
@@ -141,12 +456,23 @@ func (c *execChecker) check(ctx context.Context) error {
 		return err
 	}
 	if uid != nil && gid != nil {
-		cmd.SysProcAttr.Credential = &syscall.Credential{
-			Uid: uint32(*uid),
-			Gid: uint32(*gid),
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Credential: &syscall.Credential{
+				Uid: uint32(*uid),
+				Gid: uint32(*gid),
+			},
 		}
 	}
 
+	opts, err := sandbox.PlanOptions(&c.sandbox)
+	if err != nil {
+		return err
+	}
+	err = sandbox.Apply(cmd, opts)
+	if err != nil {
+		return err
+	}
+
 	// Start service, sending output to a ring buffer so we can show the last
 	// few lines of output on error.
 	ringBuffer := servicelog.NewRingBuffer(maxErrorBytes)
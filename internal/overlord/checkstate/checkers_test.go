@@ -15,17 +15,24 @@
 package checkstate
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"time"
 
 	. "gopkg.in/check.v1"
 
+	"github.com/canonical/pebble/internal/plan"
 	"github.com/canonical/pebble/internal/reaper"
 )
 
@@ -112,6 +119,147 @@ func (s *CheckersSuite) TestHTTP(c *C) {
 	c.Assert(err, ErrorMatches, ".* connection refused")
 }
 
+func (s *CheckersSuite) TestHTTPRequestAndResponse(c *C) {
+	var method, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		switch r.URL.Path {
+		case "/created":
+			w.WriteHeader(http.StatusCreated)
+		case "/redirect":
+			w.WriteHeader(http.StatusMultipleChoices)
+		}
+		fmt.Fprint(w, `{"status": "ok", "checks": [{"name": "db", "up": true}]}`)
+	}))
+	defer server.Close()
+
+	// Method and body are sent through
+	chk := newHTTPChecker("chk", &plan.HTTPCheck{
+		URL:    server.URL + "/created",
+		Method: "POST",
+		Body:   "ping",
+	})
+	err := chk.check(context.Background())
+	c.Assert(err, IsNil)
+	c.Check(method, Equals, "POST")
+	c.Check(body, Equals, "ping")
+
+	// Expected status codes and ranges
+	chk = newHTTPChecker("chk", &plan.HTTPCheck{
+		URL:          server.URL + "/redirect",
+		ExpectStatus: []string{"200", "300-399"},
+	})
+	err = chk.check(context.Background())
+	c.Assert(err, IsNil)
+	chk = newHTTPChecker("chk", &plan.HTTPCheck{
+		URL:          server.URL + "/created",
+		ExpectStatus: []string{"200", "300-399"},
+	})
+	err = chk.check(context.Background())
+	c.Assert(err, ErrorMatches, "received unexpected status code 201")
+
+	// Response body regex
+	chk = newHTTPChecker("chk", &plan.HTTPCheck{
+		URL:        server.URL,
+		ExpectBody: `"status": "ok"`,
+	})
+	err = chk.check(context.Background())
+	c.Assert(err, IsNil)
+	chk = newHTTPChecker("chk", &plan.HTTPCheck{
+		URL:        server.URL,
+		ExpectBody: `"status": "bad"`,
+	})
+	err = chk.check(context.Background())
+	c.Assert(err, ErrorMatches, `response body does not match "\\"status\\": \\"bad\\""`)
+	detailsErr, ok := err.(*detailsError)
+	c.Assert(ok, Equals, true)
+	c.Check(detailsErr.Details(), Matches, `.*"status": "ok".*`)
+
+	// Response JSON values
+	chk = newHTTPChecker("chk", &plan.HTTPCheck{
+		URL: server.URL,
+		ExpectJSON: map[string]string{
+			"$.status":       "ok",
+			"$.checks[0].up": "true",
+			"checks[0].name": "db",
+		},
+	})
+	err = chk.check(context.Background())
+	c.Assert(err, IsNil)
+	chk = newHTTPChecker("chk", &plan.HTTPCheck{
+		URL:        server.URL,
+		ExpectJSON: map[string]string{"$.checks[0].up": "false"},
+	})
+	err = chk.check(context.Background())
+	c.Assert(err, ErrorMatches, `response JSON value at \$.checks\[0\].up is "true", expected "false"`)
+	chk = newHTTPChecker("chk", &plan.HTTPCheck{
+		URL:        server.URL,
+		ExpectJSON: map[string]string{"$.checks[1].up": "true"},
+	})
+	err = chk.check(context.Background())
+	c.Assert(err, ErrorMatches, `response JSON has no value at \$.checks\[1\].up`)
+}
+
+func (s *CheckersSuite) TestHTTPTLS(c *C) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	// Server's certificate isn't trusted by default
+	chk := newHTTPChecker("chk", &plan.HTTPCheck{URL: server.URL})
+	err := chk.check(context.Background())
+	c.Assert(err, ErrorMatches, ".*certificate.*")
+
+	// Unless verification is skipped
+	chk = newHTTPChecker("chk", &plan.HTTPCheck{URL: server.URL, InsecureSkipVerify: true})
+	err = chk.check(context.Background())
+	c.Assert(err, IsNil)
+
+	// Or its CA is configured
+	caFile := filepath.Join(c.MkDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	err = ioutil.WriteFile(caFile, caPEM, 0o644)
+	c.Assert(err, IsNil)
+	chk = newHTTPChecker("chk", &plan.HTTPCheck{URL: server.URL, CAFile: caFile})
+	err = chk.check(context.Background())
+	c.Assert(err, IsNil)
+
+	// Invalid CA and client certificate files are reported
+	badFile := filepath.Join(c.MkDir(), "bad.pem")
+	err = ioutil.WriteFile(badFile, []byte("bad"), 0o644)
+	c.Assert(err, IsNil)
+	chk = newHTTPChecker("chk", &plan.HTTPCheck{URL: server.URL, CAFile: badFile})
+	err = chk.check(context.Background())
+	c.Assert(err, ErrorMatches, `cannot find any certificates in CA file ".*/bad.pem"`)
+	chk = newHTTPChecker("chk", &plan.HTTPCheck{URL: server.URL, ClientCert: badFile, ClientKey: badFile})
+	err = chk.check(context.Background())
+	c.Assert(err, ErrorMatches, `cannot load client certificate: .*`)
+}
+
+func (s *CheckersSuite) TestHTTPUnixSocket(c *C) {
+	socketPath := filepath.Join(c.MkDir(), "test.sock")
+	listener, err := net.Listen("unix", socketPath)
+	c.Assert(err, IsNil)
+	var path string
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		fmt.Fprint(w, "ok")
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	chk := newHTTPChecker("chk", &plan.HTTPCheck{
+		URL:        "http://localhost/health",
+		SocketPath: socketPath,
+	})
+	err = chk.check(context.Background())
+	c.Assert(err, IsNil)
+	c.Check(path, Equals, "/health")
+}
+
 func (s *CheckersSuite) TestTCP(c *C) {
 	listener, err := net.Listen("tcp", "localhost:")
 	c.Assert(err, IsNil)
@@ -147,6 +295,144 @@ func (s *CheckersSuite) TestTCP(c *C) {
 	c.Assert(err, ErrorMatches, ".* connection refused")
 }
 
+// serveReplies accepts connections on listener and, for each line received,
+// writes the reply for it (or closes the connection if there's none).
+func serveReplies(listener net.Listener, replies map[string]string) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				reply, ok := replies[scanner.Text()]
+				if !ok {
+					return
+				}
+				fmt.Fprint(conn, reply)
+			}
+		}()
+	}
+}
+
+func (s *CheckersSuite) TestTCPSendExpect(c *C) {
+	listener, err := net.Listen("tcp", "localhost:")
+	c.Assert(err, IsNil)
+	port := listener.Addr().(*net.TCPAddr).Port
+	defer listener.Close()
+	go serveReplies(listener, map[string]string{
+		"PING":   "+PONG\r\n",
+		"SILENT": "",
+	})
+
+	// Expected reply works
+	chk := &tcpChecker{port: port, send: "PING\r\n", expect: regexp.MustCompile(`^\+PONG`)}
+	err = chk.check(context.Background())
+	c.Assert(err, IsNil)
+
+	// Sending without expecting a reply works
+	chk = &tcpChecker{port: port, send: "PING\r\n"}
+	err = chk.check(context.Background())
+	c.Assert(err, IsNil)
+
+	// Unexpected reply fails with the reply in the error details
+	chk = &tcpChecker{port: port, send: "OTHER\r\n", expect: regexp.MustCompile(`^\+PONG`)}
+	err = chk.check(context.Background())
+	c.Assert(err, ErrorMatches, `connection closed without reply matching "\^\\\\\+PONG"`)
+
+	chk = &tcpChecker{port: port, send: "PING\r\n", expect: regexp.MustCompile(`^-ERR`)}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = chk.check(ctx)
+	c.Assert(err, ErrorMatches, `timed out waiting for reply matching "\^-ERR"`)
+	detailsErr, ok := err.(*detailsError)
+	c.Assert(ok, Equals, true)
+	c.Check(detailsErr.Details(), Equals, "+PONG")
+
+	// No reply within the timeout fails
+	chk = &tcpChecker{port: port, send: "SILENT\r\n", expect: regexp.MustCompile(`.`)}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = chk.check(ctx)
+	c.Assert(err, ErrorMatches, `timed out waiting for reply matching "\."`)
+}
+
+func (s *CheckersSuite) TestUnix(c *C) {
+	socketPath := filepath.Join(c.MkDir(), "test.sock")
+	listener, err := net.Listen("unix", socketPath)
+	c.Assert(err, IsNil)
+	defer listener.Close()
+	go serveReplies(listener, map[string]string{"PING": "+PONG\r\n"})
+
+	// Connecting works
+	chk := &unixChecker{path: socketPath}
+	err = chk.check(context.Background())
+	c.Assert(err, IsNil)
+
+	// Expected reply works
+	chk = &unixChecker{path: socketPath, send: "PING\n", expect: regexp.MustCompile(`PONG`)}
+	err = chk.check(context.Background())
+	c.Assert(err, IsNil)
+
+	// Unexpected reply fails
+	chk = &unixChecker{path: socketPath, send: "PING\n", expect: regexp.MustCompile(`ERR`)}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = chk.check(ctx)
+	c.Assert(err, ErrorMatches, `timed out waiting for reply matching "ERR"`)
+
+	// Missing socket fails
+	chk = &unixChecker{path: socketPath + ".missing"}
+	err = chk.check(context.Background())
+	c.Assert(err, ErrorMatches, ".* no such file or directory")
+}
+
+func (s *CheckersSuite) TestUDP(c *C) {
+	conn, err := net.ListenPacket("udp", "localhost:")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if string(buf[:n]) == "ping" {
+				conn.WriteTo([]byte("pong"), addr)
+			}
+		}
+	}()
+
+	// Any reply works if none is expected
+	chk := &udpChecker{port: port, send: "ping"}
+	err = chk.check(context.Background())
+	c.Assert(err, IsNil)
+
+	// Expected reply works
+	chk = &udpChecker{port: port, send: "ping", expect: regexp.MustCompile(`^pong$`)}
+	err = chk.check(context.Background())
+	c.Assert(err, IsNil)
+
+	// Unexpected reply fails
+	chk = &udpChecker{port: port, send: "ping", expect: regexp.MustCompile(`^PONG$`)}
+	err = chk.check(context.Background())
+	c.Assert(err, ErrorMatches, `reply does not match "\^PONG\$"`)
+	detailsErr, ok := err.(*detailsError)
+	c.Assert(ok, Equals, true)
+	c.Check(detailsErr.Details(), Equals, "pong")
+
+	// No reply within the timeout fails
+	chk = &udpChecker{port: port, send: "other"}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = chk.check(ctx)
+	c.Assert(err, ErrorMatches, `timed out waiting for reply matching ""`)
+}
+
 func (s *CheckersSuite) TestExec(c *C) {
 	err := reaper.Start()
 	c.Assert(err, IsNil)
@@ -228,6 +514,17 @@ func (s *CheckersSuite) TestExec(c *C) {
 	c.Assert(ok, Equals, true)
 	c.Assert(detailsErr.Details(), Equals, workingDir)
 
+	// Sandbox options are applied
+	chk = &execChecker{
+		command: "/bin/sh -c 'grep NoNewPrivs /proc/self/status; exit 1'",
+		sandbox: plan.Sandbox{NoNewPrivileges: true},
+	}
+	err = chk.check(context.Background())
+	c.Assert(err, ErrorMatches, "exit status 1")
+	detailsErr, ok = err.(*detailsError)
+	c.Assert(ok, Equals, true)
+	c.Assert(detailsErr.Details(), Equals, "NoNewPrivs:\t1")
+
 	// Cancelled context returns error
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
@@ -235,3 +532,25 @@ func (s *CheckersSuite) TestExec(c *C) {
 	err = chk.check(ctx)
 	c.Assert(err, ErrorMatches, "context canceled")
 }
+
+func (s *CheckersSuite) TestExecUserGroup(c *C) {
+	if os.Getuid() != 0 {
+		c.Skip("requires root to set the user and group")
+	}
+	err := reaper.Start()
+	c.Assert(err, IsNil)
+	defer reaper.Stop()
+
+	// Setting the user and group used to dereference a nil SysProcAttr.
+	uid, gid := 0, 0
+	chk := &execChecker{
+		command: "/bin/sh -c 'id -u; id -g; exit 1'",
+		userID:  &uid,
+		groupID: &gid,
+	}
+	err = chk.check(context.Background())
+	c.Assert(err, ErrorMatches, "exit status 1")
+	detailsErr, ok := err.(*detailsError)
+	c.Assert(ok, Equals, true)
+	c.Assert(detailsErr.Details(), Equals, "0\n0")
+}
@@ -16,27 +16,86 @@ package checkstate
 
 import (
 	"context"
+	"fmt"
+	"reflect"
+	"regexp"
 	"sort"
 	"sync"
 	"time"
 
 	"github.com/canonical/pebble/internal/logger"
+	"github.com/canonical/pebble/internal/metrics"
+	"github.com/canonical/pebble/internal/overlord/state"
 	"github.com/canonical/pebble/internal/plan"
 )
 
+// checkFailureKind is the kind of the changes (and their single task) that
+// record a check's consecutive failures.
+const checkFailureKind = "check-failure"
+
+// stoppedChecksKey is the state key for the names of the checks stopped with
+// StopChecks, so they stay stopped when the daemon restarts.
+const stoppedChecksKey = "stopped-checks"
+
+// defaultFlapWindow is the window in which a check's status changes are
+// counted for flap detection if the check doesn't set flap-window.
+const defaultFlapWindow = 10 * time.Minute
+
 // CheckManager starts and manages the health checks.
 type CheckManager struct {
+	state *state.State
+
 	mutex           sync.Mutex
 	checks          map[string]*checkData
 	failureHandlers []FailureFunc
+	statusHandlers  []StatusChangedFunc
+	stopped         map[string]bool
+
+	services      *serviceTracker
+	serviceActive ServiceActiveFunc
 }
 
 // FailureFunc is the type of function called when a failure action is triggered.
 type FailureFunc func(name string)
 
+// StatusChangedFunc is the type of function called when a check's status
+// changes, for example when it goes up or down.
+type StatusChangedFunc func(name string, status CheckStatus)
+
+// ServiceActiveFunc is the type of function used by SetServiceActive. It
+// reports whether the named service is active.
+type ServiceActiveFunc func(name string) bool
+
 // NewManager creates a new check manager.
-func NewManager() *CheckManager {
-	return &CheckManager{}
+func NewManager(s *state.State, runner *state.TaskRunner) *CheckManager {
+	// Check failure tasks are updated directly by the checks as they run,
+	// so register no handlers to stop the task runner from running them.
+	runner.AddHandler(checkFailureKind, nil, nil)
+
+	// Close any failure tasks left over from a previous run of the daemon.
+	s.Lock()
+	for _, task := range s.Tasks() {
+		if task.Kind() == checkFailureKind && !task.Status().Ready() {
+			task.Logf("Check stopped (daemon restarted)")
+			task.SetStatus(state.HoldStatus)
+		}
+	}
+	var stoppedNames []string
+	err := s.Get(stoppedChecksKey, &stoppedNames)
+	if err != nil && err != state.ErrNoState {
+		logger.Noticef("Cannot load stopped checks: %v", err)
+	}
+	s.Unlock()
+
+	stopped := make(map[string]bool)
+	for _, name := range stoppedNames {
+		stopped[name] = true
+	}
+	return &CheckManager{
+		state:    s,
+		stopped:  stopped,
+		services: newServiceTracker(),
+	}
 }
 
 // NotifyCheckFailed adds f to the list of functions that are called whenever
@@ -45,35 +104,92 @@ func (m *CheckManager) NotifyCheckFailed(f FailureFunc) {
 	m.failureHandlers = append(m.failureHandlers, f)
 }
 
-// PlanChanged handles updates to the plan (server configuration),
-// stopping the previous checks and starting the new ones as required.
+// NotifyCheckStatusChanged adds f to the list of functions that are called
+// whenever a check's status changes: when it first succeeds, when it goes
+// down (hits its failure threshold), and when it comes back up.
+func (m *CheckManager) NotifyCheckStatusChanged(f StatusChangedFunc) {
+	m.statusHandlers = append(m.statusHandlers, f)
+}
+
+// ServiceStatusChanged records whether the named service is active, pausing
+// the checks bound to it while it's not, and resuming them (with their
+// failure counts reset) when it's started again. It doesn't block, so it may
+// be called with the service manager's lock held.
+func (m *CheckManager) ServiceStatusChanged(name string, active bool) {
+	m.services.set(name, active)
+}
+
+// SetServiceActive sets the function used to query whether a service is
+// active when the plan changes, so that checks bound to a service that was
+// started before the manager was told about it aren't left paused.
+func (m *CheckManager) SetServiceActive(f ServiceActiveFunc) {
+	m.serviceActive = f
+}
+
+// PlanChanged handles updates to the plan (server configuration), stopping
+// the checks that were removed or whose configuration changed, and starting
+// the new and changed ones. Checks whose configuration is unchanged keep
+// running, along with their failure counts and status.
 func (m *CheckManager) PlanChanged(p *plan.Plan) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	logger.Debugf("Configuring check manager (stopping %d, starting %d)",
-		len(m.checks), len(p.Checks))
+	if m.serviceActive != nil {
+		for _, config := range p.Checks {
+			if config.Service != "" {
+				m.services.seed(config.Service, m.serviceActive(config.Service))
+			}
+		}
+	}
 
-	// First stop existing checks.
-	for _, check := range m.checks {
+	checks := make(map[string]*checkData, len(p.Checks))
+	var stopped, started int
+	for name, check := range m.checks {
+		if config, ok := p.Checks[name]; ok && reflect.DeepEqual(config, check.config) {
+			checks[name] = check
+			continue
+		}
 		check.cancel()
+		stopped++
 	}
 
-	// Then configure and start new checks.
-	checks := make(map[string]*checkData, len(p.Checks))
 	for name, config := range p.Checks {
+		if _, ok := checks[name]; ok {
+			continue
+		}
 		ctx, cancel := context.WithCancel(context.Background())
 		check := &checkData{
-			config:  config,
-			checker: newChecker(config),
-			ctx:     ctx,
-			cancel:  cancel,
-			action:  m.callFailureHandlers,
+			config:    config,
+			checker:   newChecker(config),
+			service:   config.Service,
+			services:  m.services,
+			requests:  make(chan checkRequest),
+			state:     m.state,
+			stopped:   m.stopped[name],
+			ctx:       ctx,
+			cancel:    cancel,
+			action:    m.callFailureHandlers,
+			changed:   m.callStatusHandlers,
+			durations: metrics.NewHistogram(metrics.DefaultBuckets),
+		}
+		if old, ok := m.checks[name]; ok {
+			// Keep the cumulative metrics of a check that's still configured,
+			// so they don't go back to zero when its configuration changes.
+			old.mutex.Lock()
+			check.totalFailures = old.totalFailures
+			check.durations = old.durations.Copy()
+			check.lastSuccess = old.lastSuccess
+			check.changeID = old.changeID
+			old.mutex.Unlock()
 		}
 		checks[name] = check
+		started++
 		go check.loop()
 	}
 	m.checks = checks
+
+	logger.Debugf("Configured check manager (stopped %d, started %d, unchanged %d)",
+		stopped, started, len(checks)-started)
 }
 
 func (m *CheckManager) callFailureHandlers(name string) {
@@ -82,21 +198,42 @@ func (m *CheckManager) callFailureHandlers(name string) {
 	}
 }
 
+func (m *CheckManager) callStatusHandlers(name string, status CheckStatus) {
+	for _, f := range m.statusHandlers {
+		f(name, status)
+	}
+}
+
 // newChecker creates a new checker of the configured type.
 func newChecker(config *plan.Check) checker {
 	switch {
 	case config.HTTP != nil:
-		return &httpChecker{
-			name:    config.Name,
-			url:     config.HTTP.URL,
-			headers: config.HTTP.Headers,
-		}
+		return newHTTPChecker(config.Name, config.HTTP)
 
 	case config.TCP != nil:
 		return &tcpChecker{
-			name: config.Name,
-			host: config.TCP.Host,
-			port: config.TCP.Port,
+			name:   config.Name,
+			host:   config.TCP.Host,
+			port:   config.TCP.Port,
+			send:   config.TCP.Send,
+			expect: compileExpect(config.TCP.Expect),
+		}
+
+	case config.Unix != nil:
+		return &unixChecker{
+			name:   config.Name,
+			path:   config.Unix.Path,
+			send:   config.Unix.Send,
+			expect: compileExpect(config.Unix.Expect),
+		}
+
+	case config.UDP != nil:
+		return &udpChecker{
+			name:   config.Name,
+			host:   config.UDP.Host,
+			port:   config.UDP.Port,
+			send:   config.UDP.Send,
+			expect: compileExpect(config.UDP.Expect),
 		}
 
 	case config.Exec != nil:
@@ -109,6 +246,7 @@ func newChecker(config *plan.Check) checker {
 			groupID:     config.Exec.GroupID,
 			group:       config.Exec.Group,
 			workingDir:  config.Exec.WorkingDir,
+			sandbox:     config.Exec.Sandbox,
 		}
 
 	default:
@@ -117,6 +255,15 @@ func newChecker(config *plan.Check) checker {
 	}
 }
 
+// compileExpect compiles a check's (already validated) expected reply
+// regexp, returning nil if it's not set.
+func compileExpect(expect string) *regexp.Regexp {
+	if expect == "" {
+		return nil
+	}
+	return regexp.MustCompile(expect)
+}
+
 // Checks returns the list of currently-configured checks and their status,
 // ordered by name.
 func (m *CheckManager) Checks() ([]*CheckInfo, error) {
@@ -134,22 +281,203 @@ func (m *CheckManager) Checks() ([]*CheckInfo, error) {
 	return infos, nil
 }
 
+// NotUp returns the names of the given checks that are not up, that is,
+// checks that are pending, paused, stopped, or down. It returns an error if a check doesn't
+// exist.
+func (m *CheckManager) NotUp(names []string) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var notUp []string
+	for _, name := range names {
+		check, ok := m.checks[name]
+		if !ok {
+			return nil, fmt.Errorf("cannot find check %q", name)
+		}
+		if !check.isUp() {
+			notUp = append(notUp, name)
+		}
+	}
+	return notUp, nil
+}
+
+// RunChecks runs the given checks immediately, waiting for them to finish.
+// A check that fails counts as a failure as usual; the error returned is for
+// checks that can't be run, for example because they're stopped.
+func (m *CheckManager) RunChecks(names []string) error {
+	return m.request(names, actionRun)
+}
+
+// StopChecks stops running the given checks until they're started with
+// StartChecks. This is recorded in the state, so they stay stopped when the
+// daemon restarts. The caller must not hold the state lock.
+func (m *CheckManager) StopChecks(names []string) error {
+	err := m.setStopped(names, true)
+	if err != nil {
+		return err
+	}
+	return m.request(names, actionStop)
+}
+
+// StartChecks starts running the given checks again after StopChecks. The
+// caller must not hold the state lock.
+func (m *CheckManager) StartChecks(names []string) error {
+	err := m.setStopped(names, false)
+	if err != nil {
+		return err
+	}
+	return m.request(names, actionStart)
+}
+
+// ResetChecks clears the failures of the given checks.
+func (m *CheckManager) ResetChecks(names []string) error {
+	return m.request(names, actionReset)
+}
+
+// setStopped records whether the given checks are stopped, in the manager
+// and in the state.
+func (m *CheckManager) setStopped(names []string, stopped bool) error {
+	m.state.Lock()
+	defer m.state.Unlock()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, name := range names {
+		if _, ok := m.checks[name]; !ok {
+			return fmt.Errorf("cannot find check %q", name)
+		}
+	}
+	for _, name := range names {
+		if stopped {
+			m.stopped[name] = true
+		} else {
+			delete(m.stopped, name)
+		}
+	}
+
+	stoppedNames := make([]string, 0, len(m.stopped))
+	for name := range m.stopped {
+		stoppedNames = append(stoppedNames, name)
+	}
+	sort.Strings(stoppedNames)
+	m.state.Set(stoppedChecksKey, stoppedNames)
+	return nil
+}
+
+// request sends the given action to the loops of the given checks, and waits
+// for them to handle it.
+func (m *CheckManager) request(names []string, action checkAction) error {
+	m.mutex.Lock()
+	checks := make([]*checkData, 0, len(names))
+	for _, name := range names {
+		check, ok := m.checks[name]
+		if !ok {
+			m.mutex.Unlock()
+			return fmt.Errorf("cannot find check %q", name)
+		}
+		checks = append(checks, check)
+	}
+	m.mutex.Unlock()
+
+	// Handle the checks concurrently, as running them may take a while.
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check *checkData) {
+			defer wg.Done()
+			errs[i] = check.request(action)
+		}(i, check)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Metrics returns the metrics of the currently-configured checks, ordered by
+// name.
+func (m *CheckManager) Metrics() []*CheckMetrics {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	result := make([]*CheckMetrics, 0, len(m.checks))
+	for _, check := range m.checks {
+		result = append(result, check.metrics())
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// CheckMetrics holds the metrics of a single check.
+type CheckMetrics struct {
+	Name string
+	Up   bool
+
+	// TotalFailures is the number of times the check has failed, whether or
+	// not the failures were consecutive.
+	TotalFailures int
+
+	// Durations is a histogram of how long the check took to run, in seconds.
+	Durations *metrics.Histogram
+}
+
 // CheckInfo provides status information about a single check.
 type CheckInfo struct {
 	Name         string
 	Level        plan.CheckLevel
+	Service      string
 	Status       CheckStatus
 	Failures     int
 	Threshold    int
 	LastError    string
 	ErrorDetails string
+
+	// Successes is the number of consecutive successes, and SuccessThreshold
+	// the number needed for a down or pending check to go up.
+	Successes        int
+	SuccessThreshold int
+
+	// FlapThreshold and FlapWindow are the check's flap detection settings
+	// (FlapThreshold is zero if it's disabled), and Flapping is true if the
+	// check was last detected flapping within the window.
+	FlapThreshold int
+	FlapWindow    time.Duration
+	Flapping      bool
+
+	// LastSuccess is the time the check last succeeded, or the zero time if
+	// it hasn't succeeded since the daemon started.
+	LastSuccess time.Time
+
+	// ChangeID is the ID of the change recording the check's most recent
+	// run of consecutive failures, or "" if it hasn't failed.
+	ChangeID string
 }
 
 type CheckStatus string
 
 const (
+	// CheckStatusPending means the check hasn't succeeded yet (since it was
+	// configured), nor hit its failure threshold.
+	CheckStatusPending CheckStatus = "pending"
+
 	CheckStatusUp   CheckStatus = "up"
 	CheckStatusDown CheckStatus = "down"
+
+	// CheckStatusPaused means the check isn't running because the service
+	// it's bound to isn't active.
+	CheckStatusPaused CheckStatus = "paused"
+
+	// CheckStatusStopped means the check isn't running because it was
+	// stopped with StopChecks.
+	CheckStatusStopped CheckStatus = "stopped"
 )
 
 // checkData holds state for an active health check.
@@ -159,11 +487,54 @@ type checkData struct {
 	ctx     context.Context
 	cancel  context.CancelFunc
 	action  FailureFunc
+	changed StatusChangedFunc
+	state   *state.State
+
+	// service is the name of the service the check is bound to, or "" if
+	// it isn't bound to one.
+	service  string
+	services *serviceTracker
+
+	// requests receives the actions requested by the manager's RunChecks,
+	// StopChecks, StartChecks and ResetChecks.
+	requests chan checkRequest
+
+	// taskID is the ID of the task recording the current run of failures.
+	// It's only accessed by the check's loop goroutine.
+	taskID string
+
+	mutex         sync.Mutex
+	failures      int
+	successes     int
+	actionRan     bool
+	lastErr       error
+	succeeded     bool
+	totalFailures int
+	durations     *metrics.Histogram
+	lastSuccess   time.Time
+	changeID      string
+	paused        bool
+	stopped       bool
+
+	// flips holds the times the check went up or down within the flap
+	// window, and lastFlap the time it was last detected flapping.
+	flips    []time.Time
+	lastFlap time.Time
+}
+
+// checkAction is an action requested of a check's loop goroutine.
+type checkAction string
+
+const (
+	actionRun   checkAction = "run"
+	actionStop  checkAction = "stop"
+	actionStart checkAction = "start"
+	actionReset checkAction = "reset"
+)
 
-	mutex     sync.Mutex
-	failures  int
-	actionRan bool
-	lastErr   error
+type checkRequest struct {
+	action checkAction
+	done   chan error
 }
 
 type checker interface {
@@ -172,53 +543,218 @@ type checker interface {
 
 func (c *checkData) loop() {
 	logger.Debugf("Check %q starting with period %v", c.config.Name, c.config.Period.Value)
+	defer c.recordStopped()
+
+	for c.waitRunnable() && c.run() {
+		// The check was stopped or its service stopped: pause until it can
+		// run again.
+	}
+}
 
-	ticker := time.NewTicker(c.config.Period.Value)
-	defer ticker.Stop()
+// waitRunnable waits until the check can run, that is, until it isn't
+// stopped and the service it's bound to (if any) is active, pausing the check
+// in the meantime. It returns false if the check is removed while waiting.
+func (c *checkData) waitRunnable() bool {
+	for {
+		active, changed := c.serviceStatus()
+		if active && !c.isStopped() {
+			c.resume()
+			return true
+		}
+		c.pause()
+		select {
+		case <-changed:
+		case req := <-c.requests:
+			req.done <- c.handlePaused(req.action)
+		case <-c.ctx.Done():
+			logger.Debugf("Check %q stopped while paused: %v", c.config.Name, c.ctx.Err())
+			return false
+		}
+	}
+}
+
+// handlePaused handles an action requested while the check is paused.
+func (c *checkData) handlePaused(action checkAction) error {
+	switch action {
+	case actionRun:
+		if c.isStopped() {
+			return fmt.Errorf("cannot run check %q: check is stopped", c.config.Name)
+		}
+		return fmt.Errorf("cannot run check %q: service %q is not active", c.config.Name, c.service)
+	case actionStop:
+		c.setStopped(true)
+	case actionStart:
+		if active, _ := c.serviceStatus(); active {
+			// Resume now (rather than when waitRunnable next loops) so the
+			// check's status is up to date when the request is done.
+			c.mutex.Lock()
+			c.stopped = false
+			c.mutex.Unlock()
+			c.resume()
+		} else {
+			c.setStopped(false)
+		}
+	case actionReset:
+		c.reset()
+	}
+	return nil
+}
+
+// run runs the check periodically until it's removed, returning false, or
+// until it's stopped or the service it's bound to is no longer active,
+// returning true.
+func (c *checkData) run() bool {
+	_, changed := c.serviceStatus()
+
+	// Run the check for the first time after the startup delay (if any),
+	// rather than waiting a full period.
+	first := time.NewTimer(c.config.StartupDelay.Value)
+	defer first.Stop()
+	var tick <-chan time.Time
 
 	for {
 		select {
-		case <-ticker.C:
-			c.runCheck()
+		case <-first.C:
+			ticker := time.NewTicker(c.config.Period.Value)
+			defer ticker.Stop()
+			tick = ticker.C
+		case <-tick:
+		case <-changed:
+			var active bool
+			active, changed = c.serviceStatus()
+			if !active {
+				return true
+			}
+			continue
+		case req := <-c.requests:
+			switch req.action {
+			case actionRun:
+				c.runCheck()
+			case actionStop:
+				c.setStopped(true)
+				c.pause()
+			case actionReset:
+				c.reset()
+			}
+			req.done <- nil
 			if c.ctx.Err() != nil {
-				// Don't re-run check in edge case where period is short and
-				// in-flight check was cancelled.
-				return
+				return false
 			}
+			if c.isStopped() {
+				return true
+			}
+			continue
 		case <-c.ctx.Done():
 			logger.Debugf("Check %q stopped: %v", c.config.Name, c.ctx.Err())
-			return
+			return false
+		}
+
+		c.runCheck()
+		if c.ctx.Err() != nil {
+			// Don't re-run check in edge case where period is short and
+			// in-flight check was cancelled.
+			return false
 		}
 	}
 }
 
+// request sends the given action to the check's loop goroutine, and waits
+// for it to be handled.
+func (c *checkData) request(action checkAction) error {
+	req := checkRequest{action: action, done: make(chan error, 1)}
+	select {
+	case c.requests <- req:
+	case <-c.ctx.Done():
+		return fmt.Errorf("cannot %s check %q: check was removed", action, c.config.Name)
+	}
+	return <-req.done
+}
+
+// serviceStatus reports whether the service the check is bound to is active
+// (always true if the check isn't bound to a service), along with a channel
+// that's closed when that may have changed.
+func (c *checkData) serviceStatus() (active bool, changed <-chan struct{}) {
+	if c.service == "" {
+		return true, nil
+	}
+	return c.services.get(c.service)
+}
+
 func (c *checkData) runCheck() {
 	// Run the check with a timeout.
 	ctx, cancel := context.WithTimeout(c.ctx, c.config.Timeout.Value)
 	defer cancel()
+	start := time.Now()
 	err := c.checker.check(ctx)
+	duration := time.Since(start)
+
+	if err != nil && ctx.Err() == context.Canceled {
+		// Check was stopped, don't trigger failure action.
+		logger.Debugf("Check %q canceled in flight", c.config.Name)
+		return
+	}
+	if active, _ := c.serviceStatus(); err != nil && !active {
+		// Service was stopped while the check ran, don't count the failure.
+		logger.Debugf("Check %q failed after service %q stopped: %v", c.config.Name, c.service, err)
+		return
+	}
 
+	failures, flapping := c.update(err, duration)
+
+	// Record the result in the state outside of the check's lock, as the
+	// state lock may be held by callers of the manager.
+	if err != nil {
+		c.recordFailure(err, failures)
+	} else if failures > 0 {
+		c.recordSuccess(failures)
+	}
+	if flapping {
+		c.recordFlapping()
+	}
+}
+
+// update updates the check's status after it has run. It returns the number
+// of consecutive failures: including this one if the check failed, or
+// before this success if it succeeded and is now up (zero if it needs more
+// successes to go up). It also reports whether the check is now flapping.
+func (c *checkData) update(err error, duration time.Duration) (failures int, flapping bool) {
 	// Lock while we update state, as the manager may access these too.
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
+	c.durations.ObserveDuration(duration)
+
+	oldStatus := c.status()
+	defer func() {
+		if status := c.status(); status != oldStatus {
+			c.changed(c.config.Name, status)
+			if isUpOrDown(oldStatus) && isUpOrDown(status) {
+				flapping = c.flipped(time.Now())
+			}
+		}
+	}()
+
 	if err == nil {
 		// Successful check
+		c.successes++
+		c.lastSuccess = time.Now()
+		if c.status() != CheckStatusUp && c.successes < c.successThreshold() {
+			// Not up until it has succeeded enough times in a row.
+			return 0, false
+		}
+		failures := c.failures
 		c.lastErr = nil
 		c.failures = 0
 		c.actionRan = false
-		return
-	}
-
-	if ctx.Err() == context.Canceled {
-		// Check was stopped, don't trigger failure action.
-		logger.Debugf("Check %q canceled in flight", c.config.Name)
-		return
+		c.succeeded = true
+		return failures, false
 	}
 
 	// Track failure, run failure action if "failures" threshold was hit.
 	c.lastErr = err
+	c.successes = 0
 	c.failures++
+	c.totalFailures++
 	logger.Noticef("Check %q failure %d (threshold %d): %v",
 		c.config.Name, c.failures, c.config.Threshold, err)
 	if !c.actionRan && c.failures >= c.config.Threshold {
@@ -227,6 +763,263 @@ func (c *checkData) runCheck() {
 		c.action(c.config.Name)
 		c.actionRan = true
 	}
+	return c.failures, false
+}
+
+// successThreshold returns the number of consecutive successes needed for
+// the check to go up.
+func (c *checkData) successThreshold() int {
+	if c.config.SuccessThreshold > 0 {
+		return c.config.SuccessThreshold
+	}
+	return 1
+}
+
+// flapWindow returns the window in which status changes are counted for flap
+// detection.
+func (c *checkData) flapWindow() time.Duration {
+	if c.config.FlapWindow.IsSet {
+		return c.config.FlapWindow.Value
+	}
+	return defaultFlapWindow
+}
+
+// isUpOrDown reports whether the status is one that counts towards flap
+// detection when the check changes between them.
+func isUpOrDown(status CheckStatus) bool {
+	return status == CheckStatusUp || status == CheckStatusDown
+}
+
+// flipped records that the check went up or down at the given time, and
+// reports whether it's now flapping: it has changed status flap-threshold
+// times within the flap window. The caller must hold the check's mutex.
+func (c *checkData) flipped(now time.Time) bool {
+	if c.config.FlapThreshold <= 0 {
+		return false
+	}
+	cutoff := now.Add(-c.flapWindow())
+	flips := c.flips[:0]
+	for _, t := range c.flips {
+		if t.After(cutoff) {
+			flips = append(flips, t)
+		}
+	}
+	c.flips = append(flips, now)
+	if len(c.flips) < c.config.FlapThreshold {
+		return false
+	}
+	// Start counting again, so that the check is only reported again if it
+	// keeps flapping.
+	c.flips = nil
+	c.lastFlap = now
+	return true
+}
+
+// recordFlapping records a warning that the check is flapping.
+func (c *checkData) recordFlapping() {
+	logger.Noticef("Check %q is flapping", c.config.Name)
+
+	c.state.Lock()
+	defer c.state.Unlock()
+
+	c.state.Warnf("Check %q is flapping: it went up or down %d times within %s",
+		c.config.Name, c.config.FlapThreshold, c.flapWindow())
+}
+
+// recordFailure records a check failure in the state. The first of a run of
+// consecutive failures creates a check-failure change, and each failure is
+// logged to its task along with the error details. The task is put in Error
+// status when the failure threshold is hit.
+func (c *checkData) recordFailure(err error, failures int) {
+	c.state.Lock()
+	defer c.state.Unlock()
+
+	var task *state.Task
+	if c.taskID != "" {
+		task = c.state.Task(c.taskID)
+	}
+	if task == nil {
+		summary := fmt.Sprintf("Check %q failing", c.config.Name)
+		chg := c.state.NewChange(checkFailureKind, summary)
+		task = c.state.NewTask(checkFailureKind, summary)
+		task.Set("check-name", c.config.Name)
+		chg.AddTask(task)
+		task.SetStatus(state.DoingStatus)
+		c.taskID = task.ID()
+
+		c.mutex.Lock()
+		c.changeID = chg.ID()
+		c.mutex.Unlock()
+	}
+
+	message := fmt.Sprintf("Check failure %d (threshold %d): %v", failures, c.config.Threshold, err)
+	if d, ok := err.(interface{ Details() string }); ok && d.Details() != "" {
+		message += "\n" + d.Details()
+	}
+	task.Errorf("%s", message)
+	if failures >= c.config.Threshold && !task.Status().Ready() {
+		task.SetStatus(state.ErrorStatus)
+	}
+}
+
+// recordSuccess records that the check succeeded after the given number of
+// failures, completing the current check-failure task if it didn't hit the
+// failure threshold.
+func (c *checkData) recordSuccess(failures int) {
+	if c.taskID == "" {
+		return
+	}
+
+	c.state.Lock()
+	defer c.state.Unlock()
+
+	task := c.state.Task(c.taskID)
+	c.taskID = ""
+	if task == nil {
+		return
+	}
+	task.Logf("Check succeeded after %d failure(s)", failures)
+	if !task.Status().Ready() {
+		task.SetStatus(state.DoneStatus)
+	}
+}
+
+// pause marks the check as paused because it was stopped or its service
+// isn't active, closing the current check-failure task (if any).
+func (c *checkData) pause() {
+	c.mutex.Lock()
+	if c.paused {
+		c.mutex.Unlock()
+		return
+	}
+	oldStatus := c.status()
+	c.paused = true
+	if status := c.status(); status != oldStatus {
+		c.changed(c.config.Name, status)
+	}
+	stopped := c.stopped
+	c.mutex.Unlock()
+
+	if stopped {
+		logger.Noticef("Check %q stopped by user", c.config.Name)
+		c.holdTask("Check stopped by user")
+	} else {
+		logger.Noticef("Check %q paused while service %q is not active", c.config.Name, c.service)
+		c.holdTask(fmt.Sprintf("Check paused (service %q not active)", c.service))
+	}
+}
+
+// resume marks a paused check as running again, resetting its failure count
+// so that it's pending until it next succeeds.
+func (c *checkData) resume() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.paused {
+		return
+	}
+	oldStatus := c.status()
+	c.paused = false
+	c.failures = 0
+	c.successes = 0
+	c.actionRan = false
+	c.lastErr = nil
+	c.succeeded = false
+	if status := c.status(); status != oldStatus {
+		c.changed(c.config.Name, status)
+	}
+	logger.Noticef("Check %q resumed", c.config.Name)
+}
+
+// setStopped sets whether the check is stopped.
+func (c *checkData) setStopped(stopped bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	oldStatus := c.status()
+	c.stopped = stopped
+	if status := c.status(); status != oldStatus {
+		c.changed(c.config.Name, status)
+	}
+}
+
+// isStopped reports whether the check is stopped.
+func (c *checkData) isStopped() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.stopped
+}
+
+// reset clears the check's failures, closing the current check-failure task
+// (if any).
+func (c *checkData) reset() {
+	c.mutex.Lock()
+	oldStatus := c.status()
+	c.failures = 0
+	c.actionRan = false
+	c.lastErr = nil
+	if status := c.status(); status != oldStatus {
+		c.changed(c.config.Name, status)
+	}
+	c.mutex.Unlock()
+
+	logger.Noticef("Check %q failures reset", c.config.Name)
+	c.holdTask("Check failures reset")
+}
+
+// recordStopped records that the check was stopped (for example, because it
+// was removed from the plan) during a run of failures.
+func (c *checkData) recordStopped() {
+	c.holdTask("Check stopped")
+}
+
+// holdTask logs the given message to the current check-failure task (if any)
+// and puts it on hold, as the run of failures it records has ended without
+// the check succeeding.
+func (c *checkData) holdTask(message string) {
+	if c.taskID == "" {
+		return
+	}
+
+	c.state.Lock()
+	defer c.state.Unlock()
+
+	task := c.state.Task(c.taskID)
+	c.taskID = ""
+	if task == nil {
+		return
+	}
+	task.Logf("%s", message)
+	if !task.Status().Ready() {
+		task.SetStatus(state.HoldStatus)
+	}
+}
+
+// status returns the check's current status. The caller must hold the
+// check's mutex.
+func (c *checkData) status() CheckStatus {
+	switch {
+	case c.stopped:
+		return CheckStatusStopped
+	case c.paused:
+		return CheckStatusPaused
+	case c.failures >= c.config.Threshold:
+		return CheckStatusDown
+	case !c.succeeded:
+		return CheckStatusPending
+	default:
+		return CheckStatusUp
+	}
+}
+
+// isUp reports whether the check has succeeded at least once and hasn't hit
+// its failure threshold since.
+func (c *checkData) isUp() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.status() == CheckStatusUp
 }
 
 // info returns user-facing check information for use in Checks (and tests).
@@ -235,14 +1028,22 @@ func (c *checkData) info() *CheckInfo {
 	defer c.mutex.Unlock()
 
 	info := &CheckInfo{
-		Name:      c.config.Name,
-		Level:     c.config.Level,
-		Status:    CheckStatusUp,
-		Failures:  c.failures,
-		Threshold: c.config.Threshold,
+		Name:        c.config.Name,
+		Level:       c.config.Level,
+		Service:     c.service,
+		Status:      c.status(),
+		Failures:    c.failures,
+		Threshold:   c.config.Threshold,
+		LastSuccess: c.lastSuccess,
+		ChangeID:    c.changeID,
+
+		Successes:        c.successes,
+		SuccessThreshold: c.successThreshold(),
 	}
-	if c.failures >= c.config.Threshold {
-		info.Status = CheckStatusDown
+	if c.config.FlapThreshold > 0 {
+		info.FlapThreshold = c.config.FlapThreshold
+		info.FlapWindow = c.flapWindow()
+		info.Flapping = !c.lastFlap.IsZero() && time.Since(c.lastFlap) < info.FlapWindow
 	}
 	if c.lastErr != nil {
 		info.LastError = c.lastErr.Error()
@@ -252,3 +1053,16 @@ func (c *checkData) info() *CheckInfo {
 	}
 	return info
 }
+
+// metrics returns the check's metrics for use in Metrics.
+func (c *checkData) metrics() *CheckMetrics {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return &CheckMetrics{
+		Name:          c.config.Name,
+		Up:            c.status() == CheckStatusUp,
+		TotalFailures: c.totalFailures,
+		Durations:     c.durations.Copy(),
+	}
+}
@@ -25,16 +25,29 @@ import (
 
 	. "gopkg.in/check.v1"
 
+	"github.com/canonical/pebble/internal/jsonpath"
 	"github.com/canonical/pebble/internal/logger"
+	"github.com/canonical/pebble/internal/overlord/state"
 	"github.com/canonical/pebble/internal/plan"
 	"github.com/canonical/pebble/internal/reaper"
+	"github.com/canonical/pebble/internal/sandbox"
 )
 
 func Test(t *testing.T) {
 	TestingT(t)
 }
 
-type ManagerSuite struct{}
+// TestMain runs the exec helper when a sandboxed command started by a test
+// re-runs the test binary as one.
+func TestMain(m *testing.M) {
+	sandbox.RunHelper()
+	os.Exit(m.Run())
+}
+
+type ManagerSuite struct {
+	st     *state.State
+	runner *state.TaskRunner
+}
 
 var _ = Suite(&ManagerSuite{})
 
@@ -50,34 +63,42 @@ func (s *ManagerSuite) SetUpSuite(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *ManagerSuite) SetUpTest(c *C) {
+	s.st = state.New(nil)
+	s.runner = state.NewTaskRunner(s.st)
+}
+
 func (s *ManagerSuite) TearDownSuite(c *C) {
 	err := reaper.Stop()
 	c.Assert(err, IsNil)
 }
 
 func (s *ManagerSuite) TestChecks(c *C) {
-	mgr := NewManager()
+	mgr := NewManager(s.st, s.runner)
 	mgr.PlanChanged(&plan.Plan{
 		Checks: map[string]*plan.Check{
 			"chk1": {
-				Name:      "chk1",
-				Period:    plan.OptionalDuration{Value: time.Second},
-				Threshold: 3,
-				Exec:      &plan.ExecCheck{Command: "echo chk1"},
+				Name:         "chk1",
+				Period:       plan.OptionalDuration{Value: time.Second},
+				StartupDelay: plan.OptionalDuration{Value: time.Minute, IsSet: true},
+				Threshold:    3,
+				Exec:         &plan.ExecCheck{Command: "echo chk1"},
 			},
 			"chk2": {
-				Name:      "chk2",
-				Level:     "alive",
-				Period:    plan.OptionalDuration{Value: time.Second},
-				Threshold: 3,
-				Exec:      &plan.ExecCheck{Command: "echo chk2"},
+				Name:         "chk2",
+				Level:        "alive",
+				Period:       plan.OptionalDuration{Value: time.Second},
+				StartupDelay: plan.OptionalDuration{Value: time.Minute, IsSet: true},
+				Threshold:    3,
+				Exec:         &plan.ExecCheck{Command: "echo chk2"},
 			},
 			"chk3": {
-				Name:      "chk3",
-				Level:     "ready",
-				Period:    plan.OptionalDuration{Value: time.Second},
-				Threshold: 3,
-				Exec:      &plan.ExecCheck{Command: "echo chk3"},
+				Name:         "chk3",
+				Level:        "ready",
+				Period:       plan.OptionalDuration{Value: time.Second},
+				StartupDelay: plan.OptionalDuration{Value: time.Minute, IsSet: true},
+				Threshold:    3,
+				Exec:         &plan.ExecCheck{Command: "echo chk3"},
 			},
 		},
 	})
@@ -86,26 +107,27 @@ func (s *ManagerSuite) TestChecks(c *C) {
 	checks, err := mgr.Checks()
 	c.Assert(err, IsNil)
 	c.Assert(checks, DeepEquals, []*CheckInfo{
-		{Name: "chk1", Status: "up", Threshold: 3},
-		{Name: "chk2", Status: "up", Level: "alive", Threshold: 3},
-		{Name: "chk3", Status: "up", Level: "ready", Threshold: 3},
+		{Name: "chk1", Status: "pending", Threshold: 3, SuccessThreshold: 1},
+		{Name: "chk2", Status: "pending", Level: "alive", Threshold: 3, SuccessThreshold: 1},
+		{Name: "chk3", Status: "pending", Level: "ready", Threshold: 3, SuccessThreshold: 1},
 	})
 
 	// Re-configuring should update checks
 	mgr.PlanChanged(&plan.Plan{
 		Checks: map[string]*plan.Check{
 			"chk4": {
-				Name:      "chk4",
-				Period:    plan.OptionalDuration{Value: time.Second},
-				Threshold: 3,
-				Exec:      &plan.ExecCheck{Command: "echo chk4"},
+				Name:         "chk4",
+				Period:       plan.OptionalDuration{Value: time.Second},
+				StartupDelay: plan.OptionalDuration{Value: time.Minute, IsSet: true},
+				Threshold:    3,
+				Exec:         &plan.ExecCheck{Command: "echo chk4"},
 			},
 		},
 	})
 	checks, err = mgr.Checks()
 	c.Assert(err, IsNil)
 	c.Assert(checks, DeepEquals, []*CheckInfo{
-		{Name: "chk4", Status: "up", Threshold: 3},
+		{Name: "chk4", Status: "pending", Threshold: 3, SuccessThreshold: 1},
 	})
 }
 
@@ -117,7 +139,7 @@ func stopChecks(c *C, mgr *CheckManager) {
 }
 
 func (s *ManagerSuite) TestTimeout(c *C) {
-	mgr := NewManager()
+	mgr := NewManager(s.st, s.runner)
 	mgr.PlanChanged(&plan.Plan{
 		Checks: map[string]*plan.Check{
 			"chk1": {
@@ -132,7 +154,7 @@ func (s *ManagerSuite) TestTimeout(c *C) {
 	defer stopChecks(c, mgr)
 
 	check := waitCheck(c, mgr, "chk1", func(check *CheckInfo) bool {
-		return check.Status != CheckStatusUp
+		return check.Status == CheckStatusDown
 	})
 	c.Assert(check.Failures, Equals, 1)
 	c.Assert(check.Threshold, Equals, 1)
@@ -141,7 +163,7 @@ func (s *ManagerSuite) TestTimeout(c *C) {
 }
 
 func (s *ManagerSuite) TestCheckCanceled(c *C) {
-	mgr := NewManager()
+	mgr := NewManager(s.st, s.runner)
 	failureName := ""
 	mgr.NotifyCheckFailed(func(name string) {
 		failureName = name
@@ -198,7 +220,7 @@ func (s *ManagerSuite) TestCheckCanceled(c *C) {
 
 	// Ensure it didn't update check failure details (white box testing)
 	info := check.info()
-	c.Check(info.Status, Equals, CheckStatusUp)
+	c.Check(info.Status, Equals, CheckStatusPending)
 	c.Check(info.Failures, Equals, 0)
 	c.Check(info.Threshold, Equals, 1)
 	c.Check(info.LastError, Equals, "")
@@ -206,7 +228,7 @@ func (s *ManagerSuite) TestCheckCanceled(c *C) {
 }
 
 func (s *ManagerSuite) TestFailures(c *C) {
-	mgr := NewManager()
+	mgr := NewManager(s.st, s.runner)
 	failureName := ""
 	mgr.NotifyCheckFailed(func(name string) {
 		failureName = name
@@ -234,7 +256,7 @@ func (s *ManagerSuite) TestFailures(c *C) {
 		return check.Failures == 1
 	})
 	c.Assert(check.Threshold, Equals, 3)
-	c.Assert(check.Status, Equals, CheckStatusUp)
+	c.Assert(check.Status, Equals, CheckStatusPending)
 	c.Assert(check.LastError, Matches, "exit status 1")
 	c.Assert(failureName, Equals, "")
 
@@ -243,7 +265,7 @@ func (s *ManagerSuite) TestFailures(c *C) {
 		return check.Failures == 2
 	})
 	c.Assert(check.Threshold, Equals, 3)
-	c.Assert(check.Status, Equals, CheckStatusUp)
+	c.Assert(check.Status, Equals, CheckStatusPending)
 	c.Assert(check.LastError, Matches, "exit status 1")
 	c.Assert(failureName, Equals, "")
 
@@ -269,6 +291,680 @@ func (s *ManagerSuite) TestFailures(c *C) {
 	c.Assert(failureName, Equals, "")
 }
 
+func (s *ManagerSuite) TestFailureChange(c *C) {
+	mgr := NewManager(s.st, s.runner)
+	testPath := c.MkDir() + "/test"
+	err := ioutil.WriteFile(testPath, nil, 0o644)
+	c.Assert(err, IsNil)
+	mgr.PlanChanged(&plan.Plan{
+		Checks: map[string]*plan.Check{
+			"chk1": {
+				Name:      "chk1",
+				Period:    plan.OptionalDuration{Value: 20 * time.Millisecond},
+				Timeout:   plan.OptionalDuration{Value: 100 * time.Millisecond},
+				Threshold: 2,
+				Exec: &plan.ExecCheck{
+					Command: fmt.Sprintf(`/bin/sh -c 'echo oops; [ ! -f %s ]'`, testPath),
+				},
+			},
+		},
+	})
+	defer stopChecks(c, mgr)
+
+	// Failures are recorded in a change, which is in Error status once the
+	// failure threshold is hit.
+	check := waitCheck(c, mgr, "chk1", func(check *CheckInfo) bool {
+		return check.Failures >= 2
+	})
+	c.Assert(check.ChangeID, Not(Equals), "")
+	c.Assert(check.LastSuccess.IsZero(), Equals, true)
+
+	s.st.Lock()
+	chg := s.st.Change(check.ChangeID)
+	c.Assert(chg, NotNil)
+	c.Check(chg.Kind(), Equals, "check-failure")
+	c.Check(chg.Summary(), Equals, `Check "chk1" failing`)
+	c.Check(chg.Status(), Equals, state.ErrorStatus)
+	tasks := chg.Tasks()
+	c.Assert(tasks, HasLen, 1)
+	var checkName string
+	err = tasks[0].Get("check-name", &checkName)
+	c.Check(err, IsNil)
+	c.Check(checkName, Equals, "chk1")
+	log := tasks[0].Log()
+	c.Assert(len(log) >= 2, Equals, true)
+	c.Check(log[0], Matches, `(?s).* ERROR Check failure 1 \(threshold 2\): exit status 1\n.*oops.*`)
+	c.Check(log[1], Matches, `(?s).* ERROR Check failure 2 \(threshold 2\): exit status 1\n.*oops.*`)
+	s.st.Unlock()
+
+	// When the check succeeds again, the change is kept for reference.
+	err = os.Remove(testPath)
+	c.Assert(err, IsNil)
+	check = waitCheck(c, mgr, "chk1", func(check *CheckInfo) bool {
+		return check.Status == CheckStatusUp && check.Failures == 0
+	})
+	c.Check(check.ChangeID, Equals, chg.ID())
+	c.Check(check.LastSuccess.IsZero(), Equals, false)
+
+	s.st.Lock()
+	defer s.st.Unlock()
+	c.Check(chg.Status(), Equals, state.ErrorStatus)
+	log = chg.Tasks()[0].Log()
+	c.Check(log[len(log)-1], Matches, `.* INFO Check succeeded after \d+ failure\(s\)`)
+}
+
+func (s *ManagerSuite) TestFailureChangeLeftOver(c *C) {
+	s.st.Lock()
+	chg := s.st.NewChange("check-failure", `Check "chk1" failing`)
+	task := s.st.NewTask("check-failure", `Check "chk1" failing`)
+	chg.AddTask(task)
+	task.SetStatus(state.DoingStatus)
+	s.st.Unlock()
+
+	NewManager(s.st, s.runner)
+
+	s.st.Lock()
+	defer s.st.Unlock()
+	c.Check(task.Status(), Equals, state.HoldStatus)
+	c.Check(task.Log(), HasLen, 1)
+	c.Check(task.Log()[0], Matches, `.* INFO Check stopped \(daemon restarted\)`)
+}
+
+func (s *ManagerSuite) TestPlanChangedKeepsUnchangedChecks(c *C) {
+	mgr := NewManager(s.st, s.runner)
+	failing := func() *plan.Check {
+		return &plan.Check{
+			Name:      "chk1",
+			Period:    plan.OptionalDuration{Value: 20 * time.Millisecond},
+			Timeout:   plan.OptionalDuration{Value: 10 * time.Millisecond},
+			Threshold: 100,
+			Exec:      &plan.ExecCheck{Command: "/bin/sh -c 'exit 1'"},
+		}
+	}
+	mgr.PlanChanged(&plan.Plan{
+		Checks: map[string]*plan.Check{"chk1": failing()},
+	})
+	defer stopChecks(c, mgr)
+	waitCheck(c, mgr, "chk1", func(check *CheckInfo) bool {
+		return check.Failures >= 2
+	})
+	mgr.mutex.Lock()
+	original := mgr.checks["chk1"]
+	mgr.mutex.Unlock()
+
+	// An unrelated change (a new check) leaves the existing check running
+	// with its failure count.
+	mgr.PlanChanged(&plan.Plan{
+		Checks: map[string]*plan.Check{
+			"chk1": failing(),
+			"chk2": {
+				Name:      "chk2",
+				Period:    plan.OptionalDuration{Value: time.Second},
+				Threshold: 3,
+				Exec:      &plan.ExecCheck{Command: "echo chk2"},
+			},
+		},
+	})
+	mgr.mutex.Lock()
+	c.Check(mgr.checks["chk1"], Equals, original)
+	mgr.mutex.Unlock()
+	c.Check(original.ctx.Err(), IsNil)
+	checks, err := mgr.Checks()
+	c.Assert(err, IsNil)
+	c.Assert(checks, HasLen, 2)
+	c.Check(checks[0].Failures >= 2, Equals, true)
+
+	// Changing the check's configuration restarts it.
+	changed := failing()
+	changed.Threshold = 50
+	mgr.PlanChanged(&plan.Plan{
+		Checks: map[string]*plan.Check{"chk1": changed},
+	})
+	c.Check(original.ctx.Err(), NotNil)
+	checks, err = mgr.Checks()
+	c.Assert(err, IsNil)
+	c.Assert(checks, HasLen, 1)
+	c.Check(checks[0].Threshold, Equals, 50)
+	c.Check(checks[0].Failures < 2, Equals, true)
+}
+
+func (s *ManagerSuite) TestStartupDelay(c *C) {
+	mgr := NewManager(s.st, s.runner)
+	statuses := make(chan string, 10)
+	mgr.NotifyCheckStatusChanged(func(name string, status CheckStatus) {
+		statuses <- name + " " + string(status)
+	})
+	mgr.PlanChanged(&plan.Plan{
+		Checks: map[string]*plan.Check{
+			"chk1": {
+				Name:      "chk1",
+				Period:    plan.OptionalDuration{Value: time.Minute},
+				Timeout:   plan.OptionalDuration{Value: time.Second},
+				Threshold: 3,
+				Exec:      &plan.ExecCheck{Command: "echo chk1"},
+			},
+			"chk2": {
+				Name:         "chk2",
+				Period:       plan.OptionalDuration{Value: time.Minute},
+				Timeout:      plan.OptionalDuration{Value: time.Second},
+				StartupDelay: plan.OptionalDuration{Value: 200 * time.Millisecond, IsSet: true},
+				Threshold:    3,
+				Exec:         &plan.ExecCheck{Command: "echo chk2"},
+			},
+		},
+	})
+	defer stopChecks(c, mgr)
+
+	// The second check is pending until its startup delay has passed.
+	checks, err := mgr.Checks()
+	c.Assert(err, IsNil)
+	c.Assert(checks[1].Name, Equals, "chk2")
+	c.Assert(checks[1].Status, Equals, CheckStatusPending)
+
+	// The first check runs immediately rather than after a full period, so
+	// comes up first.
+	for _, expected := range []string{"chk1 up", "chk2 up"} {
+		select {
+		case status := <-statuses:
+			c.Assert(status, Equals, expected)
+		case <-time.After(5 * time.Second):
+			c.Fatalf("timed out waiting for %q", expected)
+		}
+	}
+}
+
+func (s *ManagerSuite) TestServiceBound(c *C) {
+	mgr := NewManager(s.st, s.runner)
+	testPath := c.MkDir() + "/test"
+	err := ioutil.WriteFile(testPath, nil, 0o644)
+	c.Assert(err, IsNil)
+	mgr.PlanChanged(&plan.Plan{
+		Services: map[string]*plan.Service{
+			"svc1": {Name: "svc1", Command: "sleep 10"},
+		},
+		Checks: map[string]*plan.Check{
+			"chk1": {
+				Name:      "chk1",
+				Service:   "svc1",
+				Period:    plan.OptionalDuration{Value: 20 * time.Millisecond},
+				Timeout:   plan.OptionalDuration{Value: 100 * time.Millisecond},
+				Threshold: 3,
+				Exec: &plan.ExecCheck{
+					Command: fmt.Sprintf(`/bin/sh -c '[ ! -f %s ]'`, testPath),
+				},
+			},
+		},
+	})
+	defer stopChecks(c, mgr)
+
+	// The check doesn't run until its service is active.
+	check := waitCheck(c, mgr, "chk1", func(check *CheckInfo) bool {
+		return check.Status == CheckStatusPaused
+	})
+	c.Assert(check.Service, Equals, "svc1")
+	time.Sleep(50 * time.Millisecond)
+	checks, err := mgr.Checks()
+	c.Assert(err, IsNil)
+	c.Assert(checks[0].Failures, Equals, 0)
+
+	mgr.ServiceStatusChanged("svc1", true)
+	waitCheck(c, mgr, "chk1", func(check *CheckInfo) bool {
+		return check.Failures == 2
+	})
+
+	// Stopping the service pauses the check, and starting it again resets
+	// its failure count.
+	mgr.ServiceStatusChanged("svc1", false)
+	waitCheck(c, mgr, "chk1", func(check *CheckInfo) bool {
+		return check.Status == CheckStatusPaused
+	})
+	err = os.Remove(testPath)
+	c.Assert(err, IsNil)
+	mgr.ServiceStatusChanged("svc1", true)
+	check = waitCheck(c, mgr, "chk1", func(check *CheckInfo) bool {
+		return check.Status == CheckStatusUp
+	})
+	c.Assert(check.Failures, Equals, 0)
+
+	// The failure change was put on hold when the check was paused.
+	s.st.Lock()
+	defer s.st.Unlock()
+	chg := s.st.Change(check.ChangeID)
+	c.Assert(chg, NotNil)
+	c.Assert(chg.Status(), Equals, state.HoldStatus)
+	log := chg.Tasks()[0].Log()
+	c.Assert(log[len(log)-1], Matches, `.* Check paused \(service "svc1" not active\)`)
+}
+
+func (s *ManagerSuite) TestServiceBoundAlreadyActive(c *C) {
+	mgr := NewManager(s.st, s.runner)
+	mgr.SetServiceActive(func(name string) bool {
+		return name == "svc1" || name == "svc2"
+	})
+	// A notification is more recent than what the query returns.
+	mgr.ServiceStatusChanged("svc2", false)
+	mgr.PlanChanged(&plan.Plan{
+		Services: map[string]*plan.Service{
+			"svc1": {Name: "svc1", Command: "sleep 10"},
+			"svc2": {Name: "svc2", Command: "sleep 10"},
+		},
+		Checks: map[string]*plan.Check{
+			"chk1": {
+				Name:      "chk1",
+				Service:   "svc1",
+				Period:    plan.OptionalDuration{Value: 20 * time.Millisecond},
+				Timeout:   plan.OptionalDuration{Value: 100 * time.Millisecond},
+				Threshold: 3,
+				Exec:      &plan.ExecCheck{Command: "true"},
+			},
+			"chk2": {
+				Name:      "chk2",
+				Service:   "svc2",
+				Period:    plan.OptionalDuration{Value: 20 * time.Millisecond},
+				Timeout:   plan.OptionalDuration{Value: 100 * time.Millisecond},
+				Threshold: 3,
+				Exec:      &plan.ExecCheck{Command: "true"},
+			},
+		},
+	})
+	defer stopChecks(c, mgr)
+
+	// The check bound to a service that was already active runs straight
+	// away, rather than waiting for the service to change status.
+	waitCheck(c, mgr, "chk1", func(check *CheckInfo) bool {
+		return check.Status == CheckStatusUp
+	})
+	check := waitCheck(c, mgr, "chk2", func(check *CheckInfo) bool {
+		return check.Status == CheckStatusPaused
+	})
+	c.Assert(check.Service, Equals, "svc2")
+}
+
+func (s *ManagerSuite) TestRunChecks(c *C) {
+	mgr := NewManager(s.st, s.runner)
+	testPath := c.MkDir() + "/test"
+	err := ioutil.WriteFile(testPath, nil, 0o644)
+	c.Assert(err, IsNil)
+	mgr.PlanChanged(&plan.Plan{
+		Checks: map[string]*plan.Check{
+			"chk1": {
+				Name:         "chk1",
+				Period:       plan.OptionalDuration{Value: time.Minute},
+				Timeout:      plan.OptionalDuration{Value: time.Second},
+				StartupDelay: plan.OptionalDuration{Value: time.Minute, IsSet: true},
+				Threshold:    3,
+				Exec: &plan.ExecCheck{
+					Command: fmt.Sprintf(`/bin/sh -c '[ ! -f %s ]'`, testPath),
+				},
+			},
+		},
+	})
+	defer stopChecks(c, mgr)
+
+	// The check runs (and fails) immediately, despite its startup delay.
+	err = mgr.RunChecks([]string{"chk1"})
+	c.Assert(err, IsNil)
+	checks, err := mgr.Checks()
+	c.Assert(err, IsNil)
+	c.Assert(checks[0].Status, Equals, CheckStatusPending)
+	c.Assert(checks[0].Failures, Equals, 1)
+	c.Assert(checks[0].LastError, Equals, "exit status 1")
+
+	err = os.Remove(testPath)
+	c.Assert(err, IsNil)
+	err = mgr.RunChecks([]string{"chk1"})
+	c.Assert(err, IsNil)
+	checks, err = mgr.Checks()
+	c.Assert(err, IsNil)
+	c.Assert(checks[0].Status, Equals, CheckStatusUp)
+	c.Assert(checks[0].Failures, Equals, 0)
+
+	err = mgr.RunChecks([]string{"chk1", "chk2"})
+	c.Assert(err, ErrorMatches, `cannot find check "chk2"`)
+}
+
+func (s *ManagerSuite) TestStopStartChecks(c *C) {
+	mgr := NewManager(s.st, s.runner)
+	config := &plan.Plan{
+		Checks: map[string]*plan.Check{
+			"chk1": {
+				Name:      "chk1",
+				Period:    plan.OptionalDuration{Value: 20 * time.Millisecond},
+				Timeout:   plan.OptionalDuration{Value: time.Second},
+				Threshold: 3,
+				Exec:      &plan.ExecCheck{Command: "/bin/sh -c 'exit 1'"},
+			},
+		},
+	}
+	mgr.PlanChanged(config)
+	defer stopChecks(c, mgr)
+	check := waitCheck(c, mgr, "chk1", func(check *CheckInfo) bool {
+		return check.Failures == 1
+	})
+
+	err := mgr.StopChecks([]string{"chk1"})
+	c.Assert(err, IsNil)
+	checks, err := mgr.Checks()
+	c.Assert(err, IsNil)
+	c.Assert(checks[0].Status, Equals, CheckStatusStopped)
+	failures := checks[0].Failures
+	err = mgr.RunChecks([]string{"chk1"})
+	c.Assert(err, ErrorMatches, `cannot run check "chk1": check is stopped`)
+	time.Sleep(50 * time.Millisecond)
+	checks, err = mgr.Checks()
+	c.Assert(err, IsNil)
+	c.Assert(checks[0].Failures, Equals, failures)
+
+	// The failure change was put on hold when the check was stopped.
+	s.st.Lock()
+	var stopped []string
+	c.Assert(s.st.Get("stopped-checks", &stopped), IsNil)
+	c.Assert(stopped, DeepEquals, []string{"chk1"})
+	chg := s.st.Change(check.ChangeID)
+	c.Assert(chg.Status(), Equals, state.HoldStatus)
+	s.st.Unlock()
+
+	// Stopped checks are still stopped after a restart.
+	stopChecks(c, mgr)
+	mgr = NewManager(s.st, s.runner)
+	mgr.PlanChanged(config)
+	defer stopChecks(c, mgr)
+	checks, err = mgr.Checks()
+	c.Assert(err, IsNil)
+	c.Assert(checks[0].Status, Equals, CheckStatusStopped)
+
+	// Starting the check resets its failures.
+	err = mgr.StartChecks([]string{"chk1"})
+	c.Assert(err, IsNil)
+	checks, err = mgr.Checks()
+	c.Assert(err, IsNil)
+	c.Assert(checks[0].Status, Equals, CheckStatusPending)
+	c.Assert(checks[0].Failures, Equals, 0)
+	waitCheck(c, mgr, "chk1", func(check *CheckInfo) bool {
+		return check.Failures == 1
+	})
+	s.st.Lock()
+	c.Assert(s.st.Get("stopped-checks", &stopped), IsNil)
+	c.Assert(stopped, HasLen, 0)
+	s.st.Unlock()
+}
+
+func (s *ManagerSuite) TestResetChecks(c *C) {
+	mgr := NewManager(s.st, s.runner)
+	mgr.PlanChanged(&plan.Plan{
+		Checks: map[string]*plan.Check{
+			"chk1": {
+				Name:      "chk1",
+				Period:    plan.OptionalDuration{Value: time.Minute},
+				Timeout:   plan.OptionalDuration{Value: time.Second},
+				Threshold: 1,
+				Exec:      &plan.ExecCheck{Command: "/bin/sh -c 'exit 1'"},
+			},
+		},
+	})
+	defer stopChecks(c, mgr)
+	check := waitCheck(c, mgr, "chk1", func(check *CheckInfo) bool {
+		return check.Status == CheckStatusDown
+	})
+
+	err := mgr.ResetChecks([]string{"chk1"})
+	c.Assert(err, IsNil)
+	checks, err := mgr.Checks()
+	c.Assert(err, IsNil)
+	c.Assert(checks[0].Status, Equals, CheckStatusPending)
+	c.Assert(checks[0].Failures, Equals, 0)
+	c.Assert(checks[0].LastError, Equals, "")
+
+	s.st.Lock()
+	defer s.st.Unlock()
+	log := s.st.Change(check.ChangeID).Tasks()[0].Log()
+	c.Assert(log[len(log)-1], Matches, `.* Check failures reset`)
+}
+
+func (s *ManagerSuite) TestSuccessThreshold(c *C) {
+	mgr := NewManager(s.st, s.runner)
+	testPath := c.MkDir() + "/test"
+	err := ioutil.WriteFile(testPath, nil, 0o644)
+	c.Assert(err, IsNil)
+	mgr.PlanChanged(&plan.Plan{
+		Checks: map[string]*plan.Check{
+			"chk1": {
+				Name:             "chk1",
+				Period:           plan.OptionalDuration{Value: time.Minute},
+				Timeout:          plan.OptionalDuration{Value: time.Second},
+				StartupDelay:     plan.OptionalDuration{Value: time.Minute, IsSet: true},
+				Threshold:        1,
+				SuccessThreshold: 2,
+				Exec: &plan.ExecCheck{
+					Command: fmt.Sprintf(`/bin/sh -c '[ ! -f %s ]'`, testPath),
+				},
+			},
+		},
+	})
+	defer stopChecks(c, mgr)
+
+	err = mgr.RunChecks([]string{"chk1"})
+	c.Assert(err, IsNil)
+	checks, err := mgr.Checks()
+	c.Assert(err, IsNil)
+	c.Assert(checks[0].Status, Equals, CheckStatusDown)
+	c.Assert(checks[0].SuccessThreshold, Equals, 2)
+
+	// A single success isn't enough to bring the check back up.
+	err = os.Remove(testPath)
+	c.Assert(err, IsNil)
+	err = mgr.RunChecks([]string{"chk1"})
+	c.Assert(err, IsNil)
+	checks, err = mgr.Checks()
+	c.Assert(err, IsNil)
+	c.Assert(checks[0].Status, Equals, CheckStatusDown)
+	c.Assert(checks[0].Failures, Equals, 1)
+	c.Assert(checks[0].Successes, Equals, 1)
+	c.Assert(checks[0].LastError, Equals, "exit status 1")
+
+	err = mgr.RunChecks([]string{"chk1"})
+	c.Assert(err, IsNil)
+	checks, err = mgr.Checks()
+	c.Assert(err, IsNil)
+	c.Assert(checks[0].Status, Equals, CheckStatusUp)
+	c.Assert(checks[0].Failures, Equals, 0)
+	c.Assert(checks[0].Successes, Equals, 2)
+	c.Assert(checks[0].LastError, Equals, "")
+
+	s.st.Lock()
+	defer s.st.Unlock()
+	log := s.st.Change(checks[0].ChangeID).Tasks()[0].Log()
+	c.Assert(log[len(log)-1], Matches, `.* Check succeeded after 1 failure\(s\)`)
+}
+
+func (s *ManagerSuite) TestFlapping(c *C) {
+	mgr := NewManager(s.st, s.runner)
+	testPath := c.MkDir() + "/test"
+	mgr.PlanChanged(&plan.Plan{
+		Checks: map[string]*plan.Check{
+			"chk1": {
+				Name:          "chk1",
+				Period:        plan.OptionalDuration{Value: time.Minute},
+				Timeout:       plan.OptionalDuration{Value: time.Second},
+				StartupDelay:  plan.OptionalDuration{Value: time.Minute, IsSet: true},
+				Threshold:     1,
+				FlapThreshold: 3,
+				Exec: &plan.ExecCheck{
+					Command: fmt.Sprintf(`/bin/sh -c '[ ! -f %s ]'`, testPath),
+				},
+			},
+		},
+	})
+	defer stopChecks(c, mgr)
+
+	// Going from pending to up isn't counted, so it takes three more runs
+	// that change the status (down, up, down) to detect flapping.
+	for i := 0; i < 4; i++ {
+		if i%2 == 0 {
+			err := os.Remove(testPath)
+			if !os.IsNotExist(err) {
+				c.Assert(err, IsNil)
+			}
+		} else {
+			err := ioutil.WriteFile(testPath, nil, 0o644)
+			c.Assert(err, IsNil)
+		}
+		err := mgr.RunChecks([]string{"chk1"})
+		c.Assert(err, IsNil)
+
+		checks, err := mgr.Checks()
+		c.Assert(err, IsNil)
+		c.Assert(checks[0].Flapping, Equals, i == 3)
+		c.Assert(checks[0].FlapThreshold, Equals, 3)
+		c.Assert(checks[0].FlapWindow, Equals, defaultFlapWindow)
+	}
+
+	s.st.Lock()
+	defer s.st.Unlock()
+	warnings := s.st.AllWarnings()
+	c.Assert(warnings, HasLen, 1)
+	c.Assert(warnings[0].String(), Equals, `Check "chk1" is flapping: it went up or down 3 times within 10m0s`)
+}
+
+func (s *ManagerSuite) TestStatusChanged(c *C) {
+	mgr := NewManager(s.st, s.runner)
+	statuses := make(chan string, 10)
+	mgr.NotifyCheckStatusChanged(func(name string, status CheckStatus) {
+		statuses <- name + " " + string(status)
+	})
+	testPath := c.MkDir() + "/test"
+	err := ioutil.WriteFile(testPath, nil, 0o644)
+	c.Assert(err, IsNil)
+	mgr.PlanChanged(&plan.Plan{
+		Checks: map[string]*plan.Check{
+			"chk1": {
+				Name:      "chk1",
+				Period:    plan.OptionalDuration{Value: 20 * time.Millisecond},
+				Timeout:   plan.OptionalDuration{Value: 100 * time.Millisecond},
+				Threshold: 2,
+				Exec: &plan.ExecCheck{
+					Command: fmt.Sprintf(`/bin/sh -c '[ ! -f %s ]'`, testPath),
+				},
+			},
+		},
+	})
+	defer stopChecks(c, mgr)
+
+	// Only notified when the threshold is hit, not for each failure.
+	select {
+	case status := <-statuses:
+		c.Assert(status, Equals, "chk1 down")
+	case <-time.After(5 * time.Second):
+		c.Fatalf("timed out waiting for check to go down")
+	}
+
+	err = os.Remove(testPath)
+	c.Assert(err, IsNil)
+	select {
+	case status := <-statuses:
+		c.Assert(status, Equals, "chk1 up")
+	case <-time.After(5 * time.Second):
+		c.Fatalf("timed out waiting for check to come up")
+	}
+
+	// Successes while up don't notify again.
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(statuses, HasLen, 0)
+}
+
+func (s *ManagerSuite) TestMetrics(c *C) {
+	mgr := NewManager(s.st, s.runner)
+	testPath := c.MkDir() + "/test"
+	err := ioutil.WriteFile(testPath, nil, 0o644)
+	c.Assert(err, IsNil)
+	p := &plan.Plan{
+		Checks: map[string]*plan.Check{
+			"chk1": {
+				Name:      "chk1",
+				Period:    plan.OptionalDuration{Value: 20 * time.Millisecond},
+				Timeout:   plan.OptionalDuration{Value: 100 * time.Millisecond},
+				Threshold: 2,
+				Exec: &plan.ExecCheck{
+					Command: fmt.Sprintf(`/bin/sh -c '[ ! -f %s ]'`, testPath),
+				},
+			},
+		},
+	}
+	mgr.PlanChanged(p)
+	defer stopChecks(c, mgr)
+
+	waitCheck(c, mgr, "chk1", func(check *CheckInfo) bool {
+		return check.Status == CheckStatusDown
+	})
+	metrics := mgr.Metrics()
+	c.Assert(metrics, HasLen, 1)
+	c.Check(metrics[0].Name, Equals, "chk1")
+	c.Check(metrics[0].Up, Equals, false)
+	c.Check(metrics[0].TotalFailures >= 2, Equals, true)
+	c.Check(metrics[0].Durations.Count >= 2, Equals, true)
+
+	// Failures are still counted once the check is up again.
+	err = os.Remove(testPath)
+	c.Assert(err, IsNil)
+	waitCheck(c, mgr, "chk1", func(check *CheckInfo) bool {
+		return check.Status == CheckStatusUp
+	})
+	failures := mgr.Metrics()[0].TotalFailures
+	c.Check(failures >= 2, Equals, true)
+	c.Check(mgr.Metrics()[0].Up, Equals, true)
+
+	// And they're kept when the plan changes.
+	mgr.PlanChanged(p)
+	metrics = mgr.Metrics()
+	c.Assert(metrics, HasLen, 1)
+	c.Check(metrics[0].TotalFailures, Equals, failures)
+	c.Check(metrics[0].Durations.Count >= 3, Equals, true)
+}
+
+func (s *ManagerSuite) TestNotUp(c *C) {
+	mgr := NewManager(s.st, s.runner)
+	testPath := c.MkDir() + "/test"
+	err := ioutil.WriteFile(testPath, nil, 0o644)
+	c.Assert(err, IsNil)
+	mgr.PlanChanged(&plan.Plan{
+		Checks: map[string]*plan.Check{
+			"chk1": {
+				Name:      "chk1",
+				Period:    plan.OptionalDuration{Value: 20 * time.Millisecond},
+				Timeout:   plan.OptionalDuration{Value: 100 * time.Millisecond},
+				Threshold: 3,
+				Exec: &plan.ExecCheck{
+					Command: fmt.Sprintf(`/bin/sh -c '[ ! -f %s ]'`, testPath),
+				},
+			},
+		},
+	})
+	defer stopChecks(c, mgr)
+
+	// Check isn't up until it has succeeded at least once
+	notUp, err := mgr.NotUp([]string{"chk1"})
+	c.Assert(err, IsNil)
+	c.Assert(notUp, DeepEquals, []string{"chk1"})
+	waitCheck(c, mgr, "chk1", func(check *CheckInfo) bool {
+		return check.Failures == 1
+	})
+	notUp, err = mgr.NotUp([]string{"chk1"})
+	c.Assert(err, IsNil)
+	c.Assert(notUp, DeepEquals, []string{"chk1"})
+
+	err = os.Remove(testPath)
+	c.Assert(err, IsNil)
+	waitCheck(c, mgr, "chk1", func(check *CheckInfo) bool {
+		return check.Failures == 0
+	})
+	notUp, err = mgr.NotUp([]string{"chk1"})
+	c.Assert(err, IsNil)
+	c.Assert(notUp, HasLen, 0)
+
+	_, err = mgr.NotUp([]string{"chk1", "chk2"})
+	c.Assert(err, ErrorMatches, `cannot find check "chk2"`)
+}
+
 func waitCheck(c *C, mgr *CheckManager, name string, f func(check *CheckInfo) bool) *CheckInfo {
 	for i := 0; i < 100; i++ {
 		checks, err := mgr.Checks()
@@ -288,15 +984,30 @@ func (s *CheckersSuite) TestNewChecker(c *C) {
 	chk := newChecker(&plan.Check{
 		Name: "http",
 		HTTP: &plan.HTTPCheck{
-			URL:     "https://example.com/foo",
-			Headers: map[string]string{"k": "v"},
+			URL:          "https://example.com/foo",
+			Method:       "HEAD",
+			Headers:      map[string]string{"k": "v"},
+			ExpectStatus: []string{"200", "300-302"},
+			ExpectBody:   "^ok$",
+			ExpectJSON:   map[string]string{"$.b": "2", "a": "1"},
+			CAFile:       "/ca.pem",
+			SocketPath:   "/app.sock",
 		},
 	})
 	http, ok := chk.(*httpChecker)
 	c.Assert(ok, Equals, true)
 	c.Check(http.name, Equals, "http")
 	c.Check(http.url, Equals, "https://example.com/foo")
+	c.Check(http.method, Equals, "HEAD")
 	c.Check(http.headers, DeepEquals, map[string]string{"k": "v"})
+	c.Check(http.expectStatus, DeepEquals, []plan.StatusRange{{Min: 200, Max: 200}, {Min: 300, Max: 302}})
+	c.Check(http.expectBody.String(), Equals, "^ok$")
+	c.Check(http.expectJSON, DeepEquals, []jsonExpectation{
+		{path: jsonpath.Path{"a"}, value: "1"},
+		{path: jsonpath.Path{"b"}, value: "2"},
+	})
+	c.Check(http.caFile, Equals, "/ca.pem")
+	c.Check(http.socketPath, Equals, "/app.sock")
 
 	chk = newChecker(&plan.Check{
 		Name: "tcp",
@@ -310,6 +1021,39 @@ func (s *CheckersSuite) TestNewChecker(c *C) {
 	c.Check(tcp.name, Equals, "tcp")
 	c.Check(tcp.port, Equals, 80)
 	c.Check(tcp.host, Equals, "localhost")
+	c.Check(tcp.send, Equals, "")
+	c.Check(tcp.expect, IsNil)
+
+	chk = newChecker(&plan.Check{
+		Name: "unix",
+		Unix: &plan.UnixCheck{
+			Path:   "/run/redis.sock",
+			Send:   "PING\r\n",
+			Expect: "PONG",
+		},
+	})
+	unix, ok := chk.(*unixChecker)
+	c.Assert(ok, Equals, true)
+	c.Check(unix.name, Equals, "unix")
+	c.Check(unix.path, Equals, "/run/redis.sock")
+	c.Check(unix.send, Equals, "PING\r\n")
+	c.Check(unix.expect.String(), Equals, "PONG")
+
+	chk = newChecker(&plan.Check{
+		Name: "udp",
+		UDP: &plan.UDPCheck{
+			Port: 53,
+			Host: "127.0.0.1",
+			Send: "ping",
+		},
+	})
+	udp, ok := chk.(*udpChecker)
+	c.Assert(ok, Equals, true)
+	c.Check(udp.name, Equals, "udp")
+	c.Check(udp.port, Equals, 53)
+	c.Check(udp.host, Equals, "127.0.0.1")
+	c.Check(udp.send, Equals, "ping")
+	c.Check(udp.expect, IsNil)
 
 	userID, groupID := 100, 200
 	chk = newChecker(&plan.Check{
@@ -0,0 +1,75 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package checkstate
+
+import (
+	"sync"
+)
+
+// serviceTracker tracks which services are active, for the checks that are
+// bound to a service. It has its own lock rather than using the manager's,
+// as it's updated from service status notifications, which must not block.
+type serviceTracker struct {
+	mutex   sync.Mutex
+	active  map[string]bool
+	changed chan struct{}
+}
+
+func newServiceTracker() *serviceTracker {
+	return &serviceTracker{
+		active:  make(map[string]bool),
+		changed: make(chan struct{}),
+	}
+}
+
+// set records whether the named service is active, waking up the checks
+// waiting for a change if it's different from before.
+func (t *serviceTracker) set(name string, active bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	old := t.active[name]
+	t.active[name] = active
+	if old == active {
+		return
+	}
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// seed records whether the named service is active, unless its status has
+// already been set by a notification, which is at least as recent.
+func (t *serviceTracker) seed(name string, active bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, ok := t.active[name]; ok {
+		return
+	}
+	t.active[name] = active
+	if active {
+		close(t.changed)
+		t.changed = make(chan struct{})
+	}
+}
+
+// get reports whether the named service is active, along with a channel
+// that's closed when any service next becomes active or inactive.
+func (t *serviceTracker) get(name string) (active bool, changed <-chan struct{}) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.active[name], t.changed
+}
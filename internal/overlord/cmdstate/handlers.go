@@ -35,6 +35,7 @@ import (
 	"github.com/canonical/pebble/internal/overlord/state"
 	"github.com/canonical/pebble/internal/ptyutil"
 	"github.com/canonical/pebble/internal/reaper"
+	"github.com/canonical/pebble/internal/sandbox"
 	"github.com/canonical/pebble/internal/wsutil"
 )
 
@@ -60,6 +61,7 @@ type execution struct {
 	userID      *int
 	groupID     *int
 	workingDir  string
+	sandbox     *sandbox.Options
 
 	websockets       map[string]*websocket.Conn
 	websocketsLock   sync.Mutex
@@ -90,6 +92,7 @@ func (m *CommandManager) doExec(task *state.Task, tomb *tomb.Tomb) error {
 		userID:           setup.UserID,
 		groupID:          setup.GroupID,
 		workingDir:       setup.WorkingDir,
+		sandbox:          setup.Sandbox,
 		websockets:       make(map[string]*websocket.Conn),
 		ioConnected:      make(chan struct{}),
 		controlConnected: make(chan struct{}),
@@ -366,7 +369,10 @@ func (e *execution) do(ctx context.Context, task *state.Task) error {
 	}
 
 	// Start the command!
-	err = reaper.StartCommand(cmd)
+	err = sandbox.Apply(cmd, e.sandbox)
+	if err == nil {
+		err = reaper.StartCommand(cmd)
+	}
 	exitCode := -1
 	if err == nil {
 		// Send its PID to the control loop.
@@ -24,6 +24,7 @@ import (
 
 	"github.com/canonical/pebble/internal/logger"
 	"github.com/canonical/pebble/internal/overlord/state"
+	"github.com/canonical/pebble/internal/sandbox"
 )
 
 // ExecArgs holds the arguments for a command execution.
@@ -39,6 +40,7 @@ type ExecArgs struct {
 	SplitStderr bool
 	Width       int
 	Height      int
+	Sandbox     *sandbox.Options
 }
 
 // ExecMetadata is the metadata returned from an Exec call.
@@ -61,6 +63,7 @@ type execSetup struct {
 	UserID      *int
 	GroupID     *int
 	WorkingDir  string
+	Sandbox     *sandbox.Options
 }
 
 // Exec creates a task that will execute the command with the given arguments.
@@ -107,10 +110,13 @@ func Exec(st *state.State, args *ExecArgs) (*state.Task, ExecMetadata, error) {
 		environment["LANG"] = "C.UTF-8"
 	}
 
-	// Set default working directory to $HOME, or / if $HOME not set.
+	// Set default working directory to $HOME, or / if $HOME not set (or if
+	// the command runs in another root directory).
 	workingDir := args.WorkingDir
 	if workingDir == "" {
-		workingDir = environment["HOME"]
+		if args.Sandbox == nil || args.Sandbox.RootDir == "" {
+			workingDir = environment["HOME"]
+		}
 		if workingDir == "" {
 			workingDir = "/"
 		}
@@ -130,6 +136,7 @@ func Exec(st *state.State, args *ExecArgs) (*state.Task, ExecMetadata, error) {
 		UserID:      args.UserID,
 		GroupID:     args.GroupID,
 		WorkingDir:  workingDir,
+		Sandbox:     args.Sandbox,
 	}
 	task.Set("exec-setup", &setup)
 
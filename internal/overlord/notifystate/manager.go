@@ -0,0 +1,397 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package notifystate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/canonical/x-go/strutil/shlex"
+
+	"github.com/canonical/pebble/internal/events"
+	"github.com/canonical/pebble/internal/logger"
+	"github.com/canonical/pebble/internal/plan"
+	"github.com/canonical/pebble/internal/reaper"
+)
+
+const (
+	// queueSize is the number of notifications that can be waiting to be
+	// sent to a target before further ones are dropped.
+	queueSize = 64
+
+	// sendTimeout is how long sending a single notification may take.
+	sendTimeout = 10 * time.Second
+)
+
+// retryDelay is the delay before the first retry of a failed notification,
+// doubled for each further retry.
+var retryDelay = time.Second
+
+// NotifyManager sends notifications about events, such as a check going down
+// or a service exiting, to the notify targets in the plan.
+type NotifyManager struct {
+	hub *events.Hub
+
+	mutex   sync.Mutex
+	targets map[string]*target
+	sub     *events.Subscription
+	stopped bool
+
+	done chan struct{}
+
+	// This is only accessed by the loop goroutine.
+	checksDown map[string]bool
+}
+
+// Notification is a notification about an event, sent as JSON to webhook
+// targets and on standard input to exec targets.
+type Notification struct {
+	Event plan.NotifyEvent `json:"event"`
+	Time  time.Time        `json:"time"`
+
+	// Name is the name of the service or check, or the ID of the change.
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// NewManager creates a new notify manager, which sends notifications about
+// the events published to the given hub.
+func NewManager(hub *events.Hub) *NotifyManager {
+	m := &NotifyManager{
+		hub:        hub,
+		targets:    make(map[string]*target),
+		done:       make(chan struct{}),
+		checksDown: make(map[string]bool),
+	}
+	// Subscribe before returning so no events published after this are
+	// missed.
+	sub := m.subscribe()
+	go m.loop(sub)
+	return m
+}
+
+// PlanChanged handles updates to the plan (server configuration),
+// restarting the targets whose configuration changed.
+func (m *NotifyManager) PlanChanged(p *plan.Plan) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.stopped {
+		return
+	}
+
+	newTargets := make(map[string]*target, len(p.NotifyTargets))
+	for name, config := range p.NotifyTargets {
+		if t, ok := m.targets[name]; ok && reflect.DeepEqual(t.config, config) {
+			newTargets[name] = t
+			delete(m.targets, name)
+			continue
+		}
+		newTargets[name] = newTarget(config)
+	}
+	for _, t := range m.targets {
+		t.stop()
+	}
+	m.targets = newTargets
+}
+
+// Ensure implements StateManager.Ensure.
+func (m *NotifyManager) Ensure() error {
+	return nil
+}
+
+// Stop stops sending notifications, canceling any in flight.
+func (m *NotifyManager) Stop() {
+	m.mutex.Lock()
+	m.stopped = true
+	if m.sub != nil {
+		m.sub.Close()
+	}
+	targets := m.targets
+	m.targets = nil
+	m.mutex.Unlock()
+
+	for _, t := range targets {
+		t.stop()
+	}
+	<-m.done
+}
+
+// loop handles the events published to the hub until the manager is
+// stopped.
+func (m *NotifyManager) loop(sub *events.Subscription) {
+	defer close(m.done)
+
+	for sub != nil {
+		for event := range sub.Events() {
+			m.handle(event)
+		}
+		if m.isStopped() {
+			return
+		}
+		// The hub closes subscriptions that fall too far behind.
+		logger.Noticef("Cannot keep up with events, some notifications may be missing")
+		sub = m.subscribe()
+	}
+}
+
+func (m *NotifyManager) subscribe() *events.Subscription {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.stopped {
+		return nil
+	}
+	m.sub, _ = m.hub.Subscribe()
+	return m.sub
+}
+
+func (m *NotifyManager) isStopped() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.stopped
+}
+
+// handle sends a notification to the interested targets if the event is one
+// that targets can be notified about.
+func (m *NotifyManager) handle(event events.Event) {
+	for _, notifyEvent := range m.notifyEvents(event) {
+		n := &Notification{
+			Event:   notifyEvent,
+			Time:    event.Time,
+			Name:    event.Name,
+			Status:  event.Status,
+			Message: event.Message,
+		}
+
+		m.mutex.Lock()
+		for _, t := range m.targets {
+			if t.config.NotifiesOf(notifyEvent) {
+				t.notify(n)
+			}
+		}
+		m.mutex.Unlock()
+	}
+}
+
+// notifyEvents returns the events to notify targets about for the given
+// event, if any. A check is only reported up after being down.
+func (m *NotifyManager) notifyEvents(event events.Event) []plan.NotifyEvent {
+	switch event.Type {
+	case events.TypeCheck:
+		switch event.Status {
+		case "down":
+			m.checksDown[event.Name] = true
+			return []plan.NotifyEvent{plan.CheckDownEvent}
+		case "up":
+			if m.checksDown[event.Name] {
+				delete(m.checksDown, event.Name)
+				return []plan.NotifyEvent{plan.CheckUpEvent}
+			}
+		}
+
+	case events.TypeService:
+		switch event.Status {
+		case events.ServiceExited:
+			return []plan.NotifyEvent{plan.ServiceExitEvent}
+		case "backoff":
+			return []plan.NotifyEvent{plan.ServiceBackoffEvent}
+		}
+
+	case events.TypeChange:
+		if event.Status == "Error" {
+			return []plan.NotifyEvent{plan.ChangeErrorEvent}
+		}
+	}
+	return nil
+}
+
+// target sends notifications to a single notify target, one at a time and
+// in order, retrying failed ones.
+type target struct {
+	config *plan.NotifyTarget
+	ctx    context.Context
+	cancel context.CancelFunc
+	queue  chan *Notification
+	done   chan struct{}
+
+	// sent holds the times of the notifications sent within the last
+	// rate-limit period. It's protected by the manager's mutex.
+	sent []time.Time
+}
+
+func newTarget(config *plan.NotifyTarget) *target {
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &target{
+		config: config,
+		ctx:    ctx,
+		cancel: cancel,
+		queue:  make(chan *Notification, queueSize),
+		done:   make(chan struct{}),
+	}
+	go t.loop()
+	return t
+}
+
+// notify queues the notification to be sent, unless the target's rate limit
+// was hit or its queue is full. The caller must hold the manager's mutex.
+func (t *target) notify(n *Notification) {
+	if t.config.RateLimit > 0 {
+		now := time.Now()
+		sent := t.sent[:0]
+		for _, s := range t.sent {
+			if now.Sub(s) < t.config.RateLimitPeriod.Value {
+				sent = append(sent, s)
+			}
+		}
+		t.sent = sent
+		if len(t.sent) >= t.config.RateLimit {
+			logger.Noticef("Notify target %q rate limit hit, dropping %s notification for %q",
+				t.config.Name, n.Event, n.Name)
+			return
+		}
+		t.sent = append(t.sent, now)
+	}
+
+	select {
+	case t.queue <- n:
+	default:
+		logger.Noticef("Notify target %q queue full, dropping %s notification for %q",
+			t.config.Name, n.Event, n.Name)
+	}
+}
+
+// stop stops the target, canceling the notification in flight (if any), and
+// waits for it to finish.
+func (t *target) stop() {
+	t.cancel()
+	<-t.done
+}
+
+func (t *target) loop() {
+	defer close(t.done)
+
+	for {
+		select {
+		case n := <-t.queue:
+			t.deliver(n)
+		case <-t.ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver sends the notification, retrying with exponential backoff if it
+// fails.
+func (t *target) deliver(n *Notification) {
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		err := t.send(n)
+		if err == nil {
+			return
+		}
+		if t.ctx.Err() != nil {
+			return
+		}
+		if t.config.Retries == nil || attempt >= *t.config.Retries {
+			logger.Noticef("Cannot send %s notification for %q to notify target %q: %v",
+				n.Event, n.Name, t.config.Name, err)
+			return
+		}
+		logger.Debugf("Cannot send %s notification for %q to notify target %q, retrying in %s: %v",
+			n.Event, n.Name, t.config.Name, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-t.ctx.Done():
+			return
+		}
+		delay *= 2
+	}
+}
+
+func (t *target) send(n *Notification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(t.ctx, sendTimeout)
+	defer cancel()
+
+	switch t.config.Type {
+	case plan.WebhookTarget:
+		return sendWebhook(ctx, t.config.Location, data)
+	case plan.ExecTarget:
+		return runCommand(ctx, t.config.Command, n, data)
+	default:
+		return fmt.Errorf("unsupported notify target type %q", t.config.Type)
+	}
+}
+
+// sendWebhook POSTs the JSON notification to the given URL.
+func sendWebhook(ctx context.Context, url string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("received non-20x status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// runCommand runs the command with the JSON notification on its standard
+// input, and its fields in PEBBLE_NOTIFY_* environment variables.
+func runCommand(ctx context.Context, command string, n *Notification, data []byte) error {
+	args, err := shlex.Split(command)
+	if err != nil {
+		return fmt.Errorf("cannot parse command: %v", err)
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("command must not be empty")
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = []string{
+		"PEBBLE_NOTIFY_EVENT=" + string(n.Event),
+		"PEBBLE_NOTIFY_NAME=" + n.Name,
+		"PEBBLE_NOTIFY_STATUS=" + n.Status,
+		"PEBBLE_NOTIFY_MESSAGE=" + n.Message,
+	}
+	cmd.Stdin = bytes.NewReader(data)
+	err = reaper.StartCommand(cmd)
+	if err != nil {
+		return err
+	}
+	exitCode, err := reaper.WaitCommand(cmd)
+	if err == nil && exitCode > 0 {
+		err = fmt.Errorf("exit status %d", exitCode)
+	}
+	return err
+}
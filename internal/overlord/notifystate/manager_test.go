@@ -0,0 +1,391 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package notifystate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/pebble/internal/events"
+	"github.com/canonical/pebble/internal/logger"
+	"github.com/canonical/pebble/internal/plan"
+	"github.com/canonical/pebble/internal/reaper"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ManagerSuite struct {
+	hub *events.Hub
+	mgr *NotifyManager
+
+	restoreRetryDelay func()
+}
+
+var _ = Suite(&ManagerSuite{})
+
+var setLoggerOnce sync.Once
+
+func (s *ManagerSuite) SetUpSuite(c *C) {
+	// This can happen in parallel with tests if -test.count=N with N>1 is specified.
+	setLoggerOnce.Do(func() {
+		logger.SetLogger(logger.New(os.Stderr, "[test] "))
+	})
+
+	err := reaper.Start()
+	c.Assert(err, IsNil)
+}
+
+func (s *ManagerSuite) TearDownSuite(c *C) {
+	err := reaper.Stop()
+	c.Assert(err, IsNil)
+}
+
+func (s *ManagerSuite) SetUpTest(c *C) {
+	s.hub = events.NewHub()
+	s.mgr = NewManager(s.hub)
+
+	oldRetryDelay := retryDelay
+	retryDelay = time.Millisecond
+	s.restoreRetryDelay = func() { retryDelay = oldRetryDelay }
+}
+
+func (s *ManagerSuite) TearDownTest(c *C) {
+	s.mgr.Stop()
+	s.restoreRetryDelay()
+}
+
+// webhookServer is a test server that records the notifications POSTed to
+// it, failing the first "failures" requests.
+type webhookServer struct {
+	*httptest.Server
+
+	mutex         sync.Mutex
+	failures      int
+	requests      int
+	notifications chan *Notification
+}
+
+func newWebhookServer(c *C, failures int) *webhookServer {
+	w := &webhookServer{
+		failures:      failures,
+		notifications: make(chan *Notification, 10),
+	}
+	w.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "POST")
+		c.Check(r.Header.Get("Content-Type"), Equals, "application/json")
+
+		w.mutex.Lock()
+		w.requests++
+		fail := w.requests <= w.failures
+		w.mutex.Unlock()
+		if fail {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var n Notification
+		err := json.NewDecoder(r.Body).Decode(&n)
+		c.Check(err, IsNil)
+		w.notifications <- &n
+	}))
+	return w
+}
+
+func (w *webhookServer) numRequests() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.requests
+}
+
+func waitNotification(c *C, notifications <-chan *Notification) *Notification {
+	select {
+	case n := <-notifications:
+		n.Time = time.Time{}
+		return n
+	case <-time.After(5 * time.Second):
+		c.Fatalf("timed out waiting for notification")
+		return nil
+	}
+}
+
+func assertNoNotification(c *C, notifications <-chan *Notification) {
+	select {
+	case n := <-notifications:
+		c.Fatalf("unexpected notification: %+v", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func (s *ManagerSuite) TestWebhook(c *C) {
+	server := newWebhookServer(c, 0)
+	defer server.Close()
+	s.mgr.PlanChanged(&plan.Plan{
+		NotifyTargets: map[string]*plan.NotifyTarget{
+			"hook": {
+				Name:     "hook",
+				Type:     plan.WebhookTarget,
+				Location: server.URL,
+				Events:   []plan.NotifyEvent{plan.CheckDownEvent, plan.CheckUpEvent},
+			},
+		},
+	})
+
+	// A check going up for the first time isn't a notification.
+	s.hub.Publish(events.Event{Type: events.TypeCheck, Name: "chk1", Status: "pending"})
+	s.hub.Publish(events.Event{Type: events.TypeCheck, Name: "chk1", Status: "up"})
+	s.hub.Publish(events.Event{Type: events.TypeCheck, Name: "chk1", Status: "down"})
+	c.Assert(waitNotification(c, server.notifications), DeepEquals, &Notification{
+		Event:  plan.CheckDownEvent,
+		Name:   "chk1",
+		Status: "down",
+	})
+
+	// Events the target isn't interested in are ignored.
+	s.hub.Publish(events.Event{Type: events.TypeService, Name: "svc1", Status: "active"})
+	s.hub.Publish(events.Event{Type: events.TypeService, Name: "svc1", Status: "backoff"})
+	s.hub.Publish(events.Event{Type: events.TypeCheck, Name: "chk1", Status: "up"})
+	c.Assert(waitNotification(c, server.notifications), DeepEquals, &Notification{
+		Event:  plan.CheckUpEvent,
+		Name:   "chk1",
+		Status: "up",
+	})
+	assertNoNotification(c, server.notifications)
+}
+
+func (s *ManagerSuite) TestNotifyEvents(c *C) {
+	tests := []struct {
+		event    events.Event
+		expected []plan.NotifyEvent
+	}{
+		{events.Event{Type: events.TypeService, Name: "svc1", Status: "active"}, nil},
+		{events.Event{Type: events.TypeService, Name: "svc1", Status: "exited", Message: "exit code 1"},
+			[]plan.NotifyEvent{plan.ServiceExitEvent}},
+		{events.Event{Type: events.TypeService, Name: "svc1", Status: "backoff"},
+			[]plan.NotifyEvent{plan.ServiceBackoffEvent}},
+		{events.Event{Type: events.TypeService, Name: "svc1", Status: "active"}, nil},
+		{events.Event{Type: events.TypeService, Name: "svc1", Status: "inactive"}, nil},
+		{events.Event{Type: events.TypeService, Name: "svc1", Status: "active"}, nil},
+		// A clean exit with on-success ignore goes straight to inactive.
+		{events.Event{Type: events.TypeService, Name: "svc1", Status: "exited", Message: "exit code 0"},
+			[]plan.NotifyEvent{plan.ServiceExitEvent}},
+		{events.Event{Type: events.TypeService, Name: "svc1", Status: "inactive"}, nil},
+		{events.Event{Type: events.TypeService, Name: "svc1", Status: "error"}, nil},
+		{events.Event{Type: events.TypeCheck, Name: "chk1", Status: "up"}, nil},
+		{events.Event{Type: events.TypeCheck, Name: "chk1", Status: "down"},
+			[]plan.NotifyEvent{plan.CheckDownEvent}},
+		{events.Event{Type: events.TypeCheck, Name: "chk1", Status: "paused"}, nil},
+		{events.Event{Type: events.TypeCheck, Name: "chk1", Status: "up"},
+			[]plan.NotifyEvent{plan.CheckUpEvent}},
+		{events.Event{Type: events.TypeChange, Name: "1", Status: "Done"}, nil},
+		{events.Event{Type: events.TypeChange, Name: "2", Status: "Error"},
+			[]plan.NotifyEvent{plan.ChangeErrorEvent}},
+		{events.Event{Type: events.TypeWarning, Message: "foo"}, nil},
+	}
+	m := &NotifyManager{
+		checksDown: make(map[string]bool),
+	}
+	for _, test := range tests {
+		c.Check(m.notifyEvents(test.event), DeepEquals, test.expected, Commentf("%+v", test.event))
+	}
+}
+
+func (s *ManagerSuite) TestRetries(c *C) {
+	server := newWebhookServer(c, 2)
+	defer server.Close()
+	retries := 2
+	s.mgr.PlanChanged(&plan.Plan{
+		NotifyTargets: map[string]*plan.NotifyTarget{
+			"hook": {
+				Name:     "hook",
+				Type:     plan.WebhookTarget,
+				Location: server.URL,
+				Retries:  &retries,
+			},
+		},
+	})
+
+	s.hub.Publish(events.Event{Type: events.TypeChange, Name: "1", Status: "Error", Message: "Start service"})
+	c.Assert(waitNotification(c, server.notifications), DeepEquals, &Notification{
+		Event:   plan.ChangeErrorEvent,
+		Name:    "1",
+		Status:  "Error",
+		Message: "Start service",
+	})
+	c.Assert(server.numRequests(), Equals, 3)
+}
+
+func (s *ManagerSuite) TestRetriesExhausted(c *C) {
+	server := newWebhookServer(c, 2)
+	defer server.Close()
+	retries := 1
+	s.mgr.PlanChanged(&plan.Plan{
+		NotifyTargets: map[string]*plan.NotifyTarget{
+			"hook": {
+				Name:     "hook",
+				Type:     plan.WebhookTarget,
+				Location: server.URL,
+				Retries:  &retries,
+			},
+		},
+	})
+
+	// The first notification is dropped after two attempts, and the next
+	// one gets through.
+	s.hub.Publish(events.Event{Type: events.TypeChange, Name: "1", Status: "Error"})
+	s.hub.Publish(events.Event{Type: events.TypeChange, Name: "2", Status: "Error"})
+	n := waitNotification(c, server.notifications)
+	c.Assert(n.Name, Equals, "2")
+	c.Assert(server.numRequests(), Equals, 3)
+}
+
+func (s *ManagerSuite) TestNoRetries(c *C) {
+	server := newWebhookServer(c, 1)
+	defer server.Close()
+	retries := 0
+	s.mgr.PlanChanged(&plan.Plan{
+		NotifyTargets: map[string]*plan.NotifyTarget{
+			"hook": {
+				Name:     "hook",
+				Type:     plan.WebhookTarget,
+				Location: server.URL,
+				Retries:  &retries,
+			},
+		},
+	})
+
+	// The first notification is dropped after a single attempt.
+	s.hub.Publish(events.Event{Type: events.TypeChange, Name: "1", Status: "Error"})
+	s.hub.Publish(events.Event{Type: events.TypeChange, Name: "2", Status: "Error"})
+	n := waitNotification(c, server.notifications)
+	c.Assert(n.Name, Equals, "2")
+	c.Assert(server.numRequests(), Equals, 2)
+}
+
+func (s *ManagerSuite) TestRateLimit(c *C) {
+	server := newWebhookServer(c, 0)
+	defer server.Close()
+	s.mgr.PlanChanged(&plan.Plan{
+		NotifyTargets: map[string]*plan.NotifyTarget{
+			"hook": {
+				Name:            "hook",
+				Type:            plan.WebhookTarget,
+				Location:        server.URL,
+				RateLimit:       2,
+				RateLimitPeriod: plan.OptionalDuration{Value: 200 * time.Millisecond},
+			},
+		},
+	})
+
+	for i := 1; i <= 4; i++ {
+		s.hub.Publish(events.Event{Type: events.TypeCheck, Name: fmt.Sprintf("chk%d", i), Status: "down"})
+	}
+	c.Assert(waitNotification(c, server.notifications).Name, Equals, "chk1")
+	c.Assert(waitNotification(c, server.notifications).Name, Equals, "chk2")
+	assertNoNotification(c, server.notifications)
+
+	// Notifications are sent again once the rate-limit period has passed.
+	time.Sleep(200 * time.Millisecond)
+	s.hub.Publish(events.Event{Type: events.TypeCheck, Name: "chk5", Status: "down"})
+	c.Assert(waitNotification(c, server.notifications).Name, Equals, "chk5")
+}
+
+func (s *ManagerSuite) TestExec(c *C) {
+	dir := c.MkDir()
+	s.mgr.PlanChanged(&plan.Plan{
+		NotifyTargets: map[string]*plan.NotifyTarget{
+			"script": {
+				Name:    "script",
+				Type:    plan.ExecTarget,
+				Command: fmt.Sprintf(`/bin/sh -c 'cat >%s/stdin; echo $PEBBLE_NOTIFY_EVENT $PEBBLE_NOTIFY_NAME >%s/env'`, dir, dir),
+			},
+		},
+	})
+
+	s.hub.Publish(events.Event{Type: events.TypeService, Name: "svc1", Status: "exited", Message: "exit code 1"})
+
+	envPath := filepath.Join(dir, "env")
+	for i := 0; ; i++ {
+		if _, err := os.Stat(envPath); err == nil {
+			break
+		}
+		if i >= 500 {
+			c.Fatalf("timed out waiting for command to run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	env, err := ioutil.ReadFile(envPath)
+	c.Assert(err, IsNil)
+	c.Assert(string(env), Equals, "service-exit svc1\n")
+	data, err := ioutil.ReadFile(filepath.Join(dir, "stdin"))
+	c.Assert(err, IsNil)
+	var n Notification
+	err = json.Unmarshal(data, &n)
+	c.Assert(err, IsNil)
+	c.Assert(n.Event, Equals, plan.ServiceExitEvent)
+	c.Assert(n.Status, Equals, "exited")
+	c.Assert(n.Message, Equals, "exit code 1")
+}
+
+func (s *ManagerSuite) TestExecEmptyCommand(c *C) {
+	n := &Notification{Event: plan.ServiceExitEvent, Name: "svc1"}
+	err := runCommand(context.Background(), " ", n, nil)
+	c.Assert(err, ErrorMatches, "command must not be empty")
+}
+
+func (s *ManagerSuite) TestPlanChangedKeepsUnchangedTargets(c *C) {
+	config := &plan.NotifyTarget{
+		Name:     "hook",
+		Type:     plan.WebhookTarget,
+		Location: "http://localhost:1/hook",
+	}
+	s.mgr.PlanChanged(&plan.Plan{
+		NotifyTargets: map[string]*plan.NotifyTarget{
+			"hook":  config,
+			"other": {Name: "other", Type: plan.ExecTarget, Command: "true"},
+		},
+	})
+	hook := s.mgr.targets["hook"]
+	other := s.mgr.targets["other"]
+
+	s.mgr.PlanChanged(&plan.Plan{
+		NotifyTargets: map[string]*plan.NotifyTarget{
+			"hook":  config.Copy(),
+			"other": {Name: "other", Type: plan.ExecTarget, Command: "false"},
+		},
+	})
+	c.Assert(s.mgr.targets["hook"] == hook, Equals, true)
+	c.Assert(s.mgr.targets["other"] == other, Equals, false)
+	select {
+	case <-other.done:
+	default:
+		c.Fatalf("changed target wasn't stopped")
+	}
+
+	s.mgr.PlanChanged(&plan.Plan{})
+	c.Assert(s.mgr.targets, HasLen, 0)
+}
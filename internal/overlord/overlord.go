@@ -27,14 +27,17 @@ import (
 	"github.com/canonical/x-go/randutil"
 	"gopkg.in/tomb.v2"
 
+	"github.com/canonical/pebble/internal/events"
 	"github.com/canonical/pebble/internal/osutil"
 	"github.com/canonical/pebble/internal/overlord/checkstate"
 	"github.com/canonical/pebble/internal/overlord/cmdstate"
 	"github.com/canonical/pebble/internal/overlord/logstate"
+	"github.com/canonical/pebble/internal/overlord/notifystate"
 	"github.com/canonical/pebble/internal/overlord/patch"
 	"github.com/canonical/pebble/internal/overlord/restart"
 	"github.com/canonical/pebble/internal/overlord/servstate"
 	"github.com/canonical/pebble/internal/overlord/state"
+	"github.com/canonical/pebble/internal/plan"
 	"github.com/canonical/pebble/internal/timing"
 )
 
@@ -70,6 +73,9 @@ type Overlord struct {
 	commandMgr *cmdstate.CommandManager
 	checkMgr   *checkstate.CheckManager
 	logMgr     *logstate.LogManager
+	notifyMgr  *notifystate.NotifyManager
+
+	events *events.Hub
 }
 
 // New creates a new Overlord with all its state managers.
@@ -79,6 +85,7 @@ func New(pebbleDir string, restartHandler restart.Handler, serviceOutput io.Writ
 		pebbleDir: pebbleDir,
 		loopTomb:  new(tomb.Tomb),
 		inited:    true,
+		events:    events.NewHub(),
 	}
 
 	if !filepath.IsAbs(pebbleDir) {
@@ -119,7 +126,7 @@ func New(pebbleDir string, restartHandler restart.Handler, serviceOutput io.Writ
 	o.commandMgr = cmdstate.NewManager(o.runner)
 	o.addManager(o.commandMgr)
 
-	o.checkMgr = checkstate.NewManager()
+	o.checkMgr = checkstate.NewManager(s, o.runner)
 
 	// Tell check manager about plan updates.
 	o.serviceMgr.NotifyPlanChanged(o.checkMgr.PlanChanged)
@@ -130,6 +137,27 @@ func New(pebbleDir string, restartHandler restart.Handler, serviceOutput io.Writ
 	// Tell service manager about check failures.
 	o.checkMgr.NotifyCheckFailed(o.serviceMgr.CheckFailed)
 
+	// Let service manager query check status for services waiting for checks.
+	o.serviceMgr.SetCheckStatus(o.checkMgr.NotUp)
+
+	// Tell check manager about service status changes, so checks bound to a
+	// service only run while it's active.
+	o.serviceMgr.NotifyServiceStatusChanged(func(name string, status servstate.ServiceStatus) {
+		o.checkMgr.ServiceStatusChanged(name, status == servstate.StatusActive)
+	})
+	o.checkMgr.SetServiceActive(o.serviceMgr.ServiceActive)
+
+	o.publishEvents()
+
+	// Send notifications about events to the plan's notify targets.
+	o.notifyMgr = notifystate.NewManager(o.events)
+	o.addManager(o.notifyMgr)
+	o.serviceMgr.NotifyPlanChanged(o.notifyMgr.PlanChanged)
+
+	// Adopt services left running by the previous daemon, now that the
+	// handlers above will be told about them.
+	o.serviceMgr.AdoptServices()
+
 	// the shared task runner should be added last!
 	o.stateEng.AddManager(o.runner)
 
@@ -415,6 +443,71 @@ func (o *Overlord) CheckManager() *checkstate.CheckManager {
 	return o.checkMgr
 }
 
+// Events returns the hub that events such as service status changes are
+// published to.
+func (o *Overlord) Events() *events.Hub {
+	return o.events
+}
+
+// publishEvents sets up the state and managers to publish events about
+// service, check, change and task status changes, warnings and layers.
+func (o *Overlord) publishEvents() {
+	st := o.State()
+	st.Lock()
+	st.AddChangeStatusChangedHandler(func(chg *state.Change, old, new state.Status) {
+		o.events.Publish(events.Event{
+			Type:    events.TypeChange,
+			Name:    chg.ID(),
+			Status:  new.String(),
+			Message: chg.Summary(),
+		})
+	})
+	st.AddTaskStatusChangedHandler(func(t *state.Task, old, new state.Status) {
+		o.events.Publish(events.Event{
+			Type:    events.TypeTask,
+			Name:    t.ID(),
+			Status:  new.String(),
+			Message: t.Summary(),
+		})
+	})
+	st.AddWarningHandler(func(message string) {
+		o.events.Publish(events.Event{
+			Type:    events.TypeWarning,
+			Message: message,
+		})
+	})
+	st.Unlock()
+
+	o.serviceMgr.NotifyServiceStatusChanged(func(name string, status servstate.ServiceStatus) {
+		o.events.Publish(events.Event{
+			Type:   events.TypeService,
+			Name:   name,
+			Status: string(status),
+		})
+	})
+	o.serviceMgr.NotifyServiceExited(func(name string, exitCode int) {
+		o.events.Publish(events.Event{
+			Type:    events.TypeService,
+			Name:    name,
+			Status:  events.ServiceExited,
+			Message: fmt.Sprintf("exit code %d", exitCode),
+		})
+	})
+	o.serviceMgr.NotifyLayerAdded(func(layer *plan.Layer) {
+		o.events.Publish(events.Event{
+			Type: events.TypeLayer,
+			Name: layer.Label,
+		})
+	})
+	o.checkMgr.NotifyCheckStatusChanged(func(name string, status checkstate.CheckStatus) {
+		o.events.Publish(events.Event{
+			Type:   events.TypeCheck,
+			Name:   name,
+			Status: string(status),
+		})
+	})
+}
+
 // Fake creates an Overlord without any managers and with a backend
 // not using disk. Managers can be added with AddManager. For testing.
 func Fake() *Overlord {
@@ -428,6 +521,7 @@ func FakeWithState(handleRestart func(restart.RestartType)) *Overlord {
 	o := &Overlord{
 		loopTomb: new(tomb.Tomb),
 		inited:   false,
+		events:   events.NewHub(),
 	}
 	s := state.New(fakeBackend{o: o})
 	o.stateEng = NewStateEngine(s)
@@ -29,11 +29,13 @@ import (
 	"gopkg.in/tomb.v2"
 
 	"github.com/canonical/pebble/cmd"
+	"github.com/canonical/pebble/internal/events"
 	"github.com/canonical/pebble/internal/osutil"
 	"github.com/canonical/pebble/internal/overlord"
 	"github.com/canonical/pebble/internal/overlord/patch"
 	"github.com/canonical/pebble/internal/overlord/restart"
 	"github.com/canonical/pebble/internal/overlord/state"
+	"github.com/canonical/pebble/internal/plan"
 	"github.com/canonical/pebble/internal/testutil"
 )
 
@@ -78,6 +80,45 @@ func (ovs *overlordSuite) TestNew(c *C) {
 	c.Check(patchSublevel, Equals, 2)
 }
 
+func (ovs *overlordSuite) TestEvents(c *C) {
+	o, err := overlord.New(ovs.dir, nil, nil)
+	c.Assert(err, IsNil)
+	sub, _ := o.Events().Subscribe()
+	defer sub.Close()
+
+	st := o.State()
+	st.Lock()
+	st.Warnf("hello")
+	chg := st.NewChange("foo", "Foo change")
+	t := st.NewTask("bar", "Bar task")
+	chg.AddTask(t)
+	t.SetStatus(state.DoneStatus)
+	st.Unlock()
+
+	layer, err := plan.ParseLayer(1, "base", []byte("summary: base"))
+	c.Assert(err, IsNil)
+	err = o.ServiceManager().AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	var got []events.Event
+	for len(got) < 4 {
+		select {
+		case event := <-sub.Events():
+			c.Check(event.Time.IsZero(), Equals, false)
+			event.Time = time.Time{}
+			got = append(got, event)
+		case <-time.After(5 * time.Second):
+			c.Fatalf("timed out waiting for events, got %v", got)
+		}
+	}
+	c.Assert(got, DeepEquals, []events.Event{
+		{Type: events.TypeWarning, Message: "hello"},
+		{Type: events.TypeTask, Name: t.ID(), Status: "Done", Message: "Bar task"},
+		{Type: events.TypeChange, Name: chg.ID(), Status: "Done", Message: "Foo change"},
+		{Type: events.TypeLayer, Name: "base"},
+	})
+}
+
 func (ovs *overlordSuite) TestNewWithGoodState(c *C) {
 	fakeState := []byte(fmt.Sprintf(`{"data":{"patch-level":%d,"patch-sublevel":%d,"patch-sublevel-last-version":%q,"some":"data"},"changes":null,"tasks":null,"last-change-id":0,"last-task-id":0,"last-lane-id":0}`, patch.Level, patch.Sublevel, cmd.Version))
 	err := ioutil.WriteFile(ovs.statePath, fakeState, 0600)
@@ -0,0 +1,392 @@
+package servstate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/canonical/pebble/internal/logger"
+	"github.com/canonical/pebble/internal/osutil"
+	"github.com/canonical/pebble/internal/plan"
+	"github.com/canonical/pebble/internal/reaper"
+	"github.com/canonical/pebble/internal/servicelog"
+)
+
+// processesStateKey is the state key under which the running services'
+// processes are recorded, so they can be adopted after a daemon restart.
+const processesStateKey = "service-processes"
+
+// adoptedPollInterval is how often an adopted process that isn't a child of
+// the daemon (and so can't be waited for) is polled to see if it has exited,
+// if pidfds aren't supported by the kernel.
+var adoptedPollInterval = 200 * time.Millisecond
+
+// unknownExitCode is the exit code reported for an adopted process that
+// isn't a child of the daemon, as its actual exit code can't be retrieved.
+const unknownExitCode = -1
+
+// processInfo is the information recorded in state about a service's
+// running process.
+type processInfo struct {
+	PID int `json:"pid"`
+	// StartTime is the process's start time in clock ticks after boot, as
+	// reported by /proc/<pid>/stat. Along with BootID it ensures that a
+	// recorded PID is only adopted if it hasn't been reused.
+	StartTime uint64 `json:"start-time"`
+	BootID    string `json:"boot-id"`
+	// OutputFD is the file descriptor of the read end of the service's
+	// output pipe, if it was handed over to the daemon's next instance when
+	// it re-executed itself (see HandOver). It's only valid in the process
+	// with PID DaemonPID.
+	OutputFD  int `json:"output-fd,omitempty"`
+	DaemonPID int `json:"daemon-pid,omitempty"`
+}
+
+// hasProcess reports whether a service in the given state has a process
+// that is recorded by saveProcesses.
+func hasProcess(state serviceState) bool {
+	switch state {
+	case stateStarting, stateRunning, stateTerminating, stateKilling:
+		return true
+	}
+	return false
+}
+
+// saveProcesses records the processes of running services in state, if
+// they have changed since they were last saved.
+func (m *ServiceManager) saveProcesses() {
+	bootID, err := osutil.BootID()
+	if err != nil {
+		logger.Debugf("Cannot record service processes: %v", err)
+		return
+	}
+
+	m.servicesLock.Lock()
+	processes := make(map[string]processInfo)
+	for name, s := range m.services {
+		if !hasProcess(s.state) || s.startTime == 0 {
+			continue
+		}
+		info := processInfo{
+			PID:       s.cmd.Process.Pid,
+			StartTime: s.startTime,
+			BootID:    bootID,
+		}
+		if s.outputFD > 0 {
+			info.OutputFD = s.outputFD
+			info.DaemonPID = os.Getpid()
+		}
+		processes[name] = info
+	}
+	m.servicesLock.Unlock()
+
+	if reflect.DeepEqual(processes, m.savedProcesses) {
+		return
+	}
+	m.savedProcesses = processes
+
+	m.state.Lock()
+	defer m.state.Unlock()
+	if len(processes) == 0 {
+		m.state.Set(processesStateKey, nil)
+	} else {
+		m.state.Set(processesStateKey, processes)
+	}
+}
+
+// HandOver prepares the running services to be adopted by the daemon's next
+// instance when it re-executes itself (for example, after an upgrade). It
+// stops copying the services' output, makes a copy of the read end of each
+// service's output pipe that is inherited across exec, and records the
+// services' processes (along with those file descriptors) in state. It
+// should only be called once the manager has been stopped.
+func (m *ServiceManager) HandOver() {
+	m.servicesLock.Lock()
+	for name, s := range m.services {
+		if !hasProcess(s.state) || s.outputPipe == nil {
+			continue
+		}
+		fd, err := handOverPipe(s.outputPipe)
+		if err != nil {
+			logger.Noticef("Cannot hand over output of service %q: %v", name, err)
+			continue
+		}
+		s.outputFD = fd
+	}
+	m.servicesLock.Unlock()
+
+	m.saveProcesses()
+}
+
+// handOverPipe stops the copying of output from the given pipe (see
+// copyOutput) and returns a duplicate of its file descriptor, which unlike
+// the original isn't closed on exec.
+func handOverPipe(pipe *os.File) (int, error) {
+	err := pipe.SetReadDeadline(time.Now())
+	if err != nil {
+		return 0, err
+	}
+	conn, err := pipe.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var dupFD int
+	var dupErr error
+	err = conn.Control(func(fd uintptr) {
+		dupFD, dupErr = unix.Dup(int(fd))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return dupFD, dupErr
+}
+
+// AdoptServices adopts the still-running processes of services started by
+// a previous instance of the daemon, as recorded by saveProcesses. It's
+// called once the daemon's service status and plan handlers have been set
+// up, so they're notified about the adopted services.
+//
+// If the previous instance re-executed itself, the processes are still the
+// daemon's children, so their exit codes are known, and their output is
+// still captured if it was handed over (see HandOver). Otherwise the output
+// of the adopted processes is lost: their log buffers stay empty.
+func (m *ServiceManager) AdoptServices() {
+	var processes map[string]processInfo
+	m.state.Lock()
+	err := m.state.Get(processesStateKey, &processes)
+	m.state.Unlock()
+	if err != nil || len(processes) == 0 {
+		return
+	}
+
+	bootID, err := osutil.BootID()
+	if err != nil {
+		logger.Noticef("Cannot adopt running services: %v", err)
+		return
+	}
+
+	releasePlan, err := m.acquirePlan()
+	if err != nil {
+		logger.Noticef("Cannot adopt running services: %v", err)
+		return
+	}
+	defer releasePlan()
+
+	m.servicesLock.Lock()
+	defer m.servicesLock.Unlock()
+
+	services := m.plan.ExpandedServices()
+	for name, info := range processes {
+		// A handed over output pipe is only valid in the same process (the
+		// previous instance re-executed itself), so don't touch it
+		// otherwise, but close it if it won't be used.
+		if info.DaemonPID != os.Getpid() {
+			info.OutputFD = 0
+		}
+		if !m.adoptProcess(name, services[name], info, bootID) && info.OutputFD > 0 {
+			unix.Close(info.OutputFD)
+		}
+	}
+}
+
+// adoptProcess adopts a single service process, returning whether it was
+// adopted. Config is the service's configuration from the plan, or nil if
+// it's no longer in the plan. It must be called with the services lock held.
+func (m *ServiceManager) adoptProcess(name string, config *plan.Service, info processInfo, bootID string) bool {
+	if config == nil {
+		logger.Noticef("Not adopting PID %d of service %q: service is no longer in the plan", info.PID, name)
+		return false
+	}
+	if info.BootID != bootID {
+		return false
+	}
+	ppid, startTime, err := procStat(info.PID)
+	if err != nil || startTime != info.StartTime {
+		logger.Debugf("Not adopting PID %d of service %q: process is no longer running", info.PID, name)
+		return false
+	}
+
+	// If the process is still our child (the daemon re-executed itself),
+	// register it with the reaper before checking again that it hasn't
+	// exited in the meantime.
+	isChild := ppid == os.Getpid()
+	if isChild {
+		unwatch := reaper.WatchPID(info.PID)
+		_, startTime, err := procStat(info.PID)
+		if err != nil || startTime != info.StartTime {
+			unwatch()
+			return false
+		}
+	}
+
+	proc, err := os.FindProcess(info.PID)
+	if err != nil {
+		logger.Noticef("Cannot adopt PID %d of service %q: %v", info.PID, name, err)
+		return false
+	}
+	service := &serviceData{
+		manager:      m,
+		state:        stateRunning,
+		config:       config.Copy(),
+		logs:         servicelog.NewRingBuffer(maxLogBytes),
+		started:      make(chan error, 1),
+		stopped:      make(chan error, 2),
+		cmd:          &exec.Cmd{Process: proc},
+		startTime:    info.StartTime,
+		currentSince: time.Now(),
+	}
+	m.services[name] = service
+	logger.Noticef("Service %q adopted with PID %d", name, info.PID)
+
+	var copied <-chan struct{}
+	if info.OutputFD > 0 {
+		pipe, err := adoptPipe(info.OutputFD)
+		if err != nil {
+			logger.Noticef("Cannot capture output of adopted service %q: %v", name, err)
+			unix.Close(info.OutputFD)
+		} else {
+			service.outputPipe = pipe
+			copied = service.copyOutput(pipe)
+		}
+	}
+	if copied == nil {
+		logger.Noticef("Output of adopted service %q is not captured", name)
+	}
+
+	done := make(chan struct{})
+	if m.serviceOutput != nil {
+		service.forwardOutput(service.logs.HeadIterator(0), done)
+	}
+	go service.waitAdopted(isChild, copied, done)
+	m.logMgr.ServiceStarted(name, service.logs)
+
+	for _, f := range m.statusHandlers {
+		f(name, StatusActive)
+	}
+	return true
+}
+
+// adoptPipe returns the read end of an output pipe handed over by the
+// previous instance of the daemon.
+func adoptPipe(fd int) (*os.File, error) {
+	var stat unix.Stat_t
+	err := unix.Fstat(fd, &stat)
+	if err != nil {
+		return nil, err
+	}
+	if stat.Mode&unix.S_IFMT != unix.S_IFIFO {
+		return nil, fmt.Errorf("file descriptor %d is not a pipe", fd)
+	}
+	unix.CloseOnExec(fd)
+	err = unix.SetNonblock(fd, true)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), "output"), nil
+}
+
+// waitAdopted waits for an adopted service's process to exit. Processes that
+// aren't children of the daemon can't be waited for, so their exit is
+// observed with a pidfd (or by polling), and their exit code is unknown:
+// they are reported as exiting with unknownExitCode, which is treated as a
+// failure. Copied is closed when the process's output has all been copied
+// (or is nil if it isn't captured), and done is closed once the process has
+// exited.
+func (s *serviceData) waitAdopted(isChild bool, copied <-chan struct{}, done chan struct{}) {
+	pid := s.cmd.Process.Pid
+	exitCode := unknownExitCode
+	if isChild {
+		var err error
+		exitCode, err = reaper.WaitPID(pid)
+		if err != nil {
+			logger.Noticef("Cannot wait for service %q: %v", s.config.Name, err)
+		}
+		if copied != nil {
+			<-copied
+		}
+		logger.Debugf("Service %q exited with code %d.", s.config.Name, exitCode)
+	} else {
+		waitNonChild(pid, s.startTime)
+		logger.Debugf("Service %q exited; its exit code is unknown as it isn't a child of the daemon.", s.config.Name)
+	}
+	close(done)
+	err := s.exited(exitCode)
+	if err != nil {
+		logger.Noticef("Cannot transition state after service exit: %v", err)
+	}
+}
+
+// waitNonChild waits for a process that isn't a child of the daemon, with the
+// given start time, to exit. It uses a pidfd if the kernel supports them
+// (Linux 5.3+), and otherwise polls /proc.
+func waitNonChild(pid int, startTime uint64) {
+	pidfd, err := unix.PidfdOpen(pid, 0)
+	if err == nil {
+		defer unix.Close(pidfd)
+		// Ensure the pidfd refers to the same process, in case the PID was
+		// reused just before it was opened.
+		_, st, err := procStat(pid)
+		if err != nil || st != startTime {
+			return
+		}
+		fds := []unix.PollFd{{Fd: int32(pidfd), Events: unix.POLLIN}}
+		for {
+			_, err = unix.Poll(fds, -1)
+			if err != unix.EINTR {
+				break
+			}
+		}
+		if err == nil {
+			return
+		}
+		logger.Debugf("Cannot poll pidfd of PID %d, polling /proc instead: %v", pid, err)
+	}
+	for {
+		_, st, err := procStat(pid)
+		if err != nil || st != startTime {
+			return
+		}
+		time.Sleep(adoptedPollInterval)
+	}
+}
+
+// procStat returns the parent PID and start time (in clock ticks after
+// boot) of the given process, as reported by /proc/<pid>/stat. It returns an
+// error if the process doesn't exist or has exited (is a zombie).
+func procStat(pid int) (ppid int, startTime uint64, err error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+	// The command name (field 2) is in parentheses and may itself contain
+	// spaces or parentheses, so parse the fields after the last ')'.
+	stat := string(data)
+	i := strings.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0, 0, fmt.Errorf("invalid stat data for PID %d", pid)
+	}
+	fields := strings.Fields(stat[i+1:])
+	// Fields are numbered from 3 (state) onwards; starttime is field 22.
+	if len(fields) < 20 {
+		return 0, 0, fmt.Errorf("invalid stat data for PID %d", pid)
+	}
+	if fields[0] == "Z" || fields[0] == "X" {
+		return 0, 0, fmt.Errorf("process %d has exited", pid)
+	}
+	ppid, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid parent PID for PID %d: %v", pid, err)
+	}
+	startTime, err = strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid start time for PID %d: %v", pid, err)
+	}
+	return ppid, startTime, nil
+}
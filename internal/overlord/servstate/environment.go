@@ -0,0 +1,213 @@
+package servstate
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/canonical/pebble/internal/plan"
+	"github.com/canonical/pebble/internal/secrets"
+)
+
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// serviceEnvironment returns the environment variables configured for the
+// service: those read from its environment-files (in order), overridden by
+// those in its environment map. Encrypted secret values in the environment
+// map are decrypted with the given key, and references to $VAR or ${VAR} in
+// its other values are expanded. Values read from environment files are used
+// as is.
+func serviceEnvironment(config *plan.Service, key *secrets.Key) (map[string]string, error) {
+	environment := make(map[string]string)
+	for _, path := range config.EnvironmentFiles {
+		vars, err := readEnvironmentFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range vars {
+			environment[k] = v
+		}
+	}
+
+	// Values are expanded using the unexpanded values of the other variables
+	// (expansion isn't recursive). A variable referring to itself, like
+	// PATH=/opt/bin:$PATH, gets Pebble's own value.
+	unexpanded := make(map[string]string, len(environment)+len(config.Environment))
+	for k, v := range environment {
+		unexpanded[k] = v
+	}
+	for k, v := range config.Environment {
+		if secrets.IsEncrypted(v) {
+			plaintext, err := secrets.Decrypt(key, v)
+			if err != nil {
+				return nil, fmt.Errorf("cannot decrypt environment variable %q: %w", k, err)
+			}
+			// Decrypted secrets are used as is, never expanded.
+			environment[k] = plaintext
+			unexpanded[k] = plaintext
+			continue
+		}
+		unexpanded[k] = v
+	}
+	for k, v := range config.Environment {
+		if secrets.IsEncrypted(v) {
+			continue
+		}
+		environment[k] = expandVars(v, func(name string) (string, bool) {
+			if value, ok := unexpanded[name]; ok && name != k {
+				return value, true
+			}
+			return os.LookupEnv(name)
+		})
+	}
+	return environment, nil
+}
+
+// expandVars replaces $VAR and ${VAR} references in s with the values
+// returned by lookup. Everything else is left as written, so that commands
+// run with "sh -c" still work: references to undefined variables, special
+// shell variables such as $1, $? or $$, and other ${...} forms such as
+// ${VAR:-default}.
+func expandVars(s string, lookup func(name string) (string, bool)) string {
+	if !strings.Contains(s, "$") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if strings.HasPrefix(s[i+1:], "$") {
+			// The shell's process ID, which may be followed by a name.
+			b.WriteString("$$")
+			i++
+			continue
+		}
+		name, n := varReference(s[i+1:])
+		if name != "" {
+			if value, ok := lookup(name); ok {
+				b.WriteString(value)
+				i += n
+				continue
+			}
+		}
+		b.WriteByte('$')
+	}
+	return b.String()
+}
+
+// varReference returns the variable name referred to at the start of s
+// (which follows a "$"), and the length of the reference, or "" if it's not
+// a plain $VAR or ${VAR} reference.
+func varReference(s string) (name string, n int) {
+	if strings.HasPrefix(s, "{") {
+		end := strings.IndexByte(s, '}')
+		if end < 0 || !envNameRegexp.MatchString(s[1:end]) {
+			return "", 0
+		}
+		return s[1:end], end + 1
+	}
+	for n < len(s) && isNameByte(s[n], n == 0) {
+		n++
+	}
+	return s[:n], n
+}
+
+func isNameByte(c byte, first bool) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || !first && '0' <= c && c <= '9'
+}
+
+// readEnvironmentFile reads the variables from an environment file in the
+// "dotenv" format: one NAME=value per line, optionally prefixed by "export".
+// Blank lines and lines starting with "#" are ignored. Values may be quoted
+// with single quotes (taken literally) or double quotes (in which \n, \", \\
+// and \$ escapes are supported).
+func readEnvironmentFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read environment file: %w", err)
+	}
+	defer f.Close()
+
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, err := parseEnvironmentLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse environment file %q: line %d: %v", path, lineNum, err)
+		}
+		vars[name] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read environment file %q: %w", path, err)
+	}
+	return vars, nil
+}
+
+func parseEnvironmentLine(line string) (name, value string, err error) {
+	line = strings.TrimPrefix(line, "export ")
+	i := strings.IndexByte(line, '=')
+	if i < 0 {
+		return "", "", fmt.Errorf("expected NAME=value")
+	}
+	name = strings.TrimSpace(line[:i])
+	if !envNameRegexp.MatchString(name) {
+		return "", "", fmt.Errorf("invalid variable name %q", name)
+	}
+	value = strings.TrimSpace(line[i+1:])
+
+	switch {
+	case strings.HasPrefix(value, "'"):
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", "", fmt.Errorf("missing closing single quote")
+		}
+		if rest := strings.TrimSpace(value[end+2:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", "", fmt.Errorf("unexpected text after closing quote")
+		}
+		return name, value[1 : end+1], nil
+
+	case strings.HasPrefix(value, `"`):
+		var b strings.Builder
+		for j := 1; j < len(value); j++ {
+			c := value[j]
+			switch {
+			case c == '\\' && j+1 < len(value):
+				j++
+				switch value[j] {
+				case 'n':
+					b.WriteByte('\n')
+				case '"', '\\', '$':
+					b.WriteByte(value[j])
+				default:
+					b.WriteByte('\\')
+					b.WriteByte(value[j])
+				}
+			case c == '"':
+				if rest := strings.TrimSpace(value[j+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+					return "", "", fmt.Errorf("unexpected text after closing quote")
+				}
+				return name, b.String(), nil
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", "", fmt.Errorf("missing closing double quote")
+
+	default:
+		// Unquoted values end at a comment.
+		if j := strings.Index(value, " #"); j >= 0 {
+			value = strings.TrimSpace(value[:j])
+		}
+		return name, value, nil
+	}
+}
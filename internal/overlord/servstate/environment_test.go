@@ -0,0 +1,120 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package servstate_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/pebble/internal/overlord/servstate"
+)
+
+var environmentFileTests = []struct {
+	content string
+	vars    map[string]string
+	error   string
+}{{
+	content: "",
+	vars:    map[string]string{},
+}, {
+	content: `
+# A comment
+FOO=bar
+export BAZ = qux
+EMPTY=
+UNQUOTED=a b c # trailing comment
+SINGLE='$literal'
+SINGLE2='$HOME \n' # comment
+DOUBLE="line1\nline2 \"quoted\" \$HOME \\ \x"
+`,
+	vars: map[string]string{
+		"FOO":      "bar",
+		"BAZ":      "qux",
+		"EMPTY":    "",
+		"UNQUOTED": "a b c",
+		"SINGLE":   "$literal",
+		"SINGLE2":  `$HOME \n`,
+		"DOUBLE":   "line1\nline2 \"quoted\" $HOME \\ \\x",
+	},
+}, {
+	content: "FOO=bar\nno equals sign\n",
+	error:   `cannot parse environment file ".*": line 2: expected NAME=value`,
+}, {
+	content: "1FOO=bar\n",
+	error:   `cannot parse environment file ".*": line 1: invalid variable name "1FOO"`,
+}, {
+	content: "FOO='bar\n",
+	error:   `.* line 1: missing closing single quote`,
+}, {
+	content: "FOO=\"bar\n",
+	error:   `.* line 1: missing closing double quote`,
+}, {
+	content: "FOO=\"bar\" baz\n",
+	error:   `.* line 1: unexpected text after closing quote`,
+}, {
+	content: "FOO='bar'\nBAR=\"x\"\nBAZ=$FOO\n",
+	vars:    map[string]string{"FOO": "bar", "BAR": "x", "BAZ": "$FOO"},
+}}
+
+func (s *S) TestReadEnvironmentFile(c *C) {
+	for _, test := range environmentFileTests {
+		path := filepath.Join(c.MkDir(), "env")
+		err := ioutil.WriteFile(path, []byte(test.content), 0644)
+		c.Assert(err, IsNil)
+
+		vars, err := servstate.ReadEnvironmentFile(path)
+		if test.error != "" {
+			c.Check(err, ErrorMatches, test.error, Commentf("content %q", test.content))
+			continue
+		}
+		c.Check(err, IsNil, Commentf("content %q", test.content))
+		c.Check(vars, DeepEquals, test.vars, Commentf("content %q", test.content))
+	}
+}
+
+func (s *S) TestReadEnvironmentFileMissing(c *C) {
+	_, err := servstate.ReadEnvironmentFile(filepath.Join(c.MkDir(), "missing"))
+	c.Assert(err, ErrorMatches, `cannot read environment file: open .*/missing: no such file or directory`)
+}
+
+var expandVarsTests = []struct {
+	input    string
+	expected string
+}{
+	{"", ""},
+	{"no variables", "no variables"},
+	{"$FOO ${FOO} x$FOO.y ${FOO}bar", "foo foo xfoo.y foobar"},
+	{"$FOOBAR $FOO_ $EMPTY.", "$FOOBAR $FOO_ ."},
+	{"$UNDEFINED ${UNDEFINED}", "$UNDEFINED ${UNDEFINED}"},
+	{"${FOO:-bar} ${FOO/o/0} ${#FOO} ${FOO", "${FOO:-bar} ${FOO/o/0} ${#FOO} ${FOO"},
+	{"$$ $1 $? $@ $# $ $", "$$ $1 $? $@ $# $ $"},
+	{"$$FOO $$$FOO", "$$FOO $$foo"},
+	// Typical "sh -c" scripts are left for the shell to expand.
+	{"for i in 1 2; do echo $i; done", "for i in 1 2; do echo $i; done"},
+	{`echo "${UNDEFINED:-default}" $$ >/tmp/$FOO.pid`, `echo "${UNDEFINED:-default}" $$ >/tmp/foo.pid`},
+}
+
+func (s *S) TestExpandVars(c *C) {
+	vars := map[string]string{"FOO": "foo", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+	for _, test := range expandVarsTests {
+		c.Check(servstate.ExpandVars(test.input, lookup), Equals, test.expected, Commentf("%q", test.input))
+	}
+}
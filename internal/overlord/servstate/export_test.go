@@ -24,6 +24,9 @@ import (
 
 var CalculateNextBackoff = calculateNextBackoff
 var GetAction = getAction
+var ProcStat = procStat
+var ReadEnvironmentFile = readEnvironmentFile
+var ExpandVars = expandVars
 
 func (m *ServiceManager) RunningCmds() map[string]*exec.Cmd {
 	m.servicesLock.Lock()
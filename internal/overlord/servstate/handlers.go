@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"os/user"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -14,12 +15,14 @@ import (
 	"golang.org/x/sys/unix"
 	"gopkg.in/tomb.v2"
 
+	"github.com/canonical/pebble/internal/cgroup"
 	"github.com/canonical/pebble/internal/logger"
 	"github.com/canonical/pebble/internal/osutil"
 	"github.com/canonical/pebble/internal/overlord/restart"
 	"github.com/canonical/pebble/internal/overlord/state"
 	"github.com/canonical/pebble/internal/plan"
 	"github.com/canonical/pebble/internal/reaper"
+	"github.com/canonical/pebble/internal/sandbox"
 	"github.com/canonical/pebble/internal/servicelog"
 )
 
@@ -66,6 +69,26 @@ var (
 	// failDelay is the duration given to services for shutting down when Pebble
 	// sends a SIGKILL signal.
 	failDelay = 5 * time.Second
+
+	// waitForChecksTimeoutDefault is the duration a service waits for its
+	// wait-for-checks to be up if it hasn't specified its own duration.
+	waitForChecksTimeoutDefault = 60 * time.Second
+
+	// checkStatusInterval is how often the status of the checks is polled
+	// while waiting for them to be up.
+	checkStatusInterval = 100 * time.Millisecond
+
+	// reloadCommandTimeout is the duration a service's reload-command is
+	// given to complete before it's killed and the reload fails.
+	reloadCommandTimeout = 30 * time.Second
+
+	// hookTimeout is the duration each of a service's pre-start, post-start
+	// and post-stop commands is given to complete before it's killed.
+	hookTimeout = 60 * time.Second
+
+	// restartLimitWindowDefault is the window over which restarts are counted
+	// for a service with a restart-limit that hasn't specified its own window.
+	restartLimitWindowDefault = 60 * time.Second
 )
 
 const (
@@ -88,6 +111,7 @@ const (
 	stateStopped     serviceState = "stopped"
 	stateBackoff     serviceState = "backoff"
 	stateExited      serviceState = "exited"
+	stateFailed      serviceState = "failed"
 )
 
 // serviceData holds the state and other data for a service under our control.
@@ -99,11 +123,17 @@ type serviceData struct {
 	started      chan error
 	stopped      chan error
 	cmd          *exec.Cmd
+	startTime    uint64
+	outputPipe   *os.File
+	outputFD     int
 	backoffNum   int
 	backoffTime  time.Duration
+	restartTimes []time.Time
+	restarts     int
 	resetTimer   *time.Timer
 	restarting   bool
 	currentSince time.Time
+	hooksDone    chan struct{}
 }
 
 func (m *ServiceManager) doStart(task *state.Task, tomb *tomb.Tomb) error {
@@ -118,12 +148,30 @@ func (m *ServiceManager) doStart(task *state.Task, tomb *tomb.Tomb) error {
 	if err != nil {
 		return fmt.Errorf("cannot acquire plan lock: %w", err)
 	}
-	config, ok := m.plan.Services[request.Name]
+	config, ok := m.plan.ExpandedServices()[request.Name]
 	releasePlan()
 	if !ok {
 		return fmt.Errorf("cannot find service %q in plan", request.Name)
 	}
 
+	if !m.ServiceActive(config.Name) {
+		// Hold off starting the service until the checks it waits for are up.
+		if len(config.WaitForChecks) > 0 {
+			err := m.waitForChecks(task, tomb, config)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Run the pre-start commands. If one fails the service isn't started,
+		// but as the service itself never ran this isn't treated as a crash
+		// (there's no backoff or restart).
+		err := m.runHooks(task, tomb.Dying(), config, "pre-start", config.PreStart)
+		if err != nil {
+			return fmt.Errorf("cannot start service: %w", err)
+		}
+	}
+
 	// Create the service object (or reuse the existing one by name).
 	service := m.serviceForStart(task, config)
 	if service == nil {
@@ -147,6 +195,12 @@ func (m *ServiceManager) doStart(task *state.Task, tomb *tomb.Tomb) error {
 			return fmt.Errorf("cannot start service: %w", err)
 		}
 		// Started successfully (ran for small amount of time without exiting).
+		// A failing post-start command fails the start, though the service
+		// itself is left running.
+		err = m.runHooks(task, tomb.Dying(), config, "post-start", config.PostStart)
+		if err != nil {
+			return fmt.Errorf("service started, but %w", err)
+		}
 		return nil
 	case <-tomb.Dying():
 		// User tried to abort the start, sending SIGKILL to process is about
@@ -162,6 +216,50 @@ func (m *ServiceManager) doStart(task *state.Task, tomb *tomb.Tomb) error {
 	}
 }
 
+// ServiceActive reports whether the named service is starting or running.
+func (m *ServiceManager) ServiceActive(name string) bool {
+	m.servicesLock.Lock()
+	defer m.servicesLock.Unlock()
+
+	service := m.services[name]
+	return service != nil && stateToStatus(service.state) == StatusActive
+}
+
+// waitForChecks waits until all the checks in the service's wait-for-checks
+// are up, and returns an error if that doesn't happen within the configured
+// timeout.
+func (m *ServiceManager) waitForChecks(task *state.Task, tomb *tomb.Tomb, config *plan.Service) error {
+	if m.checkStatus == nil {
+		return fmt.Errorf("cannot wait for checks: check status not available")
+	}
+	timeout := waitForChecksTimeoutDefault
+	if config.WaitForChecksTimeout.IsSet {
+		timeout = config.WaitForChecksTimeout.Value
+	}
+	taskLogf(task, "Waiting up to %s for checks to be up: %s", timeout, strings.Join(config.WaitForChecks, ", "))
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(checkStatusInterval)
+	defer ticker.Stop()
+	for {
+		notUp, err := m.checkStatus(config.WaitForChecks)
+		if err != nil {
+			return fmt.Errorf("cannot wait for checks: %w", err)
+		}
+		if len(notUp) == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			return fmt.Errorf("timed out after %s waiting for checks to be up: %s", timeout, strings.Join(notUp, ", "))
+		case <-tomb.Dying():
+			return fmt.Errorf("start aborted while waiting for checks")
+		}
+	}
+}
+
 // serviceForStart looks up the service by name in the services map; it
 // creates a new service object if one doesn't exist, returns the existing one
 // if it already exists but is stopped, or returns nil if it already exists
@@ -192,10 +290,12 @@ func (m *ServiceManager) serviceForStart(task *state.Task, config *plan.Service)
 	case stateInitial, stateStarting, stateRunning:
 		taskLogf(task, "Service %q already started.", config.Name)
 		return nil
-	case stateBackoff, stateStopped, stateExited:
-		// Start allowed when service is backing off, was stopped, or has exited.
+	case stateBackoff, stateStopped, stateExited, stateFailed:
+		// Start allowed when service is backing off, was stopped, has exited,
+		// or has given up restarting.
 		service.backoffNum = 0
 		service.backoffTime = 0
+		service.restartTimes = nil
 		service.transition(stateInitial)
 		return service
 	default:
@@ -224,9 +324,12 @@ func (m *ServiceManager) doStop(task *state.Task, tomb *tomb.Tomb) error {
 	if service == nil {
 		return nil
 	}
+	m.servicesLock.Lock()
+	config := service.config.Copy()
+	m.servicesLock.Unlock()
 
-	// Stop service: send SIGTERM, and if that doesn't stop the process in a
-	// short time, send SIGKILL.
+	// Stop service: send the stop signal (SIGTERM by default), and if that
+	// doesn't stop the process in a short time, send SIGKILL.
 	err = service.stop()
 	if err != nil {
 		return err
@@ -238,12 +341,16 @@ func (m *ServiceManager) doStop(task *state.Task, tomb *tomb.Tomb) error {
 			if err != nil {
 				return fmt.Errorf("cannot stop service: %w", err)
 			}
-			// Stopped successfully.
+			// Stopped successfully, run the post-stop commands.
+			err = m.runHooks(task, tomb.Dying(), config, "post-stop", config.PostStop)
+			if err != nil {
+				return fmt.Errorf("service stopped, but %w", err)
+			}
 			return nil
 		case <-tomb.Dying():
-			// User tried to abort the stop, but SIGTERM and/or SIGKILL have
-			// already been sent to the process, so there's not much more we
-			// can do than log it.
+			// User tried to abort the stop, but the stop signal and/or SIGKILL
+			// have already been sent to the process, so there's not much more
+			// we can do than log it.
 			logger.Noticef("Cannot abort stop for service %q, signals already sent", request.Name)
 		}
 	}
@@ -273,11 +380,177 @@ func (m *ServiceManager) serviceForStop(task *state.Task, name string) *serviceD
 		taskLogf(task, "Service %q had already exited.", name)
 		service.transition(stateStopped)
 		return nil
+	case stateFailed:
+		taskLogf(task, "Service %q had already failed.", name)
+		service.transition(stateStopped)
+		return nil
 	default:
 		return service
 	}
 }
 
+func (m *ServiceManager) doReload(task *state.Task, tomb *tomb.Tomb) error {
+	m.state.Lock()
+	request, err := TaskServiceRequest(task)
+	m.state.Unlock()
+	if err != nil {
+		return err
+	}
+
+	m.servicesLock.Lock()
+	service := m.services[request.Name]
+	if service == nil {
+		m.servicesLock.Unlock()
+		return fmt.Errorf("cannot reload service %q: service is not running", request.Name)
+	}
+	switch service.state {
+	case stateStarting, stateRunning:
+	default:
+		m.servicesLock.Unlock()
+		return fmt.Errorf("cannot reload service %q: service is not running", request.Name)
+	}
+	config := service.config.Copy()
+	if config.ReloadCommand == "" {
+		// No reload command, send the reload signal to the service's process.
+		err := service.sendReloadSignal()
+		m.servicesLock.Unlock()
+		if err != nil {
+			return fmt.Errorf("cannot reload service %q: %w", request.Name, err)
+		}
+		return nil
+	}
+	m.servicesLock.Unlock()
+
+	// Don't hold the lock while running the reload command, as it may take
+	// a while (and the service itself may change state in the meantime).
+	err = m.runServiceCommand(task, tomb.Dying(), config, "reload command", config.ReloadCommand, reloadCommandTimeout)
+	if err != nil {
+		return fmt.Errorf("cannot reload service: %w", err)
+	}
+	return nil
+}
+
+// sendReloadSignal sends the reload signal (SIGHUP by default) to the
+// service's process group, the same way stop signals it. Note that this
+// function doesn't lock; it assumes the caller will.
+func (s *serviceData) sendReloadSignal() error {
+	sig := syscall.SIGHUP
+	if s.config.ReloadSignal != "" {
+		sig = unix.SignalNum(s.config.ReloadSignal)
+	}
+	logger.Noticef("Reloading service %q by sending %s", s.config.Name, unix.SignalName(sig))
+	return syscall.Kill(-s.cmd.Process.Pid, sig)
+}
+
+// runServiceCommand runs a command in the context of the given service (as
+// its user and group, and with its environment), waits up to timeout for it
+// to finish (or until dying is closed), and adds its output to the task's
+// log if it fails, or to Pebble's log if task is nil. The "what" argument
+// describes the command in logs and errors, for example "reload command".
+func (m *ServiceManager) runServiceCommand(task *state.Task, dying <-chan struct{}, config *plan.Service, what, command string, timeout time.Duration) error {
+	args, err := shlex.Split(command)
+	if err != nil {
+		return fmt.Errorf("cannot parse %s: %s", what, err)
+	}
+	cmd, err := m.serviceCommand(config, args, false)
+	if err != nil {
+		return err
+	}
+	outputBuffer := servicelog.NewRingBuffer(maxLogBytes)
+	defer outputBuffer.Close()
+	cmd.Stdout = outputBuffer
+	cmd.Stderr = outputBuffer
+
+	logger.Noticef("Running %s for service %q: %s", what, config.Name, command)
+	err = reaper.StartCommand(cmd)
+	if err != nil {
+		return fmt.Errorf("cannot start %s: %w", what, err)
+	}
+
+	type waitResult struct {
+		exitCode int
+		err      error
+	}
+	done := make(chan waitResult, 1)
+	go func() {
+		exitCode, err := reaper.WaitCommand(cmd)
+		done <- waitResult{exitCode, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case result := <-done:
+		switch {
+		case result.err != nil:
+			err = fmt.Errorf("cannot wait for %s: %w", what, result.err)
+		case result.exitCode != 0:
+			err = fmt.Errorf("%s exited with code %d", what, result.exitCode)
+		}
+	case <-timer.C:
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		err = fmt.Errorf("%s timed out after %s", what, timeout)
+	case <-dying:
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return fmt.Errorf("aborted, sent SIGKILL to %s", what)
+	}
+	if err != nil {
+		if task != nil {
+			addLastOutput(task, what, outputBuffer)
+		} else {
+			logLastOutput(config.Name, what, outputBuffer)
+		}
+		return err
+	}
+	return nil
+}
+
+// runHooks runs the given hook commands (for example the service's
+// pre-start commands) in order, stopping at the first one that fails.
+func (m *ServiceManager) runHooks(task *state.Task, dying <-chan struct{}, config *plan.Service, hook string, commands []string) error {
+	for _, command := range commands {
+		err := m.runServiceCommand(task, dying, config, hook+" command", command, hookTimeout)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runHooks runs the service's given hook commands in the background, outside
+// of a change, for example its post-stop commands when it exits by itself.
+// Any failure is logged, and then passed to after (if not nil). The hooks
+// run after any others started earlier for the service have finished, and
+// are killed if the manager stops. Note that this function doesn't lock; it
+// assumes the caller will.
+func (s *serviceData) runHooks(hook string, commands []string, after func(err error)) {
+	m := s.manager
+	if m.stopped {
+		return
+	}
+	config := s.config.Copy()
+	previous := s.hooksDone
+	done := make(chan struct{})
+	s.hooksDone = done
+	m.backgroundHooks.Add(1)
+	go func() {
+		defer m.backgroundHooks.Done()
+		defer close(done)
+		if previous != nil {
+			<-previous
+		}
+		err := m.runHooks(nil, m.hooksDying, config, hook, commands)
+		if err != nil {
+			logger.Noticef("Service %q: %v", config.Name, err)
+		}
+		if after != nil {
+			after(err)
+		}
+	}()
+}
+
 func (m *ServiceManager) removeService(name string) {
 	m.servicesLock.Lock()
 	defer m.servicesLock.Unlock()
@@ -300,8 +573,19 @@ func (s *serviceData) transitionRestarting(state serviceState, restarting bool)
 		s.currentSince = time.Now()
 	}
 
+	// Record the service's process in state if it has started or exited
+	// (see saveProcesses).
+	if hasProcess(s.state) != hasProcess(state) {
+		s.manager.state.EnsureBefore(0)
+	}
 	s.state = state
 	s.restarting = restarting
+
+	if oldStatus != newStatus {
+		for _, f := range s.manager.statusHandlers {
+			f(s.config.Name, newStatus)
+		}
+	}
 }
 
 // start is called to transition from the initial state and start the service.
@@ -340,49 +624,19 @@ func (s *serviceData) startInternal() error {
 		// it does not hurt to double check and report.
 		return fmt.Errorf("cannot parse service command: %s", err)
 	}
-	s.cmd = exec.Command(args[0], args[1:]...)
-	s.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-
-	// Copy environment to avoid updating original.
-	environment := make(map[string]string)
-	for k, v := range s.config.Environment {
-		environment[k] = v
-	}
-
-	// Start as another user if specified in plan.
-	uid, gid, err := osutil.NormalizeUidGid(s.config.UserID, s.config.GroupID, s.config.User, s.config.Group)
+	s.cmd, err = s.manager.serviceCommand(s.config, args, true)
 	if err != nil {
 		return err
 	}
-	if uid != nil && gid != nil {
-		setCmdCredential(s.cmd, &syscall.Credential{
-			Uid: uint32(*uid),
-			Gid: uint32(*gid),
-		})
-
-		// Also set HOME and USER if not explicitly specified in config.
-		if environment["HOME"] == "" || environment["USER"] == "" {
-			u, err := user.LookupId(strconv.Itoa(*uid))
-			if err != nil {
-				logger.Noticef("Cannot look up user %d: %v", *uid, err)
-			} else {
-				if environment["HOME"] == "" {
-					environment["HOME"] = u.HomeDir
-				}
-				if environment["USER"] == "" {
-					environment["USER"] = u.Username
-				}
-			}
-		}
-	}
 
-	// Pass service description's environment variables to child process.
-	s.cmd.Env = os.Environ()
-	for k, v := range environment {
-		s.cmd.Env = append(s.cmd.Env, k+"="+v)
+	// Set up stdout and stderr to write to a pipe, whose output is copied to
+	// the log ring buffer. Pebble owns the read end of the pipe, rather than
+	// os/exec, so it can be handed over to the daemon's next instance if the
+	// daemon re-executes itself (see HandOver).
+	outputPipe, outputWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("cannot create output pipe: %w", err)
 	}
-
-	// Set up stdout and stderr to write to log ring buffer.
 	var outputIterator servicelog.Iterator
 	if s.manager.serviceOutput != nil {
 		// Use the head iterator so that we copy from where this service
@@ -390,14 +644,15 @@ func (s *serviceData) startInternal() error {
 		outputIterator = s.logs.HeadIterator(0)
 	}
 	serviceName := s.config.Name
-	logWriter := servicelog.NewFormatWriter(s.logs, serviceName)
-	s.cmd.Stdout = logWriter
-	s.cmd.Stderr = logWriter
+	s.cmd.Stdout = outputWriter
+	s.cmd.Stderr = outputWriter
 
 	// Start the process!
 	logger.Noticef("Service %q starting: %s", serviceName, s.config.Command)
 	err = reaper.StartCommand(s.cmd)
+	_ = outputWriter.Close()
 	if err != nil {
+		_ = outputPipe.Close()
 		if outputIterator != nil {
 			_ = outputIterator.Close()
 		}
@@ -405,9 +660,19 @@ func (s *serviceData) startInternal() error {
 		return fmt.Errorf("cannot start service: %w", err)
 	}
 	logger.Debugf("Service %q started with PID %d", serviceName, s.cmd.Process.Pid)
+	_, s.startTime, err = procStat(s.cmd.Process.Pid)
+	if err != nil {
+		// Not fatal, but the service can't be adopted after a daemon restart.
+		logger.Debugf("Cannot read start time of service %q: %v", serviceName, err)
+		s.startTime = 0
+	}
+	s.outputPipe = outputPipe
+	s.outputFD = 0
+	copied := s.copyOutput(outputPipe)
 	s.resetTimer = time.AfterFunc(s.config.BackoffLimit.Value, func() { logError(s.backoffResetElapsed()) })
 
-	// Start a goroutine to wait for the process to finish.
+	// Start a goroutine to wait for the process to finish (and, as os/exec
+	// does, for its output to be copied).
 	done := make(chan struct{})
 	cmd := s.cmd
 	go func() {
@@ -417,6 +682,7 @@ func (s *serviceData) startInternal() error {
 		} else {
 			logger.Debugf("Service %q exited with code %d.", serviceName, exitCode)
 		}
+		<-copied
 		close(done)
 		err := s.exited(exitCode)
 		if err != nil {
@@ -426,15 +692,7 @@ func (s *serviceData) startInternal() error {
 
 	// Start a goroutine to read from the service's log buffer and copy to the output.
 	if s.manager.serviceOutput != nil {
-		go func() {
-			defer outputIterator.Close()
-			for outputIterator.Next(done) {
-				_, err := io.Copy(s.manager.serviceOutput, outputIterator)
-				if err != nil {
-					logger.Noticef("Service %q log write failed: %v", serviceName, err)
-				}
-			}
-		}()
+		s.forwardOutput(outputIterator, done)
 	}
 
 	// Pass buffer reference to logMgr to start log forwarding
@@ -443,6 +701,174 @@ func (s *serviceData) startInternal() error {
 	return nil
 }
 
+// copyOutput starts a goroutine to copy the service's output from the read
+// end of its output pipe to its log ring buffer. The returned channel is
+// closed when the copying stops: when the pipe has been closed by the
+// service (and any processes that inherited its output), at which point the
+// pipe is closed, or when its output is handed over (see HandOver), in which
+// case the pipe is left open.
+func (s *serviceData) copyOutput(pipe *os.File) <-chan struct{} {
+	copied := make(chan struct{})
+	serviceName := s.config.Name
+	logWriter := servicelog.NewFormatWriter(s.logs, serviceName)
+	go func() {
+		defer close(copied)
+		_, err := io.Copy(logWriter, pipe)
+		if os.IsTimeout(err) {
+			return
+		}
+		if err != nil {
+			logger.Noticef("Cannot read output of service %q: %v", serviceName, err)
+		}
+		_ = pipe.Close()
+	}()
+	return copied
+}
+
+// forwardOutput starts a goroutine to copy the service's logs from the
+// iterator to the manager's service output, until done is closed.
+func (s *serviceData) forwardOutput(iterator servicelog.Iterator, done <-chan struct{}) {
+	serviceName := s.config.Name
+	go func() {
+		defer iterator.Close()
+		for iterator.Next(done) {
+			_, err := io.Copy(s.manager.serviceOutput, iterator)
+			if err != nil {
+				logger.Noticef("Service %q log write failed: %v", serviceName, err)
+			}
+		}
+	}()
+}
+
+// serviceCommand returns a command to run the given arguments in the context
+// of the service: in its own process group, with the service's environment,
+// as the service's user and group if specified in the plan, and with its
+// sandboxing options applied. If inCgroup is true, the command is also run
+// in the service's cgroup (if cgroups are in use), which is only done for
+// the service itself, so that its reload and hook commands aren't subject to
+// its resource limits or killed along with it.
+func (m *ServiceManager) serviceCommand(config *plan.Service, args []string, inCgroup bool) (*exec.Cmd, error) {
+	// Read the environment files, decrypt secrets, and expand variables in
+	// the environment (this returns a new map, so the original config isn't
+	// updated).
+	environment, err := serviceEnvironment(config, m.secretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start as another user if specified in plan.
+	uid, gid, err := osutil.NormalizeUidGid(config.UserID, config.GroupID, config.User, config.Group)
+	if err != nil {
+		return nil, err
+	}
+	if uid != nil && gid != nil {
+		// Also set HOME and USER if not explicitly specified in config.
+		if environment["HOME"] == "" || environment["USER"] == "" {
+			u, err := user.LookupId(strconv.Itoa(*uid))
+			if err != nil {
+				logger.Noticef("Cannot look up user %d: %v", *uid, err)
+			} else {
+				if environment["HOME"] == "" {
+					environment["HOME"] = u.HomeDir
+				}
+				if environment["USER"] == "" {
+					environment["USER"] = u.Username
+				}
+			}
+		}
+	}
+
+	// Expand variables in the command's arguments, using the service's
+	// environment and falling back to Pebble's own.
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		expanded[i] = expandVars(arg, func(name string) (string, bool) {
+			if value, ok := environment[name]; ok {
+				return value, true
+			}
+			return os.LookupEnv(name)
+		})
+	}
+	cmd := exec.Command(expanded[0], expanded[1:]...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if uid != nil && gid != nil {
+		setCmdCredential(cmd, &syscall.Credential{
+			Uid: uint32(*uid),
+			Gid: uint32(*gid),
+		})
+	}
+
+	// Pass service description's environment variables to child process.
+	cmd.Env = os.Environ()
+	for k, v := range environment {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+
+	opts, err := sandbox.PlanOptions(&config.Sandbox)
+	if err != nil {
+		return nil, err
+	}
+	if inCgroup {
+		opts, err = m.cgroupOptions(config, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = sandbox.Apply(cmd, opts)
+	if err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// cgroupOptions creates the service's cgroup with its resource limits, and
+// returns the sandbox options updated to run the service in it, if cgroups
+// are in use.
+func (m *ServiceManager) cgroupOptions(config *plan.Service, opts *sandbox.Options) (*sandbox.Options, error) {
+	limits, err := serviceLimits(config)
+	if err != nil {
+		return nil, err
+	}
+	if m.cgroups == nil {
+		if limits != (cgroup.Limits{}) {
+			logger.Noticef("Cannot apply resource limits to service %q: cgroups not in use", config.Name)
+		}
+		return opts, nil
+	}
+	dir, err := m.cgroups.Create(config.Name, limits)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create cgroup: %w", err)
+	}
+	if opts == nil {
+		opts = &sandbox.Options{}
+	}
+	opts.Cgroup = dir
+	return opts, nil
+}
+
+// serviceLimits returns the service's resource limits, to apply to its
+// cgroup.
+func serviceLimits(config *plan.Service) (cgroup.Limits, error) {
+	limits := cgroup.Limits{
+		CPUWeight: config.CPUWeight,
+		PidsMax:   config.PidsMax,
+	}
+	var err error
+	if config.MemoryMax != "" {
+		limits.MemoryMax, err = plan.ParseMemory(config.MemoryMax)
+		if err != nil {
+			return cgroup.Limits{}, err
+		}
+	}
+	if config.CPUMax != "" {
+		limits.CPUMax, err = plan.ParseCPUMax(config.CPUMax)
+		if err != nil {
+			return cgroup.Limits{}, err
+		}
+	}
+	return limits, nil
+}
+
 // okayWaitElapsed is called when the okay-wait timer has elapsed (and the
 // service is considered running successfully).
 func (s *serviceData) okayWaitElapsed() error {
@@ -477,6 +903,10 @@ func (s *serviceData) exited(exitCode int) error {
 
 	case stateRunning:
 		logger.Noticef("Service %q stopped unexpectedly with code %d", s.config.Name, exitCode)
+		for _, f := range s.manager.exitHandlers {
+			f(s.config.Name, exitCode)
+		}
+		s.runHooks("post-stop", s.config.PostStop, nil)
 		action, onType := getAction(s.config, exitCode == 0)
 		switch action {
 		case plan.ActionIgnore:
@@ -501,8 +931,11 @@ func (s *serviceData) exited(exitCode int) error {
 		}
 
 	case stateTerminating, stateKilling:
+		// Kill any processes the service left behind.
+		s.killCgroup()
 		if s.restarting {
 			logger.Noticef("Service %q exited after check failure, restarting", s.config.Name)
+			s.runHooks("post-stop", s.config.PostStop, nil)
 			s.doBackoff(plan.ActionRestart, "on-check-failure")
 		} else {
 			logger.Noticef("Service %q stopped", s.config.Name)
@@ -531,7 +964,39 @@ func addLastLogs(task *state.Task, logBuffer *servicelog.RingBuffer) {
 		task.Logf("Most recent service output:\n%s", logs)
 	}
 }
+
+// logLastOutput adds the last few lines of a command's raw (unformatted)
+// output to Pebble's log.
+func logLastOutput(serviceName, what string, outputBuffer *servicelog.RingBuffer) {
+	output, err := servicelog.LastLines(outputBuffer, lastLogLines, "    ", false)
+	if err != nil {
+		logger.Noticef("Cannot read %s output for service %q: %v", what, serviceName, err)
+	}
+	if output != "" {
+		logger.Noticef("Most recent %s output for service %q:\n%s", what, serviceName, output)
+	}
+}
+
+// addLastOutput adds the last few lines of a command's raw (unformatted)
+// output to the task's log.
+func addLastOutput(task *state.Task, what string, outputBuffer *servicelog.RingBuffer) {
+	st := task.State()
+	st.Lock()
+	defer st.Unlock()
+
+	output, err := servicelog.LastLines(outputBuffer, lastLogLines, "    ", false)
+	if err != nil {
+		task.Errorf("Cannot read %s output: %v", what, err)
+	}
+	if output != "" {
+		task.Logf("Most recent %s output:\n%s", what, output)
+	}
+}
 func (s *serviceData) doBackoff(action plan.ServiceAction, onType string) {
+	if s.restartLimitReached() {
+		s.giveUp(onType)
+		return
+	}
 	s.backoffNum++
 	s.backoffTime = calculateNextBackoff(s.config, s.backoffTime)
 	logger.Noticef("Service %q %s action is %q, waiting ~%s before restart (backoff %d)",
@@ -541,6 +1006,63 @@ func (s *serviceData) doBackoff(action plan.ServiceAction, onType string) {
 	time.AfterFunc(duration, func() { logError(s.backoffTimeElapsed()) })
 }
 
+// restartLimitReached records a restart of the service and reports whether
+// it has already been restarted restart-limit times within the window (in
+// which case the restart isn't recorded).
+func (s *serviceData) restartLimitReached() bool {
+	if s.config.RestartLimit <= 0 {
+		return false
+	}
+	now := time.Now()
+	cutoff := now.Add(-s.restartLimitWindow())
+	recent := s.restartTimes[:0]
+	for _, t := range s.restartTimes {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	s.restartTimes = recent
+	if len(s.restartTimes) >= s.config.RestartLimit {
+		return true
+	}
+	s.restartTimes = append(s.restartTimes, now)
+	return false
+}
+
+// restartLimitWindow returns the window over which restarts are counted
+// for the restart-limit.
+func (s *serviceData) restartLimitWindow() time.Duration {
+	if s.config.RestartLimitWindow.IsSet {
+		return s.config.RestartLimitWindow.Value
+	}
+	return restartLimitWindowDefault
+}
+
+// giveUp transitions the service to the failed state after it has hit its
+// restart limit, and performs the on-restart-limit action.
+func (s *serviceData) giveUp(onType string) {
+	logger.Noticef("Service %q %s action is %q, but it has restarted %d times within %s; giving up",
+		s.config.Name, onType, plan.ActionRestart, len(s.restartTimes), s.restartLimitWindow())
+	s.transition(stateFailed)
+
+	// The state lock can't be acquired while holding servicesLock, so record
+	// the warning asynchronously.
+	message := fmt.Sprintf(`service %q restarted too many times (%d within %s) and will not be restarted again until it is started with "pebble start"`,
+		s.config.Name, len(s.restartTimes), s.restartLimitWindow())
+	go func() {
+		st := s.manager.state
+		st.Lock()
+		defer st.Unlock()
+		st.Warnf("%s", message)
+	}()
+
+	if s.config.OnRestartLimit == plan.ActionShutdown {
+		logger.Noticef("Service %q on-restart-limit action is %q, triggering server exit",
+			s.config.Name, s.config.OnRestartLimit)
+		s.manager.restarter.HandleRestart(restart.RestartDaemon)
+	}
+}
+
 func calculateNextBackoff(config *plan.Service, current time.Duration) time.Duration {
 	if current == 0 {
 		// First backoff time
@@ -600,7 +1122,7 @@ func (s *serviceData) sendSignal(signal string) error {
 			return err
 		}
 
-	case stateBackoff, stateTerminating, stateKilling, stateStopped, stateExited:
+	case stateBackoff, stateTerminating, stateKilling, stateStopped, stateExited, stateFailed:
 		return fmt.Errorf("service is not running")
 
 	default:
@@ -609,6 +1131,31 @@ func (s *serviceData) sendSignal(signal string) error {
 	return nil
 }
 
+// signalCgroup sends the signal to all the processes in the service's
+// cgroup, including those that have left its process group, if cgroups are
+// in use.
+func (s *serviceData) signalCgroup(sig syscall.Signal) {
+	if s.manager.cgroups == nil {
+		return
+	}
+	err := s.manager.cgroups.Signal(s.config.Name, sig)
+	if err != nil {
+		logger.Noticef("Cannot send %s to processes in cgroup of service %q: %v", unix.SignalName(sig), s.config.Name, err)
+	}
+}
+
+// killCgroup kills all the processes in the service's cgroup, including
+// those that have left its process group, if cgroups are in use.
+func (s *serviceData) killCgroup() {
+	if s.manager.cgroups == nil {
+		return
+	}
+	err := s.manager.cgroups.Kill(s.config.Name)
+	if err != nil {
+		logger.Noticef("Cannot kill processes in cgroup of service %q: %v", s.config.Name, err)
+	}
+}
+
 // killDelay reports the duration that this service should be given when being
 // asked to shutdown gracefully before being force terminated. The value
 // returned will either be the services pre configured value or the default
@@ -620,6 +1167,15 @@ func (s *serviceData) killDelay() time.Duration {
 	return killDelayDefault
 }
 
+// stopSignal returns the signal sent to the service to stop it gracefully:
+// the plan's stop-signal, or SIGTERM if that's not set.
+func (s *serviceData) stopSignal() syscall.Signal {
+	if s.config.StopSignal != "" {
+		return unix.SignalNum(s.config.StopSignal)
+	}
+	return syscall.SIGTERM
+}
+
 // stop is called to stop a running (or backing off) service.
 func (s *serviceData) stop() error {
 	s.manager.servicesLock.Lock()
@@ -627,12 +1183,15 @@ func (s *serviceData) stop() error {
 
 	switch s.state {
 	case stateRunning:
-		logger.Debugf("Attempting to stop service %q by sending SIGTERM", s.config.Name)
-		// First send SIGTERM to try to terminate it gracefully.
-		err := syscall.Kill(-s.cmd.Process.Pid, syscall.SIGTERM)
+		sig := s.stopSignal()
+		logger.Debugf("Attempting to stop service %q by sending %s", s.config.Name, unix.SignalName(sig))
+		// First send the stop signal (SIGTERM by default) to try to terminate
+		// it gracefully.
+		err := syscall.Kill(-s.cmd.Process.Pid, sig)
 		if err != nil {
-			logger.Noticef("Cannot send SIGTERM to process: %v", err)
+			logger.Noticef("Cannot send %s to process: %v", unix.SignalName(sig), err)
 		}
+		s.signalCgroup(sig)
 		s.transition(stateTerminating)
 		time.AfterFunc(s.killDelay(), func() { logError(s.terminateTimeElapsed()) })
 
@@ -653,23 +1212,68 @@ func (s *serviceData) backoffTimeElapsed() error {
 	s.manager.servicesLock.Lock()
 	defer s.manager.servicesLock.Unlock()
 
+	if s.manager.stopped {
+		// Don't restart services once the manager has stopped, as the
+		// reaper is no longer running.
+		return nil
+	}
+
 	switch s.state {
 	case stateBackoff:
-		err := s.startInternal()
-		if err != nil {
-			return err
+		if len(s.config.PreStart) > 0 {
+			// Restart the service once its pre-start commands have run.
+			s.runHooks("pre-start", s.config.PreStart, func(err error) {
+				logError(s.preStartFinished(err))
+			})
+			return nil
 		}
-		s.transition(stateRunning)
+		return s.restart()
 
 	default:
 		// Ignore if timer elapsed in any other state.
 		return nil
 	}
+}
+
+// preStartFinished is called after the pre-start commands run before an
+// automatic restart have finished.
+func (s *serviceData) preStartFinished(err error) error {
+	s.manager.servicesLock.Lock()
+	defer s.manager.servicesLock.Unlock()
+
+	if s.manager.stopped {
+		return nil
+	}
+
+	switch s.state {
+	case stateBackoff:
+		if err != nil {
+			// The service can't be started, so back off and try again (or
+			// give up, if it has hit its restart limit).
+			s.doBackoff(plan.ActionRestart, "pre-start")
+			return nil
+		}
+		return s.restart()
+
+	default:
+		// Ignore if the service was stopped or started in the meantime.
+		return nil
+	}
+}
+
+// restart restarts the service's command after backing off.
+func (s *serviceData) restart() error {
+	err := s.startInternal()
+	if err != nil {
+		return err
+	}
+	s.restarts++
+	s.transition(stateRunning)
 	return nil
 }
 
-// terminateTimeElapsed is called after stop sends SIGTERM and the service
-// still hasn't exited (and we then send SIGTERM).
+// terminateTimeElapsed is called after stop sends the stop signal and the
+// service still hasn't exited (and we then send SIGKILL).
 func (s *serviceData) terminateTimeElapsed() error {
 	s.manager.servicesLock.Lock()
 	defer s.manager.servicesLock.Unlock()
@@ -677,11 +1281,12 @@ func (s *serviceData) terminateTimeElapsed() error {
 	switch s.state {
 	case stateTerminating:
 		logger.Debugf("Attempting to stop service %q again by sending SIGKILL", s.config.Name)
-		// Process hasn't exited after SIGTERM, try SIGKILL.
+		// Process hasn't exited after the stop signal, try SIGKILL.
 		err := syscall.Kill(-s.cmd.Process.Pid, syscall.SIGKILL)
 		if err != nil {
 			logger.Noticef("Cannot send SIGKILL to process: %v", err)
 		}
+		s.killCgroup()
 
 		s.transitionRestarting(stateKilling, s.restarting)
 		time.AfterFunc(failDelay, func() { logError(s.killTimeElapsed()) })
@@ -701,12 +1306,13 @@ func (s *serviceData) killTimeElapsed() error {
 
 	switch s.state {
 	case stateKilling:
+		sigName := unix.SignalName(s.stopSignal())
 		if s.restarting {
-			logger.Noticef("Service %q still running after SIGTERM and SIGKILL", s.config.Name)
+			logger.Noticef("Service %q still running after %s and SIGKILL", s.config.Name, sigName)
 			s.transition(stateStopped)
 		} else {
-			logger.Noticef("Service %q still running after SIGTERM and SIGKILL", s.config.Name)
-			s.stopped <- fmt.Errorf("process still running after SIGTERM and SIGKILL")
+			logger.Noticef("Service %q still running after %s and SIGKILL", s.config.Name, sigName)
+			s.stopped <- fmt.Errorf("process still running after %s and SIGKILL", sigName)
 			s.transition(stateStopped)
 		}
 
@@ -756,9 +1362,10 @@ func (s *serviceData) checkFailed(action plan.ServiceAction) {
 			case stateRunning:
 				logger.Noticef("Service %q %s action is %q, terminating process before restarting",
 					s.config.Name, onType, action)
-				err := syscall.Kill(-s.cmd.Process.Pid, syscall.SIGTERM)
+				sig := s.stopSignal()
+				err := syscall.Kill(-s.cmd.Process.Pid, sig)
 				if err != nil {
-					logger.Noticef("Cannot send SIGTERM to process: %v", err)
+					logger.Noticef("Cannot send %s to process: %v", unix.SignalName(sig), err)
 				}
 				s.transitionRestarting(stateTerminating, true)
 				time.AfterFunc(s.killDelay(), func() { logError(s.terminateTimeElapsed()) })
@@ -4,16 +4,19 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"os"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/canonical/pebble/internal/cgroup"
 	"github.com/canonical/pebble/internal/logger"
 	"github.com/canonical/pebble/internal/overlord/restart"
 	"github.com/canonical/pebble/internal/overlord/state"
 	"github.com/canonical/pebble/internal/plan"
 	"github.com/canonical/pebble/internal/reaper"
+	"github.com/canonical/pebble/internal/secrets"
 	"github.com/canonical/pebble/internal/servicelog"
 )
 
@@ -22,12 +25,19 @@ type ServiceManager struct {
 	runner    *state.TaskRunner
 	pebbleDir string
 
-	planLock     sync.Mutex
-	plan         *plan.Plan
-	planHandlers []PlanFunc
+	planLock      sync.Mutex
+	plan          *plan.Plan
+	planHandlers  []PlanFunc
+	layerHandlers []LayerFunc
 
 	servicesLock sync.Mutex
 	services     map[string]*serviceData
+	stopped      bool
+
+	// Hook commands run outside of a change (see serviceData.runHooks),
+	// which are killed when the manager stops.
+	backgroundHooks sync.WaitGroup
+	hooksDying      chan struct{}
 
 	serviceOutput io.Writer
 	restarter     Restarter
@@ -36,6 +46,21 @@ type ServiceManager struct {
 	rand     *rand.Rand
 
 	logMgr LogManager
+
+	checkStatus CheckStatusFunc
+
+	statusHandlers []ServiceStatusFunc
+	exitHandlers   []ServiceExitedFunc
+
+	// Service processes last recorded in state by saveProcesses.
+	savedProcesses map[string]processInfo
+
+	// Key used to decrypt secret values in services' environment.
+	secretKey *secrets.Key
+
+	// Manager of the services' cgroups, or nil if cgroups aren't enabled
+	// (see EnableCgroups) or cgroup v2 isn't available.
+	cgroups *cgroup.Manager
 }
 
 type LogManager interface {
@@ -45,6 +70,20 @@ type LogManager interface {
 // PlanFunc is the type of function used by NotifyPlanChanged.
 type PlanFunc func(p *plan.Plan)
 
+// LayerFunc is the type of function used by NotifyLayerAdded.
+type LayerFunc func(layer *plan.Layer)
+
+// ServiceStatusFunc is the type of function used by
+// NotifyServiceStatusChanged.
+type ServiceStatusFunc func(name string, status ServiceStatus)
+
+// ServiceExitedFunc is the type of function used by NotifyServiceExited.
+type ServiceExitedFunc func(name string, exitCode int)
+
+// CheckStatusFunc is the type of function used by SetCheckStatus. It returns
+// the names of the given health checks that are not up yet.
+type CheckStatusFunc func(names []string) (notUp []string, err error)
+
 type Restarter interface {
 	HandleRestart(t restart.RestartType)
 }
@@ -69,33 +108,113 @@ func NewManager(s *state.State, runner *state.TaskRunner, pebbleDir string, serv
 		restarter:     restarter,
 		rand:          rand.New(rand.NewSource(time.Now().UnixNano())),
 		logMgr:        logMgr,
+		hooksDying:    make(chan struct{}),
+	}
+
+	secretKey, err := secrets.LoadOrCreateKey(secrets.KeyPath(pebbleDir))
+	if err != nil {
+		return nil, err
 	}
+	manager.secretKey = secretKey
 
-	err := reaper.Start()
+	err = reaper.Start()
 	if err != nil {
 		return nil, err
 	}
 
 	runner.AddHandler("start", manager.doStart, nil)
 	runner.AddHandler("stop", manager.doStop, nil)
+	runner.AddHandler("reload", manager.doReload, nil)
 
 	return manager, nil
 }
 
+// cgroupRootEnv is the environment variable that overrides the cgroup under
+// which services' cgroups are created (by default, Pebble's own cgroup).
+const cgroupRootEnv = "PEBBLE_CGROUP_ROOT"
+
+// EnableCgroups makes the manager run each service started from now on in
+// its own cgroup, if cgroup v2 is available (otherwise services run as
+// before). This moves Pebble itself to a leaf cgroup, so it's only done when
+// asked for, for example with "pebble run --cgroups".
+func (m *ServiceManager) EnableCgroups() {
+	root := os.Getenv(cgroupRootEnv)
+	if root == "" {
+		var err error
+		root, err = cgroup.Detect()
+		if err != nil {
+			logger.Noticef("Cannot use cgroups for services: %v", err)
+			return
+		}
+	}
+	cgroups, err := cgroup.New(root)
+	if err != nil {
+		logger.Noticef("Cannot use cgroups for services: %v", err)
+		return
+	}
+
+	m.servicesLock.Lock()
+	defer m.servicesLock.Unlock()
+	m.cgroups = cgroups
+}
+
 // Stop implements overlord.StateStopper and stops background functions.
 func (m *ServiceManager) Stop() {
+	m.servicesLock.Lock()
+	m.stopped = true
+	m.servicesLock.Unlock()
+
+	// Kill any hook commands still running before the reaper stops.
+	close(m.hooksDying)
+	m.backgroundHooks.Wait()
+
 	err := reaper.Stop()
 	if err != nil {
 		logger.Noticef("Cannot stop child process reaper: %v", err)
 	}
 }
 
+// EncryptSecret encrypts a secret value with the daemon's key. The result can
+// be used as the value of a variable in a service's environment, and is only
+// decrypted when the service's process is started.
+func (m *ServiceManager) EncryptSecret(plaintext string) (string, error) {
+	return secrets.Encrypt(m.secretKey, plaintext)
+}
+
 // NotifyPlanChanged adds f to the list of functions that are called whenever
 // the plan is updated.
 func (m *ServiceManager) NotifyPlanChanged(f PlanFunc) {
 	m.planHandlers = append(m.planHandlers, f)
 }
 
+// NotifyLayerAdded adds f to the list of functions that are called whenever a
+// layer is added to the plan (or combined into an existing layer).
+func (m *ServiceManager) NotifyLayerAdded(f LayerFunc) {
+	m.layerHandlers = append(m.layerHandlers, f)
+}
+
+// NotifyServiceStatusChanged adds f to the list of functions that are called
+// whenever a service's status changes. The functions are called with the
+// manager's internal lock held, so they must not block or call back into the
+// manager.
+func (m *ServiceManager) NotifyServiceStatusChanged(f ServiceStatusFunc) {
+	m.statusHandlers = append(m.statusHandlers, f)
+}
+
+// NotifyServiceExited adds f to the list of functions that are called
+// whenever a running service exits by itself, rather than being stopped,
+// before its on-success or on-failure action is taken. Like status handlers,
+// the functions are called with the manager's internal lock held.
+func (m *ServiceManager) NotifyServiceExited(f ServiceExitedFunc) {
+	m.exitHandlers = append(m.exitHandlers, f)
+}
+
+// SetCheckStatus sets the function used to query the status of health checks
+// when starting services that wait for checks.
+func (m *ServiceManager) SetCheckStatus(f CheckStatusFunc) {
+	m.checkStatus = f
+}
+
 func (m *ServiceManager) updatePlan(p *plan.Plan) {
 	m.plan = p
 	for _, f := range m.planHandlers {
@@ -153,19 +272,28 @@ func (m *ServiceManager) appendLayer(layer *plan.Layer) error {
 		return err
 	}
 	layer.Order = newOrder
+	m.notifyLayerAdded(layer)
 	return nil
 }
 
+func (m *ServiceManager) notifyLayerAdded(layer *plan.Layer) {
+	for _, f := range m.layerHandlers {
+		f(layer)
+	}
+}
+
 func (m *ServiceManager) updatePlanLayers(layers []*plan.Layer) error {
 	combined, err := plan.CombineLayers(layers...)
 	if err != nil {
 		return err
 	}
 	p := &plan.Plan{
-		Layers:     layers,
-		Services:   combined.Services,
-		Checks:     combined.Checks,
-		LogTargets: combined.LogTargets,
+		Layers:        layers,
+		Services:      combined.Services,
+		Checks:        combined.Checks,
+		LogTargets:    combined.LogTargets,
+		NotifyTargets: combined.NotifyTargets,
+		Groups:        combined.Groups,
 	}
 	m.updatePlan(p)
 	return nil
@@ -215,6 +343,7 @@ func (m *ServiceManager) CombineLayer(layer *plan.Layer) error {
 		return err
 	}
 	layer.Order = found.Order
+	m.notifyLayerAdded(layer)
 	return nil
 }
 
@@ -239,6 +368,7 @@ func (m *ServiceManager) acquirePlan() (release func(), err error) {
 
 // Ensure implements StateManager.Ensure.
 func (m *ServiceManager) Ensure() error {
+	m.saveProcesses()
 	return nil
 }
 
@@ -247,6 +377,14 @@ type ServiceInfo struct {
 	Startup      ServiceStartup
 	Current      ServiceStatus
 	CurrentSince time.Time
+
+	// Restarts is the number of times the service has been restarted
+	// automatically (after exiting or a check failure) since the daemon
+	// started.
+	Restarts int
+
+	// Resource usage of an active service, if cgroup v2 is available.
+	Usage *cgroup.Usage
 }
 
 type ServiceStartup string
@@ -262,6 +400,7 @@ const (
 	StatusActive   ServiceStatus = "active"
 	StatusBackoff  ServiceStatus = "backoff"
 	StatusError    ServiceStatus = "error"
+	StatusFailed   ServiceStatus = "failed"
 	StatusInactive ServiceStatus = "inactive"
 )
 
@@ -277,6 +416,7 @@ func (m *ServiceManager) Services(names []string) ([]*ServiceInfo, error) {
 	m.servicesLock.Lock()
 	defer m.servicesLock.Unlock()
 
+	names = m.plan.ExpandServiceNames(names)
 	requested := make(map[string]bool, len(names))
 	for _, name := range names {
 		requested[name] = true
@@ -284,7 +424,7 @@ func (m *ServiceManager) Services(names []string) ([]*ServiceInfo, error) {
 
 	var services []*ServiceInfo
 	matchNames := len(names) > 0
-	for name, config := range m.plan.Services {
+	for name, config := range m.plan.ExpandedServices() {
 		if matchNames && !requested[name] {
 			continue
 		}
@@ -299,6 +439,15 @@ func (m *ServiceManager) Services(names []string) ([]*ServiceInfo, error) {
 		if s, ok := m.services[name]; ok {
 			info.Current = stateToStatus(s.state)
 			info.CurrentSince = s.currentSince
+			info.Restarts = s.restarts
+			if info.Current == StatusActive && m.cgroups != nil {
+				usage, err := m.cgroups.Usage(name)
+				if err != nil {
+					logger.Debugf("Cannot get resource usage of service %q: %v", name, err)
+				} else {
+					info.Usage = usage
+				}
+			}
 		}
 		services = append(services, info)
 	}
@@ -340,6 +489,8 @@ func stateToStatus(state serviceState) ServiceStatus {
 		return StatusInactive
 	case stateBackoff:
 		return StatusBackoff
+	case stateFailed:
+		return StatusFailed
 	default: // stateInitial (should never happen) and stateExited
 		return StatusError
 	}
@@ -355,7 +506,7 @@ func (m *ServiceManager) DefaultServiceNames() ([]string, error) {
 	defer releasePlan()
 
 	var names []string
-	for name, service := range m.plan.Services {
+	for name, service := range m.plan.ExpandedServices() {
 		if service.Startup == plan.StartupEnabled {
 			names = append(names, name)
 		}
@@ -364,6 +515,18 @@ func (m *ServiceManager) DefaultServiceNames() ([]string, error) {
 	return m.plan.StartOrder(names)
 }
 
+// ExpandServiceNames returns the given service names with the names of
+// template services replaced by the names of their instances.
+func (m *ServiceManager) ExpandServiceNames(names []string) ([]string, error) {
+	releasePlan, err := m.acquirePlan()
+	if err != nil {
+		return nil, err
+	}
+	defer releasePlan()
+
+	return m.plan.ExpandServiceNames(names), nil
+}
+
 // StartOrder returns the provided services, together with any required
 // dependencies, in the proper order for starting them all up.
 func (m *ServiceManager) StartOrder(services []string) ([]string, error) {
@@ -398,6 +561,7 @@ func (m *ServiceManager) ServiceLogs(services []string, last int) (map[string]se
 	}
 	defer releasePlan()
 
+	services = m.plan.ExpandServiceNames(services)
 	requested := make(map[string]bool, len(services))
 	for _, name := range services {
 		requested[name] = true
@@ -436,10 +600,11 @@ func (m *ServiceManager) Replan() ([]string, []string, error) {
 	m.servicesLock.Lock()
 	defer m.servicesLock.Unlock()
 
+	services := m.plan.ExpandedServices()
 	needsRestart := make(map[string]bool)
 	var stop []string
 	for name, s := range m.services {
-		if config, ok := m.plan.Services[name]; ok {
+		if config, ok := services[name]; ok {
 			if config.Equal(s.config) {
 				continue
 			}
@@ -450,7 +615,7 @@ func (m *ServiceManager) Replan() ([]string, []string, error) {
 	}
 
 	var start []string
-	for name, config := range m.plan.Services {
+	for name, config := range services {
 		if needsRestart[name] || config.Startup == plan.StartupEnabled {
 			start = append(start, name)
 		}
@@ -475,6 +640,13 @@ func (m *ServiceManager) Replan() ([]string, []string, error) {
 }
 
 func (m *ServiceManager) SendSignal(services []string, signal string) error {
+	releasePlan, err := m.acquirePlan()
+	if err != nil {
+		return err
+	}
+	services = m.plan.ExpandServiceNames(services)
+	releasePlan()
+
 	m.servicesLock.Lock()
 	defer m.servicesLock.Unlock()
 
@@ -523,7 +695,7 @@ func servicesToStop(m *ServiceManager) ([]string, error) {
 
 	// Get all service names in plan.
 	var services []string
-	for name := range m.plan.Services {
+	for name := range m.plan.ExpandedServices() {
 		services = append(services, name)
 	}
 
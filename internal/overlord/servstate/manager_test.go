@@ -37,11 +37,14 @@ import (
 	"gopkg.in/yaml.v3"
 
 	"github.com/canonical/pebble/internal/logger"
+	"github.com/canonical/pebble/internal/osutil"
 	"github.com/canonical/pebble/internal/overlord/checkstate"
 	"github.com/canonical/pebble/internal/overlord/restart"
 	"github.com/canonical/pebble/internal/overlord/servstate"
 	"github.com/canonical/pebble/internal/overlord/state"
 	"github.com/canonical/pebble/internal/plan"
+	"github.com/canonical/pebble/internal/reaper"
+	"github.com/canonical/pebble/internal/sandbox"
 	"github.com/canonical/pebble/internal/servicelog"
 	"github.com/canonical/pebble/internal/testutil"
 )
@@ -53,6 +56,10 @@ const (
 )
 
 func TestMain(m *testing.M) {
+	// Sandboxed commands started by the tests re-run the test binary as the
+	// exec helper.
+	sandbox.RunHelper()
+
 	// See TestReaper
 	if os.Getenv("PEBBLE_TEST_CREATE_ZOMBIE") == "1" {
 		err := createZombie()
@@ -1050,7 +1057,7 @@ services:
 
 func (s *S) TestOnCheckFailureRestartWhileRunning(c *C) {
 	// Create check manager and tell it about plan updates
-	checkMgr := checkstate.NewManager()
+	checkMgr := checkstate.NewManager(s.st, s.runner)
 	defer checkMgr.PlanChanged(&plan.Plan{})
 	s.manager.NotifyPlanChanged(checkMgr.PlanChanged)
 
@@ -1099,7 +1106,7 @@ checks:
 		}
 		checks, err := checkMgr.Checks()
 		c.Assert(err, IsNil)
-		if len(checks) == 1 && checks[0].Status != checkstate.CheckStatusUp {
+		if len(checks) == 1 && checks[0].Status == checkstate.CheckStatusDown {
 			c.Assert(checks[0].Failures, Equals, 1)
 			c.Assert(checks[0].LastError, Matches, ".* executable file not found .*")
 			break
@@ -1135,12 +1142,13 @@ checks:
 	c.Assert(checks[0].LastError, Matches, ".* executable file not found .*")
 	svc := s.serviceByName(c, "test2")
 	c.Assert(svc.Current, Equals, servstate.StatusActive)
+	c.Assert(svc.Restarts, Equals, 1)
 	c.Assert(s.manager.BackoffNum("test2"), Equals, 1)
 }
 
 func (s *S) TestOnCheckFailureRestartDuringBackoff(c *C) {
 	// Create check manager and tell it about plan updates
-	checkMgr := checkstate.NewManager()
+	checkMgr := checkstate.NewManager(s.st, s.runner)
 	defer checkMgr.PlanChanged(&plan.Plan{})
 	s.manager.NotifyPlanChanged(checkMgr.PlanChanged)
 
@@ -1218,7 +1226,7 @@ checks:
 
 func (s *S) TestOnCheckFailureIgnore(c *C) {
 	// Create check manager and tell it about plan updates
-	checkMgr := checkstate.NewManager()
+	checkMgr := checkstate.NewManager(s.st, s.runner)
 	defer checkMgr.PlanChanged(&plan.Plan{})
 	s.manager.NotifyPlanChanged(checkMgr.PlanChanged)
 
@@ -1266,7 +1274,7 @@ checks:
 		}
 		checks, err := checkMgr.Checks()
 		c.Assert(err, IsNil)
-		if len(checks) == 1 && checks[0].Status != checkstate.CheckStatusUp {
+		if len(checks) == 1 && checks[0].Status == checkstate.CheckStatusDown {
 			c.Assert(checks[0].Failures, Equals, 1)
 			c.Assert(checks[0].LastError, Matches, ".* executable file not found .*")
 			break
@@ -1290,7 +1298,7 @@ checks:
 
 func (s *S) TestOnCheckFailureShutdown(c *C) {
 	// Create check manager and tell it about plan updates
-	checkMgr := checkstate.NewManager()
+	checkMgr := checkstate.NewManager(s.st, s.runner)
 	defer checkMgr.PlanChanged(&plan.Plan{})
 	s.manager.NotifyPlanChanged(checkMgr.PlanChanged)
 
@@ -1311,6 +1319,7 @@ checks:
     chk1:
          override: replace
          period: 75ms  # a bit longer than shortOkayDelay
+         startup-delay: 75ms  # don't fail before the service has started
          threshold: 1
          exec:
              command: will-fail
@@ -1338,7 +1347,7 @@ checks:
 		}
 		checks, err := checkMgr.Checks()
 		c.Assert(err, IsNil)
-		if len(checks) == 1 && checks[0].Status != checkstate.CheckStatusUp {
+		if len(checks) == 1 && checks[0].Status == checkstate.CheckStatusDown {
 			c.Assert(checks[0].Failures, Equals, 1)
 			c.Assert(checks[0].LastError, Matches, ".* executable file not found .*")
 			break
@@ -1354,213 +1363,1206 @@ checks:
 	}
 }
 
-func (s *S) waitUntilService(c *C, service string, f func(svc *servstate.ServiceInfo) bool) {
-	for i := 0; i < 310; i++ {
-		svc := s.serviceByName(c, service)
-		if f(svc) {
-			return
-		}
-		time.Sleep(10 * time.Millisecond)
-	}
-	c.Fatalf("timed out waiting for service")
-}
+func (s *S) TestWaitForChecks(c *C) {
+	// Create check manager and tell it about plan updates
+	checkMgr := checkstate.NewManager(s.st, s.runner)
+	defer checkMgr.PlanChanged(&plan.Plan{})
+	s.manager.NotifyPlanChanged(checkMgr.PlanChanged)
 
-func (s *S) TestActionShutdown(c *C) {
-	layer := parseLayer(c, 0, "layer", `
+	// Let service manager query check status
+	s.manager.SetCheckStatus(checkMgr.NotUp)
+
+	tempDir := c.MkDir()
+	tempFile := filepath.Join(tempDir, "out")
+	layer := parseLayer(c, 0, "layer", fmt.Sprintf(`
 services:
     test2:
         override: replace
-        command: sleep 0.15
-        on-success: shutdown
-`)
+        command: /bin/sh -c 'echo x >>%s; sleep 10'
+        wait-for-checks:
+            - chk1
+
+checks:
+    chk1:
+         override: replace
+         period: 75ms
+         exec:
+             command: /bin/sh -c '[ -f %s ]'
+`, tempFile, tempFile))
 	err := s.manager.AppendLayer(layer)
 	c.Assert(err, IsNil)
 
-	// Start service and wait till it starts up the first time.
-	s.startServices(c, []string{"test2"}, 1)
-	s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
-		return svc.Current == servstate.StatusActive
-	})
-
-	// Wait till it terminates.
-	s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
-		return svc.Current == servstate.StatusError
-	})
+	// Start service; it shouldn't be started while the check is failing.
+	s.st.Lock()
+	ts, err := servstate.Start(s.st, []string{"test2"})
+	c.Check(err, IsNil)
+	chg := s.st.NewChange("test", "Start test")
+	chg.AddAll(ts)
+	s.st.Unlock()
+	s.runner.Ensure()
+	time.Sleep(200 * time.Millisecond)
+	svc := s.serviceByName(c, "test2")
+	c.Assert(svc.Current, Equals, servstate.StatusInactive)
 
-	// It should have closed the stopDaemon channel.
-	select {
-	case <-s.stopDaemon:
-	case <-time.After(time.Second):
-		c.Fatalf("timed out waiting for stop-daemon channel")
-	}
+	// Once the check succeeds, the service is started.
+	err = ioutil.WriteFile(tempFile, nil, 0644)
+	c.Assert(err, IsNil)
+	s.runner.Wait()
+	s.st.Lock()
+	c.Check(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	c.Check(chg.Tasks()[0].Log()[0], Matches, `.* Waiting up to 1m0s for checks to be up: chk1`)
+	s.st.Unlock()
+	svc = s.serviceByName(c, "test2")
+	c.Assert(svc.Current, Equals, servstate.StatusActive)
 }
 
-func (s *S) TestActionIgnore(c *C) {
+func (s *S) TestWaitForChecksTimeout(c *C) {
+	// Create check manager and tell it about plan updates
+	checkMgr := checkstate.NewManager(s.st, s.runner)
+	defer checkMgr.PlanChanged(&plan.Plan{})
+	s.manager.NotifyPlanChanged(checkMgr.PlanChanged)
+
+	// Let service manager query check status
+	s.manager.SetCheckStatus(checkMgr.NotUp)
+
 	layer := parseLayer(c, 0, "layer", `
 services:
     test2:
         override: replace
-        command: sleep 0.15
-        on-success: ignore
+        command: sleep 10
+        wait-for-checks:
+            - chk1
+        wait-for-checks-timeout: 200ms
+
+checks:
+    chk1:
+         override: replace
+         period: 75ms
+         exec:
+             command: will-fail
 `)
 	err := s.manager.AppendLayer(layer)
 	c.Assert(err, IsNil)
 
-	// Start service and wait till it starts up the first time.
-	s.startServices(c, []string{"test2"}, 1)
-	s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
-		return svc.Current == servstate.StatusActive
-	})
-
-	// Wait till it terminates.
-	s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
-		return svc.Current == servstate.StatusInactive
-	})
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Check(chg.Status(), Equals, state.ErrorStatus)
+	c.Check(chg.Err(), ErrorMatches, `(?s).*\(timed out after 200ms waiting for checks to be up: chk1\)`)
+	s.st.Unlock()
+	svc := s.serviceByName(c, "test2")
+	c.Assert(svc.Current, Equals, servstate.StatusInactive)
 }
 
-func (s *S) TestGetAction(c *C) {
-	tests := []struct {
-		onSuccess plan.ServiceAction
-		onFailure plan.ServiceAction
-		success   bool
-		action    string
-		onType    string
-	}{
-		{onSuccess: "", onFailure: "", success: false, action: "restart", onType: "on-failure"},
-		{onSuccess: "", onFailure: "", success: true, action: "restart", onType: "on-success"},
-		{onSuccess: "", onFailure: "restart", success: false, action: "restart", onType: "on-failure"},
-		{onSuccess: "", onFailure: "restart", success: true, action: "restart", onType: "on-success"},
-		{onSuccess: "", onFailure: "shutdown", success: false, action: "shutdown", onType: "on-failure"},
-		{onSuccess: "", onFailure: "shutdown", success: true, action: "restart", onType: "on-success"},
-		{onSuccess: "", onFailure: "ignore", success: false, action: "ignore", onType: "on-failure"},
-		{onSuccess: "", onFailure: "ignore", success: true, action: "restart", onType: "on-success"},
-		{onSuccess: "restart", onFailure: "", success: false, action: "restart", onType: "on-failure"},
-		{onSuccess: "restart", onFailure: "", success: true, action: "restart", onType: "on-success"},
-		{onSuccess: "restart", onFailure: "restart", success: false, action: "restart", onType: "on-failure"},
-		{onSuccess: "restart", onFailure: "restart", success: true, action: "restart", onType: "on-success"},
-		{onSuccess: "restart", onFailure: "shutdown", success: false, action: "shutdown", onType: "on-failure"},
-		{onSuccess: "restart", onFailure: "shutdown", success: true, action: "restart", onType: "on-success"},
-		{onSuccess: "restart", onFailure: "ignore", success: false, action: "ignore", onType: "on-failure"},
-		{onSuccess: "restart", onFailure: "ignore", success: true, action: "restart", onType: "on-success"},
-		{onSuccess: "shutdown", onFailure: "", success: false, action: "restart", onType: "on-failure"},
-		{onSuccess: "shutdown", onFailure: "", success: true, action: "shutdown", onType: "on-success"},
-		{onSuccess: "shutdown", onFailure: "restart", success: false, action: "restart", onType: "on-failure"},
-		{onSuccess: "shutdown", onFailure: "restart", success: true, action: "shutdown", onType: "on-success"},
-		{onSuccess: "shutdown", onFailure: "shutdown", success: false, action: "shutdown", onType: "on-failure"},
-		{onSuccess: "shutdown", onFailure: "shutdown", success: true, action: "shutdown", onType: "on-success"},
-		{onSuccess: "shutdown", onFailure: "ignore", success: false, action: "ignore", onType: "on-failure"},
-		{onSuccess: "shutdown", onFailure: "ignore", success: true, action: "shutdown", onType: "on-success"},
-		{onSuccess: "ignore", onFailure: "", success: false, action: "restart", onType: "on-failure"},
-		{onSuccess: "ignore", onFailure: "", success: true, action: "ignore", onType: "on-success"},
-		{onSuccess: "ignore", onFailure: "restart", success: false, action: "restart", onType: "on-failure"},
-		{onSuccess: "ignore", onFailure: "restart", success: true, action: "ignore", onType: "on-success"},
-		{onSuccess: "ignore", onFailure: "shutdown", success: false, action: "shutdown", onType: "on-failure"},
-		{onSuccess: "ignore", onFailure: "shutdown", success: true, action: "ignore", onType: "on-success"},
-		{onSuccess: "ignore", onFailure: "ignore", success: false, action: "ignore", onType: "on-failure"},
-		{onSuccess: "ignore", onFailure: "ignore", success: true, action: "ignore", onType: "on-success"},
-	}
-	for _, test := range tests {
-		config := &plan.Service{
-			OnFailure: test.onFailure,
-			OnSuccess: test.onSuccess,
-		}
-		action, onType := servstate.GetAction(config, test.success)
-		c.Check(string(action), Equals, test.action, Commentf("onSuccess=%q, onFailure=%q, success=%v",
-			test.onSuccess, test.onFailure, test.success))
-		c.Check(onType, Equals, test.onType, Commentf("onSuccess=%q, onFailure=%q, success=%v",
-			test.onSuccess, test.onFailure, test.success))
-	}
+func (s *S) reloadServices(c *C, services []string, nEnsure int) *state.Change {
+	s.st.Lock()
+	ts, err := servstate.Reload(s.st, services)
+	c.Check(err, IsNil)
+	chg := s.st.NewChange("test", "Reload test")
+	chg.AddAll(ts)
+	s.st.Unlock()
+
+	s.ensure(c, nEnsure)
+
+	return chg
 }
 
-func (s *S) TestGetJitter(c *C) {
-	// It's tricky to test a function that generates randomness, but ensure all
-	// the values are in range, and that the number of values distributed across
-	// each of 3 buckets is reasonable.
-	var buckets [3]int
-	for i := 0; i < 3000; i++ {
-		jitter := s.manager.GetJitter(3 * time.Second)
-		c.Assert(jitter >= 0 && jitter < 300*time.Millisecond, Equals, true)
-		switch {
-		case jitter >= 0 && jitter < 100*time.Millisecond:
-			buckets[0]++
-		case jitter >= 100*time.Millisecond && jitter < 200*time.Millisecond:
-			buckets[1]++
-		case jitter >= 200*time.Millisecond && jitter < 300*time.Millisecond:
-			buckets[2]++
-		default:
-			c.Errorf("jitter %s outside range [0, 300ms)", jitter)
+func waitForFileContent(c *C, path, content string) {
+	for i := 0; ; i++ {
+		if i >= 100 {
+			b, _ := ioutil.ReadFile(path)
+			c.Fatalf("timed out waiting for %q to contain %q (got %q)", path, content, b)
 		}
-	}
-	for i := 0; i < 3; i++ {
-		if buckets[i] < 800 || buckets[i] > 1200 { // exceedingly unlikely to be outside this range
-			c.Errorf("bucket[%d] has too few or too many values in it (%d)", i, buckets[i])
+		b, _ := ioutil.ReadFile(path)
+		if string(b) == content {
+			break
 		}
+		time.Sleep(10 * time.Millisecond)
 	}
 }
 
-func (s *S) TestCalculateNextBackoff(c *C) {
-	tests := []struct {
-		delay   time.Duration
-		factor  float64
-		limit   time.Duration
-		current time.Duration
-		next    time.Duration
-	}{
-		{delay: 500 * time.Millisecond, factor: 2, limit: 30 * time.Second, current: 0, next: 500 * time.Millisecond},
-		{delay: 500 * time.Millisecond, factor: 2, limit: 30 * time.Second, current: 500 * time.Millisecond, next: time.Second},
-		{delay: 500 * time.Millisecond, factor: 2, limit: 30 * time.Second, current: time.Second, next: 2 * time.Second},
-		{delay: 500 * time.Millisecond, factor: 2, limit: 30 * time.Second, current: 16 * time.Second, next: 30 * time.Second},
-		{delay: 500 * time.Millisecond, factor: 2, limit: 30 * time.Second, current: 30 * time.Second, next: 30 * time.Second},
-		{delay: 500 * time.Millisecond, factor: 2, limit: 30 * time.Second, current: 1000 * time.Second, next: 30 * time.Second},
+func (s *S) TestStopSignal(c *C) {
+	tempFile := filepath.Join(c.MkDir(), "out")
+	layer := parseLayer(c, 0, "layer", fmt.Sprintf(`
+services:
+    test2:
+        override: replace
+        command: /bin/sh -c 'trap "echo usr2 >>%s; exit 0" USR2; trap "" TERM; echo x >>%s; sleep 10 & wait'
+        stop-signal: SIGUSR2
+        kill-delay: 5s
+`, tempFile, tempFile))
+	err := s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
 
-		{delay: time.Second, factor: 1.5, limit: 60 * time.Second, current: 0, next: time.Second},
-		{delay: time.Second, factor: 1.5, limit: 60 * time.Second, current: time.Second, next: 1500 * time.Millisecond},
-		{delay: time.Second, factor: 1.5, limit: 60 * time.Second, current: 1500 * time.Millisecond, next: 2250 * time.Millisecond},
-		{delay: time.Second, factor: 1.5, limit: 60 * time.Second, current: 50 * time.Second, next: 60 * time.Second},
-		{delay: time.Second, factor: 1.5, limit: 60 * time.Second, current: 60 * time.Second, next: 60 * time.Second},
-		{delay: time.Second, factor: 1.5, limit: 60 * time.Second, current: 70 * time.Second, next: 60 * time.Second},
-	}
-	for _, test := range tests {
-		config := &plan.Service{
-			BackoffDelay:  plan.OptionalDuration{Value: test.delay},
-			BackoffFactor: plan.OptionalFloat{Value: test.factor},
-			BackoffLimit:  plan.OptionalDuration{Value: test.limit},
-		}
-		next := servstate.CalculateNextBackoff(config, test.current)
-		c.Check(next, Equals, test.next, Commentf("delay=%s, factor=%g, limit=%s, current=%s",
-			test.delay, test.factor, test.limit, test.current))
-	}
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	waitForFileContent(c, tempFile, "x\n")
+
+	// Service ignores SIGTERM, so if it stops within kill-delay it must have
+	// been sent the stop-signal.
+	start := time.Now()
+	chg = s.stopServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	c.Assert(time.Since(start) < 5*time.Second, Equals, true)
+	waitForFileContent(c, tempFile, "x\nusr2\n")
+	svc := s.serviceByName(c, "test2")
+	c.Assert(svc.Current, Equals, servstate.StatusInactive)
 }
 
-func (s *S) TestReapZombies(c *C) {
-	// Ensure we've been set as a child subreaper
-	isSubreaper, err := getChildSubreaper()
+func (s *S) TestReloadSignal(c *C) {
+	tempFile := filepath.Join(c.MkDir(), "out")
+	layer := parseLayer(c, 0, "layer", fmt.Sprintf(`
+services:
+    test2:
+        override: replace
+        command: /bin/sh -c 'trap "echo usr1 >>%s" USR1; echo x >>%s; while true; do sleep 10 & wait; done'
+        reload-signal: SIGUSR1
+`, tempFile, tempFile))
+	err := s.manager.AppendLayer(layer)
 	c.Assert(err, IsNil)
-	c.Assert(isSubreaper, Equals, true)
 
-	// Start a service which runs this test executable with an environment
-	// variable set so the "service" knows to create a zombie child.
-	testExecutable, err := os.Executable()
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	waitForFileContent(c, tempFile, "x\n")
+
+	chg = s.reloadServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	waitForFileContent(c, tempFile, "x\nusr1\n")
+
+	// Service is still running after the reload.
+	svc := s.serviceByName(c, "test2")
+	c.Assert(svc.Current, Equals, servstate.StatusActive)
+}
+
+func (s *S) TestReloadSignalProcessGroup(c *C) {
+	dir := c.MkDir()
+	parentFile := filepath.Join(dir, "parent")
+	childFile := filepath.Join(dir, "child")
+	childScript := filepath.Join(dir, "child.sh")
+	err := ioutil.WriteFile(childScript, []byte(fmt.Sprintf(`
+trap "echo usr1 >>%s" USR1
+echo x >>%s
+while true; do sleep 10 & wait; done
+`, childFile, childFile)), 0644)
 	c.Assert(err, IsNil)
 	layer := parseLayer(c, 0, "layer", fmt.Sprintf(`
 services:
     test2:
         override: replace
-        command: %s
-        environment:
-            PEBBLE_TEST_CREATE_ZOMBIE: 1
-        on-success: ignore
-`, testExecutable))
+        command: /bin/sh -c 'trap "echo usr1 >>%s" USR1; /bin/sh %s & while true; do sleep 10 & wait; done'
+        reload-signal: SIGUSR1
+`, parentFile, childScript))
 	err = s.manager.AppendLayer(layer)
 	c.Assert(err, IsNil)
 
-	s.startServices(c, []string{"test2"}, 1)
-	s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
-		return svc.Current == servstate.StatusActive
-	})
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	waitForFileContent(c, childFile, "x\n")
 
-	// Get the PID of the zombie child (the createZombie process printed it out)
-	childPidRe := regexp.MustCompile(`.* childPid (\d+)`)
+	chg = s.reloadServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+
+	// Like the stop signal, the reload signal goes to the whole process group.
+	waitForFileContent(c, parentFile, "usr1\n")
+	waitForFileContent(c, childFile, "x\nusr1\n")
+}
+
+func (s *S) TestReloadCommand(c *C) {
+	tempFile := filepath.Join(c.MkDir(), "out")
+	layer := parseLayer(c, 0, "layer", fmt.Sprintf(`
+services:
+    test2:
+        override: replace
+        command: sleep 10
+        reload-command: /bin/sh -c 'echo $FOO >>%s'
+        environment:
+            FOO: reloaded
+`, tempFile))
+	err := s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+
+	chg = s.reloadServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	waitForFileContent(c, tempFile, "reloaded\n")
+	svc := s.serviceByName(c, "test2")
+	c.Assert(svc.Current, Equals, servstate.StatusActive)
+}
+
+func (s *S) TestReloadCommandFails(c *C) {
+	layer := parseLayer(c, 0, "layer", `
+services:
+    test2:
+        override: replace
+        command: sleep 10
+        reload-command: /bin/sh -c 'echo bad config; exit 3'
+`)
+	err := s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+
+	chg = s.reloadServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Check(chg.Status(), Equals, state.ErrorStatus)
+	c.Check(chg.Err(), ErrorMatches, `(?s).*\(cannot reload service: reload command exited with code 3\)`)
+	c.Check(chg.Tasks()[0].Log(), HasLen, 2)
+	c.Check(chg.Tasks()[0].Log()[0], Matches, `(?s).* INFO Most recent reload command output:\n    bad config`)
+	s.st.Unlock()
+
+	// A failed reload leaves the service running.
+	svc := s.serviceByName(c, "test2")
+	c.Assert(svc.Current, Equals, servstate.StatusActive)
+}
+
+func (s *S) TestReloadNotRunning(c *C) {
+	chg := s.reloadServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Check(chg.Status(), Equals, state.ErrorStatus)
+	c.Check(chg.Err(), ErrorMatches, `(?s).*\(cannot reload service "test2": service is not running\)`)
+	s.st.Unlock()
+}
+
+func (s *S) TestHooks(c *C) {
+	tempFile := filepath.Join(c.MkDir(), "out")
+	layer := parseLayer(c, 0, "layer", fmt.Sprintf(`
+services:
+    test2:
+        override: replace
+        command: /bin/sh -c 'echo running >>%[1]s; sleep 10'
+        pre-start:
+            - /bin/sh -c 'echo pre-start 1 $FOO >>%[1]s'
+            - /bin/sh -c 'echo pre-start 2 >>%[1]s'
+        post-start:
+            - /bin/sh -c 'echo post-start >>%[1]s'
+        post-stop:
+            - /bin/sh -c 'echo post-stop >>%[1]s'
+        environment:
+            FOO: bar
+`, tempFile))
+	err := s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	waitForFileContent(c, tempFile, "pre-start 1 bar\npre-start 2\nrunning\npost-start\n")
+
+	chg = s.stopServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	waitForFileContent(c, tempFile, "pre-start 1 bar\npre-start 2\nrunning\npost-start\npost-stop\n")
+}
+
+func (s *S) TestPreStartFails(c *C) {
+	tempFile := filepath.Join(c.MkDir(), "out")
+	layer := parseLayer(c, 0, "layer", fmt.Sprintf(`
+services:
+    test2:
+        override: replace
+        command: /bin/sh -c 'echo running >>%s; sleep 10'
+        pre-start:
+            - /bin/sh -c 'echo migration failed; exit 2'
+`, tempFile))
+	err := s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Check(chg.Status(), Equals, state.ErrorStatus)
+	c.Check(chg.Err(), ErrorMatches, `(?s).*\(cannot start service: pre-start command exited with code 2\)`)
+	c.Check(chg.Tasks()[0].Log(), HasLen, 2)
+	c.Check(chg.Tasks()[0].Log()[0], Matches, `(?s).* INFO Most recent pre-start command output:\n    migration failed`)
+	s.st.Unlock()
+
+	// The service was never started, and isn't backing off or restarted.
+	svc := s.serviceByName(c, "test2")
+	c.Assert(svc.Current, Equals, servstate.StatusInactive)
+	c.Assert(tempFile, testutil.FileAbsent)
+}
+
+func (s *S) TestHooksOnRestart(c *C) {
+	tempFile := filepath.Join(c.MkDir(), "out")
+	layer := parseLayer(c, 0, "layer", fmt.Sprintf(`
+services:
+    test2:
+        override: replace
+        command: /bin/sh -c 'echo running >>%[1]s; exec sleep 10'
+        backoff-delay: 50ms
+        pre-start:
+            - /bin/sh -c 'echo pre-start >>%[1]s'
+        post-stop:
+            - /bin/sh -c 'echo post-stop >>%[1]s'
+`, tempFile))
+	err := s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	waitForFileContent(c, tempFile, "pre-start\nrunning\n")
+
+	// When the service exits by itself, its post-stop commands are run, and
+	// its pre-start commands are run again before it's restarted.
+	err = s.manager.SendSignal([]string{"test2"}, "SIGTERM")
+	c.Assert(err, IsNil)
+	waitForFileContent(c, tempFile, "pre-start\nrunning\npost-stop\npre-start\nrunning\n")
+	s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
+		return svc.Current == servstate.StatusActive
+	})
+	c.Check(s.manager.BackoffNum("test2"), Equals, 1)
+
+	chg = s.stopServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	waitForFileContent(c, tempFile, "pre-start\nrunning\npost-stop\npre-start\nrunning\npost-stop\n")
+}
+
+func (s *S) TestPostStopOnExit(c *C) {
+	tempFile := filepath.Join(c.MkDir(), "out")
+	layer := parseLayer(c, 0, "layer", fmt.Sprintf(`
+services:
+    test2:
+        override: replace
+        command: /bin/sh -c 'echo running >>%[1]s; exec sleep 10'
+        on-failure: ignore
+        post-stop:
+            - /bin/sh -c 'echo post-stop >>%[1]s'
+`, tempFile))
+	err := s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	waitForFileContent(c, tempFile, "running\n")
+
+	err = s.manager.SendSignal([]string{"test2"}, "SIGTERM")
+	c.Assert(err, IsNil)
+	waitForFileContent(c, tempFile, "running\npost-stop\n")
+	s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
+		return svc.Current == servstate.StatusError
+	})
+}
+
+func (s *S) TestPreStartFailsOnRestart(c *C) {
+	dir := c.MkDir()
+	tempFile := filepath.Join(dir, "out")
+	markerFile := filepath.Join(dir, "marker")
+	layer := parseLayer(c, 0, "layer", fmt.Sprintf(`
+services:
+    test2:
+        override: replace
+        command: /bin/sh -c 'echo running >>%[1]s; exec sleep 10'
+        backoff-delay: 50ms
+        backoff-factor: 1
+        pre-start:
+            - /bin/sh -c 'echo pre-start >>%[1]s; [ ! -e %[2]s ] && touch %[2]s'
+`, tempFile, markerFile))
+	err := s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	waitForFileContent(c, tempFile, "pre-start\nrunning\n")
+
+	// The failing pre-start commands stop the service being restarted, and
+	// it keeps backing off.
+	err = s.manager.SendSignal([]string{"test2"}, "SIGTERM")
+	c.Assert(err, IsNil)
+	s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
+		return svc.Current == servstate.StatusBackoff && s.manager.BackoffNum("test2") >= 3
+	})
+	content, err := ioutil.ReadFile(tempFile)
+	c.Assert(err, IsNil)
+	c.Check(strings.HasPrefix(string(content), "pre-start\nrunning\npre-start\npre-start\n"), Equals, true)
+	c.Check(strings.Count(string(content), "running"), Equals, 1)
+}
+
+func (s *S) TestEnvironmentFilesAndExpansion(c *C) {
+	dir := c.MkDir()
+	tempFile := filepath.Join(dir, "out")
+	envFile := filepath.Join(dir, "env")
+	err := ioutil.WriteFile(envFile, []byte("PASSWORD='pa$$word'\nPORT=8080\nNAME=from-file\n"), 0600)
+	c.Assert(err, IsNil)
+	os.Setenv("PEBBLE_TEST_HOST", "example.com")
+	defer os.Unsetenv("PEBBLE_TEST_HOST")
+
+	layer := parseLayer(c, 0, "layer", fmt.Sprintf(`
+services:
+    test2:
+        override: replace
+        command: /bin/sh -c 'echo "$1 $2 $3 $4" >>%s; for i in a b; do echo $i ${PEBBLE_TEST_UNSET:-dflt} >>%[1]s; done; sleep 10' sh $URL ${PASSWORD} $NAME $PEBBLE_TEST_UNSET
+        environment-files:
+            - %s
+        environment:
+            URL: http://${PEBBLE_TEST_HOST}:$PORT/
+            NAME: from-layer
+`, tempFile, envFile))
+	err = s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	// Undefined variables and shell syntax are left for the shell.
+	waitForFileContent(c, tempFile, "http://example.com:8080/ pa$$word from-layer $PEBBLE_TEST_UNSET\na dflt\nb dflt\n")
+
+	// The plan still has the unexpanded values.
+	config := s.manager.Config("test2")
+	c.Check(config.Command, Matches, `.* sh \$URL \$\{PASSWORD\} .*`)
+	c.Check(config.Environment["URL"], Equals, "http://${PEBBLE_TEST_HOST}:$PORT/")
+}
+
+func (s *S) TestSandbox(c *C) {
+	tempFile := filepath.Join(c.MkDir(), "out")
+	layer := parseLayer(c, 0, "layer", fmt.Sprintf(`
+services:
+    test2:
+        override: replace
+        command: /bin/sh -c 'grep NoNewPrivs /proc/self/status >>%s; sleep 10'
+        no-new-privileges: true
+`, tempFile))
+	err := s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	waitForFileContent(c, tempFile, "NoNewPrivs:\t1\n")
+}
+
+func (s *S) TestCgroups(c *C) {
+	cgroupRoot := c.MkDir()
+	os.Setenv("PEBBLE_CGROUP_ROOT", cgroupRoot)
+	defer os.Unsetenv("PEBBLE_CGROUP_ROOT")
+	s.runner = state.NewTaskRunner(s.st)
+	manager, err := servstate.NewManager(s.st, s.runner, s.dir, nil, testRestarter{s.stopDaemon}, fakeLogManager{})
+	c.Assert(err, IsNil)
+	s.AddCleanup(manager.Stop)
+	s.manager = manager
+
+	// Cgroups are only used once enabled.
+	c.Assert(filepath.Join(cgroupRoot, "services"), testutil.FileAbsent)
+	s.manager.EnableCgroups()
+
+	// The post-start command runs outside the service's cgroup (if it ran in
+	// it, it would be in cgroup.procs instead of the service).
+	layer := parseLayer(c, 0, "layer", `
+services:
+    test2:
+        override: replace
+        command: sleep 10
+        memory-max: 64M
+        cpu-max: 50%
+        pids-max: 10
+        post-start:
+            - /bin/true
+`)
+	err = s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+
+	// The service runs in its own cgroup, with the limits applied.
+	dir := filepath.Join(cgroupRoot, "services", "test2")
+	pid := s.manager.RunningCmds()["test2"].Process.Pid
+	c.Check(filepath.Join(dir, "cgroup.procs"), testutil.FileEquals, strconv.Itoa(pid))
+	c.Check(filepath.Join(dir, "memory.max"), testutil.FileEquals, "67108864")
+	c.Check(filepath.Join(dir, "cpu.max"), testutil.FileEquals, "50000 100000")
+	c.Check(filepath.Join(dir, "cpu.weight"), testutil.FileEquals, "100")
+	c.Check(filepath.Join(dir, "pids.max"), testutil.FileEquals, "10")
+
+	// Its resource usage is reported.
+	err = ioutil.WriteFile(filepath.Join(dir, "memory.current"), []byte("4096\n"), 0644)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(filepath.Join(dir, "cpu.stat"), []byte("usage_usec 2000\n"), 0644)
+	c.Assert(err, IsNil)
+	usage := s.serviceByName(c, "test2").Usage
+	c.Assert(usage, NotNil)
+	c.Check(usage.MemoryBytes, Equals, uint64(4096))
+	c.Check(usage.CPUTime, Equals, 2*time.Millisecond)
+
+	// Stopping the service sends the stop signal to all the processes in its
+	// cgroup (even those outside its process group), then kills them.
+	other := exec.Command("sleep", "10")
+	c.Assert(reaper.StartCommand(other), IsNil)
+	otherExited := make(chan struct{})
+	go func() {
+		reaper.WaitCommand(other)
+		close(otherExited)
+	}()
+	procs := fmt.Sprintf("%d\n%d\n", pid, other.Process.Pid)
+	err = ioutil.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(procs), 0644)
+	c.Assert(err, IsNil)
+	chg = s.stopServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	select {
+	case <-otherExited:
+	case <-time.After(5 * time.Second):
+		c.Fatalf("timed out waiting for process in service's cgroup to be stopped")
+	}
+	c.Check(filepath.Join(dir, "cgroup.kill"), testutil.FileEquals, "1")
+	c.Check(s.serviceByName(c, "test2").Usage, IsNil)
+}
+
+func (s *S) TestServiceStats(c *C) {
+	layer := parseLayer(c, 0, "layer", `
+services:
+    test2:
+        override: replace
+        command: /bin/sh -c 'sleep 10 & sleep 10; wait'
+`)
+	err := s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	// Services that aren't running have no stats.
+	stats, err := s.manager.ServiceStats([]string{"test1", "test2"})
+	c.Assert(err, IsNil)
+	c.Assert(stats, HasLen, 0)
+
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+
+	// The figures are summed over the shell and its two children (which may
+	// take a moment to be started).
+	var st *servstate.ProcessStats
+	for i := 0; i < 100; i++ {
+		stats, err = s.manager.ServiceStats([]string{"test1", "test2"})
+		c.Assert(err, IsNil)
+		c.Assert(stats, HasLen, 1)
+		st = stats["test2"]
+		c.Assert(st, NotNil)
+		if st.Threads == 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Check(st.Threads, Equals, 3)
+	c.Check(st.RSS > 0, Equals, true)
+	c.Check(st.FDs >= 3, Equals, true)
+}
+
+func (s *S) TestNotifyServiceStatusChanged(c *C) {
+	var mutex sync.Mutex
+	var statuses []string
+	s.manager.NotifyServiceStatusChanged(func(name string, status servstate.ServiceStatus) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		statuses = append(statuses, name+" "+string(status))
+	})
+
+	s.startServices(c, []string{"test2"}, 1)
+	s.stopServices(c, []string{"test2"}, 1)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	c.Assert(statuses, DeepEquals, []string{"test2 active", "test2 inactive"})
+}
+
+func (s *S) TestNotifyLayerAdded(c *C) {
+	var labels []string
+	s.manager.NotifyLayerAdded(func(layer *plan.Layer) {
+		labels = append(labels, layer.Label)
+	})
+
+	err := s.manager.AppendLayer(parseLayer(c, 0, "layer1", "summary: one"))
+	c.Assert(err, IsNil)
+	err = s.manager.CombineLayer(parseLayer(c, 0, "layer1", "summary: two"))
+	c.Assert(err, IsNil)
+	err = s.manager.AppendLayer(parseLayer(c, 0, "layer1", "summary: three"))
+	c.Assert(err, NotNil)
+	c.Assert(labels, DeepEquals, []string{"layer1", "layer1"})
+}
+
+func (s *S) TestEnvironmentFileMissing(c *C) {
+	layer := parseLayer(c, 0, "layer", `
+services:
+    test2:
+        override: replace
+        command: sleep 10
+        environment-files:
+            - /nonexistent/env
+`)
+	err := s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Check(chg.Status(), Equals, state.ErrorStatus)
+	c.Check(chg.Err(), ErrorMatches, `(?s).*cannot read environment file: open /nonexistent/env: no such file or directory.*`)
+	s.st.Unlock()
+}
+
+func (s *S) TestEnvironmentSecrets(c *C) {
+	tempFile := filepath.Join(c.MkDir(), "out")
+	encrypted, err := s.manager.EncryptSecret("pa$$word")
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(encrypted, "pa$$word"), Equals, false)
+
+	layer := parseLayer(c, 0, "layer", fmt.Sprintf(`
+services:
+    test2:
+        override: replace
+        command: /bin/sh -c 'printenv PASSWORD DB_URL >>%s; sleep 10'
+        environment:
+            PASSWORD: %s
+            DB_URL: postgres://user:${PASSWORD}@db
+`, tempFile, encrypted))
+	err = s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	waitForFileContent(c, tempFile, "pa$$word\npostgres://user:pa$$word@db\n")
+
+	// The plan still has the encrypted value.
+	config := s.manager.Config("test2")
+	c.Check(config.Environment["PASSWORD"], Equals, encrypted)
+}
+
+func (s *S) TestEnvironmentSecretInvalid(c *C) {
+	layer := parseLayer(c, 0, "layer", `
+services:
+    test2:
+        override: replace
+        command: sleep 10
+        environment:
+            PASSWORD: pebble-secret:bm90LWEtdmFsaWQtc2VjcmV0LWJ1dC1sb25nLWVub3VnaC10by1wYXJzZQ
+`)
+	err := s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Check(chg.Status(), Equals, state.ErrorStatus)
+	c.Check(chg.Err(), ErrorMatches, `(?s).*cannot decrypt environment variable "PASSWORD": cannot decrypt secret: invalid key or corrupt value.*`)
+	s.st.Unlock()
+}
+
+func (s *S) waitUntilService(c *C, service string, f func(svc *servstate.ServiceInfo) bool) {
+	for i := 0; i < 310; i++ {
+		svc := s.serviceByName(c, service)
+		if f(svc) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatalf("timed out waiting for service")
+}
+
+func (s *S) TestActionShutdown(c *C) {
+	layer := parseLayer(c, 0, "layer", `
+services:
+    test2:
+        override: replace
+        command: sleep 0.15
+        on-success: shutdown
+`)
+	err := s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	// Start service and wait till it starts up the first time.
+	s.startServices(c, []string{"test2"}, 1)
+	s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
+		return svc.Current == servstate.StatusActive
+	})
+
+	// Wait till it terminates.
+	s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
+		return svc.Current == servstate.StatusError
+	})
+
+	// It should have closed the stopDaemon channel.
+	select {
+	case <-s.stopDaemon:
+	case <-time.After(time.Second):
+		c.Fatalf("timed out waiting for stop-daemon channel")
+	}
+}
+
+func (s *S) TestRestartLimit(c *C) {
+	layer := parseLayer(c, 0, "layer", `
+services:
+    test2:
+        override: merge
+        command: /bin/sh -c "echo test2; exec sleep 10"
+        backoff-delay: 10ms
+        backoff-limit: 20ms
+        restart-limit: 2
+        restart-limit-window: 10s
+`)
+	err := s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	s.startServices(c, []string{"test2"}, 1)
+	s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
+		return svc.Current == servstate.StatusActive
+	})
+
+	// The first two crashes are restarted.
+	for i := 1; i <= 2; i++ {
+		err = s.manager.SendSignal([]string{"test2"}, "SIGTERM")
+		c.Assert(err, IsNil)
+		s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
+			return svc.Current == servstate.StatusActive && s.manager.BackoffNum("test2") == i
+		})
+	}
+
+	// The third crash within the window hits the restart limit.
+	err = s.manager.SendSignal([]string{"test2"}, "SIGTERM")
+	c.Assert(err, IsNil)
+	s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
+		return svc.Current == servstate.StatusFailed
+	})
+	time.Sleep(50 * time.Millisecond)
+	svc := s.serviceByName(c, "test2")
+	c.Assert(svc.Current, Equals, servstate.StatusFailed)
+
+	// A warning is recorded (asynchronously).
+	for i := 0; ; i++ {
+		if i >= 100 {
+			c.Fatalf("timed out waiting for warning")
+		}
+		s.st.Lock()
+		warnings := s.st.AllWarnings()
+		s.st.Unlock()
+		if len(warnings) == 1 {
+			c.Check(warnings[0].String(), Matches, `service "test2" restarted too many times \(2 within 10s\).*`)
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The daemon isn't shut down by default.
+	select {
+	case <-s.stopDaemon:
+		c.Fatalf("stop-daemon channel unexpectedly closed")
+	default:
+	}
+
+	// An explicit start resets the restart limit.
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	err = s.manager.SendSignal([]string{"test2"}, "SIGTERM")
+	c.Assert(err, IsNil)
+	s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
+		return svc.Current == servstate.StatusActive && s.manager.BackoffNum("test2") == 1
+	})
+}
+
+func (s *S) TestRestartLimitShutdown(c *C) {
+	layer := parseLayer(c, 0, "layer", `
+services:
+    test2:
+        override: merge
+        command: /bin/sh -c "echo test2; exec sleep 10"
+        backoff-delay: 10ms
+        restart-limit: 1
+        on-restart-limit: shutdown
+`)
+	err := s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	s.startServices(c, []string{"test2"}, 1)
+	s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
+		return svc.Current == servstate.StatusActive
+	})
+
+	err = s.manager.SendSignal([]string{"test2"}, "SIGTERM")
+	c.Assert(err, IsNil)
+	s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
+		return svc.Current == servstate.StatusActive && s.manager.BackoffNum("test2") == 1
+	})
+
+	err = s.manager.SendSignal([]string{"test2"}, "SIGTERM")
+	c.Assert(err, IsNil)
+	s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
+		return svc.Current == servstate.StatusFailed
+	})
+
+	// It should have closed the stopDaemon channel.
+	select {
+	case <-s.stopDaemon:
+	case <-time.After(time.Second):
+		c.Fatalf("timed out waiting for stop-daemon channel")
+	}
+}
+
+func (s *S) TestSaveProcesses(c *C) {
+	s.startServices(c, []string{"test2"}, 1)
+	s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
+		return svc.Current == servstate.StatusActive
+	})
+	cmd := s.manager.RunningCmds()["test2"]
+	c.Assert(cmd, NotNil)
+	_, startTime, err := servstate.ProcStat(cmd.Process.Pid)
+	c.Assert(err, IsNil)
+	bootID, err := osutil.BootID()
+	c.Assert(err, IsNil)
+
+	err = s.manager.Ensure()
+	c.Assert(err, IsNil)
+	var processes map[string]map[string]interface{}
+	s.st.Lock()
+	err = s.st.Get("service-processes", &processes)
+	s.st.Unlock()
+	c.Assert(err, IsNil)
+	c.Assert(processes, HasLen, 1)
+	c.Check(processes["test2"]["pid"], Equals, float64(cmd.Process.Pid))
+	c.Check(processes["test2"]["start-time"], Equals, float64(startTime))
+	c.Check(processes["test2"]["boot-id"], Equals, bootID)
+
+	s.stopServices(c, []string{"test2"}, 1)
+	err = s.manager.Ensure()
+	c.Assert(err, IsNil)
+	s.st.Lock()
+	err = s.st.Get("service-processes", &processes)
+	s.st.Unlock()
+	c.Assert(err, Equals, state.ErrNoState)
+}
+
+func (s *S) TestAdoptServices(c *C) {
+	// Simulate a service process left running by a previous daemon.
+	cmd := exec.Command("sleep", "10")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err := cmd.Start()
+	c.Assert(err, IsNil)
+	defer syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	_, startTime, err := servstate.ProcStat(cmd.Process.Pid)
+	c.Assert(err, IsNil)
+	bootID, err := osutil.BootID()
+	c.Assert(err, IsNil)
+
+	s.st.Lock()
+	s.st.Set("service-processes", map[string]interface{}{
+		"test2": map[string]interface{}{"pid": cmd.Process.Pid, "start-time": startTime, "boot-id": bootID},
+		// PID has been reused by another process (start time doesn't match).
+		"test1": map[string]interface{}{"pid": cmd.Process.Pid, "start-time": startTime + 1, "boot-id": bootID},
+		// Service no longer in plan.
+		"gone": map[string]interface{}{"pid": cmd.Process.Pid, "start-time": startTime, "boot-id": bootID},
+	})
+	s.st.Unlock()
+
+	// Restart the service manager, which should adopt the process.
+	s.runner = state.NewTaskRunner(s.st)
+	manager, err := servstate.NewManager(s.st, s.runner, s.dir, nil, testRestarter{s.stopDaemon}, fakeLogManager{})
+	c.Assert(err, IsNil)
+	s.AddCleanup(manager.Stop)
+	s.manager = manager
+	var statuses []string
+	manager.NotifyServiceStatusChanged(func(name string, status servstate.ServiceStatus) {
+		statuses = append(statuses, name+":"+string(status))
+	})
+	manager.AdoptServices()
+
+	// Status handlers are told about the adopted service.
+	c.Check(statuses, DeepEquals, []string{"test2:active"})
+	c.Check(s.serviceByName(c, "test1").Current, Equals, servstate.StatusInactive)
+	c.Check(s.serviceByName(c, "test2").Current, Equals, servstate.StatusActive)
+	c.Check(s.manager.RunningCmds()["test2"].Process.Pid, Equals, cmd.Process.Pid)
+
+	// Starting an adopted service is a no-op.
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Check(chg.Status(), Equals, state.DoneStatus)
+	c.Check(chg.Tasks()[0].Log()[0], Matches, `.* INFO Service "test2" already started.`)
+	s.st.Unlock()
+
+	// Adopted services can be stopped as usual, and their exit is observed.
+	chg = s.stopServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Check(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	c.Check(s.serviceByName(c, "test2").Current, Equals, servstate.StatusInactive)
+	_, _, err = servstate.ProcStat(cmd.Process.Pid)
+	c.Check(err, NotNil)
+}
+
+func (s *S) TestTemplateServices(c *C) {
+	layer := parseLayer(c, 0, "layer", `
+services:
+    worker@:
+        override: replace
+        command: /bin/sh -c "echo port 80{{instance}} $NAME; sleep 10"
+        instances: 2
+        environment:
+            NAME: worker-{{instance}}
+`)
+	err := s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	// The plan holds the template as it's defined.
+	p, err := s.manager.Plan()
+	c.Assert(err, IsNil)
+	c.Check(p.Services["worker@"], NotNil)
+	c.Check(p.Services["worker@1"], IsNil)
+
+	// Starting the template starts all its instances.
+	names, err := s.manager.StartOrder([]string{"worker"})
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{"worker@1", "worker@2"})
+	chg := s.startServices(c, names, 2)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+
+	services, err := s.manager.Services([]string{"worker"})
+	c.Assert(err, IsNil)
+	c.Assert(services, HasLen, 2)
+	c.Check(services[0].Name, Equals, "worker@1")
+	c.Check(services[0].Current, Equals, servstate.StatusActive)
+	c.Check(services[1].Name, Equals, "worker@2")
+	c.Check(services[1].Current, Equals, servstate.StatusActive)
+
+	// Logs can be fetched for all instances or a single one.
+	iterators, err := s.manager.ServiceLogs([]string{"worker@"}, -1)
+	c.Assert(err, IsNil)
+	c.Assert(iterators, HasLen, 2)
+	for _, it := range iterators {
+		c.Check(it.Close(), IsNil)
+	}
+	iterators, err = s.manager.ServiceLogs([]string{"worker@2"}, 10)
+	c.Assert(err, IsNil)
+	c.Assert(iterators, HasLen, 1)
+	it := iterators["worker@2"]
+	c.Assert(it, NotNil)
+	defer it.Close()
+	var buf bytes.Buffer
+	for it.Next(nil) {
+		_, err := io.Copy(&buf, it)
+		c.Assert(err, IsNil)
+	}
+	c.Check(buf.String(), Matches, `(?s).*\[worker@2\] port 802 worker-2\n`)
+
+	// A single instance can be stopped on its own.
+	chg = s.stopServices(c, []string{"worker@1"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	c.Check(s.serviceByName(c, "worker@1").Current, Equals, servstate.StatusInactive)
+	c.Check(s.serviceByName(c, "worker@2").Current, Equals, servstate.StatusActive)
+}
+
+func (s *S) TestActionIgnore(c *C) {
+	layer := parseLayer(c, 0, "layer", `
+services:
+    test2:
+        override: replace
+        command: sleep 0.15
+        on-success: ignore
+`)
+	err := s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+	var mutex sync.Mutex
+	var exits []string
+	s.manager.NotifyServiceExited(func(name string, exitCode int) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		exits = append(exits, fmt.Sprintf("%s %d", name, exitCode))
+	})
+
+	// Start service and wait till it starts up the first time.
+	s.startServices(c, []string{"test2"}, 1)
+	s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
+		return svc.Current == servstate.StatusActive
+	})
+
+	// Wait till it terminates.
+	s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
+		return svc.Current == servstate.StatusInactive
+	})
+
+	// Exit handlers are told about the exit, even though the service went
+	// inactive as if it had been stopped.
+	mutex.Lock()
+	defer mutex.Unlock()
+	c.Assert(exits, DeepEquals, []string{"test2 0"})
+}
+
+func (s *S) TestGetAction(c *C) {
+	tests := []struct {
+		onSuccess plan.ServiceAction
+		onFailure plan.ServiceAction
+		success   bool
+		action    string
+		onType    string
+	}{
+		{onSuccess: "", onFailure: "", success: false, action: "restart", onType: "on-failure"},
+		{onSuccess: "", onFailure: "", success: true, action: "restart", onType: "on-success"},
+		{onSuccess: "", onFailure: "restart", success: false, action: "restart", onType: "on-failure"},
+		{onSuccess: "", onFailure: "restart", success: true, action: "restart", onType: "on-success"},
+		{onSuccess: "", onFailure: "shutdown", success: false, action: "shutdown", onType: "on-failure"},
+		{onSuccess: "", onFailure: "shutdown", success: true, action: "restart", onType: "on-success"},
+		{onSuccess: "", onFailure: "ignore", success: false, action: "ignore", onType: "on-failure"},
+		{onSuccess: "", onFailure: "ignore", success: true, action: "restart", onType: "on-success"},
+		{onSuccess: "restart", onFailure: "", success: false, action: "restart", onType: "on-failure"},
+		{onSuccess: "restart", onFailure: "", success: true, action: "restart", onType: "on-success"},
+		{onSuccess: "restart", onFailure: "restart", success: false, action: "restart", onType: "on-failure"},
+		{onSuccess: "restart", onFailure: "restart", success: true, action: "restart", onType: "on-success"},
+		{onSuccess: "restart", onFailure: "shutdown", success: false, action: "shutdown", onType: "on-failure"},
+		{onSuccess: "restart", onFailure: "shutdown", success: true, action: "restart", onType: "on-success"},
+		{onSuccess: "restart", onFailure: "ignore", success: false, action: "ignore", onType: "on-failure"},
+		{onSuccess: "restart", onFailure: "ignore", success: true, action: "restart", onType: "on-success"},
+		{onSuccess: "shutdown", onFailure: "", success: false, action: "restart", onType: "on-failure"},
+		{onSuccess: "shutdown", onFailure: "", success: true, action: "shutdown", onType: "on-success"},
+		{onSuccess: "shutdown", onFailure: "restart", success: false, action: "restart", onType: "on-failure"},
+		{onSuccess: "shutdown", onFailure: "restart", success: true, action: "shutdown", onType: "on-success"},
+		{onSuccess: "shutdown", onFailure: "shutdown", success: false, action: "shutdown", onType: "on-failure"},
+		{onSuccess: "shutdown", onFailure: "shutdown", success: true, action: "shutdown", onType: "on-success"},
+		{onSuccess: "shutdown", onFailure: "ignore", success: false, action: "ignore", onType: "on-failure"},
+		{onSuccess: "shutdown", onFailure: "ignore", success: true, action: "shutdown", onType: "on-success"},
+		{onSuccess: "ignore", onFailure: "", success: false, action: "restart", onType: "on-failure"},
+		{onSuccess: "ignore", onFailure: "", success: true, action: "ignore", onType: "on-success"},
+		{onSuccess: "ignore", onFailure: "restart", success: false, action: "restart", onType: "on-failure"},
+		{onSuccess: "ignore", onFailure: "restart", success: true, action: "ignore", onType: "on-success"},
+		{onSuccess: "ignore", onFailure: "shutdown", success: false, action: "shutdown", onType: "on-failure"},
+		{onSuccess: "ignore", onFailure: "shutdown", success: true, action: "ignore", onType: "on-success"},
+		{onSuccess: "ignore", onFailure: "ignore", success: false, action: "ignore", onType: "on-failure"},
+		{onSuccess: "ignore", onFailure: "ignore", success: true, action: "ignore", onType: "on-success"},
+	}
+	for _, test := range tests {
+		config := &plan.Service{
+			OnFailure: test.onFailure,
+			OnSuccess: test.onSuccess,
+		}
+		action, onType := servstate.GetAction(config, test.success)
+		c.Check(string(action), Equals, test.action, Commentf("onSuccess=%q, onFailure=%q, success=%v",
+			test.onSuccess, test.onFailure, test.success))
+		c.Check(onType, Equals, test.onType, Commentf("onSuccess=%q, onFailure=%q, success=%v",
+			test.onSuccess, test.onFailure, test.success))
+	}
+}
+
+func (s *S) TestGetJitter(c *C) {
+	// It's tricky to test a function that generates randomness, but ensure all
+	// the values are in range, and that the number of values distributed across
+	// each of 3 buckets is reasonable.
+	var buckets [3]int
+	for i := 0; i < 3000; i++ {
+		jitter := s.manager.GetJitter(3 * time.Second)
+		c.Assert(jitter >= 0 && jitter < 300*time.Millisecond, Equals, true)
+		switch {
+		case jitter >= 0 && jitter < 100*time.Millisecond:
+			buckets[0]++
+		case jitter >= 100*time.Millisecond && jitter < 200*time.Millisecond:
+			buckets[1]++
+		case jitter >= 200*time.Millisecond && jitter < 300*time.Millisecond:
+			buckets[2]++
+		default:
+			c.Errorf("jitter %s outside range [0, 300ms)", jitter)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if buckets[i] < 800 || buckets[i] > 1200 { // exceedingly unlikely to be outside this range
+			c.Errorf("bucket[%d] has too few or too many values in it (%d)", i, buckets[i])
+		}
+	}
+}
+
+func (s *S) TestCalculateNextBackoff(c *C) {
+	tests := []struct {
+		delay   time.Duration
+		factor  float64
+		limit   time.Duration
+		current time.Duration
+		next    time.Duration
+	}{
+		{delay: 500 * time.Millisecond, factor: 2, limit: 30 * time.Second, current: 0, next: 500 * time.Millisecond},
+		{delay: 500 * time.Millisecond, factor: 2, limit: 30 * time.Second, current: 500 * time.Millisecond, next: time.Second},
+		{delay: 500 * time.Millisecond, factor: 2, limit: 30 * time.Second, current: time.Second, next: 2 * time.Second},
+		{delay: 500 * time.Millisecond, factor: 2, limit: 30 * time.Second, current: 16 * time.Second, next: 30 * time.Second},
+		{delay: 500 * time.Millisecond, factor: 2, limit: 30 * time.Second, current: 30 * time.Second, next: 30 * time.Second},
+		{delay: 500 * time.Millisecond, factor: 2, limit: 30 * time.Second, current: 1000 * time.Second, next: 30 * time.Second},
+
+		{delay: time.Second, factor: 1.5, limit: 60 * time.Second, current: 0, next: time.Second},
+		{delay: time.Second, factor: 1.5, limit: 60 * time.Second, current: time.Second, next: 1500 * time.Millisecond},
+		{delay: time.Second, factor: 1.5, limit: 60 * time.Second, current: 1500 * time.Millisecond, next: 2250 * time.Millisecond},
+		{delay: time.Second, factor: 1.5, limit: 60 * time.Second, current: 50 * time.Second, next: 60 * time.Second},
+		{delay: time.Second, factor: 1.5, limit: 60 * time.Second, current: 60 * time.Second, next: 60 * time.Second},
+		{delay: time.Second, factor: 1.5, limit: 60 * time.Second, current: 70 * time.Second, next: 60 * time.Second},
+	}
+	for _, test := range tests {
+		config := &plan.Service{
+			BackoffDelay:  plan.OptionalDuration{Value: test.delay},
+			BackoffFactor: plan.OptionalFloat{Value: test.factor},
+			BackoffLimit:  plan.OptionalDuration{Value: test.limit},
+		}
+		next := servstate.CalculateNextBackoff(config, test.current)
+		c.Check(next, Equals, test.next, Commentf("delay=%s, factor=%g, limit=%s, current=%s",
+			test.delay, test.factor, test.limit, test.current))
+	}
+}
+
+func (s *S) TestReapZombies(c *C) {
+	// Ensure we've been set as a child subreaper
+	isSubreaper, err := getChildSubreaper()
+	c.Assert(err, IsNil)
+	c.Assert(isSubreaper, Equals, true)
+
+	// Start a service which runs this test executable with an environment
+	// variable set so the "service" knows to create a zombie child.
+	testExecutable, err := os.Executable()
+	c.Assert(err, IsNil)
+	layer := parseLayer(c, 0, "layer", fmt.Sprintf(`
+services:
+    test2:
+        override: replace
+        command: %s
+        environment:
+            PEBBLE_TEST_CREATE_ZOMBIE: 1
+        on-success: ignore
+`, testExecutable))
+	err = s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+
+	s.startServices(c, []string{"test2"}, 1)
+	s.waitUntilService(c, "test2", func(svc *servstate.ServiceInfo) bool {
+		return svc.Current == servstate.StatusActive
+	})
+
+	// Get the PID of the zombie child (the createZombie process printed it out)
+	childPidRe := regexp.MustCompile(`.* childPid (\d+)`)
 	matches := childPidRe.FindStringSubmatch(s.logBufferString())
 	c.Assert(len(matches), Equals, 2)
 	childPid, err := strconv.Atoi(matches[1])
@@ -1678,3 +2680,81 @@ type fakeLogManager struct{}
 func (f fakeLogManager) ServiceStarted(serviceName string, logs *servicelog.RingBuffer) {
 	// no-op
 }
+
+func (s *S) TestHandOver(c *C) {
+	dir := c.MkDir()
+	trigger := filepath.Join(dir, "trigger")
+	layer := parseLayer(c, 0, "layer", fmt.Sprintf(`
+services:
+    test2:
+        override: replace
+        command: /bin/sh -c 'echo before; while [ ! -f %s ]; do sleep 0.01; done; echo after; sleep 10'
+`, trigger))
+	err := s.manager.AppendLayer(layer)
+	c.Assert(err, IsNil)
+	chg := s.startServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Assert(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	cmd := s.manager.RunningCmds()["test2"]
+	c.Assert(cmd, NotNil)
+	defer syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	for i := 0; ; i++ {
+		if strings.Contains(s.logBufferString(), "before") {
+			break
+		}
+		if i >= 100 {
+			c.Fatalf("timed out waiting for service output")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Hand the service over, as the daemon does before re-executing itself.
+	s.manager.HandOver()
+	var processes map[string]map[string]interface{}
+	s.st.Lock()
+	err = s.st.Get("service-processes", &processes)
+	s.st.Unlock()
+	c.Assert(err, IsNil)
+	c.Check(processes["test2"]["output-fd"], Not(IsNil))
+	c.Check(processes["test2"]["daemon-pid"], Equals, float64(os.Getpid()))
+
+	// The next instance adopts the service and captures its output.
+	var output bytes.Buffer
+	var outputMut sync.Mutex
+	outputWriter := writerFunc(func(p []byte) (int, error) {
+		outputMut.Lock()
+		defer outputMut.Unlock()
+		return output.Write(p)
+	})
+	s.runner = state.NewTaskRunner(s.st)
+	manager, err := servstate.NewManager(s.st, s.runner, s.dir, outputWriter, testRestarter{s.stopDaemon}, fakeLogManager{})
+	c.Assert(err, IsNil)
+	s.AddCleanup(manager.Stop)
+	s.manager = manager
+	manager.AdoptServices()
+	c.Assert(s.manager.RunningCmds()["test2"].Process.Pid, Equals, cmd.Process.Pid)
+
+	err = ioutil.WriteFile(trigger, nil, 0644)
+	c.Assert(err, IsNil)
+	for i := 0; ; i++ {
+		outputMut.Lock()
+		str := output.String()
+		outputMut.Unlock()
+		if strings.Contains(str, "[test2] after") {
+			c.Check(str, Not(Matches), "(?s).*before.*")
+			break
+		}
+		if i >= 100 {
+			c.Fatalf("timed out waiting for adopted service output (got %q)", str)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The adopted service's exit code is known, as it's still our child.
+	chg = s.stopServices(c, []string{"test2"}, 1)
+	s.st.Lock()
+	c.Check(chg.Status(), Equals, state.DoneStatus, Commentf("Error: %v", chg.Err()))
+	s.st.Unlock()
+	c.Check(s.serviceByName(c, "test2").Current, Equals, servstate.StatusInactive)
+}
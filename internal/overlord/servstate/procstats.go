@@ -0,0 +1,172 @@
+package servstate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProcessStats holds resource usage figures for a service, summed over the
+// processes in its process group.
+type ProcessStats struct {
+	// CPUTime is the user and system CPU time used.
+	CPUTime time.Duration
+
+	// RSS is the resident set size in bytes.
+	RSS uint64
+
+	// FDs is the number of open file descriptors.
+	FDs int
+
+	// Threads is the number of threads.
+	Threads int
+}
+
+// clockTicksPerSecond is the unit of the CPU times in /proc/<pid>/stat
+// (USER_HZ), which is 100 on all architectures Linux supports.
+const clockTicksPerSecond = 100
+
+// ServiceStats returns resource usage figures for each of the named services
+// that is running, read from /proc. Services that aren't running are
+// omitted from the result.
+func (m *ServiceManager) ServiceStats(names []string) (map[string]*ProcessStats, error) {
+	// Services are started in their own process group, with the process
+	// group ID the same as the service's PID.
+	groups := make(map[int]string)
+	m.servicesLock.Lock()
+	for _, name := range names {
+		s, ok := m.services[name]
+		if !ok || s.cmd == nil || s.cmd.Process == nil {
+			continue
+		}
+		switch s.state {
+		case stateStarting, stateRunning, stateTerminating, stateKilling:
+			groups[s.cmd.Process.Pid] = name
+		}
+	}
+	m.servicesLock.Unlock()
+
+	stats := make(map[string]*ProcessStats)
+	if len(groups) == 0 {
+		return stats, nil
+	}
+	byGroup, err := processGroupStats(groups)
+	if err != nil {
+		return nil, err
+	}
+	for pgid, name := range groups {
+		if s, ok := byGroup[pgid]; ok {
+			stats[name] = s
+		}
+	}
+	return stats, nil
+}
+
+// processGroupStats sums the resource usage of all processes in /proc that
+// are in one of the given process groups.
+func processGroupStats(groups map[int]string) (map[int]*ProcessStats, error) {
+	dir, err := os.Open("/proc")
+	if err != nil {
+		return nil, err
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return nil, err
+	}
+	pageSize := uint64(os.Getpagesize())
+	stats := make(map[int]*ProcessStats)
+	for _, name := range names {
+		pid, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		stat, err := readProcessStat(pid)
+		if err != nil {
+			// The process may have exited since the directory was read.
+			continue
+		}
+		if _, ok := groups[stat.pgid]; !ok {
+			continue
+		}
+		s := stats[stat.pgid]
+		if s == nil {
+			s = &ProcessStats{}
+			stats[stat.pgid] = s
+		}
+		s.CPUTime += time.Duration(stat.cpuTicks) * time.Second / clockTicksPerSecond
+		s.RSS += stat.rssPages * pageSize
+		s.Threads += stat.threads
+		s.FDs += countFDs(pid)
+	}
+	return stats, nil
+}
+
+type processStat struct {
+	pgid     int
+	cpuTicks uint64
+	threads  int
+	rssPages uint64
+}
+
+// readProcessStat reads the figures needed for ProcessStats from
+// /proc/<pid>/stat (see proc(5)).
+func readProcessStat(pid int) (*processStat, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	// As in procStat, parse the fields after the command name. Here fields[0]
+	// is field 3 (state) in proc(5).
+	stat := string(data)
+	i := strings.LastIndexByte(stat, ')')
+	if i < 0 {
+		return nil, fmt.Errorf("invalid stat data for PID %d", pid)
+	}
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("invalid stat data for PID %d", pid)
+	}
+	var s processStat
+	s.pgid, err = strconv.Atoi(fields[2]) // pgrp (field 5)
+	if err != nil {
+		return nil, fmt.Errorf("invalid process group for PID %d: %v", pid, err)
+	}
+	utime, err := strconv.ParseUint(fields[11], 10, 64) // utime (field 14)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user time for PID %d: %v", pid, err)
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64) // stime (field 15)
+	if err != nil {
+		return nil, fmt.Errorf("invalid system time for PID %d: %v", pid, err)
+	}
+	s.threads, err = strconv.Atoi(fields[17]) // num_threads (field 20)
+	if err != nil {
+		return nil, fmt.Errorf("invalid thread count for PID %d: %v", pid, err)
+	}
+	s.rssPages, err = strconv.ParseUint(fields[21], 10, 64) // rss (field 24)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RSS for PID %d: %v", pid, err)
+	}
+	s.cpuTicks = utime + stime
+	return &s, nil
+}
+
+// countFDs returns the number of open file descriptors of the process, or
+// zero if they can't be read (for example, due to permissions).
+func countFDs(pid int) int {
+	f, err := os.Open(filepath.Join("/proc", strconv.Itoa(pid), "fd"))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return 0
+	}
+	return len(names)
+}
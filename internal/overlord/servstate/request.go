@@ -47,6 +47,23 @@ func Stop(s *state.State, services []string) (*state.TaskSet, error) {
 	return state.NewTaskSet(tasks...), nil
 }
 
+// Reload creates and returns a task set for reloading the given services.
+func Reload(s *state.State, services []string) (*state.TaskSet, error) {
+	var tasks []*state.Task
+	for _, name := range services {
+		task := s.NewTask("reload", fmt.Sprintf("Reload service %q", name))
+		req := ServiceRequest{
+			Name: name,
+		}
+		task.Set("service-request", &req)
+		if len(tasks) > 0 {
+			task.WaitFor(tasks[len(tasks)-1])
+		}
+		tasks = append(tasks, task)
+	}
+	return state.NewTaskSet(tasks...), nil
+}
+
 // StopRunning creates and returns a task set for stopping all running
 // services. It returns a nil *TaskSet if there are no services to stop.
 func StopRunning(s *state.State, m *ServiceManager) (*state.TaskSet, error) {
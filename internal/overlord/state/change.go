@@ -300,10 +300,14 @@ func (c *Change) Status() Status {
 // SetStatus sets the change status, overriding the default behavior (see Status method).
 func (c *Change) SetStatus(s Status) {
 	c.state.writing()
+	old := c.Status()
 	c.status = s
 	if s.Ready() {
 		c.markReady()
 	}
+	if new := c.Status(); new != old {
+		c.state.notifyChangeStatusChanged(c, old, new)
+	}
 }
 
 func (c *Change) markReady() {
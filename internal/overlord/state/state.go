@@ -97,6 +97,10 @@ type State struct {
 	modified bool
 
 	cache map[interface{}]interface{}
+
+	taskHandlers    []TaskStatusChangedFunc
+	changeHandlers  []ChangeStatusChangedFunc
+	warningHandlers []WarningFunc
 }
 
 // New returns a new empty state.
@@ -274,6 +278,52 @@ func (s *State) Cache(key, value interface{}) {
 	}
 }
 
+// TaskStatusChangedFunc is the type of function called when a task's status
+// changes. It's called with the state locked.
+type TaskStatusChangedFunc func(t *Task, old, new Status)
+
+// ChangeStatusChangedFunc is the type of function called when a change's
+// status changes. It's called with the state locked.
+type ChangeStatusChangedFunc func(chg *Change, old, new Status)
+
+// WarningFunc is the type of function called when a warning is recorded.
+// It's called with the state locked.
+type WarningFunc func(message string)
+
+// AddTaskStatusChangedHandler adds f to the list of functions that are called
+// whenever a task's status changes.
+func (s *State) AddTaskStatusChangedHandler(f TaskStatusChangedFunc) {
+	s.writing()
+	s.taskHandlers = append(s.taskHandlers, f)
+}
+
+// AddChangeStatusChangedHandler adds f to the list of functions that are
+// called whenever a change's status changes, whether it was set explicitly
+// or changed as a result of one of its tasks changing status.
+func (s *State) AddChangeStatusChangedHandler(f ChangeStatusChangedFunc) {
+	s.writing()
+	s.changeHandlers = append(s.changeHandlers, f)
+}
+
+// AddWarningHandler adds f to the list of functions that are called whenever
+// a warning is recorded (including when an existing warning is repeated).
+func (s *State) AddWarningHandler(f WarningFunc) {
+	s.writing()
+	s.warningHandlers = append(s.warningHandlers, f)
+}
+
+func (s *State) notifyTaskStatusChanged(t *Task, old, new Status) {
+	for _, f := range s.taskHandlers {
+		f(t, old, new)
+	}
+}
+
+func (s *State) notifyChangeStatusChanged(chg *Change, old, new Status) {
+	for _, f := range s.changeHandlers {
+		f(chg, old, new)
+	}
+}
+
 // NewChange adds a new change to the state.
 func (s *State) NewChange(kind, summary string) *Change {
 	s.writing()
@@ -200,14 +200,27 @@ func (t *Task) Status() Status {
 func (t *Task) SetStatus(new Status) {
 	t.state.writing()
 	old := t.status
+	oldStatus := t.Status()
+	chg := t.Change()
+	var oldChgStatus Status
+	if chg != nil && len(t.state.changeHandlers) > 0 {
+		oldChgStatus = chg.Status()
+	}
 	t.status = new
 	if !old.Ready() && new.Ready() {
 		t.readyTime = timeNow()
 	}
-	chg := t.Change()
 	if chg != nil {
 		chg.taskStatusChanged(t, old, new)
 	}
+	if newStatus := t.Status(); newStatus != oldStatus {
+		t.state.notifyTaskStatusChanged(t, oldStatus, newStatus)
+	}
+	if chg != nil && len(t.state.changeHandlers) > 0 {
+		if newChgStatus := chg.Status(); newChgStatus != oldChgStatus {
+			t.state.notifyChangeStatusChanged(chg, oldChgStatus, newChgStatus)
+		}
+	}
 }
 
 // IsClean returns whether the task has been cleaned. See SetClean.
@@ -144,6 +144,44 @@ func (ts *taskSuite) TestStatusAndSetStatus(c *C) {
 	c.Check(t.Status(), Equals, state.DoneStatus)
 }
 
+func (ts *taskSuite) TestStatusChangedHandlers(c *C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	var taskChanges, changeChanges []string
+	st.AddTaskStatusChangedHandler(func(t *state.Task, old, new state.Status) {
+		taskChanges = append(taskChanges, fmt.Sprintf("%s:%s->%s", t.ID(), old, new))
+	})
+	st.AddChangeStatusChangedHandler(func(chg *state.Change, old, new state.Status) {
+		changeChanges = append(changeChanges, fmt.Sprintf("%s:%s->%s", chg.ID(), old, new))
+	})
+
+	chg := st.NewChange("install", "...")
+	t1 := st.NewTask("download", "1...")
+	t2 := st.NewTask("download", "2...")
+	chg.AddTask(t1)
+	chg.AddTask(t2)
+
+	t1.SetStatus(state.DoingStatus)
+	t1.SetStatus(state.DoingStatus) // no change, no notification
+	t1.SetStatus(state.DoneStatus)
+	t2.SetStatus(state.DoneStatus)
+	chg.SetStatus(state.ErrorStatus)
+
+	c.Check(taskChanges, DeepEquals, []string{
+		t1.ID() + ":Do->Doing",
+		t1.ID() + ":Doing->Done",
+		t2.ID() + ":Do->Done",
+	})
+	c.Check(changeChanges, DeepEquals, []string{
+		chg.ID() + ":Do->Doing",
+		chg.ID() + ":Doing->Do",
+		chg.ID() + ":Do->Done",
+		chg.ID() + ":Done->Error",
+	})
+}
+
 func (ts *taskSuite) TestIsCleanAndSetClean(c *C) {
 	st := state.New(nil)
 	st.Lock()
@@ -204,6 +204,9 @@ func (s *State) addWarning(w Warning, t time.Time) {
 		s.warnings[w.message] = &w
 	}
 	s.warnings[w.message].lastAdded = t
+	for _, f := range s.warningHandlers {
+		f(w.message)
+	}
 }
 
 type byLastAdded []*Warning
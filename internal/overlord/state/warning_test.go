@@ -161,6 +161,20 @@ func (stateSuite) TestDeleteExpired(c *check.C) {
 	c.Check(fmt.Sprintf("%q", allWs), check.Equals, `["hello again"]`)
 }
 
+func (stateSuite) TestWarningHandler(c *check.C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	var messages []string
+	st.AddWarningHandler(func(message string) {
+		messages = append(messages, message)
+	})
+	st.Warnf("hello %s", "world")
+	st.Warnf("hello %s", "world")
+	c.Check(messages, check.DeepEquals, []string{"hello world", "hello world"})
+}
+
 func (stateSuite) TestOldRepeatedWarning(c *check.C) {
 	now := time.Now()
 	oldTime := now.UTC().Add(-2 * state.DefaultExpireAfter)
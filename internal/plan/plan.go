@@ -16,19 +16,25 @@ package plan
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/canonical/x-go/strutil"
 	"github.com/canonical/x-go/strutil/shlex"
+	"golang.org/x/sys/unix"
 	"gopkg.in/yaml.v3"
 
+	"github.com/canonical/pebble/internal/jsonpath"
 	"github.com/canonical/pebble/internal/osutil"
 )
 
@@ -40,23 +46,32 @@ const (
 	defaultCheckPeriod    = 10 * time.Second
 	defaultCheckTimeout   = 3 * time.Second
 	defaultCheckThreshold = 3
+
+	defaultNotifyRetries         = 3
+	defaultNotifyRateLimitPeriod = time.Minute
 )
 
 type Plan struct {
-	Layers     []*Layer              `yaml:"-"`
-	Services   map[string]*Service   `yaml:"services,omitempty"`
-	Checks     map[string]*Check     `yaml:"checks,omitempty"`
-	LogTargets map[string]*LogTarget `yaml:"log-targets,omitempty"`
+	Layers []*Layer `yaml:"-"`
+	// Services holds the services as defined in the layers, including
+	// template services (see ExpandedServices).
+	Services      map[string]*Service      `yaml:"services,omitempty"`
+	Checks        map[string]*Check        `yaml:"checks,omitempty"`
+	LogTargets    map[string]*LogTarget    `yaml:"log-targets,omitempty"`
+	NotifyTargets map[string]*NotifyTarget `yaml:"notify-targets,omitempty"`
+	Groups        map[string]*Group        `yaml:"groups,omitempty"`
 }
 
 type Layer struct {
-	Order       int                   `yaml:"-"`
-	Label       string                `yaml:"-"`
-	Summary     string                `yaml:"summary,omitempty"`
-	Description string                `yaml:"description,omitempty"`
-	Services    map[string]*Service   `yaml:"services,omitempty"`
-	Checks      map[string]*Check     `yaml:"checks,omitempty"`
-	LogTargets  map[string]*LogTarget `yaml:"log-targets,omitempty"`
+	Order         int                      `yaml:"-"`
+	Label         string                   `yaml:"-"`
+	Summary       string                   `yaml:"summary,omitempty"`
+	Description   string                   `yaml:"description,omitempty"`
+	Services      map[string]*Service      `yaml:"services,omitempty"`
+	Checks        map[string]*Check        `yaml:"checks,omitempty"`
+	LogTargets    map[string]*LogTarget    `yaml:"log-targets,omitempty"`
+	NotifyTargets map[string]*NotifyTarget `yaml:"notify-targets,omitempty"`
+	Groups        map[string]*Group        `yaml:"groups,omitempty"`
 }
 
 type Service struct {
@@ -68,17 +83,37 @@ type Service struct {
 	Override    Override       `yaml:"override,omitempty"`
 	Command     string         `yaml:"command,omitempty"`
 
+	// Instances of a template service (one whose name ends with "@")
+	Instances ServiceInstances `yaml:"instances,omitempty"`
+
 	// Service dependencies
 	After    []string `yaml:"after,omitempty"`
 	Before   []string `yaml:"before,omitempty"`
 	Requires []string `yaml:"requires,omitempty"`
 
+	// Groups the service belongs to, in addition to those listing it
+	Groups []string `yaml:"groups,omitempty"`
+
+	// Health checks that must be up before the service is started
+	WaitForChecks        []string         `yaml:"wait-for-checks,omitempty"`
+	WaitForChecksTimeout OptionalDuration `yaml:"wait-for-checks-timeout,omitempty"`
+
 	// Options for command execution
-	Environment map[string]string `yaml:"environment,omitempty"`
-	UserID      *int              `yaml:"user-id,omitempty"`
-	User        string            `yaml:"user,omitempty"`
-	GroupID     *int              `yaml:"group-id,omitempty"`
-	Group       string            `yaml:"group,omitempty"`
+	Environment      map[string]string `yaml:"environment,omitempty"`
+	EnvironmentFiles []string          `yaml:"environment-files,omitempty"`
+	UserID           *int              `yaml:"user-id,omitempty"`
+	User             string            `yaml:"user,omitempty"`
+	GroupID          *int              `yaml:"group-id,omitempty"`
+	Group            string            `yaml:"group,omitempty"`
+
+	// Process sandboxing
+	Sandbox `yaml:",inline"`
+
+	// Resource limits (applied if cgroup v2 is available)
+	MemoryMax string `yaml:"memory-max,omitempty"`
+	CPUWeight int    `yaml:"cpu-weight,omitempty"`
+	CPUMax    string `yaml:"cpu-max,omitempty"`
+	PidsMax   int    `yaml:"pids-max,omitempty"`
 
 	// Auto-restart and backoff functionality
 	OnSuccess      ServiceAction            `yaml:"on-success,omitempty"`
@@ -89,6 +124,22 @@ type Service struct {
 	BackoffLimit   OptionalDuration         `yaml:"backoff-limit,omitempty"`
 	KillDelay      OptionalDuration         `yaml:"kill-delay,omitempty"`
 
+	// Restart rate limiting
+	RestartLimit       int              `yaml:"restart-limit,omitempty"`
+	RestartLimitWindow OptionalDuration `yaml:"restart-limit-window,omitempty"`
+	OnRestartLimit     ServiceAction    `yaml:"on-restart-limit,omitempty"`
+
+	// Stop and reload functionality
+	StopSignal    string `yaml:"stop-signal,omitempty"`
+	ReloadSignal  string `yaml:"reload-signal,omitempty"`
+	ReloadCommand string `yaml:"reload-command,omitempty"`
+
+	// Commands run before the service is started, after it has started, and
+	// after it has stopped
+	PreStart  []string `yaml:"pre-start,omitempty"`
+	PostStart []string `yaml:"post-start,omitempty"`
+	PostStop  []string `yaml:"post-stop,omitempty"`
+
 	// Log forwarding
 	LogTargets []string `yaml:"log-targets,omitempty"`
 }
@@ -99,6 +150,16 @@ func (s *Service) Copy() *Service {
 	copied.After = append([]string(nil), s.After...)
 	copied.Before = append([]string(nil), s.Before...)
 	copied.Requires = append([]string(nil), s.Requires...)
+	copied.Groups = append([]string(nil), s.Groups...)
+	copied.WaitForChecks = append([]string(nil), s.WaitForChecks...)
+	copied.EnvironmentFiles = append([]string(nil), s.EnvironmentFiles...)
+	copied.PreStart = append([]string(nil), s.PreStart...)
+	copied.PostStart = append([]string(nil), s.PostStart...)
+	copied.PostStop = append([]string(nil), s.PostStop...)
+	copied.Sandbox = *s.Sandbox.Copy()
+	if s.Instances.Names != nil {
+		copied.Instances.Names = append([]string{}, s.Instances.Names...)
+	}
 	if s.Environment != nil {
 		copied.Environment = make(map[string]string)
 		for k, v := range s.Environment {
@@ -156,13 +217,39 @@ func (s *Service) Merge(other *Service) {
 	}
 	s.After = append(s.After, other.After...)
 	s.Before = append(s.Before, other.Before...)
+	if !other.Instances.IsZero() {
+		s.Instances.Count = other.Instances.Count
+		s.Instances.Names = nil
+		if other.Instances.Names != nil {
+			s.Instances.Names = append([]string{}, other.Instances.Names...)
+		}
+	}
 	s.Requires = append(s.Requires, other.Requires...)
+	s.Groups = appendUnique(s.Groups, other.Groups...)
+	s.WaitForChecks = appendUnique(s.WaitForChecks, other.WaitForChecks...)
+	if other.WaitForChecksTimeout.IsSet {
+		s.WaitForChecksTimeout = other.WaitForChecksTimeout
+	}
 	for k, v := range other.Environment {
 		if s.Environment == nil {
 			s.Environment = make(map[string]string)
 		}
 		s.Environment[k] = v
 	}
+	s.EnvironmentFiles = appendUnique(s.EnvironmentFiles, other.EnvironmentFiles...)
+	s.Sandbox.Merge(&other.Sandbox)
+	if other.MemoryMax != "" {
+		s.MemoryMax = other.MemoryMax
+	}
+	if other.CPUWeight != 0 {
+		s.CPUWeight = other.CPUWeight
+	}
+	if other.CPUMax != "" {
+		s.CPUMax = other.CPUMax
+	}
+	if other.PidsMax != 0 {
+		s.PidsMax = other.PidsMax
+	}
 	if other.OnSuccess != "" {
 		s.OnSuccess = other.OnSuccess
 	}
@@ -184,6 +271,35 @@ func (s *Service) Merge(other *Service) {
 	if other.BackoffLimit.IsSet {
 		s.BackoffLimit = other.BackoffLimit
 	}
+	if other.RestartLimit != 0 {
+		s.RestartLimit = other.RestartLimit
+	}
+	if other.RestartLimitWindow.IsSet {
+		s.RestartLimitWindow = other.RestartLimitWindow
+	}
+	if other.OnRestartLimit != "" {
+		s.OnRestartLimit = other.OnRestartLimit
+	}
+	if other.StopSignal != "" {
+		s.StopSignal = other.StopSignal
+	}
+	// reload-signal and reload-command are alternatives, so a layer that sets
+	// one of them replaces the other.
+	if other.ReloadSignal != "" {
+		s.ReloadSignal = other.ReloadSignal
+		if other.ReloadCommand == "" {
+			s.ReloadCommand = ""
+		}
+	}
+	if other.ReloadCommand != "" {
+		s.ReloadCommand = other.ReloadCommand
+		if other.ReloadSignal == "" {
+			s.ReloadSignal = ""
+		}
+	}
+	s.PreStart = append(s.PreStart, other.PreStart...)
+	s.PostStart = append(s.PostStart, other.PostStart...)
+	s.PostStop = append(s.PostStop, other.PostStop...)
 	s.LogTargets = appendUnique(s.LogTargets, other.LogTargets...)
 }
 
@@ -266,13 +382,29 @@ type Check struct {
 	Level    CheckLevel `yaml:"level,omitempty"`
 
 	// Common check settings
-	Period    OptionalDuration `yaml:"period,omitempty"`
-	Timeout   OptionalDuration `yaml:"timeout,omitempty"`
-	Threshold int              `yaml:"threshold,omitempty"`
+	Period       OptionalDuration `yaml:"period,omitempty"`
+	Timeout      OptionalDuration `yaml:"timeout,omitempty"`
+	Threshold    int              `yaml:"threshold,omitempty"`
+	StartupDelay OptionalDuration `yaml:"startup-delay,omitempty"`
+
+	// SuccessThreshold is the number of successes in a row needed for a
+	// down (or not yet up) check to be considered up. Zero means one.
+	SuccessThreshold int `yaml:"success-threshold,omitempty"`
+
+	// Flap detection: if FlapThreshold is set, a warning is raised when
+	// the check goes up or down that many times within FlapWindow.
+	FlapThreshold int              `yaml:"flap-threshold,omitempty"`
+	FlapWindow    OptionalDuration `yaml:"flap-window,omitempty"`
+
+	// Service is the name of the service this check probes, if any. The
+	// check only runs while that service is active.
+	Service string `yaml:"service,omitempty"`
 
 	// Type-specific check settings (only one of these can be set)
 	HTTP *HTTPCheck `yaml:"http,omitempty"`
 	TCP  *TCPCheck  `yaml:"tcp,omitempty"`
+	Unix *UnixCheck `yaml:"unix,omitempty"`
+	UDP  *UDPCheck  `yaml:"udp,omitempty"`
 	Exec *ExecCheck `yaml:"exec,omitempty"`
 }
 
@@ -285,6 +417,12 @@ func (c *Check) Copy() *Check {
 	if c.TCP != nil {
 		copied.TCP = c.TCP.Copy()
 	}
+	if c.Unix != nil {
+		copied.Unix = c.Unix.Copy()
+	}
+	if c.UDP != nil {
+		copied.UDP = c.UDP.Copy()
+	}
 	if c.Exec != nil {
 		copied.Exec = c.Exec.Copy()
 	}
@@ -305,6 +443,21 @@ func (c *Check) Merge(other *Check) {
 	if other.Threshold != 0 {
 		c.Threshold = other.Threshold
 	}
+	if other.StartupDelay.IsSet {
+		c.StartupDelay = other.StartupDelay
+	}
+	if other.SuccessThreshold != 0 {
+		c.SuccessThreshold = other.SuccessThreshold
+	}
+	if other.FlapThreshold != 0 {
+		c.FlapThreshold = other.FlapThreshold
+	}
+	if other.FlapWindow.IsSet {
+		c.FlapWindow = other.FlapWindow
+	}
+	if other.Service != "" {
+		c.Service = other.Service
+	}
 	if other.HTTP != nil {
 		if c.HTTP == nil {
 			c.HTTP = &HTTPCheck{}
@@ -317,6 +470,18 @@ func (c *Check) Merge(other *Check) {
 		}
 		c.TCP.Merge(other.TCP)
 	}
+	if other.Unix != nil {
+		if c.Unix == nil {
+			c.Unix = &UnixCheck{}
+		}
+		c.Unix.Merge(other.Unix)
+	}
+	if other.UDP != nil {
+		if c.UDP == nil {
+			c.UDP = &UDPCheck{}
+		}
+		c.UDP.Merge(other.UDP)
+	}
 	if other.Exec != nil {
 		if c.Exec == nil {
 			c.Exec = &ExecCheck{}
@@ -337,7 +502,23 @@ const (
 // HTTPCheck holds the configuration for an HTTP health check.
 type HTTPCheck struct {
 	URL     string            `yaml:"url,omitempty"`
+	Method  string            `yaml:"method,omitempty"`
 	Headers map[string]string `yaml:"headers,omitempty"`
+	Body    string            `yaml:"body,omitempty"`
+
+	// Expected response: status codes or ranges such as "200-299" (any 2xx
+	// status if not set), a regular expression the body must match, and
+	// values the JSON body must have at the given JSON paths.
+	ExpectStatus []string          `yaml:"expect-status,omitempty"`
+	ExpectBody   string            `yaml:"expect-body,omitempty"`
+	ExpectJSON   map[string]string `yaml:"expect-json,omitempty"`
+
+	// TLS and connection options.
+	CAFile             string `yaml:"ca-file,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure-skip-verify,omitempty"`
+	ClientCert         string `yaml:"client-cert,omitempty"`
+	ClientKey          string `yaml:"client-key,omitempty"`
+	SocketPath         string `yaml:"socket-path,omitempty"`
 }
 
 // Copy returns a deep copy of the HTTP check configuration.
@@ -349,6 +530,13 @@ func (c *HTTPCheck) Copy() *HTTPCheck {
 			copied.Headers[k] = v
 		}
 	}
+	copied.ExpectStatus = append([]string(nil), c.ExpectStatus...)
+	if c.ExpectJSON != nil {
+		copied.ExpectJSON = make(map[string]string, len(c.ExpectJSON))
+		for k, v := range c.ExpectJSON {
+			copied.ExpectJSON[k] = v
+		}
+	}
 	return &copied
 }
 
@@ -357,18 +545,112 @@ func (c *HTTPCheck) Merge(other *HTTPCheck) {
 	if other.URL != "" {
 		c.URL = other.URL
 	}
+	if other.Method != "" {
+		c.Method = other.Method
+	}
 	for k, v := range other.Headers {
 		if c.Headers == nil {
 			c.Headers = make(map[string]string)
 		}
 		c.Headers[k] = v
 	}
+	if other.Body != "" {
+		c.Body = other.Body
+	}
+	if len(other.ExpectStatus) > 0 {
+		c.ExpectStatus = append([]string(nil), other.ExpectStatus...)
+	}
+	if other.ExpectBody != "" {
+		c.ExpectBody = other.ExpectBody
+	}
+	for k, v := range other.ExpectJSON {
+		if c.ExpectJSON == nil {
+			c.ExpectJSON = make(map[string]string)
+		}
+		c.ExpectJSON[k] = v
+	}
+	if other.CAFile != "" {
+		c.CAFile = other.CAFile
+	}
+	if other.InsecureSkipVerify {
+		c.InsecureSkipVerify = true
+	}
+	if other.ClientCert != "" {
+		c.ClientCert = other.ClientCert
+	}
+	if other.ClientKey != "" {
+		c.ClientKey = other.ClientKey
+	}
+	if other.SocketPath != "" {
+		c.SocketPath = other.SocketPath
+	}
 }
 
-// TCPCheck holds the configuration for an HTTP health check.
+func (c *HTTPCheck) validate() error {
+	if c.Method != "" && !httpMethodRegexp.MatchString(c.Method) {
+		return fmt.Errorf("method %q invalid", c.Method)
+	}
+	if _, err := ParseStatusRanges(c.ExpectStatus); err != nil {
+		return fmt.Errorf("expect-status invalid: %v", err)
+	}
+	if _, err := regexp.Compile(c.ExpectBody); err != nil {
+		return fmt.Errorf("expect-body invalid: %v", err)
+	}
+	for path := range c.ExpectJSON {
+		if _, err := jsonpath.Parse(path); err != nil {
+			return fmt.Errorf("expect-json invalid: %v", err)
+		}
+	}
+	if (c.ClientCert == "") != (c.ClientKey == "") {
+		return fmt.Errorf(`must set both "client-cert" and "client-key", or neither`)
+	}
+	if c.SocketPath != "" && !filepath.IsAbs(c.SocketPath) {
+		return fmt.Errorf("socket-path %q must be absolute", c.SocketPath)
+	}
+	return nil
+}
+
+// httpMethodRegexp matches valid (upper case) HTTP methods.
+var httpMethodRegexp = regexp.MustCompile(`^[A-Z]+$`)
+
+// StatusRange is an inclusive range of HTTP status codes.
+type StatusRange struct {
+	Min, Max int
+}
+
+// Contains reports whether the status code is in the range.
+func (r StatusRange) Contains(status int) bool {
+	return status >= r.Min && status <= r.Max
+}
+
+// ParseStatusRanges parses a list of HTTP status codes (such as "200") and
+// inclusive ranges of status codes (such as "200-299").
+func ParseStatusRanges(specs []string) ([]StatusRange, error) {
+	ranges := make([]StatusRange, 0, len(specs))
+	for _, spec := range specs {
+		minStr, maxStr := spec, spec
+		if i := strings.IndexByte(spec, '-'); i >= 0 {
+			minStr, maxStr = spec[:i], spec[i+1:]
+		}
+		min, err1 := strconv.Atoi(strings.TrimSpace(minStr))
+		max, err2 := strconv.Atoi(strings.TrimSpace(maxStr))
+		if err1 != nil || err2 != nil || min < 100 || max > 599 || min > max {
+			return nil, fmt.Errorf("%q is not a status code or range of status codes", spec)
+		}
+		ranges = append(ranges, StatusRange{Min: min, Max: max})
+	}
+	return ranges, nil
+}
+
+// TCPCheck holds the configuration for a TCP health check.
 type TCPCheck struct {
 	Port int    `yaml:"port,omitempty"`
 	Host string `yaml:"host,omitempty"`
+
+	// Optional payload to send once connected, and regular expression the
+	// reply must match.
+	Send   string `yaml:"send,omitempty"`
+	Expect string `yaml:"expect,omitempty"`
 }
 
 // Copy returns a deep copy of the TCP check configuration.
@@ -385,6 +667,68 @@ func (c *TCPCheck) Merge(other *TCPCheck) {
 	if other.Host != "" {
 		c.Host = other.Host
 	}
+	if other.Send != "" {
+		c.Send = other.Send
+	}
+	if other.Expect != "" {
+		c.Expect = other.Expect
+	}
+}
+
+// UnixCheck holds the configuration for a unix (stream) socket health check.
+type UnixCheck struct {
+	Path   string `yaml:"path,omitempty"`
+	Send   string `yaml:"send,omitempty"`
+	Expect string `yaml:"expect,omitempty"`
+}
+
+// Copy returns a deep copy of the unix check configuration.
+func (c *UnixCheck) Copy() *UnixCheck {
+	copied := *c
+	return &copied
+}
+
+// Merge merges the fields set in other into c.
+func (c *UnixCheck) Merge(other *UnixCheck) {
+	if other.Path != "" {
+		c.Path = other.Path
+	}
+	if other.Send != "" {
+		c.Send = other.Send
+	}
+	if other.Expect != "" {
+		c.Expect = other.Expect
+	}
+}
+
+// UDPCheck holds the configuration for a UDP request/response health check.
+type UDPCheck struct {
+	Port   int    `yaml:"port,omitempty"`
+	Host   string `yaml:"host,omitempty"`
+	Send   string `yaml:"send,omitempty"`
+	Expect string `yaml:"expect,omitempty"`
+}
+
+// Copy returns a deep copy of the UDP check configuration.
+func (c *UDPCheck) Copy() *UDPCheck {
+	copied := *c
+	return &copied
+}
+
+// Merge merges the fields set in other into c.
+func (c *UDPCheck) Merge(other *UDPCheck) {
+	if other.Port != 0 {
+		c.Port = other.Port
+	}
+	if other.Host != "" {
+		c.Host = other.Host
+	}
+	if other.Send != "" {
+		c.Send = other.Send
+	}
+	if other.Expect != "" {
+		c.Expect = other.Expect
+	}
 }
 
 // ExecCheck holds the configuration for an exec health check.
@@ -396,6 +740,8 @@ type ExecCheck struct {
 	GroupID     *int              `yaml:"group-id,omitempty"`
 	Group       string            `yaml:"group,omitempty"`
 	WorkingDir  string            `yaml:"working-dir,omitempty"`
+
+	Sandbox `yaml:",inline"`
 }
 
 // Copy returns a deep copy of the exec check configuration.
@@ -415,6 +761,7 @@ func (c *ExecCheck) Copy() *ExecCheck {
 		groupID := *c.GroupID
 		copied.GroupID = &groupID
 	}
+	copied.Sandbox = *c.Sandbox.Copy()
 	return &copied
 }
 
@@ -446,6 +793,122 @@ func (c *ExecCheck) Merge(other *ExecCheck) {
 	if other.WorkingDir != "" {
 		c.WorkingDir = other.WorkingDir
 	}
+	c.Sandbox.Merge(&other.Sandbox)
+}
+
+func (s *Service) validateLimits() error {
+	if s.MemoryMax != "" {
+		if _, err := ParseMemory(s.MemoryMax); err != nil {
+			return fmt.Errorf("memory-max invalid: %v", err)
+		}
+	}
+	if s.CPUWeight < 0 || s.CPUWeight > 10000 {
+		return fmt.Errorf("cpu-weight must be between 1 and 10000")
+	}
+	if s.CPUMax != "" {
+		if _, err := ParseCPUMax(s.CPUMax); err != nil {
+			return fmt.Errorf("cpu-max invalid: %v", err)
+		}
+	}
+	if s.PidsMax < 0 {
+		return fmt.Errorf("pids-max must not be negative")
+	}
+	return nil
+}
+
+// ParseMemory parses a memory size in bytes, optionally with a K, M, G or T
+// suffix (powers of 1024), for example "512M".
+func ParseMemory(s string) (uint64, error) {
+	multiplier := uint64(1)
+	number := s
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K', 'k':
+			multiplier = 1 << 10
+		case 'M', 'm':
+			multiplier = 1 << 20
+		case 'G', 'g':
+			multiplier = 1 << 30
+		case 'T', 't':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			number = s[:n-1]
+		}
+	}
+	value, err := strconv.ParseUint(number, 10, 64)
+	if err != nil || value == 0 {
+		return 0, fmt.Errorf("invalid memory size %q", s)
+	}
+	return value * multiplier, nil
+}
+
+// ParseCPUMax parses a CPU limit given as a percentage of one CPU, for
+// example "50%" or "200%" (two CPUs).
+func ParseCPUMax(s string) (int, error) {
+	if !strings.HasSuffix(s, "%") {
+		return 0, fmt.Errorf("invalid CPU limit %q: must be a percentage", s)
+	}
+	value, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid CPU limit %q", s)
+	}
+	return value, nil
+}
+
+// Sandbox holds the options restricting the privileges of a service's or
+// exec check's process.
+type Sandbox struct {
+	NoNewPrivileges       bool     `yaml:"no-new-privileges,omitempty"`
+	SupplementaryGroups   []string `yaml:"supplementary-groups,omitempty"`
+	CapabilityBoundingSet []string `yaml:"capability-bounding-set,omitempty"`
+	AmbientCapabilities   []string `yaml:"ambient-capabilities,omitempty"`
+	RootDirectory         string   `yaml:"root-directory,omitempty"`
+	PrivateTmp            bool     `yaml:"private-tmp,omitempty"`
+}
+
+// Copy returns a deep copy of the sandbox options.
+func (s *Sandbox) Copy() *Sandbox {
+	copied := *s
+	copied.SupplementaryGroups = append([]string(nil), s.SupplementaryGroups...)
+	copied.CapabilityBoundingSet = append([]string(nil), s.CapabilityBoundingSet...)
+	copied.AmbientCapabilities = append([]string(nil), s.AmbientCapabilities...)
+	return &copied
+}
+
+// Merge merges the fields set in other into s.
+func (s *Sandbox) Merge(other *Sandbox) {
+	if other.NoNewPrivileges {
+		s.NoNewPrivileges = true
+	}
+	s.SupplementaryGroups = appendUnique(s.SupplementaryGroups, other.SupplementaryGroups...)
+	s.CapabilityBoundingSet = appendUnique(s.CapabilityBoundingSet, other.CapabilityBoundingSet...)
+	s.AmbientCapabilities = appendUnique(s.AmbientCapabilities, other.AmbientCapabilities...)
+	if other.RootDirectory != "" {
+		s.RootDirectory = other.RootDirectory
+	}
+	if other.PrivateTmp {
+		s.PrivateTmp = true
+	}
+}
+
+// Validate checks that the sandbox options are valid.
+func (s *Sandbox) Validate() error {
+	if _, err := osutil.ParseCapabilities(s.CapabilityBoundingSet); err != nil {
+		return fmt.Errorf("capability-bounding-set invalid: %v", err)
+	}
+	if _, err := osutil.ParseCapabilities(s.AmbientCapabilities); err != nil {
+		return fmt.Errorf("ambient-capabilities invalid: %v", err)
+	}
+	if s.RootDirectory != "" && !filepath.IsAbs(s.RootDirectory) {
+		return fmt.Errorf("root-directory %q must be absolute", s.RootDirectory)
+	}
+	for _, group := range s.SupplementaryGroups {
+		if group == "" {
+			return fmt.Errorf("supplementary-groups must not contain empty names")
+		}
+	}
+	return nil
 }
 
 // LogTarget specifies a remote server to forward logs to.
@@ -495,6 +958,118 @@ func (t *LogTarget) Merge(other *LogTarget) {
 	}
 }
 
+// NotifyTarget specifies where to send notifications about events such as a
+// check going down or a service exiting.
+type NotifyTarget struct {
+	Name     string           `yaml:"-"`
+	Override Override         `yaml:"override,omitempty"`
+	Type     NotifyTargetType `yaml:"type"`
+
+	// Location is the URL that webhook targets POST notifications to.
+	Location string `yaml:"location,omitempty"`
+
+	// Command is the command that exec targets run for each notification,
+	// with the notification as JSON on its standard input.
+	Command string `yaml:"command,omitempty"`
+
+	// Events are the events to notify about. If not set, the target is
+	// notified about all events.
+	Events []NotifyEvent `yaml:"events,omitempty"`
+
+	// Retries is the number of times to retry a notification that fails. It's
+	// a pointer so that zero (no retries) can be told apart from unset.
+	Retries *int `yaml:"retries,omitempty"`
+
+	// RateLimit is the maximum number of notifications sent per
+	// RateLimitPeriod, or zero for no limit. Notifications over the limit
+	// are dropped.
+	RateLimit       int              `yaml:"rate-limit,omitempty"`
+	RateLimitPeriod OptionalDuration `yaml:"rate-limit-period,omitempty"`
+}
+
+// NotifyTargetType defines how notifications are sent.
+type NotifyTargetType string
+
+const (
+	WebhookTarget     NotifyTargetType = "webhook"
+	ExecTarget        NotifyTargetType = "exec"
+	UnsetNotifyTarget NotifyTargetType = ""
+)
+
+// NotifyEvent is the kind of an event that notify targets can be notified
+// about.
+type NotifyEvent string
+
+const (
+	CheckDownEvent      NotifyEvent = "check-down"
+	CheckUpEvent        NotifyEvent = "check-up"
+	ServiceExitEvent    NotifyEvent = "service-exit"
+	ServiceBackoffEvent NotifyEvent = "service-backoff"
+	ChangeErrorEvent    NotifyEvent = "change-error"
+)
+
+// NotifyEvents are all the events that notify targets can be notified about.
+var NotifyEvents = []NotifyEvent{
+	CheckDownEvent,
+	CheckUpEvent,
+	ServiceExitEvent,
+	ServiceBackoffEvent,
+	ChangeErrorEvent,
+}
+
+// Copy returns a deep copy of the notify target configuration.
+func (t *NotifyTarget) Copy() *NotifyTarget {
+	copied := *t
+	copied.Events = append([]NotifyEvent(nil), t.Events...)
+	if t.Retries != nil {
+		retries := *t.Retries
+		copied.Retries = &retries
+	}
+	return &copied
+}
+
+// Merge merges the fields set in other into t.
+func (t *NotifyTarget) Merge(other *NotifyTarget) {
+	if other.Type != "" {
+		t.Type = other.Type
+	}
+	if other.Location != "" {
+		t.Location = other.Location
+	}
+	if other.Command != "" {
+		t.Command = other.Command
+	}
+	for _, event := range other.Events {
+		if !containsNotifyEvent(t.Events, event) {
+			t.Events = append(t.Events, event)
+		}
+	}
+	if other.Retries != nil {
+		retries := *other.Retries
+		t.Retries = &retries
+	}
+	if other.RateLimit != 0 {
+		t.RateLimit = other.RateLimit
+	}
+	if other.RateLimitPeriod.IsSet {
+		t.RateLimitPeriod = other.RateLimitPeriod
+	}
+}
+
+// NotifiesOf reports whether the target is notified about the given event.
+func (t *NotifyTarget) NotifiesOf(event NotifyEvent) bool {
+	return len(t.Events) == 0 || containsNotifyEvent(t.Events, event)
+}
+
+func containsNotifyEvent(events []NotifyEvent, event NotifyEvent) bool {
+	for _, e := range events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
 // FormatError is the error returned when a layer has a format error, such as
 // a missing "override" field.
 type FormatError struct {
@@ -594,6 +1169,47 @@ func CombineLayers(layers ...*Layer) (*Layer, error) {
 				}
 			}
 		}
+
+		for name, target := range layer.NotifyTargets {
+			if combined.NotifyTargets == nil {
+				combined.NotifyTargets = make(map[string]*NotifyTarget)
+			}
+			switch target.Override {
+			case MergeOverride:
+				if old, ok := combined.NotifyTargets[name]; ok {
+					copied := old.Copy()
+					copied.Merge(target)
+					combined.NotifyTargets[name] = copied
+					break
+				}
+				fallthrough
+			case ReplaceOverride:
+				combined.NotifyTargets[name] = target.Copy()
+			case UnknownOverride:
+				return nil, &FormatError{
+					Message: fmt.Sprintf(`layer %q must define "override" for notify target %q`,
+						layer.Label, target.Name),
+				}
+			default:
+				return nil, &FormatError{
+					Message: fmt.Sprintf(`layer %q has invalid "override" value for notify target %q`,
+						layer.Label, target.Name),
+				}
+			}
+		}
+	}
+
+	// Template services are kept as they are in the combined layer (and the
+	// plan), and only expanded into their instances when the plan is used
+	// (see Plan.ExpandedServices), but the instances are validated here.
+	err := validateTemplates(combined.Services)
+	if err != nil {
+		return nil, err
+	}
+
+	err = combineGroups(layers, combined)
+	if err != nil {
+		return nil, err
 	}
 
 	// Ensure fields in combined layers validate correctly (and set defaults).
@@ -639,7 +1255,95 @@ func CombineLayers(layers ...*Layer) (*Layer, error) {
 		if !service.BackoffLimit.IsSet {
 			service.BackoffLimit.Value = defaultBackoffLimit
 		}
-
+		if service.WaitForChecksTimeout.IsSet && service.WaitForChecksTimeout.Value == 0 {
+			return nil, &FormatError{
+				Message: fmt.Sprintf("plan service %q wait-for-checks-timeout must not be zero", name),
+			}
+		}
+		if err := service.Sandbox.Validate(); err != nil {
+			return nil, &FormatError{
+				Message: fmt.Sprintf("plan service %q %v", name, err),
+			}
+		}
+		if err := service.validateLimits(); err != nil {
+			return nil, &FormatError{
+				Message: fmt.Sprintf("plan service %q %v", name, err),
+			}
+		}
+		for _, path := range service.EnvironmentFiles {
+			if !filepath.IsAbs(path) {
+				return nil, &FormatError{
+					Message: fmt.Sprintf("plan service %q environment-files path %q must be absolute", name, path),
+				}
+			}
+		}
+		if service.RestartLimit < 0 {
+			return nil, &FormatError{
+				Message: fmt.Sprintf("plan service %q restart-limit must not be negative", name),
+			}
+		}
+		if service.RestartLimitWindow.IsSet && service.RestartLimitWindow.Value == 0 {
+			return nil, &FormatError{
+				Message: fmt.Sprintf("plan service %q restart-limit-window must not be zero", name),
+			}
+		}
+		switch service.OnRestartLimit {
+		case ActionUnset, ActionIgnore, ActionShutdown:
+		default:
+			return nil, &FormatError{
+				Message: fmt.Sprintf(`plan service %q on-restart-limit action must be "ignore" or "shutdown"`, name),
+			}
+		}
+		if service.StopSignal != "" && unix.SignalNum(service.StopSignal) == 0 {
+			return nil, &FormatError{
+				Message: fmt.Sprintf("plan service %q stop-signal %q invalid", name, service.StopSignal),
+			}
+		}
+		if service.ReloadSignal != "" && unix.SignalNum(service.ReloadSignal) == 0 {
+			return nil, &FormatError{
+				Message: fmt.Sprintf("plan service %q reload-signal %q invalid", name, service.ReloadSignal),
+			}
+		}
+		if service.ReloadCommand != "" {
+			if service.ReloadSignal != "" {
+				return nil, &FormatError{
+					Message: fmt.Sprintf("plan service %q cannot have both reload-signal and reload-command", name),
+				}
+			}
+			args, err := shlex.Split(service.ReloadCommand)
+			if err != nil {
+				return nil, &FormatError{
+					Message: fmt.Sprintf("plan service %q reload-command invalid: %v", name, err),
+				}
+			}
+			if len(args) == 0 {
+				return nil, &FormatError{
+					Message: fmt.Sprintf("plan service %q reload-command must not be empty", name),
+				}
+			}
+		}
+		for _, hook := range []struct {
+			name     string
+			commands []string
+		}{
+			{"pre-start", service.PreStart},
+			{"post-start", service.PostStart},
+			{"post-stop", service.PostStop},
+		} {
+			for _, command := range hook.commands {
+				args, err := shlex.Split(command)
+				if err == nil && len(args) == 0 {
+					return nil, &FormatError{
+						Message: fmt.Sprintf("plan service %q %s command must not be empty", name, hook.name),
+					}
+				}
+				if err != nil {
+					return nil, &FormatError{
+						Message: fmt.Sprintf("plan service %q %s command invalid: %v", name, hook.name, err),
+					}
+				}
+			}
+		}
 	}
 
 	for name, check := range combined.Checks {
@@ -672,6 +1376,26 @@ func CombineLayers(layers ...*Layer) (*Layer, error) {
 			// what it's worth, Kubernetes probes uses a default of 3 too.
 			check.Threshold = defaultCheckThreshold
 		}
+		if check.StartupDelay.Value < 0 {
+			return nil, &FormatError{
+				Message: fmt.Sprintf("plan check %q startup-delay must not be negative", name),
+			}
+		}
+		if check.SuccessThreshold < 0 {
+			return nil, &FormatError{
+				Message: fmt.Sprintf("plan check %q success-threshold must not be negative", name),
+			}
+		}
+		if check.FlapThreshold < 0 {
+			return nil, &FormatError{
+				Message: fmt.Sprintf("plan check %q flap-threshold must not be negative", name),
+			}
+		}
+		if check.FlapWindow.IsSet && check.FlapWindow.Value <= 0 {
+			return nil, &FormatError{
+				Message: fmt.Sprintf("plan check %q flap-window must be positive", name),
+			}
+		}
 
 		numTypes := 0
 		if check.HTTP != nil {
@@ -680,6 +1404,11 @@ func CombineLayers(layers ...*Layer) (*Layer, error) {
 					Message: fmt.Sprintf(`plan must set "url" for http check %q`, name),
 				}
 			}
+			if err := check.HTTP.validate(); err != nil {
+				return nil, &FormatError{
+					Message: fmt.Sprintf("plan check %q %v", name, err),
+				}
+			}
 			numTypes++
 		}
 		if check.TCP != nil {
@@ -688,6 +1417,47 @@ func CombineLayers(layers ...*Layer) (*Layer, error) {
 					Message: fmt.Sprintf(`plan must set "port" for tcp check %q`, name),
 				}
 			}
+			if _, err := regexp.Compile(check.TCP.Expect); err != nil {
+				return nil, &FormatError{
+					Message: fmt.Sprintf("plan check %q expect invalid: %v", name, err),
+				}
+			}
+			numTypes++
+		}
+		if check.Unix != nil {
+			if check.Unix.Path == "" {
+				return nil, &FormatError{
+					Message: fmt.Sprintf(`plan must set "path" for unix check %q`, name),
+				}
+			}
+			if !filepath.IsAbs(check.Unix.Path) {
+				return nil, &FormatError{
+					Message: fmt.Sprintf("plan check %q path %q must be absolute", name, check.Unix.Path),
+				}
+			}
+			if _, err := regexp.Compile(check.Unix.Expect); err != nil {
+				return nil, &FormatError{
+					Message: fmt.Sprintf("plan check %q expect invalid: %v", name, err),
+				}
+			}
+			numTypes++
+		}
+		if check.UDP != nil {
+			if check.UDP.Port == 0 {
+				return nil, &FormatError{
+					Message: fmt.Sprintf(`plan must set "port" for udp check %q`, name),
+				}
+			}
+			if check.UDP.Send == "" {
+				return nil, &FormatError{
+					Message: fmt.Sprintf(`plan must set "send" for udp check %q`, name),
+				}
+			}
+			if _, err := regexp.Compile(check.UDP.Expect); err != nil {
+				return nil, &FormatError{
+					Message: fmt.Sprintf("plan check %q expect invalid: %v", name, err),
+				}
+			}
 			numTypes++
 		}
 		if check.Exec != nil {
@@ -708,11 +1478,16 @@ func CombineLayers(layers ...*Layer) (*Layer, error) {
 					Message: fmt.Sprintf("plan check %q has invalid user/group: %v", name, err),
 				}
 			}
+			if err := check.Exec.Sandbox.Validate(); err != nil {
+				return nil, &FormatError{
+					Message: fmt.Sprintf("plan check %q %v", name, err),
+				}
+			}
 			numTypes++
 		}
 		if numTypes != 1 {
 			return nil, &FormatError{
-				Message: fmt.Sprintf(`plan must specify one of "http", "tcp", or "exec" for check %q`, name),
+				Message: fmt.Sprintf(`plan must specify one of "http", "tcp", "unix", "udp", or "exec" for check %q`, name),
 			}
 		}
 	}
@@ -750,6 +1525,76 @@ func CombineLayers(layers ...*Layer) (*Layer, error) {
 		}
 	}
 
+	for name, target := range combined.NotifyTargets {
+		switch target.Type {
+		case WebhookTarget:
+			u, err := url.Parse(target.Location)
+			if target.Location == "" {
+				return nil, &FormatError{
+					Message: fmt.Sprintf(`plan must define "location" for webhook notify target %q`, name),
+				}
+			} else if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return nil, &FormatError{
+					Message: fmt.Sprintf(`notify target %q location must be an HTTP or HTTPS URL`, name),
+				}
+			}
+		case ExecTarget:
+			if target.Command == "" {
+				return nil, &FormatError{
+					Message: fmt.Sprintf(`plan must define "command" for exec notify target %q`, name),
+				}
+			}
+			args, err := shlex.Split(target.Command)
+			if err != nil {
+				return nil, &FormatError{
+					Message: fmt.Sprintf("plan notify target %q command invalid: %v", name, err),
+				}
+			}
+			if len(args) == 0 {
+				return nil, &FormatError{
+					Message: fmt.Sprintf("plan notify target %q command must not be empty", name),
+				}
+			}
+		case UnsetNotifyTarget:
+			return nil, &FormatError{
+				Message: fmt.Sprintf(`plan must define "type" (%q or %q) for notify target %q`,
+					WebhookTarget, ExecTarget, name),
+			}
+		default:
+			return nil, &FormatError{
+				Message: fmt.Sprintf(`notify target %q has unsupported type %q, must be %q or %q`,
+					name, target.Type, WebhookTarget, ExecTarget),
+			}
+		}
+		for _, event := range target.Events {
+			if !validNotifyEvent(event) {
+				return nil, &FormatError{
+					Message: fmt.Sprintf(`notify target %q has unknown event %q`, name, event),
+				}
+			}
+		}
+		if target.Retries == nil {
+			retries := defaultNotifyRetries
+			target.Retries = &retries
+		} else if *target.Retries < 0 {
+			return nil, &FormatError{
+				Message: fmt.Sprintf("plan notify target %q retries must not be negative", name),
+			}
+		}
+		if target.RateLimit < 0 {
+			return nil, &FormatError{
+				Message: fmt.Sprintf("plan notify target %q rate-limit must not be negative", name),
+			}
+		}
+		if !target.RateLimitPeriod.IsSet {
+			target.RateLimitPeriod.Value = defaultNotifyRateLimitPeriod
+		} else if target.RateLimitPeriod.Value <= 0 {
+			return nil, &FormatError{
+				Message: fmt.Sprintf("plan notify target %q rate-limit-period must be positive", name),
+			}
+		}
+	}
+
 	// Validate service log targets
 	for serviceName, service := range combined.Services {
 		for _, targetName := range service.LogTargets {
@@ -762,8 +1607,40 @@ func CombineLayers(layers ...*Layer) (*Layer, error) {
 		}
 	}
 
+	// Validate checks the services wait for
+	for serviceName, service := range combined.Services {
+		for _, checkName := range service.WaitForChecks {
+			_, ok := combined.Checks[checkName]
+			if !ok {
+				return nil, &FormatError{
+					Message: fmt.Sprintf(`unknown check %q in wait-for-checks for service %q`, checkName, serviceName),
+				}
+			}
+		}
+	}
+
+	// Validate the services checks are bound to. A service can't wait for a
+	// check that's bound to it, as the check won't run until it's started.
+	services := expandTemplates(combined.Services)
+	for checkName, check := range combined.Checks {
+		if check.Service == "" {
+			continue
+		}
+		service, ok := services[check.Service]
+		if !ok {
+			return nil, &FormatError{
+				Message: fmt.Sprintf(`unknown service %q for check %q`, check.Service, checkName),
+			}
+		}
+		if strutil.ListContains(service.WaitForChecks, checkName) {
+			return nil, &FormatError{
+				Message: fmt.Sprintf(`service %q cannot wait for check %q, which only runs while the service is active`, check.Service, checkName),
+			}
+		}
+	}
+
 	// Ensure combined layers don't have cycles.
-	err := combined.checkCycles()
+	err = combined.checkCycles()
 	if err != nil {
 		return nil, err
 	}
@@ -771,12 +1648,214 @@ func CombineLayers(layers ...*Layer) (*Layer, error) {
 	return combined, nil
 }
 
+// validateTemplates checks the template services (those whose name ends with
+// "@") and their instances. It also checks that only template services have
+// instances.
+func validateTemplates(services map[string]*Service) error {
+	for name, service := range services {
+		if !strings.HasSuffix(name, "@") {
+			if !service.Instances.IsZero() {
+				return &FormatError{
+					Message: fmt.Sprintf(`plan service %q cannot have "instances" as it is not a template (name must end with "@")`, name),
+				}
+			}
+			continue
+		}
+		if service.Instances.IsZero() {
+			return &FormatError{
+				Message: fmt.Sprintf(`plan template service %q must specify "instances"`, name),
+			}
+		}
+		if service.Instances.Names == nil && service.Instances.Count < 1 {
+			return &FormatError{
+				Message: fmt.Sprintf("plan template service %q must have at least one instance", name),
+			}
+		}
+		seen := make(map[string]bool)
+		for _, instance := range service.Instances.List() {
+			if instance == "" || strings.ContainsAny(instance, "@ \t\n") {
+				return &FormatError{
+					Message: fmt.Sprintf("plan template service %q has invalid instance name %q", name, instance),
+				}
+			}
+			if seen[instance] {
+				return &FormatError{
+					Message: fmt.Sprintf("plan template service %q has duplicate instance name %q", name, instance),
+				}
+			}
+			seen[instance] = true
+			_, err := shlex.Split(service.instance(instance).Command)
+			if err != nil {
+				return &FormatError{
+					Message: fmt.Sprintf("plan service %q command invalid: %v", name+instance, err),
+				}
+			}
+			if _, ok := services[name+instance]; ok {
+				return &FormatError{
+					Message: fmt.Sprintf("plan service %q conflicts with an instance of template service %q", name+instance, name),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// expandTemplates returns the given services with the template services
+// (those whose name ends with "@") replaced by their instances. The instance
+// name is substituted for "{{instance}}" and "$PEBBLE_INSTANCE" in each
+// instance's command and environment, and dependencies on a template service
+// are replaced by dependencies on all its instances. The given services
+// aren't modified.
+func expandTemplates(services map[string]*Service) map[string]*Service {
+	instances := make(map[string][]string)
+	for name, service := range services {
+		if !strings.HasSuffix(name, "@") {
+			continue
+		}
+		for _, instance := range service.Instances.List() {
+			instances[name] = append(instances[name], name+instance)
+		}
+	}
+	if len(instances) == 0 {
+		return services
+	}
+
+	expanded := make(map[string]*Service, len(services))
+	for name, service := range services {
+		if !strings.HasSuffix(name, "@") {
+			expanded[name] = service.Copy()
+		}
+	}
+	for template, names := range instances {
+		for _, name := range names {
+			if _, ok := expanded[name]; !ok {
+				expanded[name] = services[template].instance(strings.TrimPrefix(name, template))
+			}
+		}
+	}
+
+	expand := func(deps []string) []string {
+		var result []string
+		for _, dep := range deps {
+			if names, ok := instances[dep]; ok {
+				result = append(result, names...)
+			} else {
+				result = append(result, dep)
+			}
+		}
+		return result
+	}
+	for _, service := range expanded {
+		service.After = expand(service.After)
+		service.Before = expand(service.Before)
+		service.Requires = expand(service.Requires)
+	}
+	return expanded
+}
+
+// combineGroups sets the groups of the combined layer from the groups defined
+// in each layer and the "groups" field of each service, and validates them.
+// A group with "override: replace" discards the members listed by earlier
+// layers, but not the services that list the group in their "groups" field.
+func combineGroups(layers []*Layer, combined *Layer) error {
+	groups := make(map[string][]string)
+	overrides := make(map[string]Override)
+	for _, layer := range layers {
+		for name, group := range layer.Groups {
+			switch group.Override {
+			case MergeOverride:
+				groups[name] = appendUnique(groups[name], group.Services...)
+				if _, ok := overrides[name]; !ok {
+					overrides[name] = MergeOverride
+				}
+			case ReplaceOverride:
+				groups[name] = appendUnique(nil, group.Services...)
+				overrides[name] = ReplaceOverride
+			case UnknownOverride:
+				return &FormatError{
+					Message: fmt.Sprintf(`layer %q must define "override" for group %q`,
+						layer.Label, name),
+				}
+			default:
+				return &FormatError{
+					Message: fmt.Sprintf(`layer %q has invalid "override" value for group %q`,
+						layer.Label, name),
+				}
+			}
+		}
+	}
+	serviceNames := make([]string, 0, len(combined.Services))
+	for name := range combined.Services {
+		serviceNames = append(serviceNames, name)
+	}
+	sort.Strings(serviceNames)
+	for _, name := range serviceNames {
+		for _, group := range combined.Services[name].Groups {
+			groups[group] = appendUnique(groups[group], name)
+		}
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+
+	services := expandTemplates(combined.Services)
+	for name, members := range groups {
+		if name == "" {
+			return &FormatError{
+				Message: "cannot use empty string as group name",
+			}
+		}
+		_, isService := services[name]
+		expanded := expandServiceNames(combined.Services, nil, []string{name})
+		if isService || len(expanded) != 1 || expanded[0] != name {
+			return &FormatError{
+				Message: fmt.Sprintf("group %q conflicts with service of the same name", name),
+			}
+		}
+		for _, member := range members {
+			for _, service := range expandServiceNames(combined.Services, nil, []string{member}) {
+				if _, ok := services[service]; !ok {
+					return &FormatError{
+						Message: fmt.Sprintf("group %q member %q is not a service", name, member),
+					}
+				}
+			}
+		}
+	}
+	combined.Groups = make(map[string]*Group, len(groups))
+	for name, members := range groups {
+		override := overrides[name]
+		if override == UnknownOverride {
+			// Only defined by the services' "groups" fields.
+			override = MergeOverride
+		}
+		combined.Groups[name] = &Group{Override: override, Services: members}
+	}
+	return nil
+}
+
+var instanceVarRegexp = regexp.MustCompile(`{{instance}}|\$\{PEBBLE_INSTANCE\}|\$PEBBLE_INSTANCE\b`)
+
+// instance returns a copy of the template service s for the given instance.
+func (s *Service) instance(instance string) *Service {
+	copied := s.Copy()
+	copied.Name = s.Name + instance
+	copied.Instances = ServiceInstances{}
+	copied.Command = instanceVarRegexp.ReplaceAllLiteralString(s.Command, instance)
+	copied.Environment = make(map[string]string, len(s.Environment)+1)
+	for k, v := range s.Environment {
+		copied.Environment[k] = instanceVarRegexp.ReplaceAllLiteralString(v, instance)
+	}
+	copied.Environment["PEBBLE_INSTANCE"] = instance
+	return copied
+}
+
 // StartOrder returns the required services that must be started for the named
 // services to be properly started, in the order that they must be started.
 // An error is returned when a provided service name does not exist, or there
 // is an order cycle involving the provided service or its dependencies.
 func (p *Plan) StartOrder(names []string) ([]string, error) {
-	return order(p.Services, names, false)
+	return order(p.ExpandedServices(), p.ExpandServiceNames(names), false)
 }
 
 // StopOrder returns the required services that must be stopped for the named
@@ -784,7 +1863,7 @@ func (p *Plan) StartOrder(names []string) ([]string, error) {
 // An error is returned when a provided service name does not exist, or there
 // is an order cycle involving the provided service or its dependencies.
 func (p *Plan) StopOrder(names []string) ([]string, error) {
-	return order(p.Services, names, true)
+	return order(p.ExpandedServices(), p.ExpandServiceNames(names), true)
 }
 
 func order(services map[string]*Service, names []string, stop bool) ([]string, error) {
@@ -861,12 +1940,66 @@ func order(services map[string]*Service, names []string, stop bool) ([]string, e
 	return order, nil
 }
 
+// ExpandedServices returns the plan's services with each template service
+// replaced by its instances. Unlike the Services field, which holds the
+// services as they're defined in the layers, these are the services that are
+// actually run.
+func (p *Plan) ExpandedServices() map[string]*Service {
+	return expandTemplates(p.Services)
+}
+
+// ExpandServiceNames returns the given service names with the names of
+// groups replaced by the names of their members, and the names of template
+// services ("worker" or "worker@") replaced by the names of their instances
+// ("worker@1", "worker@2", and so on). Other names are returned unchanged.
+func (p *Plan) ExpandServiceNames(names []string) []string {
+	return expandServiceNames(p.Services, p.Groups, names)
+}
+
+func expandServiceNames(services map[string]*Service, groups map[string]*Group, names []string) []string {
+	var expanded []string
+	seen := make(map[string]bool)
+	add := func(names ...string) {
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				expanded = append(expanded, name)
+			}
+		}
+	}
+	for _, name := range names {
+		if _, ok := services[name]; ok && !strings.HasSuffix(name, "@") {
+			add(name)
+			continue
+		}
+		if group, ok := groups[name]; ok {
+			add(expandServiceNames(services, nil, group.Services)...)
+			continue
+		}
+		template := name
+		if !strings.HasSuffix(template, "@") {
+			template += "@"
+		}
+		if service, ok := services[template]; ok {
+			for _, instance := range service.Instances.List() {
+				add(template + instance)
+			}
+			continue
+		}
+		// Leave other names, including those of instances, for the caller
+		// to check.
+		add(name)
+	}
+	return expanded
+}
+
 func (l *Layer) checkCycles() error {
+	services := expandTemplates(l.Services)
 	var names []string
-	for name := range l.Services {
+	for name := range services {
 		names = append(names, name)
 	}
-	_, err := order(l.Services, names, false)
+	_, err := order(services, names, false)
 	return err
 }
 
@@ -936,6 +2069,20 @@ func ParseLayer(order int, label string, data []byte) (*Layer, error) {
 		target.Name = name
 	}
 
+	for name, target := range layer.NotifyTargets {
+		if name == "" {
+			return nil, &FormatError{
+				Message: fmt.Sprintf("cannot use empty string as notify target name"),
+			}
+		}
+		if target == nil {
+			return nil, &FormatError{
+				Message: fmt.Sprintf("notify target object cannot be null for notify target %q", name),
+			}
+		}
+		target.Name = name
+	}
+
 	err = layer.checkCycles()
 	if err != nil {
 		return nil, err
@@ -943,6 +2090,10 @@ func ParseLayer(order int, label string, data []byte) (*Layer, error) {
 	return &layer, err
 }
 
+func validNotifyEvent(event NotifyEvent) bool {
+	return containsNotifyEvent(NotifyEvents, event)
+}
+
 func validServiceAction(action ServiceAction) bool {
 	switch action {
 	case ActionUnset, ActionRestart, ActionShutdown, ActionIgnore:
@@ -954,7 +2105,9 @@ func validServiceAction(action ServiceAction) bool {
 
 var fnameExp = regexp.MustCompile("^([0-9]{3})-([a-z](?:-?[a-z0-9]){2,}).yaml$")
 
-func ReadLayersDir(dirname string) ([]*Layer, error) {
+// ReadLayersDir reads the configuration layers from the files in dirname. If
+// trustedKeys is not empty, each layer must be signed by one of the keys.
+func ReadLayersDir(dirname string, trustedKeys []ed25519.PublicKey) ([]*Layer, error) {
 	finfos, err := ioutil.ReadDir(dirname)
 	if err != nil {
 		// Errors from package os generally include the path.
@@ -985,6 +2138,12 @@ func ReadLayersDir(dirname string) ([]*Layer, error) {
 			return nil, fmt.Errorf("cannot read layer file: %v", err)
 		}
 		label := match[2]
+		if len(trustedKeys) > 0 {
+			err := VerifyLayer(data, label, false, trustedKeys)
+			if err != nil {
+				return nil, fmt.Errorf("cannot verify layer file %q: %v", finfo.Name(), err)
+			}
+		}
 		order, err := strconv.Atoi(match[1])
 		if err != nil {
 			panic(fmt.Sprintf("internal error: filename regexp is wrong: %v", err))
@@ -1015,7 +2174,8 @@ func ReadLayersDir(dirname string) ([]*Layer, error) {
 
 // ReadDir reads the configuration layers from the "layers" sub-directory in
 // dir, and returns the resulting Plan. If the "layers" sub-directory doesn't
-// exist, it returns a valid Plan with no layers.
+// exist, it returns a valid Plan with no layers. If there are keys in the
+// "trusted-keys" sub-directory, the layers must be signed by one of them.
 func ReadDir(dir string) (*Plan, error) {
 	layersDir := filepath.Join(dir, "layers")
 	_, err := os.Stat(layersDir)
@@ -1026,7 +2186,11 @@ func ReadDir(dir string) (*Plan, error) {
 		return nil, err
 	}
 
-	layers, err := ReadLayersDir(layersDir)
+	trustedKeys, err := ReadTrustedKeys(dir)
+	if err != nil {
+		return nil, err
+	}
+	layers, err := ReadLayersDir(layersDir, trustedKeys)
 	if err != nil {
 		return nil, err
 	}
@@ -1035,10 +2199,12 @@ func ReadDir(dir string) (*Plan, error) {
 		return nil, err
 	}
 	plan := &Plan{
-		Layers:     layers,
-		Services:   combined.Services,
-		Checks:     combined.Checks,
-		LogTargets: combined.LogTargets,
+		Layers:        layers,
+		Services:      combined.Services,
+		Checks:        combined.Checks,
+		LogTargets:    combined.LogTargets,
+		NotifyTargets: combined.NotifyTargets,
+		Groups:        combined.Groups,
 	}
 	return plan, err
 }
@@ -37,8 +37,15 @@ const (
 	defaultCheckPeriod    = 10 * time.Second
 	defaultCheckTimeout   = 3 * time.Second
 	defaultCheckThreshold = 3
+
+	defaultNotifyRetries         = 3
+	defaultNotifyRateLimitPeriod = time.Minute
 )
 
+func intPtr(n int) *int {
+	return &n
+}
+
 // TODOs:
 // - command-chain
 // - error on invalid keys
@@ -72,13 +79,14 @@ func reindent(in string) []byte {
 }
 
 type planTest struct {
-	summary string
-	input   []string
-	layers  []*plan.Layer
-	result  *plan.Layer
-	error   string
-	start   map[string][]string
-	stop    map[string][]string
+	summary  string
+	input    []string
+	layers   []*plan.Layer
+	result   *plan.Layer
+	expanded map[string]*plan.Service
+	error    string
+	start    map[string][]string
+	stop     map[string][]string
 }
 
 var planTests = []planTest{{
@@ -724,8 +732,8 @@ var planTests = []planTest{{
 		LogTargets: map[string]*plan.LogTarget{},
 	},
 }, {
-	summary: "One of http, tcp, or exec must be present for check",
-	error:   `plan must specify one of "http", "tcp", or "exec" for check "chk1"`,
+	summary: "One of http, tcp, unix, udp, or exec must be present for check",
+	error:   `plan must specify one of "http", "tcp", "unix", "udp", or "exec" for check "chk1"`,
 	input: []string{`
 		checks:
 			chk1:
@@ -1062,260 +1070,1735 @@ var planTests = []planTest{{
 				location: http://10.1.77.196:3100/loki/api/v1/push
 				override: merge
 `},
-}}
-
-func (s *S) TestParseLayer(c *C) {
-	for _, test := range planTests {
-		c.Logf(test.summary)
-		var sup plan.Plan
-		var err error
-		for i, yml := range test.input {
-			layer, e := plan.ParseLayer(i, fmt.Sprintf("layer-%d", i), reindent(yml))
-			if e != nil {
-				err = e
-				break
-			}
-			if len(test.layers) > 0 && test.layers[i] != nil {
-				c.Assert(layer, DeepEquals, test.layers[i])
-			}
-			sup.Layers = append(sup.Layers, layer)
-		}
-		if err == nil {
-			var result *plan.Layer
-			result, err = plan.CombineLayers(sup.Layers...)
-			if err == nil && test.result != nil {
-				c.Assert(result, DeepEquals, test.result)
-			}
-			if err == nil {
-				for name, order := range test.start {
-					p := plan.Plan{Services: result.Services}
-					names, err := p.StartOrder([]string{name})
-					c.Assert(err, IsNil)
-					c.Assert(names, DeepEquals, order)
-				}
-				for name, order := range test.stop {
-					p := plan.Plan{Services: result.Services}
-					names, err := p.StopOrder([]string{name})
-					c.Assert(err, IsNil)
-					c.Assert(names, DeepEquals, order)
-				}
-			}
-		}
-		if err != nil || test.error != "" {
-			if test.error != "" {
-				c.Assert(err, ErrorMatches, test.error)
-			} else {
-				c.Assert(err, IsNil)
-			}
-		}
-	}
-}
-
-func (s *S) TestCombineLayersCycle(c *C) {
-	// Even if individual layers don't have cycles, combined layers might.
-	layer1, err := plan.ParseLayer(1, "label1", []byte(`
-services:
-    srv1:
-        override: replace
-        command: cmd
-        after:
-            - srv2
-`))
-	c.Assert(err, IsNil)
-	layer2, err := plan.ParseLayer(2, "label2", []byte(`
-services:
-    srv2:
-        override: replace
-        command: cmd
-        after:
-            - srv1
-`))
-	c.Assert(err, IsNil)
-	_, err = plan.CombineLayers(layer1, layer2)
-	c.Assert(err, ErrorMatches, `services in before/after loop: .*`)
-	_, ok := err.(*plan.FormatError)
-	c.Assert(ok, Equals, true, Commentf("error must be *plan.FormatError, not %T", err))
-}
-
-func (s *S) TestMissingOverride(c *C) {
-	layer1, err := plan.ParseLayer(1, "label1", []byte("{}"))
-	c.Assert(err, IsNil)
-	layer2, err := plan.ParseLayer(2, "label2", []byte(`
-services:
-    srv1:
-        command: cmd
-`))
-	c.Assert(err, IsNil)
-	_, err = plan.CombineLayers(layer1, layer2)
-	c.Check(err, ErrorMatches, `layer "label2" must define \"override\" for service "srv1"`)
-	_, ok := err.(*plan.FormatError)
-	c.Check(ok, Equals, true, Commentf("error must be *plan.FormatError, not %T", err))
-}
-
-func (s *S) TestMissingCommand(c *C) {
-	// Combine fails if no command in combined plan
-	layer1, err := plan.ParseLayer(1, "label1", []byte("{}"))
-	c.Assert(err, IsNil)
-	layer2, err := plan.ParseLayer(2, "label2", []byte(`
-services:
-    srv1:
-        override: merge
-`))
-	c.Assert(err, IsNil)
-	_, err = plan.CombineLayers(layer1, layer2)
-	c.Check(err, ErrorMatches, `plan must define "command" for service "srv1"`)
-	_, ok := err.(*plan.FormatError)
-	c.Check(ok, Equals, true, Commentf("error must be *plan.FormatError, not %T", err))
-
-	// Combine succeeds if there is a command in combined plan
-	layer1, err = plan.ParseLayer(1, "label1", []byte(`
-services:
-    srv1:
-        override: merge
-        command: foo --bar
-`))
-	c.Assert(err, IsNil)
-	layer2, err = plan.ParseLayer(2, "label2", []byte(`
-services:
-    srv1:
-        override: merge
-`))
-	c.Assert(err, IsNil)
-	combined, err := plan.CombineLayers(layer1, layer2)
-	c.Assert(err, IsNil)
-	c.Assert(combined.Services["srv1"].Command, Equals, "foo --bar")
-}
-
-func (s *S) TestReadDir(c *C) {
-	tempDir := c.MkDir()
-
-	for testIndex, test := range planTests {
-		c.Logf(test.summary)
-		pebbleDir := filepath.Join(tempDir, fmt.Sprintf("pebble-%03d", testIndex))
-		layersDir := filepath.Join(pebbleDir, "layers")
-		err := os.MkdirAll(layersDir, 0755)
-		c.Assert(err, IsNil)
-
-		for i, yml := range test.input {
-			err := ioutil.WriteFile(filepath.Join(layersDir, fmt.Sprintf("%03d-layer-%d.yaml", i, i)), reindent(yml), 0644)
-			c.Assert(err, IsNil)
-		}
-		sup, err := plan.ReadDir(pebbleDir)
-		if err == nil {
-			var result *plan.Layer
-			result, err = plan.CombineLayers(sup.Layers...)
-			if err == nil && test.result != nil {
-				c.Assert(result, DeepEquals, test.result)
-			}
-			if err == nil {
-				for name, order := range test.start {
-					p := plan.Plan{Services: result.Services}
-					names, err := p.StartOrder([]string{name})
-					c.Assert(err, IsNil)
-					c.Assert(names, DeepEquals, order)
-				}
-				for name, order := range test.stop {
-					p := plan.Plan{Services: result.Services}
-					names, err := p.StopOrder([]string{name})
-					c.Assert(err, IsNil)
-					c.Assert(names, DeepEquals, order)
-				}
-			}
-		}
-		if err != nil || test.error != "" {
-			if test.error != "" {
-				c.Assert(err, ErrorMatches, test.error)
-			} else {
-				c.Assert(err, IsNil)
-			}
-		}
-	}
-}
-
-var readDirBadNames = []string{
-	"001-l.yaml",
-	"01-label.yaml",
-	"0001-label.yaml",
-	"0001-label.yaml",
-	"001-label-.yaml",
-	"001--label.yaml",
-	"001-label--label.yaml",
-}
-
-func (s *S) TestReadDirBadNames(c *C) {
-	pebbleDir := c.MkDir()
-	layersDir := filepath.Join(pebbleDir, "layers")
-	err := os.Mkdir(layersDir, 0755)
-	c.Assert(err, IsNil)
-
-	for _, fname := range readDirBadNames {
-		fpath := filepath.Join(layersDir, fname)
-		err := ioutil.WriteFile(fpath, []byte("<ignore>"), 0644)
-		c.Assert(err, IsNil)
-		_, err = plan.ReadDir(pebbleDir)
-		c.Assert(err.Error(), Equals, fmt.Sprintf("invalid layer filename: %q (must look like \"123-some-label.yaml\")", fname))
-		err = os.Remove(fpath)
-		c.Assert(err, IsNil)
-	}
-}
-
-var readDirDupNames = [][]string{
-	{"001-bar.yaml", "001-foo.yaml"},
-	{"001-foo.yaml", "002-foo.yaml"},
-}
-
-func (s *S) TestReadDirDupNames(c *C) {
-	pebbleDir := c.MkDir()
-	layersDir := filepath.Join(pebbleDir, "layers")
-	err := os.Mkdir(layersDir, 0755)
-	c.Assert(err, IsNil)
-
-	for _, fnames := range readDirDupNames {
-		for _, fname := range fnames {
-			fpath := filepath.Join(layersDir, fname)
-			err := ioutil.WriteFile(fpath, []byte("summary: ignore"), 0644)
-			c.Assert(err, IsNil)
-		}
-		_, err = plan.ReadDir(pebbleDir)
-		c.Assert(err.Error(), Equals, fmt.Sprintf("invalid layer filename: %q not unique (have %q already)", fnames[1], fnames[0]))
-		for _, fname := range fnames {
-			fpath := filepath.Join(layersDir, fname)
-			err = os.Remove(fpath)
-			c.Assert(err, IsNil)
-		}
-	}
-}
-
-func (s *S) TestMarshalLayer(c *C) {
-	layerBytes := reindent(`
-		summary: Simple layer
-		description: A simple layer.
+}, {
+	summary: "Services waiting for checks",
+	input: []string{`
 		services:
-			srv1:
-				summary: Service summary
-				startup: enabled
+			svc1:
 				override: replace
-				command: cmd arg1 "arg2 arg3"
-				after:
-					- srv2
-				before:
-					- srv3
-				requires:
-					- srv2
-					- srv3
-				environment:
-					var0: val0
-					var1: val1
-					var2: val2
-				backoff-delay: 1s
-				backoff-factor: 1.5
-				backoff-limit: 10s
-			srv2:
+				command: foo
+				wait-for-checks:
+					- chk1
+		checks:
+			chk1:
 				override: replace
-				command: srv2cmd
-			srv3:
+				exec:
+					command: true
+			chk2:
 				override: replace
-				command: srv3cmd`)
+				exec:
+					command: true
+`, `
+		services:
+			svc1:
+				override: merge
+				wait-for-checks:
+					- chk1
+					- chk2
+				wait-for-checks-timeout: 10s
+`},
+	result: &plan.Layer{
+		Services: map[string]*plan.Service{
+			"svc1": {
+				Name:                 "svc1",
+				Override:             plan.ReplaceOverride,
+				Command:              "foo",
+				WaitForChecks:        []string{"chk1", "chk2"},
+				WaitForChecksTimeout: plan.OptionalDuration{Value: 10 * time.Second, IsSet: true},
+				BackoffDelay:         plan.OptionalDuration{Value: defaultBackoffDelay},
+				BackoffFactor:        plan.OptionalFloat{Value: defaultBackoffFactor},
+				BackoffLimit:         plan.OptionalDuration{Value: defaultBackoffLimit},
+			},
+		},
+		Checks: map[string]*plan.Check{
+			"chk1": {
+				Name:      "chk1",
+				Override:  plan.ReplaceOverride,
+				Period:    plan.OptionalDuration{Value: defaultCheckPeriod},
+				Timeout:   plan.OptionalDuration{Value: defaultCheckTimeout},
+				Threshold: defaultCheckThreshold,
+				Exec: &plan.ExecCheck{
+					Command: "true",
+				},
+			},
+			"chk2": {
+				Name:      "chk2",
+				Override:  plan.ReplaceOverride,
+				Period:    plan.OptionalDuration{Value: defaultCheckPeriod},
+				Timeout:   plan.OptionalDuration{Value: defaultCheckTimeout},
+				Threshold: defaultCheckThreshold,
+				Exec: &plan.ExecCheck{
+					Command: "true",
+				},
+			},
+		},
+		LogTargets: map[string]*plan.LogTarget{},
+	},
+}, {
+	summary: "Service waits for unknown check",
+	error:   `unknown check "chk2" in wait-for-checks for service "svc1"`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				wait-for-checks:
+					- chk2
+		checks:
+			chk1:
+				override: replace
+				exec:
+					command: true
+`},
+}, {
+	summary: "Stop and reload settings are merged",
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				stop-signal: SIGQUIT
+				reload-signal: SIGUSR1
+			svc2:
+				override: replace
+				command: bar
+				reload-command: bar --reload
+`, `
+		services:
+			svc1:
+				override: merge
+				reload-signal: SIGHUP
+			svc2:
+				override: merge
+				stop-signal: SIGINT
+`},
+	result: &plan.Layer{
+		Services: map[string]*plan.Service{
+			"svc1": {
+				Name:          "svc1",
+				Override:      plan.ReplaceOverride,
+				Command:       "foo",
+				StopSignal:    "SIGQUIT",
+				ReloadSignal:  "SIGHUP",
+				BackoffDelay:  plan.OptionalDuration{Value: defaultBackoffDelay},
+				BackoffFactor: plan.OptionalFloat{Value: defaultBackoffFactor},
+				BackoffLimit:  plan.OptionalDuration{Value: defaultBackoffLimit},
+			},
+			"svc2": {
+				Name:          "svc2",
+				Override:      plan.ReplaceOverride,
+				Command:       "bar",
+				StopSignal:    "SIGINT",
+				ReloadCommand: "bar --reload",
+				BackoffDelay:  plan.OptionalDuration{Value: defaultBackoffDelay},
+				BackoffFactor: plan.OptionalFloat{Value: defaultBackoffFactor},
+				BackoffLimit:  plan.OptionalDuration{Value: defaultBackoffLimit},
+			},
+		},
+		Checks:     map[string]*plan.Check{},
+		LogTargets: map[string]*plan.LogTarget{},
+	},
+}, {
+	summary: "Reload command and reload signal replace each other when merged",
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				reload-signal: SIGUSR1
+			svc2:
+				override: replace
+				command: bar
+				reload-command: bar --reload
+`, `
+		services:
+			svc1:
+				override: merge
+				reload-command: foo --reload
+			svc2:
+				override: merge
+				reload-signal: SIGUSR2
+`},
+	result: &plan.Layer{
+		Services: map[string]*plan.Service{
+			"svc1": {
+				Name:          "svc1",
+				Override:      plan.ReplaceOverride,
+				Command:       "foo",
+				ReloadCommand: "foo --reload",
+				BackoffDelay:  plan.OptionalDuration{Value: defaultBackoffDelay},
+				BackoffFactor: plan.OptionalFloat{Value: defaultBackoffFactor},
+				BackoffLimit:  plan.OptionalDuration{Value: defaultBackoffLimit},
+			},
+			"svc2": {
+				Name:          "svc2",
+				Override:      plan.ReplaceOverride,
+				Command:       "bar",
+				ReloadSignal:  "SIGUSR2",
+				BackoffDelay:  plan.OptionalDuration{Value: defaultBackoffDelay},
+				BackoffFactor: plan.OptionalFloat{Value: defaultBackoffFactor},
+				BackoffLimit:  plan.OptionalDuration{Value: defaultBackoffLimit},
+			},
+		},
+		Checks:     map[string]*plan.Check{},
+		LogTargets: map[string]*plan.LogTarget{},
+	},
+}, {
+	summary: "Invalid stop-signal",
+	error:   `plan service "svc1" stop-signal "QUIT" invalid`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				stop-signal: QUIT
+`},
+}, {
+	summary: "Invalid reload-signal",
+	error:   `plan service "svc1" reload-signal "SIGFOO" invalid`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				reload-signal: SIGFOO
+`},
+}, {
+	summary: "Both reload-signal and reload-command",
+	error:   `plan service "svc1" cannot have both reload-signal and reload-command`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				reload-signal: SIGHUP
+				reload-command: foo --reload
+`},
+}, {
+	summary: `Invalid reload-command`,
+	error:   `plan service "svc1" reload-command invalid: EOF found when expecting closing quote`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				reload-command: foo '
+`},
+}, {
+	summary: `Empty reload-command`,
+	error:   `plan service "svc1" reload-command must not be empty`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				reload-command: " "
+`},
+}, {
+	summary: "Hook commands are appended when merged",
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				pre-start:
+					- mkdir -p /run/foo
+				post-start:
+					- foo --ping
+				post-stop:
+					- rm -rf /run/foo
+`, `
+		services:
+			svc1:
+				override: merge
+				pre-start:
+					- foo --migrate
+`},
+	result: &plan.Layer{
+		Services: map[string]*plan.Service{
+			"svc1": {
+				Name:          "svc1",
+				Override:      plan.ReplaceOverride,
+				Command:       "foo",
+				PreStart:      []string{"mkdir -p /run/foo", "foo --migrate"},
+				PostStart:     []string{"foo --ping"},
+				PostStop:      []string{"rm -rf /run/foo"},
+				BackoffDelay:  plan.OptionalDuration{Value: defaultBackoffDelay},
+				BackoffFactor: plan.OptionalFloat{Value: defaultBackoffFactor},
+				BackoffLimit:  plan.OptionalDuration{Value: defaultBackoffLimit},
+			},
+		},
+		Checks:     map[string]*plan.Check{},
+		LogTargets: map[string]*plan.LogTarget{},
+	},
+}, {
+	summary: "Invalid pre-start command",
+	error:   `plan service "svc1" pre-start command invalid: EOF found when expecting closing quote`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				pre-start:
+					- foo '
+`},
+}, {
+	summary: "Empty post-stop command",
+	error:   `plan service "svc1" post-stop command must not be empty`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				post-stop:
+					- ""
+`},
+}, {
+	summary: "Environment files are merged",
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				environment-files:
+					- /etc/foo/env
+`, `
+		services:
+			svc1:
+				override: merge
+				environment-files:
+					- /etc/foo/env
+					- /etc/foo/secrets
+`},
+	result: &plan.Layer{
+		Services: map[string]*plan.Service{
+			"svc1": {
+				Name:             "svc1",
+				Override:         plan.ReplaceOverride,
+				Command:          "foo",
+				EnvironmentFiles: []string{"/etc/foo/env", "/etc/foo/secrets"},
+				BackoffDelay:     plan.OptionalDuration{Value: defaultBackoffDelay},
+				BackoffFactor:    plan.OptionalFloat{Value: defaultBackoffFactor},
+				BackoffLimit:     plan.OptionalDuration{Value: defaultBackoffLimit},
+			},
+		},
+		Checks:     map[string]*plan.Check{},
+		LogTargets: map[string]*plan.LogTarget{},
+	},
+}, {
+	summary: "Relative environment-files path",
+	error:   `plan service "svc1" environment-files path "foo.env" must be absolute`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				environment-files:
+					- foo.env
+`},
+}, {
+	summary: "Sandbox options are merged",
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				no-new-privileges: true
+				supplementary-groups: [adm]
+				capability-bounding-set: [CAP_NET_BIND_SERVICE]
+				root-directory: /srv/root
+`, `
+		services:
+			svc1:
+				override: merge
+				supplementary-groups: [adm, audio]
+				capability-bounding-set: [CAP_CHOWN]
+				ambient-capabilities: [net_bind_service]
+				private-tmp: true
+`},
+	result: &plan.Layer{
+		Services: map[string]*plan.Service{
+			"svc1": {
+				Name:     "svc1",
+				Override: plan.ReplaceOverride,
+				Command:  "foo",
+				Sandbox: plan.Sandbox{
+					NoNewPrivileges:       true,
+					SupplementaryGroups:   []string{"adm", "audio"},
+					CapabilityBoundingSet: []string{"CAP_NET_BIND_SERVICE", "CAP_CHOWN"},
+					AmbientCapabilities:   []string{"net_bind_service"},
+					RootDirectory:         "/srv/root",
+					PrivateTmp:            true,
+				},
+				BackoffDelay:  plan.OptionalDuration{Value: defaultBackoffDelay},
+				BackoffFactor: plan.OptionalFloat{Value: defaultBackoffFactor},
+				BackoffLimit:  plan.OptionalDuration{Value: defaultBackoffLimit},
+			},
+		},
+		Checks:     map[string]*plan.Check{},
+		LogTargets: map[string]*plan.LogTarget{},
+	},
+}, {
+	summary: "Invalid capability",
+	error:   `plan service "svc1" ambient-capabilities invalid: unknown capability "CAP_FLY"`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				ambient-capabilities: [CAP_FLY]
+`},
+}, {
+	summary: "Relative root-directory",
+	error:   `plan service "svc1" root-directory "srv" must be absolute`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				root-directory: srv
+`},
+}, {
+	summary: "Invalid capability in exec check",
+	error:   `plan check "chk1" capability-bounding-set invalid: unknown capability "bogus"`,
+	input: []string{`
+		checks:
+			chk1:
+				override: replace
+				exec:
+					command: foo
+					capability-bounding-set: [bogus]
+`},
+}, {
+	summary: "Resource limits are merged",
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				memory-max: 512M
+				cpu-weight: 200
+`, `
+		services:
+			svc1:
+				override: merge
+				memory-max: 1G
+				cpu-max: 150%
+				pids-max: 100
+`},
+	result: &plan.Layer{
+		Services: map[string]*plan.Service{
+			"svc1": {
+				Name:          "svc1",
+				Override:      plan.ReplaceOverride,
+				Command:       "foo",
+				MemoryMax:     "1G",
+				CPUWeight:     200,
+				CPUMax:        "150%",
+				PidsMax:       100,
+				BackoffDelay:  plan.OptionalDuration{Value: defaultBackoffDelay},
+				BackoffFactor: plan.OptionalFloat{Value: defaultBackoffFactor},
+				BackoffLimit:  plan.OptionalDuration{Value: defaultBackoffLimit},
+			},
+		},
+		Checks:     map[string]*plan.Check{},
+		LogTargets: map[string]*plan.LogTarget{},
+	},
+}, {
+	summary: "Invalid memory-max",
+	error:   `plan service "svc1" memory-max invalid: invalid memory size "lots"`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				memory-max: lots
+`},
+}, {
+	summary: "Invalid cpu-max",
+	error:   `plan service "svc1" cpu-max invalid: invalid CPU limit "2": must be a percentage`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				cpu-max: 2
+`},
+}, {
+	summary: "Invalid cpu-weight",
+	error:   `plan service "svc1" cpu-weight must be between 1 and 10000`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				cpu-weight: 20000
+`},
+}, {
+	summary: "Negative pids-max",
+	error:   `plan service "svc1" pids-max must not be negative`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				pids-max: -1
+`},
+}, {
+	summary: "Zero wait-for-checks-timeout",
+	error:   `plan service "svc1" wait-for-checks-timeout must not be zero`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				wait-for-checks-timeout: 0s
+`},
+}, {
+	summary: "Restart limit settings are merged",
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				restart-limit: 5
+				restart-limit-window: 1m
+`, `
+		services:
+			svc1:
+				override: merge
+				restart-limit: 3
+				on-restart-limit: shutdown
+`},
+	result: &plan.Layer{
+		Services: map[string]*plan.Service{
+			"svc1": {
+				Name:               "svc1",
+				Override:           plan.ReplaceOverride,
+				Command:            "foo",
+				RestartLimit:       3,
+				RestartLimitWindow: plan.OptionalDuration{Value: time.Minute, IsSet: true},
+				OnRestartLimit:     plan.ActionShutdown,
+				BackoffDelay:       plan.OptionalDuration{Value: defaultBackoffDelay},
+				BackoffFactor:      plan.OptionalFloat{Value: defaultBackoffFactor},
+				BackoffLimit:       plan.OptionalDuration{Value: defaultBackoffLimit},
+			},
+		},
+		Checks:     map[string]*plan.Check{},
+		LogTargets: map[string]*plan.LogTarget{},
+	},
+}, {
+	summary: "Negative restart-limit",
+	error:   `plan service "svc1" restart-limit must not be negative`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				restart-limit: -1
+`},
+}, {
+	summary: "Zero restart-limit-window",
+	error:   `plan service "svc1" restart-limit-window must not be zero`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				restart-limit: 3
+				restart-limit-window: 0s
+`},
+}, {
+	summary: "Invalid on-restart-limit",
+	error:   `plan service "svc1" on-restart-limit action must be "ignore" or "shutdown"`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				restart-limit: 3
+				on-restart-limit: restart
+`},
+}, {
+	summary: "Template services are expanded into instances when used",
+	input: []string{`
+		services:
+			worker@:
+				override: replace
+				command: worker --port 80{{instance}} --id $PEBBLE_INSTANCE
+				instances: 2
+				environment:
+					NAME: worker-{{instance}}
+				requires:
+					- db
+			named@:
+				override: replace
+				command: named ${PEBBLE_INSTANCE}
+				instances: [a, b]
+			db:
+				override: replace
+				command: db
+				before:
+					- worker@
+			db@backup:
+				override: replace
+				command: backup
+`, `
+		services:
+			named@:
+				override: merge
+				instances: [x]
+`},
+	result: &plan.Layer{
+		Services: map[string]*plan.Service{
+			"worker@": {
+				Name:      "worker@",
+				Override:  plan.ReplaceOverride,
+				Command:   "worker --port 80{{instance}} --id $PEBBLE_INSTANCE",
+				Instances: plan.ServiceInstances{Count: 2},
+				Environment: map[string]string{
+					"NAME": "worker-{{instance}}",
+				},
+				Requires:      []string{"db"},
+				BackoffDelay:  plan.OptionalDuration{Value: defaultBackoffDelay},
+				BackoffFactor: plan.OptionalFloat{Value: defaultBackoffFactor},
+				BackoffLimit:  plan.OptionalDuration{Value: defaultBackoffLimit},
+			},
+			"named@": {
+				Name:          "named@",
+				Override:      plan.ReplaceOverride,
+				Command:       "named ${PEBBLE_INSTANCE}",
+				Instances:     plan.ServiceInstances{Names: []string{"x"}},
+				BackoffDelay:  plan.OptionalDuration{Value: defaultBackoffDelay},
+				BackoffFactor: plan.OptionalFloat{Value: defaultBackoffFactor},
+				BackoffLimit:  plan.OptionalDuration{Value: defaultBackoffLimit},
+			},
+			"db": {
+				Name:          "db",
+				Override:      plan.ReplaceOverride,
+				Command:       "db",
+				Before:        []string{"worker@"},
+				BackoffDelay:  plan.OptionalDuration{Value: defaultBackoffDelay},
+				BackoffFactor: plan.OptionalFloat{Value: defaultBackoffFactor},
+				BackoffLimit:  plan.OptionalDuration{Value: defaultBackoffLimit},
+			},
+			"db@backup": {
+				Name:          "db@backup",
+				Override:      plan.ReplaceOverride,
+				Command:       "backup",
+				BackoffDelay:  plan.OptionalDuration{Value: defaultBackoffDelay},
+				BackoffFactor: plan.OptionalFloat{Value: defaultBackoffFactor},
+				BackoffLimit:  plan.OptionalDuration{Value: defaultBackoffLimit},
+			},
+		},
+		Checks:     map[string]*plan.Check{},
+		LogTargets: map[string]*plan.LogTarget{},
+	},
+	expanded: map[string]*plan.Service{
+		"worker@1": {
+			Name:     "worker@1",
+			Override: plan.ReplaceOverride,
+			Command:  "worker --port 801 --id 1",
+			Environment: map[string]string{
+				"NAME":            "worker-1",
+				"PEBBLE_INSTANCE": "1",
+			},
+			Requires:      []string{"db"},
+			BackoffDelay:  plan.OptionalDuration{Value: defaultBackoffDelay},
+			BackoffFactor: plan.OptionalFloat{Value: defaultBackoffFactor},
+			BackoffLimit:  plan.OptionalDuration{Value: defaultBackoffLimit},
+		},
+		"worker@2": {
+			Name:     "worker@2",
+			Override: plan.ReplaceOverride,
+			Command:  "worker --port 802 --id 2",
+			Environment: map[string]string{
+				"NAME":            "worker-2",
+				"PEBBLE_INSTANCE": "2",
+			},
+			Requires:      []string{"db"},
+			BackoffDelay:  plan.OptionalDuration{Value: defaultBackoffDelay},
+			BackoffFactor: plan.OptionalFloat{Value: defaultBackoffFactor},
+			BackoffLimit:  plan.OptionalDuration{Value: defaultBackoffLimit},
+		},
+		"named@x": {
+			Name:     "named@x",
+			Override: plan.ReplaceOverride,
+			Command:  "named x",
+			Environment: map[string]string{
+				"PEBBLE_INSTANCE": "x",
+			},
+			BackoffDelay:  plan.OptionalDuration{Value: defaultBackoffDelay},
+			BackoffFactor: plan.OptionalFloat{Value: defaultBackoffFactor},
+			BackoffLimit:  plan.OptionalDuration{Value: defaultBackoffLimit},
+		},
+		"db": {
+			Name:          "db",
+			Override:      plan.ReplaceOverride,
+			Command:       "db",
+			Before:        []string{"worker@1", "worker@2"},
+			BackoffDelay:  plan.OptionalDuration{Value: defaultBackoffDelay},
+			BackoffFactor: plan.OptionalFloat{Value: defaultBackoffFactor},
+			BackoffLimit:  plan.OptionalDuration{Value: defaultBackoffLimit},
+		},
+		"db@backup": {
+			Name:          "db@backup",
+			Override:      plan.ReplaceOverride,
+			Command:       "backup",
+			BackoffDelay:  plan.OptionalDuration{Value: defaultBackoffDelay},
+			BackoffFactor: plan.OptionalFloat{Value: defaultBackoffFactor},
+			BackoffLimit:  plan.OptionalDuration{Value: defaultBackoffLimit},
+		},
+	},
+	start: map[string][]string{
+		"worker":   {"db", "worker@1", "worker@2"},
+		"worker@":  {"db", "worker@1", "worker@2"},
+		"worker@2": {"db", "worker@2"},
+		"named":    {"named@x"},
+		// Services containing "@" that aren't instances of a template
+		// aren't matched.
+		"db":        {"db"},
+		"db@backup": {"db@backup"},
+	},
+	stop: map[string][]string{
+		"worker":   {"worker@1", "worker@2"},
+		"worker@1": {"worker@1"},
+		"db":       {"worker@1", "worker@2", "db"},
+	},
+}, {
+	summary: "Template service without instances",
+	error:   `plan template service "worker@" must specify "instances"`,
+	input: []string{`
+		services:
+			worker@:
+				override: replace
+				command: worker
+`},
+}, {
+	summary: "Non-template service with instances",
+	error:   `plan service "worker" cannot have "instances" as it is not a template \(name must end with "@"\)`,
+	input: []string{`
+		services:
+			worker:
+				override: replace
+				command: worker
+				instances: 2
+`},
+}, {
+	summary: "Template service with negative instances",
+	error:   `plan template service "worker@" must have at least one instance`,
+	input: []string{`
+		services:
+			worker@:
+				override: replace
+				command: worker
+				instances: -1
+`},
+}, {
+	summary: "Template service with duplicate instance names",
+	error:   `plan template service "worker@" has duplicate instance name "a"`,
+	input: []string{`
+		services:
+			worker@:
+				override: replace
+				command: worker
+				instances: [a, b, a]
+`},
+}, {
+	summary: "Template service with invalid instance name",
+	error:   `plan template service "worker@" has invalid instance name "a@b"`,
+	input: []string{`
+		services:
+			worker@:
+				override: replace
+				command: worker
+				instances: [a@b]
+`},
+}, {
+	summary: "Template service instance conflicts with service",
+	error:   `plan service "worker@2" conflicts with an instance of template service "worker@"`,
+	input: []string{`
+		services:
+			worker@:
+				override: replace
+				command: worker
+				instances: 2
+			worker@2:
+				override: replace
+				command: other
+`},
+}, {
+	summary: "Invalid instances",
+	error:   `cannot parse layer "layer-0": instances must be a number or a list of names`,
+	input: []string{`
+		services:
+			worker@:
+				override: replace
+				command: worker
+				instances: lots
+`},
+}, {
+	summary: "Service groups",
+	input: []string{`
+		groups:
+			backend: [api, worker]
+		services:
+			api:
+				override: replace
+				command: api
+				requires:
+					- db
+			worker@:
+				override: replace
+				command: worker
+				instances: 2
+				groups:
+					- workers
+			db:
+				override: replace
+				command: db
+				before:
+					- api
+				groups:
+					- storage
+`, `
+		groups:
+			backend: [cron]
+		services:
+			cron:
+				override: replace
+				command: cron
+				groups:
+					- backend
+`},
+	start: map[string][]string{
+		"backend": {"db", "api", "cron", "worker@1", "worker@2"},
+		"workers": {"worker@1", "worker@2"},
+		"storage": {"db"},
+	},
+	stop: map[string][]string{
+		"backend": {"api", "cron", "worker@1", "worker@2"},
+		"storage": {"api", "db"},
+	},
+}, {
+	summary: "Group conflicts with service",
+	error:   `group "api" conflicts with service of the same name`,
+	input: []string{`
+		groups:
+			api: [db]
+		services:
+			api:
+				override: replace
+				command: api
+			db:
+				override: replace
+				command: db
+`},
+}, {
+	summary: "Group conflicts with template service",
+	error:   `group "worker" conflicts with service of the same name`,
+	input: []string{`
+		services:
+			worker@:
+				override: replace
+				command: worker
+				instances: 2
+			db:
+				override: replace
+				command: db
+				groups: [worker]
+`},
+}, {
+	summary: "Group with unknown member",
+	error:   `group "backend" member "nope" is not a service`,
+	input: []string{`
+		groups:
+			backend: [api, nope]
+		services:
+			api:
+				override: replace
+				command: api
+`},
+}, {
+	summary: "Group replaced by a later layer",
+	input: []string{`
+		groups:
+			backend: [api, worker]
+		services:
+			api:
+				override: replace
+				command: api
+			worker:
+				override: replace
+				command: worker
+			db:
+				override: replace
+				command: db
+				groups: [backend]
+`, `
+		groups:
+			backend:
+				override: replace
+				services: [api]
+`, `
+		groups:
+			backend: [cron]
+		services:
+			cron:
+				override: replace
+				command: cron
+`},
+	start: map[string][]string{
+		"backend": {"api", "cron", "db"},
+	},
+}, {
+	summary: "Group merged by a later layer",
+	input: []string{`
+		groups:
+			backend: [api]
+		services:
+			api:
+				override: replace
+				command: api
+			worker:
+				override: replace
+				command: worker
+`, `
+		groups:
+			backend:
+				override: merge
+				services: [worker]
+`},
+	start: map[string][]string{
+		"backend": {"api", "worker"},
+	},
+}, {
+	summary: "Group without override",
+	error:   `layer "layer-1" must define "override" for group "backend"`,
+	input: []string{`
+		groups:
+			backend: [api]
+		services:
+			api:
+				override: replace
+				command: api
+`, `
+		groups:
+			backend:
+				services: [api]
+`},
+}, {
+	summary: "Group with invalid override",
+	error:   `layer "layer-0" has invalid "override" value for group "backend"`,
+	input: []string{`
+		groups:
+			backend:
+				override: foo
+				services: [api]
+		services:
+			api:
+				override: replace
+				command: api
+`},
+}, {
+	summary: "HTTP check options are merged",
+	input: []string{`
+		checks:
+			chk1:
+				override: replace
+				http:
+					url: https://localhost/health
+					method: POST
+					body: '{"ping": true}'
+					expect-status: [200, 300-399]
+					expect-json:
+						$.status: ok
+					ca-file: /etc/ssl/ca.pem
+`, `
+		checks:
+			chk1:
+				override: merge
+				http:
+					expect-status: [204]
+					expect-body: "^ok"
+					expect-json:
+						$.db.up: "true"
+					insecure-skip-verify: true
+					client-cert: /etc/ssl/client.pem
+					client-key: /etc/ssl/client.key
+					socket-path: /run/app.sock
+`},
+	result: &plan.Layer{
+		Services: map[string]*plan.Service{},
+		Checks: map[string]*plan.Check{
+			"chk1": {
+				Name:      "chk1",
+				Override:  plan.ReplaceOverride,
+				Period:    plan.OptionalDuration{Value: defaultCheckPeriod},
+				Timeout:   plan.OptionalDuration{Value: defaultCheckTimeout},
+				Threshold: defaultCheckThreshold,
+				HTTP: &plan.HTTPCheck{
+					URL:          "https://localhost/health",
+					Method:       "POST",
+					Body:         `{"ping": true}`,
+					ExpectStatus: []string{"204"},
+					ExpectBody:   "^ok",
+					ExpectJSON: map[string]string{
+						"$.status": "ok",
+						"$.db.up":  "true",
+					},
+					CAFile:             "/etc/ssl/ca.pem",
+					InsecureSkipVerify: true,
+					ClientCert:         "/etc/ssl/client.pem",
+					ClientKey:          "/etc/ssl/client.key",
+					SocketPath:         "/run/app.sock",
+				},
+			},
+		},
+		LogTargets: map[string]*plan.LogTarget{},
+	},
+}, {
+	summary: "Invalid HTTP check method",
+	error:   `plan check "chk1" method "get me" invalid`,
+	input: []string{`
+		checks:
+			chk1:
+				override: replace
+				http:
+					url: https://localhost/health
+					method: get me
+`},
+}, {
+	summary: "Invalid HTTP check expect-status",
+	error:   `plan check "chk1" expect-status invalid: "299-200" is not a status code or range of status codes`,
+	input: []string{`
+		checks:
+			chk1:
+				override: replace
+				http:
+					url: https://localhost/health
+					expect-status: [200, 299-200]
+`},
+}, {
+	summary: "Invalid HTTP check expect-body",
+	error:   `plan check "chk1" expect-body invalid: error parsing regexp: .*`,
+	input: []string{`
+		checks:
+			chk1:
+				override: replace
+				http:
+					url: https://localhost/health
+					expect-body: "(ok"
+`},
+}, {
+	summary: "Invalid HTTP check expect-json",
+	error:   `plan check "chk1" expect-json invalid: invalid JSON path "\$.a\[x\]": invalid index "x"`,
+	input: []string{`
+		checks:
+			chk1:
+				override: replace
+				http:
+					url: https://localhost/health
+					expect-json:
+						$.a[x]: "1"
+`},
+}, {
+	summary: "HTTP check client-cert without client-key",
+	error:   `plan check "chk1" must set both "client-cert" and "client-key", or neither`,
+	input: []string{`
+		checks:
+			chk1:
+				override: replace
+				http:
+					url: https://localhost/health
+					client-cert: /etc/ssl/client.pem
+`},
+}, {
+	summary: "Relative HTTP check socket-path",
+	error:   `plan check "chk1" socket-path "app.sock" must be absolute`,
+	input: []string{`
+		checks:
+			chk1:
+				override: replace
+				http:
+					url: http://localhost/health
+					socket-path: app.sock
+`},
+}, {
+	summary: "TCP, unix and UDP send/expect checks",
+	input: []string{`
+		checks:
+			chk-tcp:
+				override: replace
+				tcp:
+					port: 6379
+					send: "PING\r\n"
+			chk-unix:
+				override: replace
+				unix:
+					path: /run/redis.sock
+					send: "PING\r\n"
+			chk-udp:
+				override: replace
+				udp:
+					port: 53
+					send: ping
+`, `
+		checks:
+			chk-tcp:
+				override: merge
+				tcp:
+					expect: ^\+PONG
+			chk-unix:
+				override: merge
+				unix:
+					expect: ^\+PONG
+			chk-udp:
+				override: merge
+				udp:
+					host: 127.0.0.1
+					expect: pong
+`},
+	result: &plan.Layer{
+		Services: map[string]*plan.Service{},
+		Checks: map[string]*plan.Check{
+			"chk-tcp": {
+				Name:      "chk-tcp",
+				Override:  plan.ReplaceOverride,
+				Period:    plan.OptionalDuration{Value: defaultCheckPeriod},
+				Timeout:   plan.OptionalDuration{Value: defaultCheckTimeout},
+				Threshold: defaultCheckThreshold,
+				TCP: &plan.TCPCheck{
+					Port:   6379,
+					Send:   "PING\r\n",
+					Expect: `^\+PONG`,
+				},
+			},
+			"chk-unix": {
+				Name:      "chk-unix",
+				Override:  plan.ReplaceOverride,
+				Period:    plan.OptionalDuration{Value: defaultCheckPeriod},
+				Timeout:   plan.OptionalDuration{Value: defaultCheckTimeout},
+				Threshold: defaultCheckThreshold,
+				Unix: &plan.UnixCheck{
+					Path:   "/run/redis.sock",
+					Send:   "PING\r\n",
+					Expect: `^\+PONG`,
+				},
+			},
+			"chk-udp": {
+				Name:      "chk-udp",
+				Override:  plan.ReplaceOverride,
+				Period:    plan.OptionalDuration{Value: defaultCheckPeriod},
+				Timeout:   plan.OptionalDuration{Value: defaultCheckTimeout},
+				Threshold: defaultCheckThreshold,
+				UDP: &plan.UDPCheck{
+					Port:   53,
+					Host:   "127.0.0.1",
+					Send:   "ping",
+					Expect: "pong",
+				},
+			},
+		},
+		LogTargets: map[string]*plan.LogTarget{},
+	},
+}, {
+	summary: "Invalid TCP check expect",
+	error:   `plan check "chk1" expect invalid: error parsing regexp: .*`,
+	input: []string{`
+		checks:
+			chk1:
+				override: replace
+				tcp:
+					port: 6379
+					expect: "(PONG"
+`},
+}, {
+	summary: "Unix check requires path field",
+	error:   `plan must set "path" for unix check "chk1"`,
+	input: []string{`
+		checks:
+			chk1:
+				override: replace
+				unix: {}
+`},
+}, {
+	summary: "Relative unix check path",
+	error:   `plan check "chk1" path "redis.sock" must be absolute`,
+	input: []string{`
+		checks:
+			chk1:
+				override: replace
+				unix:
+					path: redis.sock
+`},
+}, {
+	summary: "UDP check requires port field",
+	error:   `plan must set "port" for udp check "chk1"`,
+	input: []string{`
+		checks:
+			chk1:
+				override: replace
+				udp:
+					send: ping
+`},
+}, {
+	summary: "UDP check requires send field",
+	error:   `plan must set "send" for udp check "chk1"`,
+	input: []string{`
+		checks:
+			chk1:
+				override: replace
+				udp:
+					port: 53
+`},
+}, {
+	summary: "Only one check type may be specified",
+	error:   `plan must specify one of "http", "tcp", "unix", "udp", or "exec" for check "chk1"`,
+	input: []string{`
+		checks:
+			chk1:
+				override: replace
+				tcp:
+					port: 6379
+				unix:
+					path: /run/redis.sock
+`},
+}, {
+	summary: "Check startup-delay is merged",
+	input: []string{`
+		checks:
+			chk1:
+				override: replace
+				startup-delay: 30s
+				tcp:
+					port: 8080
+`, `
+		checks:
+			chk1:
+				override: merge
+				startup-delay: 1m
+`},
+	result: &plan.Layer{
+		Services: map[string]*plan.Service{},
+		Checks: map[string]*plan.Check{
+			"chk1": {
+				Name:         "chk1",
+				Override:     plan.ReplaceOverride,
+				Period:       plan.OptionalDuration{Value: defaultCheckPeriod},
+				Timeout:      plan.OptionalDuration{Value: defaultCheckTimeout},
+				Threshold:    defaultCheckThreshold,
+				StartupDelay: plan.OptionalDuration{Value: time.Minute, IsSet: true},
+				TCP:          &plan.TCPCheck{Port: 8080},
+			},
+		},
+		LogTargets: map[string]*plan.LogTarget{},
+	},
+}, {
+	summary: "Negative check startup-delay",
+	error:   `plan check "chk1" startup-delay must not be negative`,
+	input: []string{`
+		checks:
+			chk1:
+				override: replace
+				startup-delay: -1s
+				tcp:
+					port: 8080
+`},
+}, {
+	summary: "Check success and flap settings are merged",
+	input: []string{`
+		checks:
+			chk1:
+				override: replace
+				success-threshold: 2
+				flap-threshold: 4
+				tcp:
+					port: 8080
+`, `
+		checks:
+			chk1:
+				override: merge
+				flap-window: 5m
+`},
+	result: &plan.Layer{
+		Services: map[string]*plan.Service{},
+		Checks: map[string]*plan.Check{
+			"chk1": {
+				Name:             "chk1",
+				Override:         plan.ReplaceOverride,
+				Period:           plan.OptionalDuration{Value: defaultCheckPeriod},
+				Timeout:          plan.OptionalDuration{Value: defaultCheckTimeout},
+				Threshold:        defaultCheckThreshold,
+				SuccessThreshold: 2,
+				FlapThreshold:    4,
+				FlapWindow:       plan.OptionalDuration{Value: 5 * time.Minute, IsSet: true},
+				TCP:              &plan.TCPCheck{Port: 8080},
+			},
+		},
+		LogTargets: map[string]*plan.LogTarget{},
+	},
+}, {
+	summary: "Negative check success-threshold",
+	error:   `plan check "chk1" success-threshold must not be negative`,
+	input: []string{`
+		checks:
+			chk1:
+				override: replace
+				success-threshold: -1
+				tcp:
+					port: 8080
+`},
+}, {
+	summary: "Zero check flap-window",
+	error:   `plan check "chk1" flap-window must be positive`,
+	input: []string{`
+		checks:
+			chk1:
+				override: replace
+				flap-threshold: 3
+				flap-window: 0s
+				tcp:
+					port: 8080
+`},
+}, {
+	summary: "Check bound to a service",
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+		checks:
+			chk1:
+				override: replace
+				service: svc1
+				tcp:
+					port: 8080
+`},
+}, {
+	summary: "Check bound to unknown service",
+	error:   `unknown service "svc2" for check "chk1"`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+		checks:
+			chk1:
+				override: replace
+				service: svc2
+				tcp:
+					port: 8080
+`},
+}, {
+	summary: "Service waits for check bound to it",
+	error:   `service "svc1" cannot wait for check "chk1", which only runs while the service is active`,
+	input: []string{`
+		services:
+			svc1:
+				override: replace
+				command: foo
+				wait-for-checks:
+					- chk1
+		checks:
+			chk1:
+				override: replace
+				service: svc1
+				tcp:
+					port: 8080
+`},
+}, {
+	summary: "Notify targets are merged",
+	input: []string{`
+		notify-targets:
+			alerts:
+				override: replace
+				type: webhook
+				location: https://example.com/hook
+				events:
+					- check-down
+			script:
+				override: replace
+				type: exec
+				command: /usr/bin/notify --quiet
+				retries: 0
+				rate-limit: 5
+				rate-limit-period: 30s
+`, `
+		notify-targets:
+			alerts:
+				override: merge
+				events:
+					- check-up
+				rate-limit: 10
+`},
+	result: &plan.Layer{
+		Services:   map[string]*plan.Service{},
+		Checks:     map[string]*plan.Check{},
+		LogTargets: map[string]*plan.LogTarget{},
+		NotifyTargets: map[string]*plan.NotifyTarget{
+			"alerts": {
+				Name:            "alerts",
+				Override:        plan.ReplaceOverride,
+				Type:            plan.WebhookTarget,
+				Location:        "https://example.com/hook",
+				Events:          []plan.NotifyEvent{plan.CheckDownEvent, plan.CheckUpEvent},
+				Retries:         intPtr(defaultNotifyRetries),
+				RateLimit:       10,
+				RateLimitPeriod: plan.OptionalDuration{Value: defaultNotifyRateLimitPeriod},
+			},
+			"script": {
+				Name:            "script",
+				Override:        plan.ReplaceOverride,
+				Type:            plan.ExecTarget,
+				Command:         "/usr/bin/notify --quiet",
+				Retries:         intPtr(0),
+				RateLimit:       5,
+				RateLimitPeriod: plan.OptionalDuration{Value: 30 * time.Second, IsSet: true},
+			},
+		},
+	},
+}, {
+	summary: "Notify target without type",
+	error:   `plan must define "type" \("webhook" or "exec"\) for notify target "alerts"`,
+	input: []string{`
+		notify-targets:
+			alerts:
+				override: replace
+				location: https://example.com/hook
+`},
+}, {
+	summary: "Webhook notify target with invalid location",
+	error:   `notify target "alerts" location must be an HTTP or HTTPS URL`,
+	input: []string{`
+		notify-targets:
+			alerts:
+				override: replace
+				type: webhook
+				location: ftp://example.com/hook
+`},
+}, {
+	summary: "Exec notify target without command",
+	error:   `plan must define "command" for exec notify target "script"`,
+	input: []string{`
+		notify-targets:
+			script:
+				override: replace
+				type: exec
+`},
+}, {
+	summary: "Exec notify target with empty command",
+	error:   `plan notify target "script" command must not be empty`,
+	input: []string{`
+		notify-targets:
+			script:
+				override: replace
+				type: exec
+				command: " "
+`},
+}, {
+	summary: "Notify target with zero rate-limit-period",
+	error:   `plan notify target "alerts" rate-limit-period must be positive`,
+	input: []string{`
+		notify-targets:
+			alerts:
+				override: replace
+				type: webhook
+				location: https://example.com/hook
+				rate-limit: 5
+				rate-limit-period: 0s
+`},
+}, {
+	summary: "Notify target with unknown event",
+	error:   `notify target "alerts" has unknown event "service-start"`,
+	input: []string{`
+		notify-targets:
+			alerts:
+				override: replace
+				type: webhook
+				location: https://example.com/hook
+				events:
+					- service-start
+`},
+}, {
+	summary: "Notify target without override",
+	error:   `layer "layer-0" must define "override" for notify target "alerts"`,
+	input: []string{`
+		notify-targets:
+			alerts:
+				type: webhook
+				location: https://example.com/hook
+`},
+}}
+
+func (s *S) TestParseLayer(c *C) {
+	for _, test := range planTests {
+		c.Logf(test.summary)
+		var sup plan.Plan
+		var err error
+		for i, yml := range test.input {
+			layer, e := plan.ParseLayer(i, fmt.Sprintf("layer-%d", i), reindent(yml))
+			if e != nil {
+				err = e
+				break
+			}
+			if len(test.layers) > 0 && test.layers[i] != nil {
+				c.Assert(layer, DeepEquals, test.layers[i])
+			}
+			sup.Layers = append(sup.Layers, layer)
+		}
+		if err == nil {
+			var result *plan.Layer
+			result, err = plan.CombineLayers(sup.Layers...)
+			if err == nil && test.result != nil {
+				c.Assert(result, DeepEquals, test.result)
+			}
+			if err == nil && test.expanded != nil {
+				p := plan.Plan{Services: result.Services}
+				c.Assert(p.ExpandedServices(), DeepEquals, test.expanded)
+			}
+			if err == nil {
+				for name, order := range test.start {
+					p := plan.Plan{Services: result.Services, Groups: result.Groups}
+					names, err := p.StartOrder([]string{name})
+					c.Assert(err, IsNil)
+					c.Assert(names, DeepEquals, order)
+				}
+				for name, order := range test.stop {
+					p := plan.Plan{Services: result.Services, Groups: result.Groups}
+					names, err := p.StopOrder([]string{name})
+					c.Assert(err, IsNil)
+					c.Assert(names, DeepEquals, order)
+				}
+			}
+		}
+		if err != nil || test.error != "" {
+			if test.error != "" {
+				c.Assert(err, ErrorMatches, test.error)
+			} else {
+				c.Assert(err, IsNil)
+			}
+		}
+	}
+}
+
+func (s *S) TestCombineLayersCycle(c *C) {
+	// Even if individual layers don't have cycles, combined layers might.
+	layer1, err := plan.ParseLayer(1, "label1", []byte(`
+services:
+    srv1:
+        override: replace
+        command: cmd
+        after:
+            - srv2
+`))
+	c.Assert(err, IsNil)
+	layer2, err := plan.ParseLayer(2, "label2", []byte(`
+services:
+    srv2:
+        override: replace
+        command: cmd
+        after:
+            - srv1
+`))
+	c.Assert(err, IsNil)
+	_, err = plan.CombineLayers(layer1, layer2)
+	c.Assert(err, ErrorMatches, `services in before/after loop: .*`)
+	_, ok := err.(*plan.FormatError)
+	c.Assert(ok, Equals, true, Commentf("error must be *plan.FormatError, not %T", err))
+}
+
+func (s *S) TestMissingOverride(c *C) {
+	layer1, err := plan.ParseLayer(1, "label1", []byte("{}"))
+	c.Assert(err, IsNil)
+	layer2, err := plan.ParseLayer(2, "label2", []byte(`
+services:
+    srv1:
+        command: cmd
+`))
+	c.Assert(err, IsNil)
+	_, err = plan.CombineLayers(layer1, layer2)
+	c.Check(err, ErrorMatches, `layer "label2" must define \"override\" for service "srv1"`)
+	_, ok := err.(*plan.FormatError)
+	c.Check(ok, Equals, true, Commentf("error must be *plan.FormatError, not %T", err))
+}
+
+func (s *S) TestMissingCommand(c *C) {
+	// Combine fails if no command in combined plan
+	layer1, err := plan.ParseLayer(1, "label1", []byte("{}"))
+	c.Assert(err, IsNil)
+	layer2, err := plan.ParseLayer(2, "label2", []byte(`
+services:
+    srv1:
+        override: merge
+`))
+	c.Assert(err, IsNil)
+	_, err = plan.CombineLayers(layer1, layer2)
+	c.Check(err, ErrorMatches, `plan must define "command" for service "srv1"`)
+	_, ok := err.(*plan.FormatError)
+	c.Check(ok, Equals, true, Commentf("error must be *plan.FormatError, not %T", err))
+
+	// Combine succeeds if there is a command in combined plan
+	layer1, err = plan.ParseLayer(1, "label1", []byte(`
+services:
+    srv1:
+        override: merge
+        command: foo --bar
+`))
+	c.Assert(err, IsNil)
+	layer2, err = plan.ParseLayer(2, "label2", []byte(`
+services:
+    srv1:
+        override: merge
+`))
+	c.Assert(err, IsNil)
+	combined, err := plan.CombineLayers(layer1, layer2)
+	c.Assert(err, IsNil)
+	c.Assert(combined.Services["srv1"].Command, Equals, "foo --bar")
+}
+
+func (s *S) TestReadDir(c *C) {
+	tempDir := c.MkDir()
+
+	for testIndex, test := range planTests {
+		c.Logf(test.summary)
+		pebbleDir := filepath.Join(tempDir, fmt.Sprintf("pebble-%03d", testIndex))
+		layersDir := filepath.Join(pebbleDir, "layers")
+		err := os.MkdirAll(layersDir, 0755)
+		c.Assert(err, IsNil)
+
+		for i, yml := range test.input {
+			err := ioutil.WriteFile(filepath.Join(layersDir, fmt.Sprintf("%03d-layer-%d.yaml", i, i)), reindent(yml), 0644)
+			c.Assert(err, IsNil)
+		}
+		sup, err := plan.ReadDir(pebbleDir)
+		if err == nil {
+			var result *plan.Layer
+			result, err = plan.CombineLayers(sup.Layers...)
+			if err == nil && test.result != nil {
+				c.Assert(result, DeepEquals, test.result)
+			}
+			if err == nil && test.expanded != nil {
+				p := plan.Plan{Services: result.Services}
+				c.Assert(p.ExpandedServices(), DeepEquals, test.expanded)
+			}
+			if err == nil {
+				for name, order := range test.start {
+					p := plan.Plan{Services: result.Services, Groups: result.Groups}
+					names, err := p.StartOrder([]string{name})
+					c.Assert(err, IsNil)
+					c.Assert(names, DeepEquals, order)
+				}
+				for name, order := range test.stop {
+					p := plan.Plan{Services: result.Services, Groups: result.Groups}
+					names, err := p.StopOrder([]string{name})
+					c.Assert(err, IsNil)
+					c.Assert(names, DeepEquals, order)
+				}
+			}
+		}
+		if err != nil || test.error != "" {
+			if test.error != "" {
+				c.Assert(err, ErrorMatches, test.error)
+			} else {
+				c.Assert(err, IsNil)
+			}
+		}
+	}
+}
+
+var readDirBadNames = []string{
+	"001-l.yaml",
+	"01-label.yaml",
+	"0001-label.yaml",
+	"0001-label.yaml",
+	"001-label-.yaml",
+	"001--label.yaml",
+	"001-label--label.yaml",
+}
+
+func (s *S) TestReadDirBadNames(c *C) {
+	pebbleDir := c.MkDir()
+	layersDir := filepath.Join(pebbleDir, "layers")
+	err := os.Mkdir(layersDir, 0755)
+	c.Assert(err, IsNil)
+
+	for _, fname := range readDirBadNames {
+		fpath := filepath.Join(layersDir, fname)
+		err := ioutil.WriteFile(fpath, []byte("<ignore>"), 0644)
+		c.Assert(err, IsNil)
+		_, err = plan.ReadDir(pebbleDir)
+		c.Assert(err.Error(), Equals, fmt.Sprintf("invalid layer filename: %q (must look like \"123-some-label.yaml\")", fname))
+		err = os.Remove(fpath)
+		c.Assert(err, IsNil)
+	}
+}
+
+var readDirDupNames = [][]string{
+	{"001-bar.yaml", "001-foo.yaml"},
+	{"001-foo.yaml", "002-foo.yaml"},
+}
+
+func (s *S) TestReadDirDupNames(c *C) {
+	pebbleDir := c.MkDir()
+	layersDir := filepath.Join(pebbleDir, "layers")
+	err := os.Mkdir(layersDir, 0755)
+	c.Assert(err, IsNil)
+
+	for _, fnames := range readDirDupNames {
+		for _, fname := range fnames {
+			fpath := filepath.Join(layersDir, fname)
+			err := ioutil.WriteFile(fpath, []byte("summary: ignore"), 0644)
+			c.Assert(err, IsNil)
+		}
+		_, err = plan.ReadDir(pebbleDir)
+		c.Assert(err.Error(), Equals, fmt.Sprintf("invalid layer filename: %q not unique (have %q already)", fnames[1], fnames[0]))
+		for _, fname := range fnames {
+			fpath := filepath.Join(layersDir, fname)
+			err = os.Remove(fpath)
+			c.Assert(err, IsNil)
+		}
+	}
+}
+
+func (s *S) TestMarshalLayer(c *C) {
+	layerBytes := reindent(`
+		summary: Simple layer
+		description: A simple layer.
+		services:
+			srv1:
+				summary: Service summary
+				startup: enabled
+				override: replace
+				command: cmd arg1 "arg2 arg3"
+				after:
+					- srv2
+				before:
+					- srv3
+				requires:
+					- srv2
+					- srv3
+				environment:
+					var0: val0
+					var1: val1
+					var2: val2
+				backoff-delay: 1s
+				backoff-factor: 1.5
+				backoff-limit: 10s
+			srv2:
+				override: replace
+				command: srv2cmd
+			srv3:
+				override: replace
+				command: srv3cmd
+		groups:
+			backend:
+				- srv1
+				- srv2
+			frontend:
+				override: replace
+				services:
+					- srv3`)
 	layer, err := plan.ParseLayer(1, "layer1", layerBytes)
 	c.Assert(err, IsNil)
 	out, err := yaml.Marshal(layer)
@@ -1361,3 +2844,44 @@ func (s *S) TestSelectTargets(c *C) {
 		}
 	}
 }
+
+func (s *S) TestParseMemory(c *C) {
+	tests := []struct {
+		input string
+		bytes uint64
+		error string
+	}{
+		{"1024", 1024, ""},
+		{"4K", 4096, ""},
+		{"512M", 512 << 20, ""},
+		{"2g", 2 << 30, ""},
+		{"1T", 1 << 40, ""},
+		{"", 0, `invalid memory size ""`},
+		{"0", 0, `invalid memory size "0"`},
+		{"12X", 0, `invalid memory size "12X"`},
+		{"M", 0, `invalid memory size "M"`},
+	}
+	for _, test := range tests {
+		bytes, err := plan.ParseMemory(test.input)
+		if test.error != "" {
+			c.Check(err, ErrorMatches, test.error)
+			continue
+		}
+		c.Check(err, IsNil)
+		c.Check(bytes, Equals, test.bytes)
+	}
+}
+
+func (s *S) TestParseCPUMax(c *C) {
+	value, err := plan.ParseCPUMax("50%")
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, 50)
+	value, err = plan.ParseCPUMax("250%")
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, 250)
+
+	_, err = plan.ParseCPUMax("1.5")
+	c.Assert(err, ErrorMatches, `invalid CPU limit "1.5": must be a percentage`)
+	_, err = plan.ParseCPUMax("0%")
+	c.Assert(err, ErrorMatches, `invalid CPU limit "0%"`)
+}
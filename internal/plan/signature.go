@@ -0,0 +1,165 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// signaturePrefix starts the comment line, appended to a layer's YAML by
+// SignLayer, that holds the layer's signature.
+const signaturePrefix = "# pebble-signature: "
+
+// ReadTrustedKeys reads the public keys trusted to sign layers from the
+// "trusted-keys" sub-directory of dir. Each file in it must contain an
+// ed25519 public key in PEM format (as written by "openssl pkey -pubout").
+// If the sub-directory doesn't exist or is empty it returns no keys, meaning
+// layer signatures aren't enforced.
+func ReadTrustedKeys(dir string) ([]ed25519.PublicKey, error) {
+	keysDir := filepath.Join(dir, "trusted-keys")
+	finfos, err := ioutil.ReadDir(keysDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read trusted keys directory: %v", err)
+	}
+	var keys []ed25519.PublicKey
+	for _, finfo := range finfos {
+		if finfo.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(keysDir, finfo.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read trusted key: %v", err)
+		}
+		key, err := ParsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse trusted key %q: %v", finfo.Name(), err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// ParsePublicKey parses an ed25519 public key in PEM format.
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key is not an ed25519 public key")
+	}
+	return publicKey, nil
+}
+
+// ParsePrivateKey parses an ed25519 private key in PEM format (as written
+// by "openssl genpkey -algorithm ed25519").
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key is not an ed25519 private key")
+	}
+	return privateKey, nil
+}
+
+// SignLayer signs the layer's YAML data with the private key, returning the
+// data with a signature comment line appended. The signature also covers the
+// label the layer is added with and whether it's combined into an existing
+// layer (layers in the layers directory are never combined), so it's only
+// valid for that label and combine mode. If the data is already signed, the
+// existing signature is replaced.
+func SignLayer(data []byte, label string, combine bool, key ed25519.PrivateKey) []byte {
+	if content, _, ok := splitSignature(data); ok {
+		data = content
+	}
+	signed := make([]byte, 0, len(data)+len(signaturePrefix)+90)
+	signed = append(signed, data...)
+	if len(signed) > 0 && signed[len(signed)-1] != '\n' {
+		signed = append(signed, '\n')
+	}
+	signature := ed25519.Sign(key, signedPayload(signed, label, combine))
+	signed = append(signed, signaturePrefix...)
+	signed = append(signed, base64.StdEncoding.EncodeToString(signature)...)
+	signed = append(signed, '\n')
+	return signed
+}
+
+// VerifyLayer checks that the layer's YAML data ends with a valid signature
+// made by one of the trusted keys for the given label and combine mode.
+func VerifyLayer(data []byte, label string, combine bool, keys []ed25519.PublicKey) error {
+	content, signature, ok := splitSignature(data)
+	if !ok {
+		return fmt.Errorf("layer is not signed")
+	}
+	payload := signedPayload(content, label, combine)
+	for _, key := range keys {
+		if ed25519.Verify(key, payload, signature) {
+			return nil
+		}
+	}
+	return fmt.Errorf("layer signature is not valid for any trusted key")
+}
+
+// signedPayload returns the message signed for a layer: a header with the
+// layer's label (quoted, so it can't run into the following lines) and
+// combine mode, followed by the layer's content.
+func signedPayload(content []byte, label string, combine bool) []byte {
+	header := fmt.Sprintf("pebble-layer\nlabel: %s\ncombine: %t\n", strconv.Quote(label), combine)
+	payload := make([]byte, 0, len(header)+len(content))
+	payload = append(payload, header...)
+	payload = append(payload, content...)
+	return payload
+}
+
+// splitSignature splits signed layer data into the signed content and the
+// signature. It returns false if the last line of data isn't a well-formed
+// signature line.
+func splitSignature(data []byte) (content, signature []byte, ok bool) {
+	trimmed := bytes.TrimRight(data, "\n")
+	start := bytes.LastIndexByte(trimmed, '\n') + 1
+	line := trimmed[start:]
+	if !bytes.HasPrefix(line, []byte(signaturePrefix)) {
+		return nil, nil, false
+	}
+	signature, err := base64.StdEncoding.DecodeString(string(line[len(signaturePrefix):]))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return nil, nil, false
+	}
+	return data[:start], signature, true
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/pebble/internal/plan"
+)
+
+const signTestLayer = `
+services:
+    svc1:
+        override: replace
+        command: foo
+`
+
+// writeTrustedKey generates a new key pair, writes the public key in PEM
+// format to the pebble dir's trusted-keys directory, and returns the private
+// key.
+func writeTrustedKey(c *C, pebbleDir, name string) ed25519.PrivateKey {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	c.Assert(err, IsNil)
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	c.Assert(err, IsNil)
+	keysDir := filepath.Join(pebbleDir, "trusted-keys")
+	err = os.MkdirAll(keysDir, 0755)
+	c.Assert(err, IsNil)
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	err = ioutil.WriteFile(filepath.Join(keysDir, name), data, 0644)
+	c.Assert(err, IsNil)
+	return privateKey
+}
+
+func (s *S) TestSignAndVerifyLayer(c *C) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	c.Assert(err, IsNil)
+	otherKey, _, err := ed25519.GenerateKey(nil)
+	c.Assert(err, IsNil)
+
+	data := []byte(signTestLayer[1:])
+	signed := plan.SignLayer(data, "label", false, privateKey)
+	c.Assert(bytes.HasPrefix(signed, data), Equals, true)
+	c.Assert(string(signed[len(data):]), Matches, "# pebble-signature: [A-Za-z0-9+/=]+\n")
+
+	// Verification succeeds with the right key, and the signed layer parses.
+	c.Assert(plan.VerifyLayer(signed, "label", false, []ed25519.PublicKey{otherKey, publicKey}), IsNil)
+	layer, err := plan.ParseLayer(0, "label", signed)
+	c.Assert(err, IsNil)
+	c.Assert(layer.Services["svc1"].Command, Equals, "foo")
+
+	// Re-signing replaces the signature rather than adding another.
+	resigned := plan.SignLayer(signed, "label", false, privateKey)
+	c.Assert(bytes.Count(resigned, []byte("pebble-signature")), Equals, 1)
+	c.Assert(plan.VerifyLayer(resigned, "label", false, []ed25519.PublicKey{publicKey}), IsNil)
+
+	err = plan.VerifyLayer(signed, "label", false, []ed25519.PublicKey{otherKey})
+	c.Assert(err, ErrorMatches, "layer signature is not valid for any trusted key")
+
+	tampered := bytes.Replace(signed, []byte("command: foo"), []byte("command: bar"), 1)
+	err = plan.VerifyLayer(tampered, "label", false, []ed25519.PublicKey{publicKey})
+	c.Assert(err, ErrorMatches, "layer signature is not valid for any trusted key")
+
+	// The signature is only valid for the label and combine mode it was
+	// made for.
+	err = plan.VerifyLayer(signed, "other", false, []ed25519.PublicKey{publicKey})
+	c.Assert(err, ErrorMatches, "layer signature is not valid for any trusted key")
+	err = plan.VerifyLayer(signed, "label", true, []ed25519.PublicKey{publicKey})
+	c.Assert(err, ErrorMatches, "layer signature is not valid for any trusted key")
+	combined := plan.SignLayer(data, "label", true, privateKey)
+	c.Assert(plan.VerifyLayer(combined, "label", true, []ed25519.PublicKey{publicKey}), IsNil)
+	err = plan.VerifyLayer(combined, "label", false, []ed25519.PublicKey{publicKey})
+	c.Assert(err, ErrorMatches, "layer signature is not valid for any trusted key")
+
+	err = plan.VerifyLayer(data, "label", false, []ed25519.PublicKey{publicKey})
+	c.Assert(err, ErrorMatches, "layer is not signed")
+}
+
+func (s *S) TestReadDirTrustedKeys(c *C) {
+	pebbleDir := c.MkDir()
+	layersDir := filepath.Join(pebbleDir, "layers")
+	err := os.Mkdir(layersDir, 0755)
+	c.Assert(err, IsNil)
+	layerPath := filepath.Join(layersDir, "001-base.yaml")
+	err = ioutil.WriteFile(layerPath, []byte(signTestLayer[1:]), 0644)
+	c.Assert(err, IsNil)
+
+	// Unsigned layers are fine when there are no trusted keys.
+	p, err := plan.ReadDir(pebbleDir)
+	c.Assert(err, IsNil)
+	c.Assert(p.Layers, HasLen, 1)
+
+	// With a trusted key, the unsigned layer is rejected.
+	privateKey := writeTrustedKey(c, pebbleDir, "ci.pem")
+	_, err = plan.ReadDir(pebbleDir)
+	c.Assert(err, ErrorMatches, `cannot verify layer file "001-base.yaml": layer is not signed`)
+
+	// A layer signed by another key is rejected.
+	_, otherKey, err := ed25519.GenerateKey(nil)
+	c.Assert(err, IsNil)
+	err = ioutil.WriteFile(layerPath, plan.SignLayer([]byte(signTestLayer[1:]), "base", false, otherKey), 0644)
+	c.Assert(err, IsNil)
+	_, err = plan.ReadDir(pebbleDir)
+	c.Assert(err, ErrorMatches, `cannot verify layer file "001-base.yaml": layer signature is not valid for any trusted key`)
+
+	// A layer signed by the trusted key for another label is rejected.
+	err = ioutil.WriteFile(layerPath, plan.SignLayer([]byte(signTestLayer[1:]), "other", false, privateKey), 0644)
+	c.Assert(err, IsNil)
+	_, err = plan.ReadDir(pebbleDir)
+	c.Assert(err, ErrorMatches, `cannot verify layer file "001-base.yaml": layer signature is not valid for any trusted key`)
+
+	// A layer signed by the trusted key is accepted.
+	err = ioutil.WriteFile(layerPath, plan.SignLayer([]byte(signTestLayer[1:]), "base", false, privateKey), 0644)
+	c.Assert(err, IsNil)
+	p, err = plan.ReadDir(pebbleDir)
+	c.Assert(err, IsNil)
+	c.Assert(p.Services["svc1"].Command, Equals, "foo")
+}
+
+func (s *S) TestReadTrustedKeysInvalid(c *C) {
+	pebbleDir := c.MkDir()
+	keysDir := filepath.Join(pebbleDir, "trusted-keys")
+	err := os.Mkdir(keysDir, 0755)
+	c.Assert(err, IsNil)
+
+	keys, err := plan.ReadTrustedKeys(pebbleDir)
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, 0)
+
+	err = ioutil.WriteFile(filepath.Join(keysDir, "bad.pem"), []byte("not a key"), 0644)
+	c.Assert(err, IsNil)
+	_, err = plan.ReadTrustedKeys(pebbleDir)
+	c.Assert(err, ErrorMatches, `cannot parse trusted key "bad.pem": no PEM data found`)
+}
@@ -65,3 +65,114 @@ func (o *OptionalFloat) UnmarshalYAML(value *yaml.Node) error {
 	o.IsSet = true
 	return nil
 }
+
+// ServiceInstances holds the instances of a template service: either a
+// number of instances (named "1" to "N"), or a list of instance names.
+type ServiceInstances struct {
+	Count int
+	Names []string
+}
+
+func (i ServiceInstances) IsZero() bool {
+	return i.Count == 0 && i.Names == nil
+}
+
+// List returns the names of the instances.
+func (i ServiceInstances) List() []string {
+	if i.Names != nil {
+		return i.Names
+	}
+	if i.Count <= 0 {
+		return nil
+	}
+	names := make([]string, i.Count)
+	for n := range names {
+		names[n] = strconv.Itoa(n + 1)
+	}
+	return names
+}
+
+func (i ServiceInstances) MarshalYAML() (interface{}, error) {
+	if i.Names != nil {
+		return i.Names, nil
+	}
+	if i.Count == 0 {
+		return nil, nil
+	}
+	return i.Count, nil
+}
+
+func (i *ServiceInstances) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		n, err := strconv.Atoi(value.Value)
+		if err != nil {
+			return fmt.Errorf("instances must be a number or a list of names")
+		}
+		i.Count = n
+		i.Names = nil
+	case yaml.SequenceNode:
+		var names []string
+		err := value.Decode(&names)
+		if err != nil {
+			return fmt.Errorf("instances must be a number or a list of names")
+		}
+		i.Count = 0
+		i.Names = append([]string{}, names...)
+	default:
+		return fmt.Errorf("instances must be a number or a list of names")
+	}
+	return nil
+}
+
+// Group holds the services listed in a group in a layer's "groups" section.
+// A group can be written as a list of service names, which are merged with
+// the group's members from earlier layers, or as a map with "override" and
+// "services" fields.
+type Group struct {
+	Override Override
+	Services []string
+}
+
+// Copy returns a deep copy of the group.
+func (g *Group) Copy() *Group {
+	copied := *g
+	copied.Services = append([]string(nil), g.Services...)
+	return &copied
+}
+
+type groupMap struct {
+	Override Override `yaml:"override,omitempty"`
+	Services []string `yaml:"services,omitempty"`
+}
+
+func (g Group) MarshalYAML() (interface{}, error) {
+	if g.Override == MergeOverride {
+		return g.Services, nil
+	}
+	return groupMap{Override: g.Override, Services: g.Services}, nil
+}
+
+func (g *Group) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.SequenceNode:
+		var services []string
+		err := value.Decode(&services)
+		if err != nil {
+			return fmt.Errorf("group must be a list of services or a map")
+		}
+		g.Override = MergeOverride
+		g.Services = services
+	case yaml.MappingNode:
+		var m groupMap
+		err := value.Decode(&m)
+		if err != nil {
+			return err
+		}
+		g.Override = m.Override
+		g.Services = m.Services
+	default:
+		return fmt.Errorf("group must be a list of services or a map")
+	}
+	return nil
+}
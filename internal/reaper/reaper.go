@@ -99,6 +99,10 @@ func reapChildren() error {
 	logger.Debugf("Reaper started, waiting for SIGCHLD.")
 	sigChld := make(chan os.Signal, 1)
 	signal.Notify(sigChld, unix.SIGCHLD)
+	// Reap any children that exited before we were notified of SIGCHLD, for
+	// example processes adopted from a previous daemon, or ones started
+	// before this goroutine began running.
+	reapOnce()
 	for {
 		select {
 		case <-sigChld:
@@ -187,11 +191,15 @@ func StartCommand(cmd *exec.Cmd) error {
 // WaitCommand doesn't return an error for nonzero exit codes.
 func WaitCommand(cmd *exec.Cmd) (int, error) {
 	mutex.Lock()
-	if !started {
+	ch, ok := pids[cmd.Process.Pid]
+	// A command started while the reaper was running may still be waited
+	// for after it has been stopped (the wait completes only if it's started
+	// again), for example by a goroutine that began waiting just as the
+	// daemon was shutting down.
+	if !ok && !started {
 		mutex.Unlock()
 		panic("internal error: reaper must be started")
 	}
-	ch, ok := pids[cmd.Process.Pid]
 	if !ok {
 		// Shouldn't happen, but doesn't hurt to handle it.
 		mutex.Unlock()
@@ -226,6 +234,56 @@ func WaitCommand(cmd *exec.Cmd) (int, error) {
 	}
 }
 
+// WatchPID registers an existing child process with the reaper, for example
+// one started by a previous instance of Pebble that re-executed itself, so
+// that its exit code can be retrieved with WaitPID. The returned function
+// unregisters the PID; call it if WaitPID won't be called.
+func WatchPID(pid int) (unwatch func()) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if !started {
+		panic("internal error: reaper must be started")
+	}
+	if _, ok := pids[pid]; ok {
+		logger.Noticef("internal error: PID %d is already being tracked", pid)
+	}
+	ch := make(chan int, 1)
+	pids[pid] = ch
+
+	return func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if pids[pid] == ch {
+			delete(pids, pid)
+		}
+	}
+}
+
+// WaitPID waits for the child process (which must have been registered with
+// WatchPID) to finish and returns its exit code.
+func WaitPID(pid int) (int, error) {
+	mutex.Lock()
+	if !started {
+		mutex.Unlock()
+		panic("internal error: reaper must be started")
+	}
+	ch, ok := pids[pid]
+	if !ok {
+		mutex.Unlock()
+		return -1, fmt.Errorf("internal error: PID %d was not registered with WatchPID", pid)
+	}
+	mutex.Unlock()
+
+	exitCode := <-ch
+
+	mutex.Lock()
+	delete(pids, pid)
+	mutex.Unlock()
+
+	return exitCode, nil
+}
+
 // CommandCombinedOutput is like cmd.CombinedOutput, but for use when the
 // reaper is running.
 func CommandCombinedOutput(cmd *exec.Cmd) ([]byte, error) {
@@ -0,0 +1,331 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package sandbox runs commands with restricted privileges.
+//
+// Some restrictions (such as setting no_new_privs or dropping capabilities
+// from the bounding set) can't be applied with exec.Cmd's SysProcAttr, as
+// they must be applied by the child process itself between fork and exec.
+// For those, the command is run via a small exec helper: the current
+// executable is re-executed with the restrictions in an environment
+// variable, and RunHelper (called at the very start of the program's main
+// function) applies them and then execs the real command in place of the
+// helper.
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/canonical/pebble/internal/osutil"
+	"github.com/canonical/pebble/internal/plan"
+)
+
+// helperEnv is the environment variable through which the exec helper
+// receives its instructions (as JSON).
+const helperEnv = "PEBBLE_SANDBOX_HELPER"
+
+// Options are the restrictions applied to a command. The zero value applies
+// no restrictions.
+type Options struct {
+	// NoNewPrivileges prevents the command (and its children) from gaining
+	// privileges, for example through setuid binaries.
+	NoNewPrivileges bool
+
+	// Groups are the supplementary group IDs of the process. If nil and the
+	// command's user or group is changed, it has no supplementary groups.
+	Groups []uint32
+
+	// BoundingSet, if not nil, limits the capability bounding set to the
+	// given capabilities: all others are dropped.
+	BoundingSet []uintptr
+
+	// AmbientCaps are capabilities raised in the ambient set, so they are
+	// kept by a process started as a non-root user.
+	AmbientCaps []uintptr
+
+	// RootDir is the directory the command is chrooted to. The command path
+	// and working directory are relative to it.
+	RootDir string
+
+	// PrivateTmp gives the command its own empty /tmp (in a private mount
+	// namespace), which is discarded when the command exits.
+	PrivateTmp bool
+
+	// Cgroup is the directory of the cgroup (v2) the command is moved into
+	// before it runs, so all its child processes are in it too.
+	Cgroup string
+}
+
+// IsZero reports whether the options apply no restrictions.
+func (o *Options) IsZero() bool {
+	return o == nil || (!o.NoNewPrivileges && o.Groups == nil && o.BoundingSet == nil &&
+		len(o.AmbientCaps) == 0 && o.RootDir == "" && !o.PrivateTmp && o.Cgroup == "")
+}
+
+// PlanOptions validates the sandbox options in the plan and returns them in
+// the form used to run the process, looking up the supplementary group
+// names. It returns nil if no options are set.
+func PlanOptions(config *plan.Sandbox) (*Options, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	opts := &Options{
+		NoNewPrivileges: config.NoNewPrivileges,
+		RootDir:         config.RootDirectory,
+		PrivateTmp:      config.PrivateTmp,
+	}
+	var err error
+	if len(config.SupplementaryGroups) > 0 {
+		opts.Groups, err = osutil.LookupGroups(config.SupplementaryGroups)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(config.CapabilityBoundingSet) > 0 {
+		opts.BoundingSet, err = osutil.ParseCapabilities(config.CapabilityBoundingSet)
+		if err != nil {
+			return nil, err
+		}
+	}
+	opts.AmbientCaps, err = osutil.ParseCapabilities(config.AmbientCapabilities)
+	if err != nil {
+		return nil, err
+	}
+	if opts.IsZero() {
+		return nil, nil
+	}
+	return opts, nil
+}
+
+// helperSpec holds what the exec helper needs to start the real command.
+type helperSpec struct {
+	Options
+	UID  *uint32
+	GID  *uint32
+	Dir  string
+	Path string
+	Args []string
+}
+
+// Apply configures cmd to run with the given restrictions, via the exec
+// helper. It must be called once cmd is otherwise ready to start, as it
+// moves the command's path, arguments, working directory and credentials
+// (cmd.SysProcAttr.Credential) to the helper's instructions.
+func Apply(cmd *exec.Cmd, opts *Options) error {
+	if opts.IsZero() {
+		return nil
+	}
+	if opts.RootDir != "" && !filepath.IsAbs(cmd.Args[0]) {
+		return fmt.Errorf("command %q must be an absolute path when using a root directory", cmd.Args[0])
+	}
+
+	spec := helperSpec{
+		Options: *opts,
+		Dir:     cmd.Dir,
+		Path:    cmd.Path,
+		Args:    cmd.Args,
+	}
+	if opts.RootDir != "" {
+		// The path must be resolved within the root directory.
+		spec.Path = cmd.Args[0]
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if cred := cmd.SysProcAttr.Credential; cred != nil {
+		uid, gid := cred.Uid, cred.Gid
+		spec.UID, spec.GID = &uid, &gid
+		cmd.SysProcAttr.Credential = nil
+	}
+	if opts.PrivateTmp {
+		// Go makes the new mount namespace's mounts private, so the helper's
+		// tmpfs mount isn't visible outside it.
+		cmd.SysProcAttr.Unshareflags |= syscall.CLONE_NEWNS
+	}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(env[:len(env):len(env)], helperEnv+"="+string(data))
+	cmd.Path = "/proc/self/exe"
+	cmd.Args = []string{"pebble-sandbox-helper"}
+	cmd.Dir = ""
+	return nil
+}
+
+// RunHelper runs the exec helper if the current process was started as one
+// by a command configured with Apply: it applies the restrictions and execs
+// the real command, exiting if that fails. Otherwise it returns immediately.
+// It must be called at the start of main, before any other initialization.
+func RunHelper() {
+	data, ok := os.LookupEnv(helperEnv)
+	if !ok {
+		return
+	}
+	// Credentials and capabilities are per-thread, so everything up to the
+	// exec must happen on this thread.
+	runtime.LockOSThread()
+	err := runHelper(data)
+	fmt.Fprintf(os.Stderr, "cannot start sandboxed command: %v\n", err)
+	os.Exit(127)
+}
+
+// runHelper applies the restrictions in the JSON-encoded helper spec and
+// execs the command. It only returns if there's an error.
+func runHelper(data string) error {
+	os.Unsetenv(helperEnv)
+	var spec helperSpec
+	err := json.Unmarshal([]byte(data), &spec)
+	if err != nil {
+		return fmt.Errorf("invalid helper instructions: %v", err)
+	}
+
+	if spec.Cgroup != "" {
+		procs := filepath.Join(spec.Cgroup, "cgroup.procs")
+		err := ioutil.WriteFile(procs, []byte(strconv.Itoa(os.Getpid())), 0644)
+		if err != nil {
+			return fmt.Errorf("cannot move process to cgroup: %v", err)
+		}
+	}
+	if spec.PrivateTmp {
+		tmp := filepath.Join(spec.RootDir, "/tmp")
+		err := unix.Mount("tmpfs", tmp, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777")
+		if err != nil {
+			return fmt.Errorf("cannot mount private %s: %v", tmp, err)
+		}
+	}
+	if spec.RootDir != "" {
+		err := unix.Chroot(spec.RootDir)
+		if err != nil {
+			return fmt.Errorf("cannot change root directory to %q: %v", spec.RootDir, err)
+		}
+		if spec.Dir == "" {
+			spec.Dir = "/"
+		}
+	}
+	if spec.Dir != "" {
+		err := unix.Chdir(spec.Dir)
+		if err != nil {
+			return fmt.Errorf("cannot change working directory to %q: %v", spec.Dir, err)
+		}
+	}
+
+	if spec.BoundingSet != nil {
+		keep := make(map[uintptr]bool)
+		for _, c := range spec.BoundingSet {
+			keep[c] = true
+		}
+		for c := uintptr(0); c <= lastCap(); c++ {
+			if keep[c] {
+				continue
+			}
+			err := unix.Prctl(unix.PR_CAPBSET_DROP, c, 0, 0, 0)
+			if err != nil {
+				return fmt.Errorf("cannot drop capability %s from bounding set: %v", osutil.CapabilityName(c), err)
+			}
+		}
+	}
+
+	// Change user and group. Raw syscalls are used so only this thread's
+	// credentials change (the exec discards the other threads).
+	changeUser := spec.UID != nil && *spec.UID != 0
+	if changeUser && len(spec.AmbientCaps) > 0 {
+		err := unix.Prctl(unix.PR_SET_KEEPCAPS, 1, 0, 0, 0)
+		if err != nil {
+			return fmt.Errorf("cannot keep capabilities: %v", err)
+		}
+	}
+	if spec.Groups != nil || spec.GID != nil {
+		var groupsPtr uintptr
+		if len(spec.Groups) > 0 {
+			groupsPtr = uintptr(unsafe.Pointer(&spec.Groups[0]))
+		}
+		_, _, errno := unix.RawSyscall(unix.SYS_SETGROUPS, uintptr(len(spec.Groups)), groupsPtr, 0)
+		if errno != 0 {
+			return fmt.Errorf("cannot set supplementary groups: %v", errno)
+		}
+	}
+	if spec.GID != nil {
+		gid := uintptr(*spec.GID)
+		_, _, errno := unix.RawSyscall(unix.SYS_SETRESGID, gid, gid, gid)
+		if errno != 0 {
+			return fmt.Errorf("cannot set group ID: %v", errno)
+		}
+	}
+	if spec.UID != nil {
+		uid := uintptr(*spec.UID)
+		_, _, errno := unix.RawSyscall(unix.SYS_SETRESUID, uid, uid, uid)
+		if errno != 0 {
+			return fmt.Errorf("cannot set user ID: %v", errno)
+		}
+	}
+
+	if len(spec.AmbientCaps) > 0 {
+		// Ambient capabilities must be both permitted and inheritable.
+		header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+		var caps [2]unix.CapUserData
+		for _, c := range spec.AmbientCaps {
+			caps[c/32].Effective |= 1 << (c % 32)
+			caps[c/32].Permitted |= 1 << (c % 32)
+			caps[c/32].Inheritable |= 1 << (c % 32)
+		}
+		err := unix.Capset(&header, &caps[0])
+		if err != nil {
+			return fmt.Errorf("cannot set capabilities: %v", err)
+		}
+		for _, c := range spec.AmbientCaps {
+			err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_RAISE, c, 0, 0)
+			if err != nil {
+				return fmt.Errorf("cannot raise ambient capability %s: %v", osutil.CapabilityName(c), err)
+			}
+		}
+	}
+
+	if spec.NoNewPrivileges {
+		err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0)
+		if err != nil {
+			return fmt.Errorf("cannot set no_new_privs: %v", err)
+		}
+	}
+
+	return syscall.Exec(spec.Path, spec.Args, os.Environ())
+}
+
+// lastCap returns the highest capability number supported by the kernel.
+func lastCap() uintptr {
+	data, err := ioutil.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err == nil {
+		var n uintptr
+		if _, err := fmt.Sscanf(string(data), "%d", &n); err == nil {
+			return n
+		}
+	}
+	return unix.CAP_LAST_CAP
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sandbox_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/pebble/internal/sandbox"
+	"github.com/canonical/pebble/internal/testutil"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+// TestMain runs the exec helper when a sandboxed command started by a test
+// re-runs the test binary as one.
+func TestMain(m *testing.M) {
+	sandbox.RunHelper()
+	os.Exit(m.Run())
+}
+
+type sandboxSuite struct{}
+
+var _ = Suite(&sandboxSuite{})
+
+// statusField returns the value of the given field of /proc/self/status as
+// seen by a command run with the given options.
+func statusField(c *C, cred *syscall.Credential, opts *sandbox.Options, field string) string {
+	cmd := exec.Command("/bin/cat", "/proc/self/status")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+	err := sandbox.Apply(cmd, opts)
+	c.Assert(err, IsNil)
+	output, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("output: %s", output))
+	m := regexp.MustCompile(`(?m)^` + field + `:\s*(.*)$`).FindSubmatch(output)
+	c.Assert(m, NotNil, Commentf("output: %s", output))
+	return strings.TrimSpace(string(m[1]))
+}
+
+func requireRoot(c *C) {
+	if os.Getuid() != 0 {
+		c.Skip("requires root")
+	}
+}
+
+func (s *sandboxSuite) TestApplyZero(c *C) {
+	cmd := exec.Command("/bin/true")
+	err := sandbox.Apply(cmd, nil)
+	c.Assert(err, IsNil)
+	c.Assert(cmd.Path, Equals, "/bin/true")
+	err = sandbox.Apply(cmd, &sandbox.Options{})
+	c.Assert(err, IsNil)
+	c.Assert(cmd.Path, Equals, "/bin/true")
+	c.Assert(cmd.Env, IsNil)
+}
+
+func (s *sandboxSuite) TestNoNewPrivileges(c *C) {
+	value := statusField(c, nil, &sandbox.Options{NoNewPrivileges: true}, "NoNewPrivs")
+	c.Assert(value, Equals, "1")
+}
+
+func (s *sandboxSuite) TestBoundingSet(c *C) {
+	requireRoot(c)
+	opts := &sandbox.Options{BoundingSet: []uintptr{0, 10}}
+	value := statusField(c, nil, opts, "CapBnd")
+	c.Assert(value, Equals, "0000000000000401")
+}
+
+func (s *sandboxSuite) TestAmbientCapsAndGroups(c *C) {
+	requireRoot(c)
+	cred := &syscall.Credential{Uid: 65534, Gid: 65534}
+	opts := &sandbox.Options{AmbientCaps: []uintptr{10}, Groups: []uint32{1234, 5678}}
+	c.Assert(statusField(c, cred, opts, "CapAmb"), Equals, "0000000000000400")
+	c.Assert(statusField(c, cred, opts, "CapEff"), Equals, "0000000000000400")
+	c.Assert(statusField(c, cred, opts, "Uid"), Matches, `65534\s+65534\s+65534\s+65534`)
+	c.Assert(statusField(c, cred, opts, "Groups"), Equals, "1234 5678")
+}
+
+func (s *sandboxSuite) TestPrivateTmp(c *C) {
+	requireRoot(c)
+	hostFile, err := ioutil.TempFile("/tmp", "sandbox-test-")
+	c.Assert(err, IsNil)
+	hostFile.Close()
+	defer os.Remove(hostFile.Name())
+
+	cmd := exec.Command("/bin/sh", "-c", "touch /tmp/private && ls -A /tmp")
+	err = sandbox.Apply(cmd, &sandbox.Options{PrivateTmp: true})
+	c.Assert(err, IsNil)
+	output, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("output: %s", output))
+	c.Assert(string(output), Equals, "private\n")
+
+	c.Assert(filepath.Join("/tmp", "private"), testutil.FileAbsent)
+}
+
+func (s *sandboxSuite) TestRootDir(c *C) {
+	requireRoot(c)
+
+	// The command is looked up within the (empty) root directory.
+	cmd := exec.Command("/bin/true")
+	err := sandbox.Apply(cmd, &sandbox.Options{RootDir: c.MkDir()})
+	c.Assert(err, IsNil)
+	output, err := cmd.CombinedOutput()
+	c.Assert(err, ErrorMatches, "exit status 127")
+	c.Assert(string(output), Equals, "cannot start sandboxed command: no such file or directory\n")
+
+	cmd = exec.Command("true")
+	err = sandbox.Apply(cmd, &sandbox.Options{RootDir: c.MkDir()})
+	c.Assert(err, ErrorMatches, `command "true" must be an absolute path when using a root directory`)
+}
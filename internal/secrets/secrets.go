@@ -0,0 +1,116 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package secrets encrypts and decrypts secret values (such as passwords in
+// a service's environment) so they can be stored in layers without being
+// readable by anyone who can read the plan.
+package secrets
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+
+	"github.com/canonical/pebble/internal/osutil"
+)
+
+const (
+	// Prefix marks an encrypted secret value.
+	Prefix = "pebble-secret:"
+
+	// Redacted is what encrypted values are replaced with when the plan is
+	// shown to clients.
+	Redacted = Prefix + "redacted"
+
+	keySize   = 32
+	nonceSize = 24
+)
+
+// Key is the key used to encrypt and decrypt secret values.
+type Key [keySize]byte
+
+// KeyPath returns the path of the daemon's secret key file.
+func KeyPath(pebbleDir string) string {
+	return filepath.Join(pebbleDir, ".pebble.secret-key")
+}
+
+// LoadOrCreateKey reads the key from the given file, generating a new random
+// key and writing it to the file (readable only by its owner) if the file
+// doesn't exist.
+func LoadOrCreateKey(path string) (*Key, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		key := new(Key)
+		if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+			return nil, fmt.Errorf("cannot generate secret key: %w", err)
+		}
+		err = osutil.AtomicWriteFile(path, key[:], 0600, 0)
+		if err != nil {
+			return nil, fmt.Errorf("cannot write secret key: %w", err)
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read secret key: %w", err)
+	}
+	if len(data) != keySize {
+		return nil, fmt.Errorf("invalid secret key in %q: must be %d bytes", path, keySize)
+	}
+	key := new(Key)
+	copy(key[:], data)
+	return key, nil
+}
+
+// IsEncrypted reports whether value is an encrypted secret value (or a
+// redacted one).
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// Encrypt encrypts the plaintext with the key, returning a value with the
+// secret prefix that can be used in a layer.
+func Encrypt(key *Key, plaintext string) (string, error) {
+	var nonce [nonceSize]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return "", fmt.Errorf("cannot generate nonce: %w", err)
+	}
+	box := secretbox.Seal(nonce[:], []byte(plaintext), &nonce, (*[keySize]byte)(key))
+	return Prefix + base64.RawURLEncoding.EncodeToString(box), nil
+}
+
+// Decrypt decrypts a value returned by Encrypt. It returns an error if the
+// value isn't an encrypted value, or if it wasn't encrypted with this key.
+func Decrypt(key *Key, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return "", fmt.Errorf("value is not an encrypted secret")
+	}
+	box, err := base64.RawURLEncoding.DecodeString(value[len(Prefix):])
+	if err != nil || len(box) < nonceSize+secretbox.Overhead {
+		return "", fmt.Errorf("invalid encrypted secret")
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], box[:nonceSize])
+	plaintext, ok := secretbox.Open(nil, box[nonceSize:], &nonce, (*[keySize]byte)(key))
+	if !ok {
+		return "", fmt.Errorf("cannot decrypt secret: invalid key or corrupt value")
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2023 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package secrets_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/pebble/internal/secrets"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type secretsSuite struct{}
+
+var _ = Suite(&secretsSuite{})
+
+func (s *secretsSuite) TestLoadOrCreateKey(c *C) {
+	path := secrets.KeyPath(c.MkDir())
+
+	key1, err := secrets.LoadOrCreateKey(path)
+	c.Assert(err, IsNil)
+	st, err := os.Stat(path)
+	c.Assert(err, IsNil)
+	c.Check(st.Mode().Perm(), Equals, os.FileMode(0600))
+	c.Check(st.Size(), Equals, int64(32))
+
+	// The second time, the same key is read from the file.
+	key2, err := secrets.LoadOrCreateKey(path)
+	c.Assert(err, IsNil)
+	c.Check(key2, DeepEquals, key1)
+}
+
+func (s *secretsSuite) TestLoadKeyInvalid(c *C) {
+	path := filepath.Join(c.MkDir(), "key")
+	err := ioutil.WriteFile(path, []byte("short"), 0600)
+	c.Assert(err, IsNil)
+
+	_, err = secrets.LoadOrCreateKey(path)
+	c.Assert(err, ErrorMatches, `invalid secret key in ".*/key": must be 32 bytes`)
+}
+
+func (s *secretsSuite) TestEncryptDecrypt(c *C) {
+	key, err := secrets.LoadOrCreateKey(secrets.KeyPath(c.MkDir()))
+	c.Assert(err, IsNil)
+
+	encrypted, err := secrets.Encrypt(key, "s3cr3t pa$$word")
+	c.Assert(err, IsNil)
+	c.Check(secrets.IsEncrypted(encrypted), Equals, true)
+	c.Check(strings.Contains(encrypted, "s3cr3t"), Equals, false)
+
+	// Encrypting the same value twice gives different results (random nonce).
+	encrypted2, err := secrets.Encrypt(key, "s3cr3t pa$$word")
+	c.Assert(err, IsNil)
+	c.Check(encrypted2, Not(Equals), encrypted)
+
+	plaintext, err := secrets.Decrypt(key, encrypted)
+	c.Assert(err, IsNil)
+	c.Check(plaintext, Equals, "s3cr3t pa$$word")
+}
+
+func (s *secretsSuite) TestDecryptErrors(c *C) {
+	key1, err := secrets.LoadOrCreateKey(secrets.KeyPath(c.MkDir()))
+	c.Assert(err, IsNil)
+	key2, err := secrets.LoadOrCreateKey(secrets.KeyPath(c.MkDir()))
+	c.Assert(err, IsNil)
+	encrypted, err := secrets.Encrypt(key1, "foo")
+	c.Assert(err, IsNil)
+
+	_, err = secrets.Decrypt(key2, encrypted)
+	c.Check(err, ErrorMatches, "cannot decrypt secret: invalid key or corrupt value")
+
+	_, err = secrets.Decrypt(key1, "foo")
+	c.Check(err, ErrorMatches, "value is not an encrypted secret")
+
+	_, err = secrets.Decrypt(key1, secrets.Redacted)
+	c.Check(err, ErrorMatches, "invalid encrypted secret")
+
+	_, err = secrets.Decrypt(key1, secrets.Prefix+"!!!")
+	c.Check(err, ErrorMatches, "invalid encrypted secret")
+}